@@ -0,0 +1,198 @@
+// Package cli implements ReGo's headless subcommands so backups and restores
+// can be driven from scripts, SSH sessions without a TTY, or CI.
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK      = 0 // Everything succeeded
+	ExitFailure = 1 // The operation failed
+	ExitUsage   = 2 // Bad arguments or unknown subcommand
+	ExitPartial = 3 // The operation finished but some items failed
+)
+
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// Run executes a subcommand and returns the process exit code
+func Run(args []string) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "save":
+		return runSave(args[1:])
+	case "load":
+		return runLoad(args[1:])
+	case "check":
+		return runCheck(args[1:])
+	case "verify":
+		return runVerify(args[1:])
+	case "list":
+		return runList(args[1:])
+	case "undo":
+		return runUndo(args[1:])
+	case "resume":
+		return runResume(args[1:])
+	case privileged.HelperCommand:
+		// Started by ReGo itself through sudo or pkexec, not listed in usage
+		if err := privileged.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(stderr, "rego: %v\n", err)
+			return ExitFailure
+		}
+		return ExitOK
+	case "help", "-h", "--help":
+		usage(stdout)
+		return ExitOK
+	}
+
+	fmt.Fprintf(stderr, "rego: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return ExitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: rego [command] [flags]
+
+Run without a command to start the interactive interface.
+
+Commands:
+  save quick [flags]     Create a Quick Save (.json package lists)
+  save full [flags]      Create a Full Save (.tar.gz archive with files)
+  load <path> [flags]    Restore from a .json, .tar.gz or backup directory
+  check <path>           Show what a restore would install
+  verify <path>          Check a .tar.gz or backup directory against its checksums
+  list                   List backups found on this machine
+  undo [flags]           Revert the last restore, or some of its components
+  resume [flags]         Finish a Quick Save restore that was interrupted
+
+Run "rego <command> -h" for the flags of a command.
+
+Encrypted backups take their passphrase from --passphrase-file or the
+REGO_PASSPHRASE environment variable.
+`)
+}
+
+// interruptContext returns a context cancelled by Ctrl+C or SIGTERM. Commands
+// run for a backup are in their own process groups and never see the
+// terminal's SIGINT, so cancelling this is what stops them.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// passphraseEnv is read when --passphrase-file is not given
+const passphraseEnv = "REGO_PASSPHRASE"
+
+// readPassphrase returns the passphrase from file, or from the environment
+// when file is empty. It returns "" when neither is set.
+func readPassphrase(file string) (string, error) {
+	if file == "" {
+		return os.Getenv(passphraseEnv), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// describeOpenError adds a hint for encrypted files to errors from opening
+// a backup
+func describeOpenError(err error) error {
+	if errors.Is(err, utils.ErrPassphraseRequired) {
+		return fmt.Errorf("%w (use --passphrase-file or set %s)", err, passphraseEnv)
+	}
+	return err
+}
+
+// runList prints the Quick Saves, Full Saves and backup directories found in
+// the default locations
+func runList(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(stderr, "rego list: takes no arguments")
+		return ExitUsage
+	}
+
+	home, err := utils.GetHomeDir()
+	if err != nil {
+		fmt.Fprintf(stderr, "rego list: %v\n", err)
+		return ExitFailure
+	}
+
+	quick, _ := filepath.Glob(filepath.Join(home, "rego-*.json"))
+	full, _ := filepath.Glob(filepath.Join(home, "rego-*.tar.gz"))
+	sort.Strings(quick)
+	sort.Strings(full)
+
+	fmt.Fprintln(stdout, "Quick Saves:")
+	printFiles(quick)
+
+	fmt.Fprintln(stdout, "Full Saves:")
+	printFiles(full)
+
+	fmt.Fprintln(stdout, "Backup directories:")
+	manifests, err := backup.NewManager().ListBackups()
+	if err != nil {
+		fmt.Fprintf(stderr, "rego list: %v\n", err)
+		return ExitFailure
+	}
+	if len(manifests) == 0 {
+		fmt.Fprintln(stdout, "  (none)")
+	}
+	for _, m := range manifests {
+		var comps []string
+		for _, c := range m.Components {
+			comps = append(comps, string(c))
+		}
+		fmt.Fprintf(stdout, "  %s  %s  %s\n", m.CreatedAt.Format("2006-01-02 15:04"), m.BackupPath, strings.Join(comps, ","))
+	}
+
+	return ExitOK
+}
+
+func printFiles(files []string) {
+	if len(files) == 0 {
+		fmt.Fprintln(stdout, "  (none)")
+		return
+	}
+	for _, f := range files {
+		size := ""
+		if info, err := os.Stat(f); err == nil {
+			size = formatSize(info.Size())
+		}
+		fmt.Fprintf(stdout, "  %-10s %s\n", size, f)
+	}
+}
+
+func formatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,638 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// loadFlags mirrors RestoreOptions; the Quick Save restore only uses the
+// subset that exists in a LightBackup
+type loadFlags struct {
+	dryRun     bool
+	flatpaks   bool
+	packages   bool
+	repos      bool
+	extensions bool
+	settings   bool
+	dotfiles   bool
+	fonts      bool
+	merge      bool
+	pin        bool
+
+	// Full Save only
+	ssh         bool
+	autostart   bool
+	backgrounds bool
+	themes      bool
+	kde         bool
+
+	passphrase string
+	skipVerify bool
+	noSnapshot bool
+	restart    bool
+	source     string         // Path given on the command line
+	target     restore.Target // Where to restore into
+}
+
+func runLoad(args []string) int {
+	defaults := restore.DefaultRestoreOptions()
+	var f loadFlags
+	fs := flag.NewFlagSet("rego load", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.BoolVar(&f.dryRun, "dry-run", false, "show what would be restored without changing anything")
+	fs.BoolVar(&f.flatpaks, "flatpaks", defaults.IncludeFlatpak, "restore Flatpak applications")
+	fs.BoolVar(&f.packages, "packages", defaults.IncludeRPM, "restore system packages")
+	fs.BoolVar(&f.repos, "repos", defaults.IncludeRepos, "restore third-party repositories")
+	fs.BoolVar(&f.extensions, "extensions", defaults.IncludeGnomeExtensions, "restore GNOME extensions")
+	fs.BoolVar(&f.settings, "settings", defaults.IncludeGnomeSettings, "restore GNOME dconf settings")
+	fs.BoolVar(&f.dotfiles, "dotfiles", defaults.IncludeDotfiles, "restore dotfiles")
+	fs.BoolVar(&f.fonts, "fonts", defaults.IncludeFonts, "restore user fonts")
+	fs.BoolVar(&f.merge, "merge-dotfiles", defaults.MergeDotfiles, "merge existing dotfiles with the backup, or keep them, instead of overwriting them")
+	fs.BoolVar(&f.pin, "pin-flatpaks", defaults.PinFlatpaks, "update each Flatpak app to the commit it was backed up at")
+	fs.BoolVar(&f.ssh, "ssh", true, "restore SSH config (Full Save)")
+	fs.BoolVar(&f.autostart, "autostart", true, "restore autostart entries (Full Save)")
+	fs.BoolVar(&f.backgrounds, "backgrounds", true, "restore wallpapers (Full Save)")
+	fs.BoolVar(&f.themes, "themes", true, "restore GTK themes and icons (Full Save)")
+	fs.BoolVar(&f.kde, "kde", true, "restore KDE Plasma config and data (Full Save)")
+	fs.BoolVar(&f.skipVerify, "skip-verify", false, "restore even if the backup fails checksum verification")
+	fs.BoolVar(&f.noSnapshot, "no-snapshot", false, "don't save a pre-restore snapshot of what is about to change")
+	fs.BoolVar(&f.restart, "restart", false, "start an interrupted Quick Save restore over instead of resuming it")
+	passFile := fs.String("passphrase-file", "", "read the passphrase of an encrypted backup from this file instead of $"+passphraseEnv)
+	targetRoot := fs.String("target-root", "", "install packages and write system files into the root filesystem mounted here")
+	targetHome := fs.String("target-home", "", "restore files and settings into this home directory (default: your home inside --target-root)")
+
+	path, code := parseWithPath(fs, args)
+	if code != ExitOK {
+		return code
+	}
+	target, err := restore.NewTarget(*targetRoot, *targetHome)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego load: %v\n", err)
+		return ExitUsage
+	}
+	f.target = target
+	passphrase, err := readPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego load: %v\n", err)
+		return ExitFailure
+	}
+	f.passphrase = passphrase
+	f.source = path
+
+	if !f.target.Live() {
+		fmt.Fprintf(stdout, "Restoring into %s\n", f.target)
+	}
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		return loadQuick(path, f)
+	case utils.DirExists(path):
+		if !f.skipVerify && !verifyExtracted("rego load", path) {
+			return ExitFailure
+		}
+		return loadDir(path, f)
+	case strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		dir, full, cleanup, err := importArchive(path, f.passphrase)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego load: %v\n", describeOpenError(err))
+			return ExitFailure
+		}
+		defer cleanup()
+		if !f.skipVerify && !verifyExtracted("rego load", dir) {
+			return ExitFailure
+		}
+		if full {
+			return loadFull(dir, f)
+		}
+		return loadDir(dir, f)
+	}
+
+	fmt.Fprintf(stderr, "rego load: %s is not a .json, .tar.gz or backup directory\n", path)
+	return ExitUsage
+}
+
+// loadQuick restores a Quick Save through LightRestore
+func loadQuick(path string, f loadFlags) int {
+	b, err := backup.LoadLightBackupWithPassphrase(path, f.passphrase)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego load: failed to read %s: %v\n", path, describeOpenError(err))
+		return ExitFailure
+	}
+
+	printRejected(b.Rejected)
+
+	// sudo asks for the password the first time root is needed
+	h := privileged.New(true)
+	defer h.Close()
+
+	r := restore.NewLightRestore(b, f.dryRun)
+	r.SetProgress(printProgress())
+	r.SetHelper(h)
+	r.SetTarget(f.target)
+	r.SetPinFlatpaks(f.pin)
+	if f.dryRun {
+		fmt.Fprintln(stdout, "DRY RUN - no changes will be made")
+	} else {
+		j, err := restore.NewJournal(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego load: failed to start undo journal: %v\n", err)
+			return ExitFailure
+		}
+		j.SetTarget(f.target)
+		r.SetJournal(j)
+		defer printUndoHint(j)
+
+		state, resumed, err := resumeState(path, f)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego load: failed to save restore state: %v\n", err)
+			return ExitFailure
+		}
+		r.SetResume(state)
+		defer func() {
+			if err := state.Finish(); err != nil {
+				fmt.Fprintf(stderr, "rego load: failed to remove restore state: %v\n", err)
+			}
+			if _, failed := state.Counts(); failed > 0 {
+				fmt.Fprintln(stdout, `Run "rego resume" to retry what failed`)
+			}
+		}()
+
+		// A resumed restore already saved the state from before it started.
+		// Snapshots are of this system, so there is none for another target.
+		if !f.noSnapshot && !resumed && f.target.Live() {
+			s, err := restore.NewSnapshot()
+			if err != nil {
+				fmt.Fprintf(stderr, "rego load: failed to start pre-restore snapshot: %v\n", err)
+				return ExitFailure
+			}
+			r.SetSnapshot(s)
+			defer printSnapshot(s)
+		}
+	}
+
+	failed, reported := len(b.Rejected), 0
+	report := func(label string, ok, bad int, err error) {
+		if ok == 0 && bad == 0 && err == nil {
+			return
+		}
+		fmt.Fprintf(stdout, "  %-12s %d ok, %d failed\n", label, ok, bad)
+		results := r.Results()
+		for _, res := range results[reported:] {
+			printFailedItems(res)
+		}
+		reported = len(results)
+		if err != nil {
+			fmt.Fprintf(stderr, "    %v\n", err)
+		}
+		failed += bad
+		if err != nil && bad == 0 {
+			failed++
+		}
+	}
+
+	if f.flatpaks {
+		ok, bad, err := r.RestoreFlatpaks()
+		report("flatpaks", ok, bad, err)
+	}
+	if f.packages {
+		ok, bad, err := r.RestoreRPM()
+		report("rpm", ok, bad, err)
+		ok, bad, err = r.RestoreAPT()
+		report("apt", ok, bad, err)
+		ok, bad, err = r.RestorePacman()
+		report("pacman", ok, bad, err)
+		ok, bad, err = r.RestoreAUR()
+		report("aur", ok, bad, err)
+	}
+	if f.repos {
+		ok, bad, err := r.RestoreZypperRepos()
+		report("zypper repos", ok, bad, err)
+	}
+	if f.packages {
+		ok, bad, err := r.RestoreZypper()
+		report("zypper", ok, bad, err)
+	}
+	if f.extensions {
+		ok, bad, err := r.RestoreExtensions()
+		report("extensions", ok, bad, err)
+	}
+	if f.settings && b.DconfSettings != "" {
+		err := r.RestoreDconf()
+		if err == nil {
+			report("settings", 1, 0, nil)
+		} else {
+			report("settings", 0, 1, err)
+		}
+	}
+
+	if failed > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// quickComponents returns what a Quick Save restore with f changes
+func quickComponents(f loadFlags) []restore.RestoreType {
+	var components []restore.RestoreType
+	for _, c := range []struct {
+		on bool
+		t  restore.RestoreType
+	}{
+		{f.flatpaks, restore.RestoreTypeFlatpak},
+		{f.packages, restore.RestoreTypePackages},
+		{f.repos, restore.RestoreTypeZypperRepos},
+		{f.extensions, restore.RestoreTypeGnomeExtensions},
+		{f.settings, restore.RestoreTypeGnomeSettings},
+	} {
+		if c.on {
+			components = append(components, c.t)
+		}
+	}
+	return components
+}
+
+// resumeState picks up the interrupted restore of path into the same
+// target, unless f asks to start over, or starts tracking a new one.
+// resumed says which it did.
+func resumeState(path string, f loadFlags) (state *restore.ResumeState, resumed bool, err error) {
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false, err
+	}
+	state, err = restore.LoadResumeState()
+	same := err == nil && state.Source == source && state.Target == f.target
+	if same && !f.restart {
+		done, failed := state.Counts()
+		fmt.Fprintf(stdout, "Resuming the restore started %s: %d items done, %d to retry (pass --restart to start over)\n",
+			state.StartedAt.Format("2006-01-02 15:04"), done, failed)
+		return state, true, nil
+	}
+	if err == nil && !same {
+		fmt.Fprintf(stderr, "rego load: discarding the interrupted restore of %s into %s\n", state.Source, state.Target)
+	}
+	state, err = restore.NewResumeState(source, quickComponents(f))
+	if err == nil && !f.target.Live() {
+		err = state.SetTarget(f.target)
+	}
+	if err == nil && f.pin {
+		err = state.SetPinFlatpaks(true)
+	}
+	return state, false, err
+}
+
+// loadDir restores a component backup directory through restore.Manager
+func loadDir(dir string, f loadFlags) int {
+	opts := restore.RestoreOptions{
+		BackupPath:             dir,
+		Source:                 f.source,
+		DryRun:                 f.dryRun,
+		IncludeFlatpak:         f.flatpaks,
+		IncludeFlatpakRemotes:  f.flatpaks,
+		IncludeRPM:             f.packages,
+		IncludeRepos:           f.repos,
+		IncludeGnomeExtensions: f.extensions,
+		IncludeGnomeSettings:   f.settings,
+		IncludeDotfiles:        f.dotfiles,
+		IncludeFonts:           f.fonts,
+		IncludeZypper:          f.packages,
+		IncludeZypperRepos:     f.repos,
+		IncludeAPT:             f.packages,
+		IncludeAPTSources:      f.repos,
+		MergeDotfiles:          f.merge,
+		PinFlatpaks:            f.pin,
+		SkipSnapshot:           f.noSnapshot,
+		TargetRoot:             f.target.Root,
+		TargetHome:             f.target.Home,
+	}
+
+	h := privileged.New(true)
+	defer h.Close()
+
+	mgr := restore.NewManager()
+	mgr.SetHelper(h)
+	if f.dryRun {
+		fmt.Fprintln(stdout, "DRY RUN - no changes will be made")
+		plan, err := mgr.Plan(opts)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego load: %v\n", err)
+			return ExitFailure
+		}
+		fmt.Fprintln(stdout, "Plan:")
+		for _, line := range plan.Lines() {
+			fmt.Fprintf(stdout, "  %s\n", line)
+		}
+	}
+
+	defer func() {
+		printSnapshot(mgr.Snapshot())
+		printUndoHint(mgr.Journal())
+	}()
+	results, err := mgr.RunRestore(opts, func(p restore.RestoreProgress) {
+		if p.InProgress {
+			fmt.Fprintf(stdout, "[%d/%d] %s\n", p.CurrentStep, p.TotalSteps, p.CurrentName)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "rego load: %v\n", err)
+		return ExitFailure
+	}
+
+	return printResults(results)
+}
+
+// loadFull restores an extracted Full Save archive
+func loadFull(dir string, f loadFlags) int {
+	r, err := restore.NewFullRestoreFromDir(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego load: %v\n", err)
+		return ExitFailure
+	}
+	printRejected(r.Manifest().Rejected)
+	r.SetMerge(f.merge)
+	r.SetPinFlatpaks(f.pin)
+	r.SetSource(f.source)
+	r.SetSkipSnapshot(f.noSnapshot)
+	r.SetProgress(printProgress())
+	h := privileged.New(true)
+	defer h.Close()
+	r.SetHelper(h)
+	r.SetTarget(f.target)
+
+	wanted := map[restore.RestoreType]bool{
+		restore.RestoreTypeFlatpak:         f.flatpaks,
+		restore.RestoreTypePackages:        f.packages,
+		restore.RestoreTypeZypperRepos:     f.repos,
+		restore.RestoreTypeAPTSources:      f.repos,
+		restore.RestoreTypeGnomeExtensions: f.extensions,
+		restore.RestoreTypeGnomeSettings:   f.settings,
+		restore.RestoreTypeDotfiles:        f.dotfiles,
+		restore.RestoreTypeFonts:           f.fonts,
+		restore.RestoreTypeSSH:             f.ssh,
+		restore.RestoreTypeAutostart:       f.autostart,
+		restore.RestoreTypeBackgrounds:     f.backgrounds,
+		restore.RestoreTypeThemes:          f.themes,
+		restore.RestoreTypeKDEConfig:       f.kde,
+		restore.RestoreTypeKDEData:         f.kde,
+	}
+	var sections []restore.RestoreType
+	for _, s := range r.Sections() {
+		if wanted[s] {
+			sections = append(sections, s)
+		}
+	}
+
+	if f.dryRun {
+		fmt.Fprintln(stdout, "DRY RUN - no changes will be made")
+	}
+	code := printResults(r.Restore(sections, f.dryRun))
+	printSnapshot(r.Snapshot())
+	printUndoHint(r.Journal())
+	return code
+}
+
+// printProgress prints each component as it starts and each command as it
+// runs, so a long install shows what it is waiting on
+func printProgress() utils.ProgressFunc {
+	var component, command string
+	return func(ev utils.ProgressEvent) {
+		if ev.Component != component {
+			component = ev.Component
+			if ev.Steps > 0 {
+				fmt.Fprintf(stdout, "[%d/%d] %s\n", min(ev.Step+1, ev.Steps), ev.Steps, component)
+			} else {
+				fmt.Fprintln(stdout, component)
+			}
+		}
+		if ev.Command != command {
+			command = ev.Command
+			if command != "" {
+				fmt.Fprintf(stdout, "    $ %s\n", command)
+			}
+		}
+	}
+}
+
+// printSnapshot says where the pre-restore snapshot was saved, if any
+func printSnapshot(s *restore.Snapshot) {
+	if path := s.Path(); path != "" {
+		fmt.Fprintf(stdout, "Pre-restore snapshot saved to %s\n", path)
+	}
+}
+
+func printResults(results []restore.RestoreResult) int {
+	code := ExitOK
+	for _, r := range results {
+		status := "ok"
+		if !r.Success {
+			status = "FAILED"
+			code = ExitPartial
+		}
+		fmt.Fprintf(stdout, "  %-18s %d/%d items %s\n", r.Type, r.ItemsSuccess, r.ItemsTotal, status)
+		if r.ItemsMerged > 0 || r.ItemsConflicted > 0 {
+			fmt.Fprintf(stdout, "    %d merged, %d with conflicts\n", r.ItemsMerged, r.ItemsConflicted)
+		}
+		for _, path := range r.Conflicts {
+			fmt.Fprintf(stderr, "    ~/%s has conflict markers to resolve\n", path)
+		}
+		printFailedItems(r)
+		for _, e := range r.Errors {
+			fmt.Fprintf(stderr, "    %s\n", e)
+		}
+	}
+	return code
+}
+
+// printRejected lists the entries of a backup that failed validation and
+// were left out of the restore
+func printRejected(rejected []backup.Rejection) {
+	if len(rejected) == 0 {
+		return
+	}
+	fmt.Fprintf(stderr, "Left out %d entries that failed validation:\n", len(rejected))
+	for _, r := range rejected {
+		fmt.Fprintf(stderr, "  %s\n", r)
+	}
+}
+
+// printFailedItems lists the items of r that were not restored and why
+func printFailedItems(r restore.RestoreResult) {
+	for _, item := range r.FailedItems() {
+		fmt.Fprintf(stderr, "    %s %s: %s\n", item.Status, item.Name, item.Reason)
+	}
+}
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("rego check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passFile := fs.String("passphrase-file", "", "read the passphrase of an encrypted backup from this file instead of $"+passphraseEnv)
+	path, code := parseWithPath(fs, args)
+	if code != ExitOK {
+		return code
+	}
+	passphrase, err := readPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego check: %v\n", err)
+		return ExitFailure
+	}
+
+	if strings.HasSuffix(path, ".json") {
+		b, err := backup.LoadLightBackupWithPassphrase(path, passphrase)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego check: failed to read %s: %v\n", path, describeOpenError(err))
+			return ExitFailure
+		}
+		fmt.Fprintf(stdout, "Backup of %s (%s) from %s\n", b.Hostname, b.Distro, b.CreatedAt.Format("2006-01-02 15:04"))
+		printRejected(b.Rejected)
+
+		c := backup.CheckRestore(b)
+		printCheck("Flatpaks to install", c.FlatpaksToInstall, c.FlatpaksSkipped)
+		printCheck("RPM packages to install", c.RPMToInstall, c.RPMSkipped)
+		printCheck("APT packages to install", c.APTToInstall, c.APTSkipped)
+		printCheck("Pacman packages to install", c.PacmanToInstall, c.PacmanSkipped)
+		printCheck("AUR packages to install", c.AURToInstall, c.AURSkipped)
+		var aliases []string
+		for _, repo := range c.ZypperReposToAdd {
+			aliases = append(aliases, repo.Alias)
+		}
+		printCheck("Zypper repositories to add", aliases, c.ZypperReposSkipped)
+		printCheck("Zypper packages to install", c.ZypperToInstall, c.ZypperSkipped)
+		printCheck("Extensions to enable", c.ExtensionsToEnable, c.ExtensionsSkipped)
+		if c.HasDconfSettings {
+			fmt.Fprintln(stdout, "Dconf settings: present")
+		}
+		return ExitOK
+	}
+
+	dir := path
+	if !utils.DirExists(path) {
+		var cleanup func()
+		var full bool
+		dir, full, cleanup, err = importArchive(path, passphrase)
+		if err != nil {
+			fmt.Fprintf(stderr, "rego check: %v\n", describeOpenError(err))
+			return ExitFailure
+		}
+		defer cleanup()
+		if full {
+			return checkFull(dir)
+		}
+	}
+
+	mgr := restore.NewManager()
+	if _, err := mgr.LoadBackup(dir); err != nil {
+		fmt.Fprintf(stderr, "rego check: %v\n", err)
+		return ExitFailure
+	}
+	preview := mgr.PreviewRestore(dir)
+	var types []string
+	for t := range preview {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		items := preview[restore.RestoreType(t)]
+		fmt.Fprintf(stdout, "%s (%d):\n", restore.RestoreTypeName(restore.RestoreType(t)), len(items))
+		for _, item := range items {
+			fmt.Fprintf(stdout, "  %s\n", item)
+		}
+	}
+	return ExitOK
+}
+
+// checkFull prints the sections of an extracted Full Save archive
+func checkFull(dir string) int {
+	r, err := restore.NewFullRestoreFromDir(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego check: %v\n", err)
+		return ExitFailure
+	}
+	m := r.Manifest()
+	fmt.Fprintf(stdout, "Full Save of %s from %s\n", m.Hostname, m.CreatedAt.Format("2006-01-02 15:04"))
+	printRejected(m.Rejected)
+	if p := r.Packages(); p != nil {
+		printRejected(p.Rejected)
+	}
+	for _, s := range r.Sections() {
+		items, _ := r.Preview(s)
+		fmt.Fprintf(stdout, "%s (%d):\n", restore.RestoreTypeName(s), len(items))
+		for _, item := range items {
+			fmt.Fprintf(stdout, "  %s\n", item)
+		}
+	}
+	return ExitOK
+}
+
+func printCheck(label string, items []string, skipped int) {
+	if len(items) == 0 && skipped == 0 {
+		return
+	}
+	fmt.Fprintf(stdout, "%s: %d (%d already installed)\n", label, len(items), skipped)
+	for _, item := range items {
+		fmt.Fprintf(stdout, "  %s\n", item)
+	}
+}
+
+// importArchive extracts a backup archive into a temporary directory and
+// reports whether it is a Full Save rather than an exported component backup
+func importArchive(path, passphrase string) (string, bool, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "rego-load-*")
+	if err != nil {
+		return "", false, nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	exporter := backup.NewExporter()
+	exporter.SetPassphrase(passphrase)
+	if err := exporter.ImportFromFile(path, tmpDir); err != nil {
+		cleanup()
+		return "", false, nil, err
+	}
+
+	manifest, err := backup.NewManager().LoadBackup(tmpDir)
+	if err != nil {
+		cleanup()
+		return "", false, nil, err
+	}
+
+	return tmpDir, len(manifest.Components) == 0, cleanup, nil
+}
+
+// parseWithPath parses flags that may appear before or after a single
+// positional path argument
+func parseWithPath(fs *flag.FlagSet, args []string) (string, int) {
+	var path string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", ExitUsage
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return "", ExitUsage
+		}
+	}
+	if path == "" || fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "Usage: %s <path> [flags]\n", fs.Name())
+		return "", ExitUsage
+	}
+	return path, ExitOK
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
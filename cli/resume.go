@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/r8bert/rego/internal/restore"
+)
+
+// runResume finishes the Quick Save restore that was cut short, skipping
+// what it restored and retrying what failed
+func runResume(args []string) int {
+	fs := flag.NewFlagSet("rego resume", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	discard := fs.Bool("discard", false, "forget the interrupted restore instead of resuming it")
+	passFile := fs.String("passphrase-file", "", "read the passphrase of an encrypted backup from this file instead of $"+passphraseEnv)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "rego resume: takes no arguments")
+		return ExitUsage
+	}
+
+	state, err := restore.LoadResumeState()
+	if errors.Is(err, restore.ErrNoResumeState) {
+		fmt.Fprintln(stdout, "Nothing to resume")
+		return ExitOK
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "rego resume: %v\n", err)
+		return ExitFailure
+	}
+
+	if *discard {
+		if err := state.Discard(); err != nil {
+			fmt.Fprintf(stderr, "rego resume: %v\n", err)
+			return ExitFailure
+		}
+		fmt.Fprintf(stdout, "Forgot the interrupted restore of %s\n", state.Source)
+		return ExitOK
+	}
+
+	passphrase, err := readPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego resume: %v\n", err)
+		return ExitFailure
+	}
+
+	// Restore the same components as the interrupted run
+	has := func(t restore.RestoreType) bool { return slices.Contains(state.Components, t) }
+	f := loadFlags{
+		flatpaks:   has(restore.RestoreTypeFlatpak),
+		packages:   has(restore.RestoreTypePackages),
+		repos:      has(restore.RestoreTypeZypperRepos),
+		extensions: has(restore.RestoreTypeGnomeExtensions),
+		settings:   has(restore.RestoreTypeGnomeSettings),
+		passphrase: passphrase,
+		source:     state.Source,
+		target:     state.Target,
+		pin:        state.PinFlatpaks,
+	}
+	if !f.target.Live() {
+		fmt.Fprintf(stdout, "Restoring into %s\n", f.target)
+	}
+	return loadQuick(state.Source, f)
+}
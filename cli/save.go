@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+func runSave(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "Usage: rego save quick|full [flags]")
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "quick":
+		return runSaveQuick(args[1:])
+	case "full":
+		return runSaveFull(args[1:])
+	}
+
+	fmt.Fprintf(stderr, "rego save: unknown backup type %q (want quick or full)\n", args[0])
+	return ExitUsage
+}
+
+// runSaveQuick creates a Quick Save, with one flag per LightBackupOptions field
+func runSaveQuick(args []string) int {
+	defaults := backup.DefaultLightBackupOptions()
+	fs := flag.NewFlagSet("rego save quick", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", backup.GetDefaultLightBackupPath(), "path of the .json file to write")
+	flatpaks := fs.Bool("flatpaks", defaults.Flatpaks, "include Flatpak applications")
+	packages := fs.Bool("packages", defaults.RPM, "include user-installed system packages")
+	extensions := fs.Bool("extensions", defaults.Extensions, "include GNOME extensions")
+	settings := fs.Bool("settings", defaults.Settings, "include GNOME dconf settings")
+	kde := fs.Bool("kde", defaults.KDE, "include KDE Plasma widgets")
+	repos := fs.Bool("repos", defaults.Repos, "include third-party repositories")
+	encrypt := fs.Bool("encrypt", false, "encrypt the file with a passphrase")
+	passFile := fs.String("passphrase-file", "", "read the passphrase from this file instead of $"+passphraseEnv)
+	sourceRoot, sourceHome := sourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "rego save quick: unexpected argument %q\n", fs.Arg(0))
+		return ExitUsage
+	}
+	passphrase, code := savePassphrase("rego save quick", *encrypt, *passFile)
+	if code != ExitOK {
+		return code
+	}
+	source, code := saveSource("rego save quick", *sourceRoot, *sourceHome)
+	if code != ExitOK {
+		return code
+	}
+
+	opts := backup.LightBackupOptions{
+		Flatpaks:   *flatpaks,
+		RPM:        *packages,
+		Extensions: *extensions,
+		Settings:   *settings,
+		KDE:        *kde,
+		Repos:      *repos,
+		Source:     source,
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	b, err := backup.CreateLightBackupWithOptions(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego save quick: %v\n", err)
+		return ExitFailure
+	}
+	if err := b.SaveToFileWithPassphrase(*output, passphrase); err != nil {
+		fmt.Fprintf(stderr, "rego save quick: failed to write %s: %v\n", *output, err)
+		return ExitFailure
+	}
+
+	fmt.Fprintf(stdout, "Saved %s\n", *output)
+	printStats(b.Stats())
+	return ExitOK
+}
+
+// runSaveFull creates a Full Save, with one flag per FullBackupOptions field
+func runSaveFull(args []string) int {
+	defaults := backup.DefaultFullBackupOptions()
+	fs := flag.NewFlagSet("rego save full", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", backup.GetDefaultFullBackupPath(), "path of the .tar.gz archive to write")
+	flatpaks := fs.Bool("flatpaks", defaults.Flatpaks, "include Flatpak applications")
+	rpm := fs.Bool("packages", defaults.RPM, "include user-installed system packages")
+	repos := fs.Bool("repos", defaults.Repos, "include third-party repositories")
+	extensions := fs.Bool("extensions", defaults.Extensions, "include GNOME extensions")
+	settings := fs.Bool("settings", defaults.Settings, "include GNOME dconf settings")
+	kdeConfig := fs.Bool("kde-config", defaults.KDEConfig, "include KDE Plasma config files")
+	kdeData := fs.Bool("kde-data", defaults.KDEData, "include KDE themes, widgets and colors")
+	dotfiles := fs.Bool("dotfiles", defaults.Dotfiles, "include dotfiles")
+	fonts := fs.Bool("fonts", defaults.Fonts, "include user fonts")
+	ssh := fs.Bool("ssh", defaults.SSHConfig, "include ~/.ssh/config and known_hosts (never keys)")
+	autostart := fs.Bool("autostart", defaults.Autostart, "include autostart entries")
+	backgrounds := fs.Bool("backgrounds", defaults.Backgrounds, "include wallpapers")
+	themes := fs.Bool("themes", defaults.Themes, "include GTK themes and icons")
+	packageCache := fs.Bool("package-cache", defaults.PackageCache, "download the packages and Flatpaks into the archive for restoring without a network")
+	followSymlinks := fs.Bool("follow-symlinks", defaults.FollowSymlinks, "save what symlinks point to instead of the links")
+	encrypt := fs.Bool("encrypt", false, "encrypt the archive with a passphrase")
+	passFile := fs.String("passphrase-file", "", "read the passphrase from this file instead of $"+passphraseEnv)
+	sourceRoot, sourceHome := sourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "rego save full: unexpected argument %q\n", fs.Arg(0))
+		return ExitUsage
+	}
+	passphrase, code := savePassphrase("rego save full", *encrypt, *passFile)
+	if code != ExitOK {
+		return code
+	}
+	source, code := saveSource("rego save full", *sourceRoot, *sourceHome)
+	if code != ExitOK {
+		return code
+	}
+	if *packageCache && !source.LiveSystem() {
+		fmt.Fprintln(stderr, "rego save full: --package-cache downloads from this system's repositories, so it can't be used with --source-root")
+		return ExitUsage
+	}
+
+	opts := backup.FullBackupOptions{
+		Flatpaks:       *flatpaks,
+		RPM:            *rpm,
+		Repos:          *repos,
+		Extensions:     *extensions,
+		Settings:       *settings,
+		KDEConfig:      *kdeConfig,
+		KDEData:        *kdeData,
+		Dotfiles:       *dotfiles,
+		Fonts:          *fonts,
+		SSHConfig:      *ssh,
+		Autostart:      *autostart,
+		Backgrounds:    *backgrounds,
+		Themes:         *themes,
+		PackageCache:   *packageCache,
+		Source:         source,
+		Passphrase:     passphrase,
+		FollowSymlinks: *followSymlinks,
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	stats, err := backup.CreateFullBackup(ctx, opts, *output)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego save full: %v\n", err)
+		return ExitFailure
+	}
+
+	size := ""
+	if info, err := os.Stat(*output); err == nil {
+		size = " (" + formatSize(info.Size()) + ")"
+	}
+	fmt.Fprintf(stdout, "Saved %s%s\n", *output, size)
+	printStats(stats)
+	return ExitOK
+}
+
+// sourceFlags adds the flags that back up a system other than this one
+func sourceFlags(fs *flag.FlagSet) (root, home *string) {
+	root = fs.String("source-root", "", "back up the root filesystem mounted here, such as the disk of a system that won't boot")
+	home = fs.String("source-home", "", "back up files and settings from this home directory (default: your home inside --source-root)")
+	return root, home
+}
+
+// saveSource returns the source the --source flags name
+func saveSource(cmd, root, home string) (backup.Source, int) {
+	source, err := backup.NewSource(root, home)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return backup.Source{}, ExitUsage
+	}
+	if !source.Live() {
+		fmt.Fprintf(stdout, "Backing up %s\n", source)
+		if dir, err := source.HomeDir(); err == nil && !utils.DirExists(dir) {
+			fmt.Fprintf(stderr, "%s: warning: home directory %s does not exist; pass --source-home\n", cmd, dir)
+		}
+	}
+	return source, ExitOK
+}
+
+// savePassphrase returns the passphrase to encrypt with, or "" when
+// encryption was not asked for
+func savePassphrase(cmd string, encrypt bool, file string) (string, int) {
+	if !encrypt {
+		if file != "" {
+			fmt.Fprintf(stderr, "%s: --passphrase-file needs --encrypt\n", cmd)
+			return "", ExitUsage
+		}
+		return "", ExitOK
+	}
+
+	passphrase, err := readPassphrase(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return "", ExitFailure
+	}
+	if passphrase == "" {
+		fmt.Fprintf(stderr, "%s: --encrypt needs a passphrase from --passphrase-file or $%s\n", cmd, passphraseEnv)
+		return "", ExitUsage
+	}
+	return passphrase, ExitOK
+}
+
+func printStats(stats map[string]int) {
+	for _, key := range sortedKeys(stats) {
+		if stats[key] > 0 {
+			fmt.Fprintf(stdout, "  %-12s %d\n", key, stats[key])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/restore"
+)
+
+// runUndo reverts the last restore, fully or only some of its components
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("rego undo", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	list := fs.Bool("list", false, "show what undo would revert without changing anything")
+	only := fs.String("only", "", "comma-separated components to revert, e.g. dotfiles,gnome_settings")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "rego undo: takes no arguments")
+		return ExitUsage
+	}
+
+	j, err := restore.LastJournal()
+	if errors.Is(err, restore.ErrNoJournal) {
+		fmt.Fprintln(stdout, "Nothing to undo")
+		return ExitOK
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "rego undo: %v\n", err)
+		return ExitFailure
+	}
+
+	pending := j.Pending()
+	var components []restore.RestoreType
+	if *only != "" {
+		for _, name := range strings.Split(*only, ",") {
+			c := restore.RestoreType(strings.TrimSpace(name))
+			if !slices.Contains(pending, c) {
+				fmt.Fprintf(stderr, "rego undo: nothing to undo for %q (have: %s)\n", c, joinTypes(pending))
+				return ExitUsage
+			}
+			components = append(components, c)
+		}
+	}
+
+	fmt.Fprintf(stdout, "Restore of %s from %s\n", j.Source, j.CreatedAt.Format("2006-01-02 15:04"))
+	if !j.Target.Live() {
+		fmt.Fprintf(stdout, "Restored into %s\n", j.Target)
+	}
+	if *list {
+		for _, c := range pending {
+			fmt.Fprintf(stdout, "%s (%s):\n", restore.RestoreTypeName(c), c)
+			for _, line := range j.Changes(c) {
+				fmt.Fprintf(stdout, "  %s\n", line)
+			}
+		}
+		return ExitOK
+	}
+
+	h := privileged.New(true)
+	defer h.Close()
+	j.SetHelper(h)
+	return printResults(j.Undo(components))
+}
+
+// printUndoHint tells how to revert a restore that changed something
+func printUndoHint(j *restore.Journal) {
+	if len(j.Pending()) > 0 {
+		fmt.Fprintln(stdout, `Run "rego undo" to revert this restore`)
+	}
+}
+
+func joinTypes(types []restore.RestoreType) string {
+	var names []string
+	for _, t := range types {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, ", ")
+}
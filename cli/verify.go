@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// runVerify checks a backup directory or archive against the checksums in
+// its manifest without restoring anything
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("rego verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passFile := fs.String("passphrase-file", "", "read the passphrase of an encrypted backup from this file instead of $"+passphraseEnv)
+	path, code := parseWithPath(fs, args)
+	if code != ExitOK {
+		return code
+	}
+	passphrase, err := readPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "rego verify: %v\n", err)
+		return ExitFailure
+	}
+
+	var report *backup.VerifyReport
+	switch {
+	case utils.DirExists(path):
+		report, err = backup.VerifyBackupDir(path)
+	case strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		report, err = backup.VerifyArchive(path, passphrase)
+	default:
+		fmt.Fprintf(stderr, "rego verify: %s is not a .tar.gz or backup directory\n", path)
+		return ExitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "rego verify: %v\n", describeOpenError(err))
+		return ExitFailure
+	}
+
+	if !printVerify(report) {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// printVerify prints a verification report and reports whether it passed
+func printVerify(report *backup.VerifyReport) bool {
+	if report.OK() {
+		fmt.Fprintf(stdout, "OK: %d files verified\n", report.Checked)
+		return true
+	}
+	fmt.Fprintf(stdout, "FAILED: %d files verified, %d problems\n", report.Checked, len(report.Problems()))
+	for _, line := range report.Problems() {
+		fmt.Fprintf(stdout, "  %s\n", line)
+	}
+	return false
+}
+
+// verifyExtracted checks an extracted backup before it is restored. Backups
+// made before checksums were recorded are let through.
+func verifyExtracted(cmd, dir string) bool {
+	report, err := backup.VerifyBackupDir(dir)
+	if errors.Is(err, backup.ErrNoChecksums) {
+		return true
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return false
+	}
+	if report.OK() {
+		return true
+	}
+	fmt.Fprintf(stderr, "%s: backup failed verification, nothing was restored\n", cmd)
+	for _, line := range report.Problems() {
+		fmt.Fprintf(stderr, "  %s\n", line)
+	}
+	return false
+}
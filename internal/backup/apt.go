@@ -0,0 +1,374 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// APTBackup handles apt package backup for Debian/Ubuntu
+type APTBackup struct {
+	root string
+}
+
+func NewAPTBackup() *APTBackup { return &APTBackup{} }
+
+// SetRoot reads the packages of the root filesystem mounted at root
+// instead of this system's
+func (a *APTBackup) SetRoot(root string) { a.root = root }
+
+// Name returns the display name
+func (a *APTBackup) Name() string {
+	return "APT Packages"
+}
+
+// Type returns the backup type
+func (a *APTBackup) Type() BackupType {
+	return BackupTypeAPT
+}
+
+// Available checks if this is an apt-based system
+func (a *APTBackup) Available() bool {
+	return utils.CommandExists("apt-mark") && utils.FileExists("/etc/debian_version")
+}
+
+// List returns manually installed packages
+func (a *APTBackup) List(ctx context.Context) ([]BackupItem, error) {
+	packages, err := a.ListUserInstalled(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []BackupItem
+	for _, pkg := range packages {
+		items = append(items, BackupItem{
+			Name: pkg,
+			Type: BackupTypeAPT,
+		})
+	}
+	return items, nil
+}
+
+// ListUserInstalled returns manually installed packages
+func (a *APTBackup) ListUserInstalled(ctx context.Context) ([]string, error) {
+	if a.root != "" {
+		return a.listOffline(ctx)
+	}
+
+	// apt-mark showmanual lists manually installed packages
+	result := utils.RunCommandContext(ctx, "apt-mark", "showmanual")
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var packages []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !a.isBasePackage(line) {
+			packages = append(packages, line)
+		}
+	}
+	return packages, nil
+}
+
+// listOffline returns what apt-mark showmanual would on the system at
+// a.root: the packages its dpkg database has installed that apt did not
+// record as pulled in automatically
+func (a *APTBackup) listOffline(ctx context.Context) ([]string, error) {
+	auto := make(map[string]bool)
+	if content, err := os.ReadFile(filepath.Join(a.root, "var", "lib", "apt", "extended_states")); err == nil {
+		// Stanzas of "Package: name" followed by "Auto-Installed: 1" or 0
+		var pkg string
+		for _, line := range strings.Split(string(content), "\n") {
+			key, value, _ := strings.Cut(line, ":")
+			switch strings.TrimSpace(key) {
+			case "Package":
+				pkg = strings.TrimSpace(value)
+			case "Auto-Installed":
+				if strings.TrimSpace(value) == "1" {
+					auto[pkg] = true
+				}
+			}
+		}
+	}
+
+	result := utils.RunCommandContext(ctx, "dpkg-query", "--admindir="+filepath.Join(a.root, "var", "lib", "dpkg"),
+		"-W", "-f=${db:Status-Abbrev} ${Package}\n")
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var packages []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		status, pkg, ok := strings.Cut(strings.TrimSpace(line), " ")
+		pkg = strings.TrimSpace(pkg)
+		if ok && status == "ii" && !auto[pkg] && !a.isBasePackage(pkg) {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// isBasePackage filters out base system packages
+func (a *APTBackup) isBasePackage(pkg string) bool {
+	base := map[string]bool{
+		"apt": true, "dpkg": true, "base-files": true, "init": true,
+		"systemd": true, "libc6": true, "bash": true, "coreutils": true,
+		"ubuntu-minimal": true, "ubuntu-standard": true, "ubuntu-desktop": true,
+		"debian-archive-keyring": true, "ubuntu-keyring": true,
+	}
+	return base[pkg]
+}
+
+// APTData represents the backup data structure
+type APTData struct {
+	Packages      []BackupItem `json:"packages"`
+	PackageCount  int          `json:"package_count"`
+	PackageMethod string       `json:"package_method"` // "apt_showmanual"
+}
+
+// Backup performs the apt package backup
+func (a *APTBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
+	result := BackupResult{
+		Type:      BackupTypeAPT,
+		Timestamp: time.Now(),
+	}
+
+	packages, err := a.List(ctx)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	data := APTData{
+		Packages:      packages,
+		PackageCount:  len(packages),
+		PackageMethod: "apt_showmanual",
+	}
+
+	filePath := filepath.Join(backupDir, "apt_packages.json")
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := utils.WriteFile(filePath, jsonData); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	result.Success = true
+	result.Items = packages
+	result.ItemCount = len(packages)
+	result.FilePath = filePath
+
+	return result, nil
+}
+
+// APTSourcesBackup handles APT source list and signing key backup
+type APTSourcesBackup struct {
+	root string
+}
+
+// NewAPTSourcesBackup creates a new APTSourcesBackup instance
+func NewAPTSourcesBackup() *APTSourcesBackup {
+	return &APTSourcesBackup{}
+}
+
+// SetRoot copies the sources and keys of the root filesystem mounted at
+// root. They are still recorded by their path on that system.
+func (a *APTSourcesBackup) SetRoot(root string) {
+	a.root = root
+}
+
+// Name returns the display name
+func (a *APTSourcesBackup) Name() string {
+	return "APT Sources"
+}
+
+// Type returns the backup type
+func (a *APTSourcesBackup) Type() BackupType {
+	return BackupTypeAPTSources
+}
+
+// Available checks if the apt config directory exists
+func (a *APTSourcesBackup) Available() bool {
+	return utils.DirExists(a.path("/etc/apt")) && utils.FileExists(a.path("/etc/debian_version"))
+}
+
+// path returns where the system path p is read from
+func (a *APTSourcesBackup) path(p string) string {
+	return filepath.Join("/", a.root, p)
+}
+
+// APTSourcesData represents the backup data structure. Paths are absolute;
+// the copies live under apt-sources/ at the same path.
+type APTSourcesData struct {
+	Sources []string `json:"sources"`
+	Keys    []string `json:"keys"`
+}
+
+// List returns source files and signing keys
+func (a *APTSourcesBackup) List(ctx context.Context) ([]BackupItem, error) {
+	data := a.collect()
+
+	var items []BackupItem
+	for _, path := range data.Sources {
+		items = append(items, BackupItem{
+			Name:     path,
+			Type:     BackupTypeAPTSources,
+			Metadata: map[string]string{"kind": "source"},
+		})
+	}
+	for _, path := range data.Keys {
+		items = append(items, BackupItem{
+			Name:     path,
+			Type:     BackupTypeAPTSources,
+			Metadata: map[string]string{"kind": "key"},
+		})
+	}
+	return items, nil
+}
+
+// collect finds the source files (one-line .list and deb822 .sources) and the
+// keys they depend on: trusted.gpg.d, /etc/apt/keyrings, and any keyring a
+// source names with signed-by
+func (a *APTSourcesBackup) collect() APTSourcesData {
+	var data APTSourcesData
+
+	// glob returns the system paths that match pattern
+	glob := func(pattern string) []string {
+		files, _ := filepath.Glob(a.path(pattern))
+		for i, f := range files {
+			files[i] = filepath.Join("/", strings.TrimPrefix(f, a.root))
+		}
+		return files
+	}
+
+	if utils.FileExists(a.path("/etc/apt/sources.list")) {
+		data.Sources = append(data.Sources, "/etc/apt/sources.list")
+	}
+	for _, pattern := range []string{"*.list", "*.sources"} {
+		data.Sources = append(data.Sources, glob(filepath.Join("/etc/apt/sources.list.d", pattern))...)
+	}
+
+	seen := make(map[string]bool)
+	addKey := func(path string) {
+		if err := CheckAPTKeyPath(path); err != nil {
+			utils.Warn("Skipping apt keyring %s, which %v", path, err)
+			return
+		}
+		if !seen[path] && utils.FileExists(a.path(path)) {
+			seen[path] = true
+			data.Keys = append(data.Keys, path)
+		}
+	}
+
+	for _, dir := range []string{"/etc/apt/trusted.gpg.d", "/etc/apt/keyrings"} {
+		for _, f := range glob(filepath.Join(dir, "*")) {
+			addKey(f)
+		}
+	}
+	for _, source := range data.Sources {
+		for _, key := range signedByKeys(a.path(source)) {
+			addKey(key)
+		}
+	}
+
+	return data
+}
+
+// signedByKeys returns the keyring paths a source file references, from
+// [signed-by=...] options and deb822 Signed-By fields
+func signedByKeys(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var value string
+		if i := strings.Index(line, "signed-by="); i >= 0 {
+			value = line[i+len("signed-by="):]
+			if end := strings.IndexAny(value, " ]"); end >= 0 {
+				value = value[:end]
+			}
+		} else if strings.HasPrefix(strings.ToLower(line), "signed-by:") {
+			value = strings.TrimSpace(line[len("signed-by:"):])
+		}
+
+		// Signed-By may also hold an inline key, which needs no file
+		for _, key := range strings.Split(value, ",") {
+			if strings.HasPrefix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Backup performs the APT sources backup
+func (a *APTSourcesBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
+	result := BackupResult{
+		Type:      BackupTypeAPTSources,
+		Timestamp: time.Now(),
+	}
+
+	collected := a.collect()
+	filesDir := filepath.Join(backupDir, "apt-sources")
+
+	var data APTSourcesData
+	var items []BackupItem
+	copyAll := func(paths []string, kind string) []string {
+		var copied []string
+		for _, path := range paths {
+			if err := utils.CopyFile(a.path(path), filepath.Join(filesDir, path)); err != nil {
+				utils.Warn("Failed to copy %s: %v", path, err)
+				continue
+			}
+			copied = append(copied, path)
+			items = append(items, BackupItem{
+				Name:     path,
+				Type:     BackupTypeAPTSources,
+				Metadata: map[string]string{"kind": kind},
+			})
+		}
+		return copied
+	}
+	data.Sources = copyAll(collected.Sources, "source")
+	data.Keys = copyAll(collected.Keys, "key")
+
+	filePath := filepath.Join(backupDir, "apt_sources.json")
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := utils.WriteFile(filePath, jsonData); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	result.Success = true
+	result.Items = items
+	result.ItemCount = len(items)
+	result.FilePath = filePath
+
+	return result, nil
+}
@@ -1,80 +1,95 @@
 package backup
 
 import (
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
 // GetInstalledFlatpaks returns a list of currently installed Flatpak app IDs
 func GetInstalledFlatpaks() []string {
-	cmd := exec.Command("flatpak", "list", "--app", "--columns=application")
-	output, err := cmd.Output()
+	return outputLines(exec.Command("flatpak", "list", "--app", "--columns=application"))
+}
+
+// GetInstalledFlatpaksIn returns the Flatpak apps in the system
+// installation of the root filesystem mounted at root
+func GetInstalledFlatpaksIn(root string) []string {
+	env, cleanup, err := FlatpakInstallationEnv(root)
 	if err != nil {
 		return nil
 	}
-
-	var installed []string
-	for _, line := range strings.Split(string(output), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			installed = append(installed, line)
-		}
+	defer cleanup()
+	cmd := exec.Command("flatpak", "list", "--installation="+FlatpakInstallation, "--app", "--columns=application")
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
 	}
-	return installed
+	return outputLines(cmd)
 }
 
 // GetInstalledRPM returns a list of currently installed RPM package names
 func GetInstalledRPM() []string {
-	cmd := exec.Command("rpm", "-qa", "--qf", "%{NAME}\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil
-	}
+	return GetInstalledRPMIn("")
+}
 
-	var installed []string
-	for _, line := range strings.Split(string(output), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			installed = append(installed, line)
-		}
-	}
-	return installed
+// GetInstalledRPMIn returns the RPM packages installed in the root
+// filesystem mounted at root, or on this system if root is ""
+func GetInstalledRPMIn(root string) []string {
+	return outputLines(exec.Command("rpm", rpmRoot(root, "-qa", "--qf", "%{NAME}\n")...))
 }
 
 // GetInstalledAPT returns a list of currently installed APT package names
 func GetInstalledAPT() []string {
-	cmd := exec.Command("dpkg-query", "-W", "-f=${Package}\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil
+	return GetInstalledAPTIn("")
+}
+
+// GetInstalledAPTIn returns the Debian packages installed in the root
+// filesystem mounted at root, or on this system if root is ""
+func GetInstalledAPTIn(root string) []string {
+	args := []string{"-W", "-f=${Package}\n"}
+	if root != "" {
+		args = append([]string{"--admindir=" + filepath.Join(root, "var", "lib", "dpkg")}, args...)
 	}
+	return outputLines(exec.Command("dpkg-query", args...))
+}
 
-	var installed []string
-	for _, line := range strings.Split(string(output), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			installed = append(installed, line)
-		}
+// GetInstalledPacman returns a list of currently installed pacman package names
+func GetInstalledPacman() []string {
+	return GetInstalledPacmanIn("")
+}
+
+// GetInstalledPacmanIn returns the pacman packages installed in the root
+// filesystem mounted at root, or on this system if root is ""
+func GetInstalledPacmanIn(root string) []string {
+	args := []string{"-Qq"}
+	if root != "" {
+		args = append([]string{"--dbpath", filepath.Join(root, "var", "lib", "pacman")}, args...)
 	}
-	return installed
+	return outputLines(exec.Command("pacman", args...))
 }
 
 // GetInstalledGnomeExtensions returns a list of installed GNOME extension UUIDs
 func GetInstalledGnomeExtensions() []string {
-	cmd := exec.Command("gnome-extensions", "list")
+	return outputLines(exec.Command("gnome-extensions", "list"))
+}
+
+// outputLines runs cmd and returns the non-empty lines it prints, or nil
+// if it fails
+func outputLines(cmd *exec.Cmd) []string {
 	output, err := cmd.Output()
 	if err != nil {
 		return nil
 	}
 
-	var enabled []string
+	var lines []string
 	for _, line := range strings.Split(string(output), "\n") {
 		line = strings.TrimSpace(line)
 		if line != "" {
-			enabled = append(enabled, line)
+			lines = append(lines, line)
 		}
 	}
-	return enabled
+	return lines
 }
 
 // FilterMissing returns items from 'wanted' that are not in 'installed'
@@ -101,6 +116,14 @@ type RestoreCheck struct {
 	RPMSkipped         int
 	APTToInstall       []string
 	APTSkipped         int
+	PacmanToInstall    []string
+	PacmanSkipped      int
+	AURToInstall       []string
+	AURSkipped         int
+	ZypperToInstall    []string
+	ZypperSkipped      int
+	ZypperReposToAdd   []ZypperRepo
+	ZypperReposSkipped int
 	ExtensionsToEnable []string
 	ExtensionsSkipped  int
 	HasDconfSettings   bool
@@ -131,6 +154,37 @@ func CheckRestore(b *LightBackup) *RestoreCheck {
 		check.APTSkipped = len(b.APTPackages) - len(check.APTToInstall)
 	}
 
+	// Check pacman and AUR packages (both end up in the local pacman database)
+	if (len(b.PacmanPackages) > 0 || len(b.AURPackages) > 0) && DetectPackageManager() == PMPacman {
+		installed := GetInstalledPacman()
+		check.PacmanToInstall = FilterMissing(b.PacmanPackages, installed)
+		check.PacmanSkipped = len(b.PacmanPackages) - len(check.PacmanToInstall)
+		check.AURToInstall = FilterMissing(b.AURPackages, installed)
+		check.AURSkipped = len(b.AURPackages) - len(check.AURToInstall)
+	}
+
+	// Check zypper packages and repositories
+	if DetectPackageManager() == PMZypper {
+		if len(b.ZypperPackages) > 0 {
+			installed := GetInstalledRPM()
+			check.ZypperToInstall = FilterMissing(b.ZypperPackages, installed)
+			check.ZypperSkipped = len(b.ZypperPackages) - len(check.ZypperToInstall)
+		}
+		if len(b.ZypperRepos) > 0 {
+			existing := make(map[string]bool)
+			repos, _ := ListZypperRepos()
+			for _, repo := range repos {
+				existing[repo.Alias] = true
+			}
+			for _, repo := range b.ZypperRepos {
+				if !existing[repo.Alias] {
+					check.ZypperReposToAdd = append(check.ZypperReposToAdd, repo)
+				}
+			}
+			check.ZypperReposSkipped = len(b.ZypperRepos) - len(check.ZypperReposToAdd)
+		}
+	}
+
 	// Check GNOME extensions
 	if len(b.GnomeExtensions) > 0 {
 		installed := GetInstalledGnomeExtensions()
@@ -1,6 +1,8 @@
 package backup
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -12,6 +14,8 @@ import (
 // DotfilesBackup handles dotfiles backup
 type DotfilesBackup struct {
 	dotfiles []string
+	links    utils.SymlinkMode
+	home     string
 }
 
 // NewDotfilesBackup creates a new DotfilesBackup instance
@@ -43,17 +47,21 @@ func (d *DotfilesBackup) Available() bool {
 	return true
 }
 
-// DotfileInfo contains information about a dotfile
+// DotfileInfo contains information about a dotfile. A dotfile saved as a
+// symlink has LinkTarget set and no copy in the backup.
 type DotfileInfo struct {
-	Path         string `json:"path"`
-	RelativePath string `json:"relative_path"`
-	Size         int64  `json:"size"`
-	IsDir        bool   `json:"is_dir"`
-	Exists       bool   `json:"exists"`
+	Path         string      `json:"path"`
+	RelativePath string      `json:"relative_path"`
+	Size         int64       `json:"size"`
+	IsDir        bool        `json:"is_dir"`
+	Exists       bool        `json:"exists"`
+	LinkTarget   string      `json:"link_target,omitempty"`
+	Mode         os.FileMode `json:"mode,omitempty"`
+	ModTime      time.Time   `json:"mod_time"`
 }
 
 // List returns dotfiles that exist and can be backed up
-func (d *DotfilesBackup) List() ([]BackupItem, error) {
+func (d *DotfilesBackup) List(ctx context.Context) ([]BackupItem, error) {
 	home, err := utils.GetHomeDir()
 	if err != nil {
 		return nil, err
@@ -65,7 +73,10 @@ func (d *DotfilesBackup) List() ([]BackupItem, error) {
 
 		info, err := os.Stat(fullPath)
 		if os.IsNotExist(err) {
-			continue // Skip non-existent files
+			// A dangling symlink can still be saved as a link
+			if _, err := os.Lstat(fullPath); err != nil || d.links == utils.SymlinksFollow {
+				continue // Skip non-existent files
+			}
 		}
 
 		item := BackupItem{
@@ -75,7 +86,10 @@ func (d *DotfilesBackup) List() ([]BackupItem, error) {
 			Metadata:    make(map[string]string),
 		}
 
-		if info != nil {
+		if target, err := os.Readlink(fullPath); err == nil && d.links == utils.SymlinksPreserve {
+			item.Metadata["type"] = "symlink"
+			item.Metadata["target"] = target
+		} else if info != nil {
 			if info.IsDir() {
 				item.Metadata["type"] = "directory"
 			} else {
@@ -112,16 +126,57 @@ type DotfilesData struct {
 	Files      []DotfileInfo `json:"files"`
 	BackupDir  string        `json:"backup_dir"`
 	SourceHome string        `json:"source_home"`
+	// Skeleton holds the distro's version of each saved dotfile from
+	// /etc/skel, by relative path, so a restore can merge the user's
+	// changes into the new distro's version
+	Skeleton map[string]string `json:"skeleton,omitempty"`
+}
+
+// SkeletonDir is where the distro keeps the dotfiles new homes start with
+const SkeletonDir = "/etc/skel"
+
+// maxSkeletonSize is the largest skeleton file recorded
+const maxSkeletonSize = 1 << 20
+
+// SkeletonFiles returns the text of the skeleton version of each regular
+// file in files that has one
+func SkeletonFiles(files []DotfileInfo) map[string]string {
+	return SkeletonFilesIn("", files)
+}
+
+// SkeletonFilesIn returns the skeleton versions from the root filesystem
+// mounted at root, or from this system if root is ""
+func SkeletonFilesIn(root string, files []DotfileInfo) map[string]string {
+	skeleton := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir || file.LinkTarget != "" {
+			continue
+		}
+		path := filepath.Join("/", root, SkeletonDir, file.RelativePath)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxSkeletonSize {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(content, 0) >= 0 {
+			continue
+		}
+		skeleton[file.RelativePath] = string(content)
+	}
+	if len(skeleton) == 0 {
+		return nil
+	}
+	return skeleton
 }
 
 // Backup performs the dotfiles backup
-func (d *DotfilesBackup) Backup(backupDir string) (BackupResult, error) {
+func (d *DotfilesBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeDotfiles,
 		Timestamp: time.Now(),
 	}
 
-	home, err := utils.GetHomeDir()
+	home, err := d.homeDir()
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
@@ -133,40 +188,33 @@ func (d *DotfilesBackup) Backup(backupDir string) (BackupResult, error) {
 		return result, err
 	}
 
+	entries, err := d.Entries()
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
 	var files []DotfileInfo
 	var items []BackupItem
 
-	for _, dotfile := range d.dotfiles {
-		srcPath := filepath.Join(home, dotfile)
-		dstPath := filepath.Join(dotfilesDir, dotfile)
-
-		info, err := os.Stat(srcPath)
-		if os.IsNotExist(err) {
-			continue // Skip non-existent files
-		}
-
-		fileInfo := DotfileInfo{
-			Path:         srcPath,
-			RelativePath: dotfile,
-			Exists:       true,
-		}
-
-		if info.IsDir() {
-			fileInfo.IsDir = true
-			err = utils.CopyDir(srcPath, dstPath)
-		} else {
-			fileInfo.Size = info.Size()
-			err = utils.CopyFile(srcPath, dstPath)
-		}
-
-		if err != nil {
-			utils.Warn("Failed to backup %s: %v", dotfile, err)
-			continue
+	for _, fileInfo := range entries {
+		// A symlink kept as a link has nothing to copy
+		if fileInfo.LinkTarget == "" {
+			dstPath := filepath.Join(dotfilesDir, fileInfo.RelativePath)
+			if fileInfo.IsDir {
+				err = utils.CopyTree(fileInfo.Path, dstPath, d.links)
+			} else {
+				err = utils.CopyFile(fileInfo.Path, dstPath)
+			}
+			if err != nil {
+				utils.Warn("Failed to backup %s: %v", fileInfo.RelativePath, err)
+				continue
+			}
 		}
 
 		files = append(files, fileInfo)
 		items = append(items, BackupItem{
-			Name: dotfile,
+			Name: fileInfo.RelativePath,
 			Type: BackupTypeDotfiles,
 		})
 	}
@@ -175,6 +223,7 @@ func (d *DotfilesBackup) Backup(backupDir string) (BackupResult, error) {
 		Files:      files,
 		BackupDir:  dotfilesDir,
 		SourceHome: home,
+		Skeleton:   SkeletonFiles(files),
 	}
 
 	// Write metadata
@@ -198,11 +247,77 @@ func (d *DotfilesBackup) Backup(backupDir string) (BackupResult, error) {
 	return result, nil
 }
 
+// Entries returns what Backup saves for each dotfile that exists: its mode
+// and mtime, and either its size or, for a symlink kept as a link, its target
+func (d *DotfilesBackup) Entries() ([]DotfileInfo, error) {
+	home, err := d.homeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DotfileInfo
+	for _, dotfile := range d.dotfiles {
+		srcPath := filepath.Join(home, dotfile)
+
+		info, err := os.Lstat(srcPath)
+		if os.IsNotExist(err) {
+			continue // Skip non-existent files
+		}
+
+		fileInfo := DotfileInfo{
+			Path:         srcPath,
+			RelativePath: dotfile,
+			Exists:       true,
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 && d.links == utils.SymlinksPreserve {
+			// Only the link is saved, so restore recreates the same layout
+			// (e.g. a GNU stow tree) rather than a copy
+			if fileInfo.LinkTarget, err = os.Readlink(srcPath); err != nil {
+				utils.Warn("Failed to backup %s: %v", dotfile, err)
+				continue
+			}
+		} else if info, err = os.Stat(srcPath); err != nil {
+			utils.Warn("Failed to backup %s: %v", dotfile, err)
+			continue
+		} else if info.IsDir() {
+			fileInfo.IsDir = true
+		} else {
+			fileInfo.Size = info.Size()
+		}
+		fileInfo.Mode = info.Mode()
+		fileInfo.ModTime = info.ModTime()
+
+		entries = append(entries, fileInfo)
+	}
+	return entries, nil
+}
+
 // SetDotfiles updates the list of dotfiles to backup
 func (d *DotfilesBackup) SetDotfiles(files []string) {
 	d.dotfiles = files
 }
 
+// SetHome reads the dotfiles from home instead of the current user's home
+// directory
+func (d *DotfilesBackup) SetHome(home string) {
+	d.home = home
+}
+
+// homeDir returns the home directory the dotfiles are read from
+func (d *DotfilesBackup) homeDir() (string, error) {
+	if d.home != "" {
+		return d.home, nil
+	}
+	return utils.GetHomeDir()
+}
+
+// SetSymlinkMode sets whether symlinked dotfiles are saved as links or as
+// copies of what they point to
+func (d *DotfilesBackup) SetSymlinkMode(links utils.SymlinkMode) {
+	d.links = links
+}
+
 // GetDotfiles returns the current list of dotfiles
 func (d *DotfilesBackup) GetDotfiles() []string {
 	return d.dotfiles
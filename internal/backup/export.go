@@ -1,10 +1,8 @@
 package backup
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,10 +11,16 @@ import (
 )
 
 // Exporter handles creating portable backup archives
-type Exporter struct{}
+type Exporter struct {
+	passphrase string
+}
 
 func NewExporter() *Exporter { return &Exporter{} }
 
+// SetPassphrase sets the passphrase used to encrypt exported archives and
+// to decrypt encrypted ones on import
+func (e *Exporter) SetPassphrase(passphrase string) { e.passphrase = passphrase }
+
 // ExportToFile creates a single portable .tar.gz file from a backup
 func (e *Exporter) ExportToFile(backupDir, outputPath string) error {
 	// If no extension, add .tar.gz
@@ -24,57 +28,14 @@ func (e *Exporter) ExportToFile(backupDir, outputPath string) error {
 		outputPath += ".tar.gz"
 	}
 
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+	if err := createTarGz(backupDir, outputPath, e.passphrase); err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
 	}
-	defer outFile.Close()
-
-	gzWriter := gzip.NewWriter(outFile)
-	defer gzWriter.Close()
-
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
-
-	// Walk the backup directory and add all files
-	return filepath.Walk(backupDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Create header
-		header, err := tar.FileInfoHeader(info, info.Name())
-		if err != nil {
-			return err
-		}
-
-		// Update name to be relative to backup dir
-		relPath, err := filepath.Rel(backupDir, path)
-		if err != nil {
-			return err
-		}
-		header.Name = relPath
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
-		}
-
-		// If it's a file, write contents
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(tarWriter, file)
-			return err
-		}
-		return nil
-	})
+	return nil
 }
 
 // ExportQuick does a backup and immediately exports to a single file
-func (e *Exporter) ExportQuick(opts BackupOptions, outputPath string) error {
+func (e *Exporter) ExportQuick(ctx context.Context, opts BackupOptions, outputPath string) error {
 	// Create temp backup
 	tmpDir, err := os.MkdirTemp("", "rego-backup-*")
 	if err != nil {
@@ -84,7 +45,7 @@ func (e *Exporter) ExportQuick(opts BackupOptions, outputPath string) error {
 
 	opts.BackupPath = tmpDir
 	mgr := NewManager()
-	_, err = mgr.RunBackup(opts, nil)
+	_, err = mgr.RunBackup(ctx, opts, nil)
 	if err != nil {
 		return err
 	}
@@ -92,7 +53,8 @@ func (e *Exporter) ExportQuick(opts BackupOptions, outputPath string) error {
 	return e.ExportToFile(tmpDir, outputPath)
 }
 
-// ImportFromFile extracts a .tar.gz backup to a directory
+// ImportFromFile extracts a .tar.gz backup to a directory. Entries that
+// would land outside outputDir are rejected; see utils.ExtractTar.
 func (e *Exporter) ImportFromFile(archivePath, outputDir string) error {
 	file, err := os.Open(archivePath)
 	if err != nil {
@@ -100,46 +62,12 @@ func (e *Exporter) ImportFromFile(archivePath, outputDir string) error {
 	}
 	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	plain, err := utils.OpenMaybeEncrypted(file, e.passphrase)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
 	}
-	defer gzReader.Close()
-
-	tarReader := tar.NewReader(gzReader)
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		targetPath := filepath.Join(outputDir, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, 0755); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := utils.EnsureDir(filepath.Dir(targetPath)); err != nil {
-				return err
-			}
-			outFile, err := os.Create(targetPath)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				outFile.Close()
-				return err
-			}
-			outFile.Close()
-		}
-	}
-	return nil
+	return utils.ExtractTarGz(plain, outputDir, utils.DefaultExtractLimits())
 }
 
 // GetDefaultExportPath returns a suggested export filename
@@ -1,8 +1,12 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -33,49 +37,170 @@ func (f *FlatpakBackup) Available() bool {
 }
 
 // List returns all installed Flatpak applications
-func (f *FlatpakBackup) List() ([]BackupItem, error) {
-	// Get list of installed applications (not runtimes)
-	lines, err := utils.RunCommandLines("flatpak", "list", "--app", "--columns=application,name,branch,origin")
+func (f *FlatpakBackup) List(ctx context.Context) ([]BackupItem, error) {
+	apps, err := ListFlatpakDeployments(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var items []BackupItem
-	for _, line := range lines {
-		if line == "" {
-			continue
+	for _, app := range apps {
+		item := BackupItem{
+			Name:        app.ID,
+			Description: app.Name,
+			Type:        BackupTypeFlatpak,
+			Metadata: map[string]string{
+				"branch":       app.Branch,
+				"origin":       app.Origin,
+				"installation": app.Installation,
+				"commit":       app.Commit,
+				"runtime":      app.Runtime,
+			},
+		}
+		if len(app.Extensions) > 0 {
+			item.Metadata["extensions"] = strings.Join(app.Extensions, ",")
 		}
+		items = append(items, item)
+	}
 
+	return items, nil
+}
+
+// Installations a Flatpak app can be in. flatpak list reports any other
+// installation, one configured in /etc/flatpak/installations.d, by its name.
+const (
+	FlatpakSystem = "system"
+	FlatpakUser   = "user"
+)
+
+// FlatpakDeployment is how a Flatpak app is installed, so it can be put
+// back into the same installation from the same remote, and at the same
+// commit if asked to
+type FlatpakDeployment struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name,omitempty"`
+	Installation string   `json:"installation,omitempty"` // FlatpakSystem, FlatpakUser or another installation's name
+	Origin       string   `json:"origin,omitempty"`       // Remote it was installed from
+	Branch       string   `json:"branch,omitempty"`
+	Commit       string   `json:"commit,omitempty"`     // Commit that was deployed
+	Runtime      string   `json:"runtime,omitempty"`    // e.g. org.gnome.Platform/x86_64/46
+	Extensions   []string `json:"extensions,omitempty"` // Refs of the extensions installed for it
+}
+
+// Remote returns the remote to install d from, flathub unless one was recorded
+func (d FlatpakDeployment) Remote() string {
+	if d.Origin != "" {
+		return d.Origin
+	}
+	return "flathub"
+}
+
+// Ref returns d's app and branch as flatpak install and update take them
+func (d FlatpakDeployment) Ref() string {
+	if d.Branch == "" {
+		return d.ID
+	}
+	return d.ID + "//" + d.Branch
+}
+
+// InstallationFlag returns the flatpak option that selects d's
+// installation, the system one unless another was recorded
+func (d FlatpakDeployment) InstallationFlag() string {
+	switch d.Installation {
+	case "", FlatpakSystem:
+		return "--system"
+	case FlatpakUser:
+		return "--user"
+	}
+	return "--installation=" + d.Installation
+}
+
+// ListFlatpakDeployments returns the installed Flatpak apps and how each
+// is deployed
+func ListFlatpakDeployments(ctx context.Context) ([]FlatpakDeployment, error) {
+	// Without --app, runtimes are listed too, for finding the extensions
+	lines, err := utils.RunCommandLinesContext(ctx, "flatpak", "list", "--columns=ref,name,installation,origin,runtime")
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []FlatpakDeployment
+	var runtimes []flatpakRef
+	for _, line := range lines {
 		parts := strings.Split(line, "\t")
-		if len(parts) < 1 {
+		column := func(i int) string {
+			if i < len(parts) {
+				return strings.TrimSpace(parts[i])
+			}
+			return ""
+		}
+		ref, ok := parseFlatpakRef(column(0))
+		if !ok {
 			continue
 		}
-
-		appID := strings.TrimSpace(parts[0])
-		if appID == "" {
+		if ref.kind == "runtime" {
+			runtimes = append(runtimes, ref)
 			continue
 		}
+		apps = append(apps, FlatpakDeployment{
+			ID:           ref.id,
+			Name:         column(1),
+			Installation: column(2),
+			Origin:       column(3),
+			Branch:       ref.branch,
+			Runtime:      column(4),
+		})
+	}
 
-		item := BackupItem{
-			Name:     appID,
-			Type:     BackupTypeFlatpak,
-			Metadata: make(map[string]string),
+	for i, app := range apps {
+		apps[i].Extensions = flatpakExtensions(app, runtimes)
+		// flatpak list shortens the commit, info has all of it
+		result := utils.RunCommandContext(ctx, "flatpak", "info", "--show-commit", app.InstallationFlag(), app.ID, app.Branch)
+		if result.Error == nil {
+			apps[i].Commit = strings.TrimSpace(result.Stdout)
 		}
+	}
+	return apps, nil
+}
 
-		if len(parts) > 1 {
-			item.Description = strings.TrimSpace(parts[1])
-		}
-		if len(parts) > 2 {
-			item.Metadata["branch"] = strings.TrimSpace(parts[2])
-		}
-		if len(parts) > 3 {
-			item.Metadata["origin"] = strings.TrimSpace(parts[3])
-		}
+// flatpakRef is an installed app or runtime, kind/id/arch/branch
+type flatpakRef struct {
+	kind, id, arch, branch string
+}
 
-		items = append(items, item)
+// parseFlatpakRef parses a ref such as app/org.mozilla.firefox/x86_64/stable
+func parseFlatpakRef(s string) (flatpakRef, bool) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 4 || (parts[0] != "app" && parts[0] != "runtime") {
+		return flatpakRef{}, false
 	}
+	return flatpakRef{kind: parts[0], id: parts[1], arch: parts[2], branch: parts[3]}, true
+}
 
-	return items, nil
+func (r flatpakRef) String() string {
+	return strings.Join([]string{r.kind, r.id, r.arch, r.branch}, "/")
+}
+
+// flatpakExtensions returns the runtimes that extend app: those named after
+// it, such as org.gimp.GIMP.Plugin.GMic, and those named after its runtime
+// on the runtime's branch, such as org.freedesktop.Platform.ffmpeg-full.
+// Translations come with the app and debug info is not wanted, so .Locale
+// and .Debug extensions are left out as flatpak list leaves them out.
+func flatpakExtensions(app FlatpakDeployment, runtimes []flatpakRef) []string {
+	runtimeID, runtimeBranch := "", ""
+	if parts := strings.Split(app.Runtime, "/"); len(parts) == 3 {
+		runtimeID, runtimeBranch = parts[0], parts[2]
+	}
+	var extensions []string
+	for _, r := range runtimes {
+		if strings.HasSuffix(r.id, ".Locale") || strings.HasSuffix(r.id, ".Debug") || slices.Contains(extensions, r.String()) {
+			continue
+		}
+		if strings.HasPrefix(r.id, app.ID+".") || (runtimeID != "" && r.branch == runtimeBranch && strings.HasPrefix(r.id, runtimeID+".")) {
+			extensions = append(extensions, r.String())
+		}
+	}
+	return extensions
 }
 
 // FlatpakData represents the backup data structure
@@ -92,8 +217,8 @@ type FlatpakRemote struct {
 }
 
 // ListRemotes returns all configured Flatpak remotes
-func (f *FlatpakBackup) ListRemotes() ([]FlatpakRemote, error) {
-	lines, err := utils.RunCommandLines("flatpak", "remotes", "--columns=name,url,options")
+func (f *FlatpakBackup) ListRemotes(ctx context.Context) ([]FlatpakRemote, error) {
+	lines, err := utils.RunCommandLinesContext(ctx, "flatpak", "remotes", "--columns=name,url,options")
 	if err != nil {
 		return nil, err
 	}
@@ -125,21 +250,21 @@ func (f *FlatpakBackup) ListRemotes() ([]FlatpakRemote, error) {
 }
 
 // Backup performs the Flatpak backup
-func (f *FlatpakBackup) Backup(backupDir string) (BackupResult, error) {
+func (f *FlatpakBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeFlatpak,
 		Timestamp: time.Now(),
 	}
 
 	// Get applications
-	apps, err := f.List()
+	apps, err := f.List(ctx)
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
 	}
 
 	// Get remotes
-	remotes, err := f.ListRemotes()
+	remotes, err := f.ListRemotes(ctx)
 	if err != nil {
 		utils.Warn("Failed to list Flatpak remotes: %v", err)
 		// Continue anyway, apps are more important
@@ -170,3 +295,125 @@ func (f *FlatpakBackup) Backup(backupDir string) (BackupResult, error) {
 
 	return result, nil
 }
+
+// FlatpakInstallation is the name ReGo gives the system Flatpak
+// installation of another root filesystem, for flatpak --installation
+const FlatpakInstallation = "rego-target"
+
+// FlatpakInstallationEnv writes a Flatpak configuration in which the system
+// installation of the root filesystem mounted at root is FlatpakInstallation.
+// It returns the environment that makes flatpak read that configuration and
+// a func that removes it again.
+func FlatpakInstallationEnv(root string) (map[string]string, func(), error) {
+	dir, err := os.MkdirTemp("", "rego-flatpak-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	conf := fmt.Sprintf("[Installation \"%s\"]\nPath=%s\nDisplayName=%s\nStorageType=harddisk\n",
+		FlatpakInstallation, filepath.Join(root, "var", "lib", "flatpak"), root)
+	if err := utils.WriteFile(filepath.Join(dir, "installations.d", FlatpakInstallation+".conf"), []byte(conf)); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to configure the Flatpak installation of %s: %w", root, err)
+	}
+	return map[string]string{"FLATPAK_CONFIG_DIR": dir}, cleanup, nil
+}
+
+// ListFlatpakDeploymentsIn returns the apps deployed in the Flatpak
+// installation directories system and user, such as /var/lib/flatpak and
+// ~/.local/share/flatpak, without asking flatpak. An app is deployed when
+// it has a current version; one deployed in both is taken from system.
+func ListFlatpakDeploymentsIn(system, user string) []FlatpakDeployment {
+	seen := make(map[string]bool)
+	var apps []FlatpakDeployment
+	var runtimes []flatpakRef
+	for _, installation := range []struct{ dir, name string }{{system, FlatpakSystem}, {user, FlatpakUser}} {
+		runtimes = append(runtimes, deployedRefs(installation.dir, "runtime")...)
+		for _, ref := range deployedRefs(installation.dir, "app") {
+			if seen[ref.id] {
+				continue
+			}
+			seen[ref.id] = true
+			// active links to the deployed commit
+			active := filepath.Join(installation.dir, ref.kind, ref.id, ref.arch, ref.branch, "active")
+			commit, _ := os.Readlink(active)
+			apps = append(apps, FlatpakDeployment{
+				ID:           ref.id,
+				Installation: installation.name,
+				Origin:       flatpakOrigin(installation.dir, ref),
+				Branch:       ref.branch,
+				Commit:       commit,
+				Runtime:      flatpakRuntime(filepath.Join(active, "metadata")),
+			})
+		}
+	}
+	for i := range apps {
+		apps[i].Extensions = flatpakExtensions(apps[i], runtimes)
+	}
+	return apps
+}
+
+// deployedRefs returns the refs of kind, "app" or "runtime", deployed in
+// the installation directory dir: the current branch of each app, and each
+// branch of a runtime that has an active version
+func deployedRefs(dir, kind string) []flatpakRef {
+	entries, err := os.ReadDir(filepath.Join(dir, kind))
+	if err != nil {
+		return nil
+	}
+	var refs []flatpakRef
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		base := filepath.Join(dir, kind, entry.Name())
+		if kind == "app" {
+			// current links to the deployed arch/branch
+			current, err := os.Readlink(filepath.Join(base, "current"))
+			if arch, branch, ok := strings.Cut(current, "/"); err == nil && ok {
+				refs = append(refs, flatpakRef{kind: kind, id: entry.Name(), arch: arch, branch: branch})
+			}
+			continue
+		}
+		actives, _ := filepath.Glob(filepath.Join(base, "*", "*", "active"))
+		for _, active := range actives {
+			branch := filepath.Dir(active)
+			refs = append(refs, flatpakRef{kind: kind, id: entry.Name(), arch: filepath.Base(filepath.Dir(branch)), branch: filepath.Base(branch)})
+		}
+	}
+	return refs
+}
+
+// flatpakOrigin returns the remote the installation directory dir has ref
+// from, or "" if it can't tell
+func flatpakOrigin(dir string, ref flatpakRef) string {
+	remotes := filepath.Join(dir, "repo", "refs", "remotes")
+	matches, _ := filepath.Glob(filepath.Join(remotes, "*", ref.kind, ref.id, ref.arch, ref.branch))
+	if len(matches) == 0 {
+		return ""
+	}
+	rel, _ := filepath.Rel(remotes, matches[0])
+	remote, _, _ := strings.Cut(rel, string(filepath.Separator))
+	return remote
+}
+
+// flatpakRuntime returns the runtime an app's metadata file names
+func flatpakRuntime(metadata string) string {
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		return ""
+	}
+	group := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			group = line
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "runtime="); ok && group == "[Application]" {
+			return value
+		}
+	}
+	return ""
+}
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -54,7 +55,7 @@ type FontInfo struct {
 }
 
 // List returns font files in the user fonts directory
-func (f *FontsBackup) List() ([]BackupItem, error) {
+func (f *FontsBackup) List(ctx context.Context) ([]BackupItem, error) {
 	fontsDir, err := f.getUserFontsDir()
 	if err != nil {
 		return nil, err
@@ -159,7 +160,7 @@ type FontsData struct {
 }
 
 // Backup performs the fonts backup
-func (f *FontsBackup) Backup(backupDir string) (BackupResult, error) {
+func (f *FontsBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeFonts,
 		Timestamp: time.Now(),
@@ -3,11 +3,14 @@ package backup
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/r8bert/rego/internal/utils"
@@ -28,6 +31,22 @@ type FullBackupOptions struct {
 	Autostart   bool
 	Backgrounds bool
 	Themes      bool
+
+	// PackageCache downloads the saved packages and Flatpaks with their
+	// dependencies into the archive, so it can be restored without a
+	// network. Only the running system's repositories can be downloaded
+	// from, so it is ignored for any other Source.
+	PackageCache bool
+
+	// Source is the system and home directory files are read from
+	Source Source
+	// Passphrase encrypts the archive when set
+	Passphrase string
+	// FollowSymlinks saves what symlinks point to instead of the links
+	FollowSymlinks bool
+	// Progress, when set, receives an event for each section, file and
+	// command, with totals counted before the archive is written
+	Progress utils.ProgressFunc
 }
 
 // DefaultFullBackupOptions returns all options enabled
@@ -46,6 +65,49 @@ type FullBackupManifest struct {
 	Hostname  string         `json:"hostname"`
 	Stats     map[string]int `json:"stats"`
 	Included  []string       `json:"included"`
+	// Locations maps each top-level archive directory to the place it was
+	// copied from, relative to the home directory
+	Locations map[string]string `json:"locations,omitempty"`
+	// Checksums maps every other file in the archive to its SHA-256
+	Checksums map[string]string `json:"checksums,omitempty"`
+
+	// Rejected lists the locations left out when the manifest was loaded
+	// because they would lead out of the home directory
+	Rejected []Rejection `json:"-"`
+}
+
+// FullBackupBackgroundDirs returns the wallpaper directories saved as
+// backgrounds_0, backgrounds_1, ... relative to the home directory
+func FullBackupBackgroundDirs() []string {
+	return []string{
+		filepath.Join(".local", "share", "backgrounds"),
+		filepath.Join("Pictures", "Wallpapers"),
+	}
+}
+
+// FullBackupThemeDirs returns the theme and icon directories saved as
+// themes_0, themes_1, ... relative to the home directory
+func FullBackupThemeDirs() []string {
+	return []string{
+		".themes",
+		".icons",
+		filepath.Join(".local", "share", "themes"),
+		filepath.Join(".local", "share", "icons"),
+	}
+}
+
+// FullBackupDotfiles returns the dotfiles included in a Full Save
+func FullBackupDotfiles() []string {
+	return []string{
+		".bashrc", ".bash_profile", ".bash_aliases",
+		".zshrc", ".zprofile",
+		".profile",
+		".gitconfig", ".gitignore_global",
+		".vimrc", ".nanorc",
+		".tmux.conf",
+		".config/fish/config.fish",
+		".config/starship.toml",
+	}
 }
 
 // GetDefaultFullBackupPath returns the default path for full backup
@@ -56,218 +118,389 @@ func GetDefaultFullBackupPath() string {
 	return filepath.Join(home, fmt.Sprintf("rego-full-%s-%s.tar.gz", hostname, date))
 }
 
-// CreateFullBackup creates a comprehensive backup archive
-func CreateFullBackup(opts FullBackupOptions, outputPath string) (map[string]int, error) {
-	stats := make(map[string]int)
+// FindFullBackups returns the Full Save archives in the home directory,
+// newest first
+func FindFullBackups() []string {
 	home, _ := utils.GetHomeDir()
+	matches, _ := filepath.Glob(filepath.Join(home, "rego-full-*.tar.gz"))
+	sort.Slice(matches, func(i, j int) bool {
+		a, errA := os.Stat(matches[i])
+		b, errB := os.Stat(matches[j])
+		if errA != nil || errB != nil {
+			return matches[i] > matches[j]
+		}
+		return a.ModTime().After(b.ModTime())
+	})
+	return matches
+}
+
+// CreateFullBackup creates a comprehensive backup archive. Every section is
+// streamed from its source straight into the tar.gz writer, so nothing is
+// staged on disk and memory use does not grow with the size of the backup.
+// The archive is written to outputPath.part and renamed once complete; if
+// ctx is cancelled the partial file is removed and ctx's error returned.
+func CreateFullBackup(ctx context.Context, opts FullBackupOptions, outputPath string) (map[string]int, error) {
+	home, err := opts.Source.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	links := utils.SymlinksPreserve
+	if opts.FollowSymlinks {
+		links = utils.SymlinksFollow
+	}
+
+	// Size up the sections first so progress has totals to count against
+	progress := utils.NewProgress(opts.Progress)
+	if opts.Progress != nil {
+		count := newFullArchive(ctx, nil, links, nil)
+		count.addSections(opts, home)
+		progress.SetTotals(count.steps, count.files, count.bytes)
+	}
 
-	// Create temp directory for backup
-	tmpDir, err := os.MkdirTemp("", "rego-full-*")
+	partPath := outputPath + ".part"
+	outFile, err := os.Create(partPath)
 	if err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+	w, err := newArchiveWriter(outFile, opts.Passphrase)
+	if err != nil {
+		outFile.Close()
+		os.Remove(partPath)
+		return nil, err
+	}
+	a := newFullArchive(ctx, w, links, progress)
+	stats, included, locations := a.addSections(opts, home)
+
+	// Write manifest last, once every checksum is known
+	manifest := FullBackupManifest{
+		Version:   manifestVersion,
+		CreatedAt: time.Now(),
+		Hostname:  opts.Source.Hostname(),
+		Stats:     stats,
+		Included:  included,
+		Locations: locations,
+		Checksums: a.checksums,
+	}
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	a.addManifest(manifestData)
+
+	err = a.close()
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return stats, err
+	}
+	if err := os.Rename(partPath, outputPath); err != nil {
+		os.Remove(partPath)
+		return stats, err
+	}
+	return stats, nil
+}
 
+// addSections adds every section opts asks for. It returns the per-section
+// counts, the sections that saved anything and where each top-level archive
+// directory came from.
+func (a *fullArchive) addSections(opts FullBackupOptions, home string) (map[string]int, []string, map[string]string) {
+	stats := make(map[string]int)
 	var included []string
+	locations := make(map[string]string)
 
 	// Package lists (always as JSON)
+	var lightBackup *LightBackup
 	if opts.Flatpaks || opts.RPM || opts.Extensions || opts.Settings || opts.Repos {
+		a.startSection("Package lists")
 		lightOpts := LightBackupOptions{
 			Flatpaks: opts.Flatpaks, RPM: opts.RPM, Extensions: opts.Extensions,
-			Settings: opts.Settings, Repos: opts.Repos,
+			Settings: opts.Settings, Repos: opts.Repos, Source: opts.Source,
+		}
+		if !a.counting {
+			var err error
+			lightBackup, err = CreateLightBackupWithOptions(utils.WithCommandHook(a.ctx, a.progress.Run), lightOpts)
+			if err != nil {
+				// Only cancelling fails it, which ends the whole archive
+				a.fail(err)
+				lightBackup = nil
+			}
 		}
-		lightBackup, _ := CreateLightBackupWithOptions(lightOpts)
 		if lightBackup != nil {
-			lightBackup.SaveToFile(filepath.Join(tmpDir, "packages.json"))
+			if data, err := json.MarshalIndent(lightBackup, "", "  "); err == nil {
+				a.addBytes("packages.json", data)
+			}
 			stats["flatpaks"] = len(lightBackup.Flatpaks)
 			stats["rpm"] = len(lightBackup.RPMPackages)
+			stats["apt"] = len(lightBackup.APTPackages)
+			stats["pacman"] = len(lightBackup.PacmanPackages)
+			stats["aur"] = len(lightBackup.AURPackages)
+			stats["zypper"] = len(lightBackup.ZypperPackages)
 			stats["extensions"] = len(lightBackup.GnomeExtensions)
 			if lightBackup.DconfSettings != "" {
 				stats["settings"] = 1
 			}
-			stats["repos"] = len(lightBackup.Repos)
+			stats["repos"] = len(lightBackup.Repos) + len(lightBackup.ZypperRepos)
+		}
+		a.endSection()
+	}
+
+	// Package files for offline restores. Downloads land in a scratch
+	// directory first, since dnf, apt-get and flatpak only write to one.
+	if opts.PackageCache && opts.Source.LiveSystem() {
+		a.startSection("Package cache")
+		if lightBackup != nil {
+			if scratch, err := os.MkdirTemp("", "rego-package-cache-*"); err == nil {
+				packages, flatpaks := DownloadPackageCache(utils.WithCommandHook(a.ctx, a.progress.Run), lightBackup, scratch)
+				if packages+flatpaks > 0 {
+					a.addTree(PackageCacheDir, scratch)
+					included = append(included, "package_cache")
+				}
+				stats["cached_pkgs"] = packages
+				stats["cached_apps"] = flatpaks
+				os.RemoveAll(scratch)
+			}
+		}
+		a.endSection()
+	}
+
+	// Zypper repo files and the rpm keys that trust them
+	pm := opts.Source.PackageManager()
+	if opts.Repos && pm == PMZypper {
+		a.startSection("Zypper repositories")
+		repos := NewZypperReposBackup()
+		repos.SetRoot(opts.Source.Root)
+		if a.addBacker(repos) {
+			included = append(included, "zypper_repos")
 		}
+		a.endSection()
+	}
+
+	// APT source lists and signing keys
+	if opts.Repos && pm == PMAPT {
+		a.startSection("APT sources")
+		sources := NewAPTSourcesBackup()
+		sources.SetRoot(opts.Source.Root)
+		if a.addBacker(sources) {
+			included = append(included, "apt_sources")
+		}
+		a.endSection()
 	}
 
 	// KDE Plasma Config
 	if opts.KDEConfig {
-		kde := NewKDEBackup()
-		count, _ := kde.BackupConfigs(tmpDir)
+		a.startSection("KDE Plasma config")
+		count := 0
+		for _, rel := range NewKDEBackup().ConfigSources() {
+			if a.addFile(path.Join("kde-config", filepath.ToSlash(rel)), filepath.Join(home, rel)) {
+				count++
+			}
+		}
 		stats["kde_config"] = count
 		if count > 0 {
 			included = append(included, "kde_config")
+			locations["kde-config"] = "."
 		}
+		a.endSection()
 	}
 
 	// KDE Themes/Widgets/Colors
 	if opts.KDEData {
-		kde := NewKDEBackup()
-		count, _ := kde.BackupData(tmpDir)
+		a.startSection("KDE themes and widgets")
+		count := 0
+		for _, rel := range NewKDEBackup().DataSources() {
+			count += a.addTree(path.Join("kde-data", filepath.ToSlash(rel)), filepath.Join(home, rel))
+		}
 		stats["kde_data"] = count
 		if count > 0 {
 			included = append(included, "kde_data")
+			locations["kde-data"] = "."
 		}
+		a.endSection()
 	}
 
-	// Dotfiles
+	// Dotfiles (same layout as the dotfiles component, so DotfilesRestore can
+	// read them straight from the extracted archive)
 	if opts.Dotfiles {
-		dotfiles := []string{
-			".bashrc", ".bash_profile", ".bash_aliases",
-			".zshrc", ".zprofile",
-			".profile",
-			".gitconfig", ".gitignore_global",
-			".vimrc", ".nanorc",
-			".tmux.conf",
-			".config/fish/config.fish",
-			".config/starship.toml",
-		}
-		count := copyFilesToDir(home, dotfiles, filepath.Join(tmpDir, "dotfiles"))
+		a.startSection("Dotfiles")
+		count := a.addDotfiles(FullBackupDotfiles(), home, opts.Source.Root)
 		stats["dotfiles"] = count
 		if count > 0 {
 			included = append(included, "dotfiles")
+			locations["dotfiles"] = "."
 		}
+		a.endSection()
 	}
 
 	// SSH Config (NOT keys for security)
 	if opts.SSHConfig {
-		sshFiles := []string{".ssh/config", ".ssh/known_hosts"}
-		count := copyFilesToDir(home, sshFiles, filepath.Join(tmpDir, "ssh"))
+		a.startSection("SSH config")
+		count := 0
+		for _, file := range []string{".ssh/config", ".ssh/known_hosts"} {
+			src := filepath.Join(home, file)
+			if utils.FileExists(src) && a.addFile(path.Join("ssh", path.Base(file)), src) {
+				count++
+			}
+		}
 		stats["ssh"] = count
 		if count > 0 {
 			included = append(included, "ssh")
+			locations["ssh"] = ".ssh"
 		}
+		a.endSection()
 	}
 
 	// Fonts
 	if opts.Fonts {
+		a.startSection("Fonts")
 		fontsDir := filepath.Join(home, ".local", "share", "fonts")
 		if utils.DirExists(fontsDir) {
-			destDir := filepath.Join(tmpDir, "fonts")
-			utils.CopyDir(fontsDir, destDir)
-			files, _ := utils.ListFilesRecursive(destDir)
-			stats["fonts"] = len(files)
-			if len(files) > 0 {
+			count := a.addTree("fonts", fontsDir)
+			stats["fonts"] = count
+			if count > 0 {
 				included = append(included, "fonts")
+				locations["fonts"] = filepath.Join(".local", "share", "fonts")
 			}
 		}
+		a.endSection()
 	}
 
 	// Autostart
 	if opts.Autostart {
+		a.startSection("Autostart apps")
 		autostartDir := filepath.Join(home, ".config", "autostart")
 		if utils.DirExists(autostartDir) {
-			destDir := filepath.Join(tmpDir, "autostart")
-			utils.CopyDir(autostartDir, destDir)
-			files, _ := utils.ListFilesRecursive(destDir)
-			stats["autostart"] = len(files)
-			if len(files) > 0 {
+			count := a.addTree("autostart", autostartDir)
+			stats["autostart"] = count
+			if count > 0 {
 				included = append(included, "autostart")
+				locations["autostart"] = filepath.Join(".config", "autostart")
 			}
 		}
+		a.endSection()
 	}
 
 	// Backgrounds/Wallpapers
 	if opts.Backgrounds {
-		bgDirs := []string{
-			filepath.Join(home, ".local", "share", "backgrounds"),
-			filepath.Join(home, "Pictures", "Wallpapers"),
-		}
-		destDir := filepath.Join(tmpDir, "backgrounds")
+		a.startSection("Wallpapers")
 		count := 0
-		for _, bgDir := range bgDirs {
+		for i, rel := range FullBackupBackgroundDirs() {
+			bgDir := filepath.Join(home, rel)
 			if utils.DirExists(bgDir) {
-				utils.CopyDir(bgDir, destDir)
-				files, _ := utils.ListFilesRecursive(destDir)
-				count = len(files)
+				name := fmt.Sprintf("backgrounds_%d", i)
+				count += a.addTree(name, bgDir)
+				locations[name] = rel
 			}
 		}
 		stats["backgrounds"] = count
 		if count > 0 {
 			included = append(included, "backgrounds")
 		}
+		a.endSection()
 	}
 
 	// Themes and Icons
 	if opts.Themes {
-		themeDirs := []string{
-			filepath.Join(home, ".themes"),
-			filepath.Join(home, ".icons"),
-			filepath.Join(home, ".local", "share", "themes"),
-			filepath.Join(home, ".local", "share", "icons"),
-		}
+		a.startSection("Themes and icons")
 		count := 0
-		for i, themeDir := range themeDirs {
+		for i, rel := range FullBackupThemeDirs() {
+			themeDir := filepath.Join(home, rel)
 			if utils.DirExists(themeDir) {
-				destDir := filepath.Join(tmpDir, fmt.Sprintf("themes_%d", i))
-				utils.CopyDir(themeDir, destDir)
-				files, _ := utils.ListFilesRecursive(destDir)
-				count += len(files)
+				name := fmt.Sprintf("themes_%d", i)
+				count += a.addTree(name, themeDir)
+				locations[name] = rel
 			}
 		}
 		stats["themes"] = count
 		if count > 0 {
 			included = append(included, "themes")
 		}
+		a.endSection()
 	}
 
-	// Write manifest
-	manifest := FullBackupManifest{
-		Version:   "1.0",
-		CreatedAt: time.Now(),
-		Hostname:  getHostnameSimple(),
-		Stats:     stats,
-		Included:  included,
+	return stats, included, locations
+}
+
+// createTarGz archives sourceDir into destPath, encrypting the archive when
+// passphrase is not empty
+func createTarGz(sourceDir, destPath, passphrase string) error {
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return err
 	}
-	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
-	os.WriteFile(filepath.Join(tmpDir, "manifest.json"), manifestData, 0644)
+	defer outFile.Close()
 
-	// Create tar.gz
-	if err := createTarGz(tmpDir, outputPath); err != nil {
-		return stats, err
+	w, err := newArchiveWriter(outFile, passphrase)
+	if err != nil {
+		return err
 	}
 
-	return stats, nil
+	if err := writeTar(w.tar, sourceDir); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return outFile.Close()
 }
 
-func copyFilesToDir(baseDir string, files []string, destDir string) int {
-	utils.EnsureDir(destDir)
-	count := 0
-	for _, file := range files {
-		src := filepath.Join(baseDir, file)
-		if utils.FileExists(src) {
-			dst := filepath.Join(destDir, filepath.Base(file))
-			if utils.CopyFile(src, dst) == nil {
-				count++
-			}
-		}
-	}
-	return count
+// archiveWriter stacks tar, gzip and optional encryption over a file
+type archiveWriter struct {
+	tar     *tar.Writer
+	gz      *gzip.Writer
+	encrypt io.WriteCloser
 }
 
-func getHostnameSimple() string {
-	hostname, _ := os.Hostname()
-	return hostname
+func newArchiveWriter(out io.Writer, passphrase string) (*archiveWriter, error) {
+	w := &archiveWriter{}
+	if passphrase != "" {
+		enc, err := utils.NewEncryptWriter(out, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		w.encrypt = enc
+		out = enc
+	}
+	w.gz = gzip.NewWriter(out)
+	w.tar = tar.NewWriter(w.gz)
+	return w, nil
 }
 
-func createTarGz(sourceDir, destPath string) error {
-	outFile, err := os.Create(destPath)
-	if err != nil {
+// Close flushes every layer, innermost first
+func (w *archiveWriter) Close() error {
+	if err := w.tar.Close(); err != nil {
 		return err
 	}
-	defer outFile.Close()
-
-	gzWriter := gzip.NewWriter(outFile)
-	defer gzWriter.Close()
-
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	if w.encrypt != nil {
+		return w.encrypt.Close()
+	}
+	return nil
+}
 
+// writeTar adds every file under sourceDir to tw, named relative to it.
+// Headers carry the mode, mtime and owner of each file. Symlinks inside
+// sourceDir are stored as links; links that leave it are stored as the file
+// they point to, since extraction refuses links outside the archive root.
+func writeTar(tarWriter *tar.Writer, sourceDir string) error {
 	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		header, err := tar.FileInfoHeader(info, info.Name())
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if utils.LinkStaysInside(sourceDir, path) {
+				link, _ = os.Readlink(path)
+			} else if info, err = os.Stat(path); err != nil || info.IsDir() {
+				utils.Warn("Not archiving symlink %s", path)
+				return nil
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
 			return err
 		}
@@ -279,7 +512,7 @@ func createTarGz(sourceDir, destPath string) error {
 			return err
 		}
 
-		if !info.IsDir() {
+		if info.Mode().IsRegular() {
 			file, err := os.Open(path)
 			if err != nil {
 				return err
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -56,8 +57,8 @@ type ExtensionInfo struct {
 }
 
 // List returns installed GNOME extensions
-func (g *GnomeExtensionsBackup) List() ([]BackupItem, error) {
-	extensions, err := g.listExtensions()
+func (g *GnomeExtensionsBackup) List(ctx context.Context) ([]BackupItem, error) {
+	extensions, err := g.listExtensions(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -79,12 +80,12 @@ func (g *GnomeExtensionsBackup) List() ([]BackupItem, error) {
 }
 
 // listExtensions gets details about all installed extensions
-func (g *GnomeExtensionsBackup) listExtensions() ([]ExtensionInfo, error) {
+func (g *GnomeExtensionsBackup) listExtensions(ctx context.Context) ([]ExtensionInfo, error) {
 	var extensions []ExtensionInfo
 
 	// Try using gnome-extensions command first
 	if utils.CommandExists("gnome-extensions") {
-		result := utils.RunCommand("gnome-extensions", "list", "--details")
+		result := utils.RunCommandContext(ctx, "gnome-extensions", "list", "--details")
 		if result.Error == nil {
 			extensions = g.parseExtensionsList(result.Stdout)
 		}
@@ -100,7 +101,7 @@ func (g *GnomeExtensionsBackup) listExtensions() ([]ExtensionInfo, error) {
 	}
 
 	// Get enabled status
-	enabledExtensions := g.getEnabledExtensions()
+	enabledExtensions := g.getEnabledExtensions(ctx)
 	for i := range extensions {
 		extensions[i].Enabled = enabledExtensions[extensions[i].UUID]
 	}
@@ -196,7 +197,7 @@ func (g *GnomeExtensionsBackup) scanExtensionsDirectory() ([]ExtensionInfo, erro
 }
 
 // getEnabledExtensions returns a map of enabled extension UUIDs
-func (g *GnomeExtensionsBackup) getEnabledExtensions() map[string]bool {
+func (g *GnomeExtensionsBackup) getEnabledExtensions(ctx context.Context) map[string]bool {
 	enabled := make(map[string]bool)
 
 	// Use gsettings to get enabled extensions
@@ -204,7 +205,7 @@ func (g *GnomeExtensionsBackup) getEnabledExtensions() map[string]bool {
 		return enabled
 	}
 
-	result := utils.RunCommand("gsettings", "get", "org.gnome.shell", "enabled-extensions")
+	result := utils.RunCommandContext(ctx, "gsettings", "get", "org.gnome.shell", "enabled-extensions")
 	if result.Error != nil {
 		return enabled
 	}
@@ -231,19 +232,19 @@ type ExtensionsData struct {
 }
 
 // Backup performs the GNOME extensions backup
-func (g *GnomeExtensionsBackup) Backup(backupDir string) (BackupResult, error) {
+func (g *GnomeExtensionsBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeGnomeExtensions,
 		Timestamp: time.Now(),
 	}
 
-	items, err := g.List()
+	items, err := g.List(ctx)
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
 	}
 
-	allExtensions, _ := g.listExtensions()
+	allExtensions, _ := g.listExtensions(ctx)
 
 	// Filter to only enabled extensions
 	var extensions []ExtensionInfo
@@ -288,7 +289,7 @@ func (g *GnomeExtensionsBackup) Backup(backupDir string) (BackupResult, error) {
 	}
 
 	// Backup extension settings (dconf keys for extensions)
-	g.backupExtensionSettings(backupDir, extensions)
+	g.backupExtensionSettings(ctx, backupDir, extensions)
 
 	result.Success = true
 	result.Items = items
@@ -299,7 +300,7 @@ func (g *GnomeExtensionsBackup) Backup(backupDir string) (BackupResult, error) {
 }
 
 // backupExtensionSettings exports dconf settings for extensions
-func (g *GnomeExtensionsBackup) backupExtensionSettings(backupDir string, extensions []ExtensionInfo) {
+func (g *GnomeExtensionsBackup) backupExtensionSettings(ctx context.Context, backupDir string, extensions []ExtensionInfo) {
 	if !utils.CommandExists("dconf") {
 		return
 	}
@@ -314,10 +315,30 @@ func (g *GnomeExtensionsBackup) backupExtensionSettings(backupDir string, extens
 
 		// Each extension stores settings under /org/gnome/shell/extensions/<uuid>/
 		path := "/org/gnome/shell/extensions/" + strings.ReplaceAll(ext.UUID, "@", "-") + "/"
-		result := utils.RunCommand("dconf", "dump", path)
+		result := utils.RunCommandContext(ctx, "dconf", "dump", path)
 		if result.Error == nil && result.Stdout != "" {
 			settingsFile := filepath.Join(settingsDir, ext.UUID+".dconf")
 			utils.WriteFile(settingsFile, []byte(result.Stdout))
 		}
 	}
 }
+
+// ListExtensionDirsIn returns the extensions installed in the extension
+// directories dirs, such as ~/.local/share/gnome-shell/extensions, by UUID
+func ListExtensionDirsIn(dirs ...string) []string {
+	seen := make(map[string]bool)
+	var uuids []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				uuids = append(uuids, entry.Name())
+			}
+		}
+	}
+	return uuids
+}
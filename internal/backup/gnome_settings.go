@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"path/filepath"
 	"time"
 
@@ -31,7 +32,7 @@ func (g *GnomeSettingsBackup) Available() bool {
 }
 
 // List returns a summary of dconf paths that will be backed up
-func (g *GnomeSettingsBackup) List() ([]BackupItem, error) {
+func (g *GnomeSettingsBackup) List(ctx context.Context) ([]BackupItem, error) {
 	// Return key areas that will be backed up
 	paths := []struct {
 		name string
@@ -51,7 +52,7 @@ func (g *GnomeSettingsBackup) List() ([]BackupItem, error) {
 	var items []BackupItem
 	for _, p := range paths {
 		// Check if path has any keys
-		result := utils.RunCommand("dconf", "list", p.path)
+		result := utils.RunCommandContext(ctx, "dconf", "list", p.path)
 		if result.Error == nil && result.Stdout != "" {
 			items = append(items, BackupItem{
 				Name:        p.name,
@@ -65,20 +66,20 @@ func (g *GnomeSettingsBackup) List() ([]BackupItem, error) {
 }
 
 // Backup performs the GNOME settings backup using dconf dump
-func (g *GnomeSettingsBackup) Backup(backupDir string) (BackupResult, error) {
+func (g *GnomeSettingsBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeGnomeSettings,
 		Timestamp: time.Now(),
 	}
 
-	items, err := g.List()
+	items, err := g.List(ctx)
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
 	}
 
 	// Dump entire dconf database
-	dconfResult := utils.RunCommand("dconf", "dump", "/")
+	dconfResult := utils.RunCommandContext(ctx, "dconf", "dump", "/")
 	if dconfResult.Error != nil {
 		result.Error = dconfResult.Error.Error()
 		return result, dconfResult.Error
@@ -109,7 +110,7 @@ func (g *GnomeSettingsBackup) Backup(backupDir string) (BackupResult, error) {
 	}
 
 	for name, path := range paths {
-		dumpResult := utils.RunCommand("dconf", "dump", path)
+		dumpResult := utils.RunCommandContext(ctx, "dconf", "dump", path)
 		if dumpResult.Error == nil && dumpResult.Stdout != "" {
 			pathFile := filepath.Join(selectiveDir, name+".dconf")
 			utils.WriteFile(pathFile, []byte(dumpResult.Stdout))
@@ -123,3 +124,11 @@ func (g *GnomeSettingsBackup) Backup(backupDir string) (BackupResult, error) {
 
 	return result, nil
 }
+
+// DumpDconfDatabase dumps the user dconf database of the home whose
+// configuration directory is configDir, rather than the current user's
+func DumpDconfDatabase(configDir string) utils.CommandResult {
+	// dconf reads the database of whatever home XDG_CONFIG_HOME is in
+	env := map[string]string{"XDG_CONFIG_HOME": configDir}
+	return utils.RunCommandWithEnv(env, 30*time.Second, "dconf", "dump", "/")
+}
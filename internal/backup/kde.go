@@ -97,60 +97,84 @@ func (k *KDEBackup) KDEDataDirs() []string {
 	}
 }
 
-// BackupConfigs copies KDE config files to destination
-func (k *KDEBackup) BackupConfigs(destDir string) (int, error) {
-	count := 0
-	configDest := filepath.Join(destDir, "kde-config")
-	utils.EnsureDir(configDest)
-
+// ConfigSources returns the KDE config files that exist, relative to the
+// home directory, with wildcards expanded
+func (k *KDEBackup) ConfigSources() []string {
+	var sources []string
 	for _, relPath := range k.KDEConfigFiles() {
 		// Handle wildcards
 		if strings.Contains(relPath, "*") {
-			basePath := filepath.Join(k.home, filepath.Dir(relPath))
-			pattern := filepath.Base(relPath)
-			if utils.DirExists(basePath) {
-				matches, _ := filepath.Glob(filepath.Join(basePath, pattern))
-				for _, match := range matches {
-					dest := filepath.Join(configDest, filepath.Base(match))
-					if utils.CopyFile(match, dest) == nil {
-						count++
-					}
-				}
-			}
-		} else {
-			src := filepath.Join(k.home, relPath)
-			if utils.FileExists(src) {
-				// Preserve directory structure for nested files
-				dest := filepath.Join(configDest, filepath.Base(relPath))
-				if utils.CopyFile(src, dest) == nil {
-					count++
+			matches, _ := filepath.Glob(filepath.Join(k.home, relPath))
+			for _, match := range matches {
+				if utils.FileExists(match) {
+					sources = append(sources, filepath.Join(filepath.Dir(relPath), filepath.Base(match)))
 				}
 			}
+		} else if utils.FileExists(filepath.Join(k.home, relPath)) {
+			sources = append(sources, relPath)
+		}
+	}
+	return sources
+}
+
+// DataSources returns the KDE data directories that exist, relative to the
+// home directory, leaving out those inside another one in the list
+func (k *KDEBackup) DataSources() []string {
+	var sources []string
+	for _, relPath := range k.KDEDataDirs() {
+		if isWithinAny(relPath, sources) {
+			continue // Already covered by a parent directory
+		}
+		if utils.DirExists(filepath.Join(k.home, relPath)) {
+			sources = append(sources, relPath)
+		}
+	}
+	return sources
+}
+
+// BackupConfigs copies KDE config files to destination, keeping their paths
+// relative to the home directory
+func (k *KDEBackup) BackupConfigs(destDir string) (int, error) {
+	count := 0
+	configDest := filepath.Join(destDir, "kde-config")
+	utils.EnsureDir(configDest)
+
+	for _, relPath := range k.ConfigSources() {
+		// Preserve directory structure for nested files
+		if utils.CopyFile(filepath.Join(k.home, relPath), filepath.Join(configDest, relPath)) == nil {
+			count++
 		}
 	}
 	return count, nil
 }
 
-// BackupData copies KDE data directories to destination
+// BackupData copies KDE data directories to destination, keeping their paths
+// relative to the home directory
 func (k *KDEBackup) BackupData(destDir string) (int, error) {
 	count := 0
 	dataDest := filepath.Join(destDir, "kde-data")
 	utils.EnsureDir(dataDest)
 
-	for _, relPath := range k.KDEDataDirs() {
-		src := filepath.Join(k.home, relPath)
-		if utils.DirExists(src) {
-			dirName := filepath.Base(relPath)
-			dest := filepath.Join(dataDest, dirName)
-			if utils.CopyDir(src, dest) == nil {
-				files, _ := utils.ListFilesRecursive(dest)
-				count += len(files)
-			}
+	for _, relPath := range k.DataSources() {
+		dest := filepath.Join(dataDest, relPath)
+		if utils.CopyDir(filepath.Join(k.home, relPath), dest) == nil {
+			files, _ := utils.ListFilesRecursive(dest)
+			count += len(files)
 		}
 	}
 	return count, nil
 }
 
+// isWithinAny reports whether path is one of dirs or nested inside one
+func isWithinAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetInstalledWidgets returns a list of installed Plasma widgets
 func (k *KDEBackup) GetInstalledWidgets() []string {
 	var widgets []string
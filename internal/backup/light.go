@@ -1,8 +1,11 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/r8bert/rego/internal/utils"
@@ -22,6 +25,19 @@ type LightBackup struct {
 	RPMPackages []string `json:"rpm_packages,omitempty"`
 	APTPackages []string `json:"apt_packages,omitempty"`
 
+	// Arch: native repo packages and foreign (AUR) packages are kept apart
+	// because they are installed with different tools
+	PacmanPackages []string `json:"pacman_packages,omitempty"`
+	AURPackages    []string `json:"aur_packages,omitempty"`
+
+	// openSUSE: packages plus the full repo settings, since zypper repos
+	// can't be recreated from a name alone
+	ZypperPackages []string     `json:"zypper_packages,omitempty"`
+	ZypperRepos    []ZypperRepo `json:"zypper_repos,omitempty"`
+
+	// Flatpak: the installation, remote and commit of each app in Flatpaks
+	FlatpakApps []FlatpakDeployment `json:"flatpak_apps,omitempty"`
+
 	// GNOME
 	GnomeExtensions []string `json:"gnome_extensions,omitempty"`
 	DconfSettings   string   `json:"dconf_settings,omitempty"`
@@ -31,6 +47,10 @@ type LightBackup struct {
 
 	// Repos (just the names)
 	Repos []string `json:"repos,omitempty"`
+
+	// Rejected lists the entries left out when the backup was loaded
+	// because they failed validation
+	Rejected []Rejection `json:"-"`
 }
 
 // LightBackupOptions controls what to include in the backup
@@ -41,6 +61,16 @@ type LightBackupOptions struct {
 	Settings   bool // GNOME dconf settings
 	KDE        bool // KDE Plasma settings
 	Repos      bool
+
+	// Source is the system and home to back up; the zero Source is this
+	// system. Anything but the running system and the current user's home
+	// is read from its files, since flatpak, gnome-extensions and dconf
+	// only report on those.
+	Source Source
+
+	// Progress, when set, receives an event as each component starts and
+	// finishes and for each command run
+	Progress utils.ProgressFunc
 }
 
 // DefaultLightBackupOptions returns all options enabled
@@ -49,77 +79,139 @@ func DefaultLightBackupOptions() LightBackupOptions {
 }
 
 // CreateLightBackup creates a minimal single-file backup with all options
-func CreateLightBackup() (*LightBackup, error) {
-	return CreateLightBackupWithOptions(DefaultLightBackupOptions())
+func CreateLightBackup(ctx context.Context) (*LightBackup, error) {
+	return CreateLightBackupWithOptions(ctx, DefaultLightBackupOptions())
 }
 
-// CreateLightBackupWithOptions creates a backup with selected components
-func CreateLightBackupWithOptions(opts LightBackupOptions) (*LightBackup, error) {
-	hostname, _ := os.Hostname()
+// CreateLightBackupWithOptions creates a backup with selected components.
+// The components are collected concurrently; if ctx is cancelled, running
+// commands are stopped and the partial backup is returned with ctx's error.
+func CreateLightBackupWithOptions(ctx context.Context, opts LightBackupOptions) (*LightBackup, error) {
+	src := opts.Source
+	home, _ := src.HomeDir()
 
 	backup := &LightBackup{
 		Version:   "1.0",
 		CreatedAt: time.Now(),
-		Hostname:  hostname,
-		User:      os.Getenv("USER"),
-		Distro:    GetDistroName(),
+		Hostname:  src.Hostname(),
+		User:      src.User(),
+		Distro:    GetDistroNameIn(src.Root),
+	}
+
+	progress := utils.NewProgress(opts.Progress)
+
+	// Each job fills in its own fields, so they need no locking
+	var jobs []func(ctx context.Context)
+	add := func(component string, job func(ctx context.Context)) {
+		jobs = append(jobs, func(ctx context.Context) {
+			progress.Start(component)
+			job(utils.WithCommandHook(ctx, progress.Run))
+			progress.StepDone()
+		})
 	}
 
 	// Flatpaks
-	if opts.Flatpaks && utils.CommandExists("flatpak") {
-		lines, _ := utils.RunCommandLines("flatpak", "list", "--app", "--columns=application")
-		for _, line := range lines {
-			if line != "" {
-				backup.Flatpaks = append(backup.Flatpaks, line)
-			}
-		}
+	if opts.Flatpaks && !src.Live() {
+		add("Flatpak apps", func(ctx context.Context) {
+			backup.setFlatpaks(ListFlatpakDeploymentsIn(src.SystemPath("/var/lib/flatpak"), filepath.Join(home, ".local", "share", "flatpak")))
+		})
+	} else if opts.Flatpaks && utils.CommandExists("flatpak") {
+		add("Flatpak apps", func(ctx context.Context) {
+			apps, _ := ListFlatpakDeployments(ctx)
+			backup.setFlatpaks(apps)
+		})
 	}
 
 	// System packages - auto-detect package manager
 	if opts.RPM {
-		pm := DetectPackageManager()
-		switch pm {
-		case PMDNF:
-			result := utils.RunCommand("dnf", "repoquery", "--userinstalled", "--qf", "%{name}")
-			if result.Error == nil {
-				for _, line := range splitLines(result.Stdout) {
-					if line != "" && !isBasePackage(line) {
-						backup.RPMPackages = append(backup.RPMPackages, line)
+		add("System packages", func(ctx context.Context) {
+			switch src.PackageManager() {
+			case PMDNF:
+				if !src.LiveSystem() {
+					rpm := NewRPMBackup()
+					rpm.SetRoot(src.Root)
+					items, _ := rpm.List(ctx)
+					for _, item := range items {
+						backup.RPMPackages = append(backup.RPMPackages, item.Name)
 					}
+					break
 				}
+				result := utils.RunCommandContext(ctx, "dnf", "repoquery", "--userinstalled", "--qf", "%{name}")
+				if result.Error == nil {
+					for _, line := range splitLines(result.Stdout) {
+						if line != "" && !isBasePackage(line) {
+							backup.RPMPackages = append(backup.RPMPackages, line)
+						}
+					}
+				}
+			case PMAPT:
+				apt := NewAPTBackup()
+				apt.SetRoot(src.Root)
+				backup.APTPackages, _ = apt.ListUserInstalled(ctx)
+			case PMPacman:
+				pacman := NewPacmanBackup()
+				pacman.SetRoot(src.Root)
+				backup.PacmanPackages, _ = pacman.ListNative(ctx)
+				backup.AURPackages, _ = pacman.ListForeign(ctx)
+			case PMZypper:
+				zypper := NewZypperBackup()
+				zypper.SetRoot(src.Root)
+				backup.ZypperPackages, _, _ = zypper.ListUserInstalled(ctx)
 			}
-		case PMAPT:
-			apt := NewAPTBackup()
-			pkgs, _ := apt.ListUserInstalled()
-			backup.APTPackages = pkgs
-		}
+		})
 	}
 
 	// GNOME extensions
-	if opts.Extensions && utils.CommandExists("gnome-extensions") {
-		lines, _ := utils.RunCommandLines("gnome-extensions", "list")
-		backup.GnomeExtensions = lines
+	if opts.Extensions && !src.Live() {
+		add("GNOME extensions", func(ctx context.Context) {
+			backup.GnomeExtensions = ListExtensionDirsIn(filepath.Join(home, ".local", "share", "gnome-shell", "extensions"),
+				src.SystemPath("/usr/share/gnome-shell/extensions"))
+		})
+	} else if opts.Extensions && utils.CommandExists("gnome-extensions") {
+		add("GNOME extensions", func(ctx context.Context) {
+			backup.GnomeExtensions, _ = utils.RunCommandLinesContext(ctx, "gnome-extensions", "list")
+		})
 	}
 
 	// Dconf settings
 	if opts.Settings && utils.CommandExists("dconf") {
-		result := utils.RunCommand("dconf", "dump", "/")
-		if result.Error == nil {
-			backup.DconfSettings = result.Stdout
-		}
+		add("GNOME settings", func(ctx context.Context) {
+			var result utils.CommandResult
+			switch configDir := filepath.Join(home, ".config"); {
+			case src.LiveHome():
+				result = utils.RunCommandContext(ctx, "dconf", "dump", "/")
+			case utils.FileExists(filepath.Join(configDir, "dconf", "user")):
+				result = DumpDconfDatabase(configDir)
+			default:
+				return
+			}
+			if result.Error == nil {
+				backup.DconfSettings = result.Stdout
+			}
+		})
 	}
 
 	// Repos
 	if opts.Repos {
-		reposBackup := NewReposBackup()
-		if items, err := reposBackup.List(); err == nil {
-			for _, item := range items {
-				backup.Repos = append(backup.Repos, item.Name)
+		add("Repositories", func(ctx context.Context) {
+			repos := NewReposBackup()
+			repos.SetRoot(src.Root)
+			if items, err := repos.List(ctx); err == nil {
+				for _, item := range items {
+					backup.Repos = append(backup.Repos, item.Name)
+				}
 			}
-		}
+			if src.PackageManager() == PMZypper {
+				zypperRepos := NewZypperReposBackup()
+				zypperRepos.SetRoot(src.Root)
+				backup.ZypperRepos, _ = zypperRepos.ListThirdParty(ctx)
+			}
+		})
 	}
 
-	return backup, nil
+	progress.SetTotals(len(jobs), 0, 0)
+	runParallel(ctx, jobs)
+	return backup, ctx.Err()
 }
 
 func splitLines(s string) []string {
@@ -157,18 +249,53 @@ func isBasePackage(pkg string) bool {
 	return base[pkg]
 }
 
+// setFlatpaks records apps, listing each app once in Flatpaks even if it
+// is in more than one installation
+func (b *LightBackup) setFlatpaks(apps []FlatpakDeployment) {
+	b.FlatpakApps = apps
+	for _, app := range apps {
+		if !slices.Contains(b.Flatpaks, app.ID) {
+			b.Flatpaks = append(b.Flatpaks, app.ID)
+		}
+	}
+}
+
+// FlatpakApp returns how the app id was installed. Backups from before
+// this was recorded have it in the system installation from flathub.
+func (b *LightBackup) FlatpakApp(id string) FlatpakDeployment {
+	for _, app := range b.FlatpakApps {
+		if app.ID == id {
+			return app
+		}
+	}
+	return FlatpakDeployment{ID: id, Installation: FlatpakSystem, Origin: "flathub"}
+}
+
 // SaveToFile saves the backup to a JSON file
 func (b *LightBackup) SaveToFile(path string) error {
+	return b.SaveToFileWithPassphrase(path, "")
+}
+
+// SaveToFileWithPassphrase saves the backup to a JSON file, encrypted when
+// passphrase is not empty
+func (b *LightBackup) SaveToFileWithPassphrase(path, passphrase string) error {
 	data, err := json.MarshalIndent(b, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	return utils.WriteFileEncrypted(path, data, passphrase)
 }
 
-// LoadLightBackup loads a backup from a JSON file
+// LoadLightBackup loads a backup from a JSON file. Encrypted files return
+// utils.ErrPassphraseRequired.
 func LoadLightBackup(path string) (*LightBackup, error) {
-	data, err := os.ReadFile(path)
+	return LoadLightBackupWithPassphrase(path, "")
+}
+
+// LoadLightBackupWithPassphrase loads a backup from a plain or encrypted
+// JSON file
+func LoadLightBackupWithPassphrase(path, passphrase string) (*LightBackup, error) {
+	data, err := utils.ReadFileEncrypted(path, passphrase)
 	if err != nil {
 		return nil, err
 	}
@@ -176,6 +303,7 @@ func LoadLightBackup(path string) (*LightBackup, error) {
 	if err := json.Unmarshal(data, &backup); err != nil {
 		return nil, err
 	}
+	backup.Rejected = backup.Validate()
 	return &backup, nil
 }
 
@@ -192,7 +320,10 @@ func (b *LightBackup) Stats() map[string]int {
 		"flatpaks":   len(b.Flatpaks),
 		"rpm":        len(b.RPMPackages),
 		"apt":        len(b.APTPackages),
+		"pacman":     len(b.PacmanPackages),
+		"aur":        len(b.AURPackages),
+		"zypper":     len(b.ZypperPackages),
 		"extensions": len(b.GnomeExtensions),
-		"repos":      len(b.Repos),
+		"repos":      len(b.Repos) + len(b.ZypperRepos),
 	}
 }
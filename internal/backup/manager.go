@@ -1,10 +1,12 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/r8bert/rego/internal/utils"
@@ -26,6 +28,10 @@ func NewManager() *Manager {
 	m.RegisterBacker(NewFlatpakBackup())
 	m.RegisterBacker(NewRPMBackup())
 	m.RegisterBacker(NewReposBackup())
+	m.RegisterBacker(NewZypperBackup())
+	m.RegisterBacker(NewZypperReposBackup())
+	m.RegisterBacker(NewAPTBackup())
+	m.RegisterBacker(NewAPTSourcesBackup())
 	m.RegisterBacker(NewGnomeExtensionsBackup())
 	m.RegisterBacker(NewGnomeSettingsBackup())
 	m.RegisterBacker(NewDotfilesBackup())
@@ -70,8 +76,11 @@ type BackupProgress struct {
 // ProgressCallback is called during backup to report progress
 type ProgressCallback func(progress BackupProgress)
 
-// RunBackup performs a backup with the given options
-func (m *Manager) RunBackup(opts BackupOptions, callback ProgressCallback) (*BackupManifest, error) {
+// RunBackup performs a backup with the given options. Components run
+// concurrently on a bounded pool; the callback is called from those
+// goroutines, one call at a time. If ctx is cancelled, running commands are
+// stopped and ctx's error is returned without writing a manifest.
+func (m *Manager) RunBackup(ctx context.Context, opts BackupOptions, callback ProgressCallback) (*BackupManifest, error) {
 	// Determine backup directory
 	backupDir := opts.BackupPath
 	if backupDir == "" {
@@ -100,6 +109,18 @@ func (m *Manager) RunBackup(opts BackupOptions, callback ProgressCallback) (*Bac
 	if opts.IncludeRepos {
 		typesToBackup = append(typesToBackup, BackupTypeRepos)
 	}
+	if opts.IncludeZypper {
+		typesToBackup = append(typesToBackup, BackupTypeZypper)
+	}
+	if opts.IncludeZypperRepos {
+		typesToBackup = append(typesToBackup, BackupTypeZypperRepos)
+	}
+	if opts.IncludeAPT {
+		typesToBackup = append(typesToBackup, BackupTypeAPT)
+	}
+	if opts.IncludeAPTSources {
+		typesToBackup = append(typesToBackup, BackupTypeAPTSources)
+	}
 	if opts.IncludeGnomeExtensions {
 		typesToBackup = append(typesToBackup, BackupTypeGnomeExtensions)
 	}
@@ -114,15 +135,20 @@ func (m *Manager) RunBackup(opts BackupOptions, callback ProgressCallback) (*Bac
 	}
 
 	// Set custom dotfiles if provided
-	if len(opts.DotfilesList) > 0 {
-		if b, ok := m.backers[BackupTypeDotfiles].(*DotfilesBackup); ok {
+	if b, ok := m.backers[BackupTypeDotfiles].(*DotfilesBackup); ok {
+		if len(opts.DotfilesList) > 0 {
 			b.SetDotfiles(opts.DotfilesList)
 		}
+		if opts.FollowSymlinks {
+			b.SetSymlinkMode(utils.SymlinksFollow)
+		} else {
+			b.SetSymlinkMode(utils.SymlinksPreserve)
+		}
 	}
 
 	// Initialize manifest
 	manifest := &BackupManifest{
-		Version:     "1.0",
+		Version:     manifestVersion,
 		CreatedAt:   time.Now(),
 		Hostname:    getHostname(),
 		User:        getUsername(),
@@ -138,31 +164,50 @@ func (m *Manager) RunBackup(opts BackupOptions, callback ProgressCallback) (*Bac
 		InProgress: true,
 	}
 
-	for i, backupType := range typesToBackup {
+	var mu sync.Mutex
+	var jobs []func(ctx context.Context)
+	for _, backupType := range typesToBackup {
 		backer, ok := m.backers[backupType]
 		if !ok || !backer.Available() {
 			continue
 		}
 
-		progress.CurrentStep = i + 1
-		progress.CurrentType = backupType
-		progress.CurrentName = backer.Name()
-
-		if callback != nil {
-			callback(progress)
-		}
+		jobs = append(jobs, func(ctx context.Context) {
+			mu.Lock()
+			progress.CurrentStep++
+			progress.CurrentType = backupType
+			progress.CurrentName = backer.Name()
+			if callback != nil {
+				callback(progress)
+			}
+			mu.Unlock()
+
+			result, err := backer.Backup(ctx, backupDir)
+			if err != nil {
+				utils.Error("Backup failed for %s: %v", backupType, err)
+			}
+
+			mu.Lock()
+			manifest.Results[backupType] = result
+			progress.Completed = append(progress.Completed, result)
+			mu.Unlock()
+		})
+	}
+	runParallel(ctx, jobs)
 
-		result, err := backer.Backup(backupDir)
-		if err != nil {
-			utils.Error("Backup failed for %s: %v", backupType, err)
-		}
+	if err := ctx.Err(); err != nil {
+		return manifest, err
+	}
 
-		manifest.Results[backupType] = result
-		progress.Completed = append(progress.Completed, result)
+	// Record a checksum for every file so copies can be verified later
+	checksums, err := utils.ChecksumDir(backupDir, manifestName)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to checksum backup: %w", err)
 	}
+	manifest.Checksums = checksums
 
 	// Save manifest
-	manifestPath := filepath.Join(backupDir, "manifest.json")
+	manifestPath := filepath.Join(backupDir, manifestName)
 	manifestData, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return manifest, fmt.Errorf("failed to marshal manifest: %w", err)
@@ -202,7 +247,7 @@ func (m *Manager) ListBackups() ([]BackupManifest, error) {
 			continue
 		}
 
-		manifestPath := filepath.Join(backupDir, entry.Name(), "manifest.json")
+		manifestPath := filepath.Join(backupDir, entry.Name(), manifestName)
 		data, err := os.ReadFile(manifestPath)
 		if err != nil {
 			continue // Skip directories without manifest
@@ -221,7 +266,7 @@ func (m *Manager) ListBackups() ([]BackupManifest, error) {
 
 // LoadBackup loads a backup manifest from a directory
 func (m *Manager) LoadBackup(backupPath string) (*BackupManifest, error) {
-	manifestPath := filepath.Join(backupPath, "manifest.json")
+	manifestPath := filepath.Join(backupPath, manifestName)
 	data, err := os.ReadFile(manifestPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read manifest: %w", err)
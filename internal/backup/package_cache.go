@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// PackageCacheDir is the archive directory of a Full Save made with
+// FullBackupOptions.PackageCache. It holds the RPMs or debs of the saved
+// packages and everything they depend on, and an offline Flatpak
+// repository, so the archive can be restored without a network.
+const PackageCacheDir = "package-cache"
+
+// FlatpakCacheRepo is the offline Flatpak repository flatpak create-usb
+// writes below the directory it is given
+const FlatpakCacheRepo = ".ostree/repo"
+
+// downloadTimeout bounds each download command; a cache can be gigabytes
+const downloadTimeout = 60 * time.Minute
+
+// PackageCacheSubdir returns the directory below PackageCacheDir with the
+// package files manager installs, or "" if there are none for it
+func PackageCacheSubdir(manager string) string {
+	switch manager {
+	case "dnf":
+		return "rpm"
+	case "apt-get":
+		return "deb"
+	case "flatpak":
+		return "flatpak"
+	}
+	return ""
+}
+
+// DownloadPackageCache downloads the packages and Flatpaks in b into dir,
+// laid out as PackageCacheDir. Packages that can't be downloaded are left
+// out with a warning. It returns how many package files and Flatpak apps
+// were saved.
+func DownloadPackageCache(ctx context.Context, b *LightBackup, dir string) (packages, flatpaks int) {
+	if len(b.RPMPackages) > 0 && utils.CommandExists("dnf") {
+		packages += downloadPackages(ctx, filepath.Join(dir, PackageCacheSubdir("dnf")), b.RPMPackages, downloadRPMs)
+	}
+	if len(b.APTPackages) > 0 && utils.CommandExists("apt-get") {
+		packages += downloadPackages(ctx, filepath.Join(dir, PackageCacheSubdir("apt-get")), b.APTPackages, downloadDebs)
+	}
+	if len(b.Flatpaks) > 0 && utils.CommandExists("flatpak") {
+		flatpaks = downloadFlatpaks(ctx, filepath.Join(dir, PackageCacheSubdir("flatpak")), b.Flatpaks)
+	}
+	return packages, flatpaks
+}
+
+// downloadPackages downloads names into dir with download. One package
+// that is no longer in any repository fails the whole command, so each is
+// tried on its own after that. It returns the number of files saved.
+func downloadPackages(ctx context.Context, dir string, names []string, download func(ctx context.Context, dir string, names []string) utils.CommandResult) int {
+	if err := utils.EnsureDir(dir); err != nil {
+		utils.Warn("Failed to create %s: %v", dir, err)
+		return 0
+	}
+	if result := download(ctx, dir, names); result.Error != nil && ctx.Err() == nil {
+		for _, name := range names {
+			if result := download(ctx, dir, []string{name}); result.Error != nil {
+				utils.Warn("Failed to download %s: %s", name, result.Stderr)
+			}
+		}
+	}
+	files, _ := os.ReadDir(dir)
+	return len(files)
+}
+
+// downloadRPMs downloads names with every package they need, including
+// those installed here already, which a fresh system may lack
+func downloadRPMs(ctx context.Context, dir string, names []string) utils.CommandResult {
+	args := append([]string{"download", "--resolve", "--alldeps", "--destdir", dir}, names...)
+	return utils.RunCommandInDir(ctx, "", downloadTimeout, "dnf", args...)
+}
+
+// downloadDebs downloads names with everything they depend on. apt-get
+// download only fetches what it is given, so the dependencies are listed
+// with apt-cache first.
+func downloadDebs(ctx context.Context, dir string, names []string) utils.CommandResult {
+	args := append([]string{"depends", "--recurse", "--no-recommends", "--no-suggests",
+		"--no-conflicts", "--no-breaks", "--no-replaces", "--no-enhances"}, names...)
+	result := utils.RunCommandInDir(ctx, "", downloadTimeout, "apt-cache", args...)
+	if result.Error != nil {
+		return result
+	}
+
+	// Packages are the unindented lines; <name> is a virtual package
+	var all []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "<") {
+			all = append(all, strings.TrimSpace(line))
+		}
+	}
+	return utils.RunCommandInDir(ctx, dir, downloadTimeout, "apt-get", append([]string{"download"}, all...)...)
+}
+
+// downloadFlatpaks writes apps, their runtimes and extensions to an offline
+// repository in dir, which flatpak install --sideload-repo reads. The
+// remote they came from must have a collection ID. It returns the number
+// of apps saved.
+func downloadFlatpaks(ctx context.Context, dir string, apps []string) int {
+	if err := utils.EnsureDir(dir); err != nil {
+		utils.Warn("Failed to create %s: %v", dir, err)
+		return 0
+	}
+	args := append([]string{"create-usb", "--allow-partial", dir}, apps...)
+	if result := utils.RunCommandInDir(ctx, "", downloadTimeout, "flatpak", args...); result.Error != nil {
+		utils.Warn("Failed to save Flatpaks for offline install: %s", result.Stderr)
+		os.RemoveAll(dir)
+		return 0
+	}
+	return len(apps)
+}
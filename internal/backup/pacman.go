@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// PacmanBackup handles pacman package backup for Arch and Manjaro
+type PacmanBackup struct {
+	root string
+}
+
+func NewPacmanBackup() *PacmanBackup { return &PacmanBackup{} }
+
+// SetRoot queries the pacman database of the root filesystem mounted at
+// root, whose sync databases tell native packages from foreign ones
+func (p *PacmanBackup) SetRoot(root string) { p.root = root }
+
+// ListNative returns explicitly installed packages from the sync repositories
+func (p *PacmanBackup) ListNative(ctx context.Context) ([]string, error) {
+	return p.query(ctx, "-Qqen")
+}
+
+// ListForeign returns explicitly installed packages that are not in any sync
+// repository, which in practice means AUR packages
+func (p *PacmanBackup) ListForeign(ctx context.Context) ([]string, error) {
+	return p.query(ctx, "-Qqem")
+}
+
+func (p *PacmanBackup) query(ctx context.Context, flags string) ([]string, error) {
+	args := []string{flags}
+	if p.root != "" {
+		args = append([]string{"--dbpath", filepath.Join(p.root, "var", "lib", "pacman")}, args...)
+	}
+	result := utils.RunCommandContext(ctx, "pacman", args...)
+	// pacman exits 1 when the query matches nothing
+	if result.Error != nil && result.ExitCode != 1 {
+		return nil, result.Error
+	}
+
+	var packages []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !p.isBasePackage(line) {
+			packages = append(packages, line)
+		}
+	}
+	return packages, nil
+}
+
+// isBasePackage filters out base system packages
+func (p *PacmanBackup) isBasePackage(pkg string) bool {
+	base := map[string]bool{
+		"base": true, "base-devel": true, "filesystem": true, "pacman": true,
+		"linux": true, "linux-lts": true, "linux-zen": true, "linux-firmware": true,
+		"systemd": true, "glibc": true, "bash": true, "coreutils": true,
+		"archlinux-keyring": true, "manjaro-keyring": true, "manjaro-release": true,
+	}
+	return base[pkg]
+}
@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"context"
+	"sync"
+)
+
+// maxParallel bounds how many components are collected at once. Most of the
+// time goes to waiting on package managers, so a few at a time hides that
+// without having every tool query the package database together.
+const maxParallel = 4
+
+// runParallel runs each job on at most maxParallel goroutines and waits for
+// all of them to finish. Once ctx is done no further jobs are started; jobs
+// already running are expected to return early through ctx.
+func runParallel(ctx context.Context, jobs []func(ctx context.Context)) {
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, job := range jobs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		if ctx.Err() != nil {
+			<-sem
+			return
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			job(ctx)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -11,13 +12,26 @@ import (
 )
 
 // ReposBackup handles repository backup
-type ReposBackup struct{}
+type ReposBackup struct {
+	root string
+}
 
 // NewReposBackup creates a new ReposBackup instance
 func NewReposBackup() *ReposBackup {
 	return &ReposBackup{}
 }
 
+// SetRoot backs up the repositories and imported keys of the root
+// filesystem mounted at root
+func (r *ReposBackup) SetRoot(root string) {
+	r.root = root
+}
+
+// reposDir returns the .repo file directory of r's root filesystem
+func (r *ReposBackup) reposDir() string {
+	return filepath.Join("/", r.root, "etc", "yum.repos.d")
+}
+
 // Name returns the display name
 func (r *ReposBackup) Name() string {
 	return "DNF Repositories"
@@ -30,7 +44,7 @@ func (r *ReposBackup) Type() BackupType {
 
 // Available checks if DNF repos directory exists
 func (r *ReposBackup) Available() bool {
-	return utils.DirExists("/etc/yum.repos.d")
+	return utils.DirExists(r.reposDir())
 }
 
 // RepoInfo contains information about a repository
@@ -46,7 +60,7 @@ type RepoInfo struct {
 }
 
 // List returns enabled third-party repositories
-func (r *ReposBackup) List() ([]BackupItem, error) {
+func (r *ReposBackup) List(ctx context.Context) ([]BackupItem, error) {
 	repos, err := r.listRepos()
 	if err != nil {
 		return nil, err
@@ -107,7 +121,7 @@ func (r *ReposBackup) isFedoraBaseRepo(repoID string) bool {
 
 // listRepos parses all .repo files
 func (r *ReposBackup) listRepos() ([]RepoInfo, error) {
-	reposDir := "/etc/yum.repos.d"
+	reposDir := r.reposDir()
 
 	entries, err := os.ReadDir(reposDir)
 	if err != nil {
@@ -208,13 +222,13 @@ type ReposData struct {
 }
 
 // Backup performs the repos backup
-func (r *ReposBackup) Backup(backupDir string) (BackupResult, error) {
+func (r *ReposBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeRepos,
 		Timestamp: time.Now(),
 	}
 
-	items, err := r.List()
+	items, err := r.List(ctx)
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
@@ -246,7 +260,7 @@ func (r *ReposBackup) Backup(backupDir string) (BackupResult, error) {
 
 	var copiedFiles []string
 	for fileName := range fileSet {
-		srcPath := filepath.Join("/etc/yum.repos.d", fileName)
+		srcPath := filepath.Join(r.reposDir(), fileName)
 		dstPath := filepath.Join(reposBackupDir, fileName)
 
 		if err := utils.CopyFile(srcPath, dstPath); err != nil {
@@ -257,7 +271,7 @@ func (r *ReposBackup) Backup(backupDir string) (BackupResult, error) {
 	}
 
 	// Also backup GPG keys if accessible
-	r.backupGPGKeys(backupDir)
+	r.backupGPGKeys(ctx, backupDir)
 
 	data := ReposData{
 		Repos:     thirdPartyRepos,
@@ -286,14 +300,14 @@ func (r *ReposBackup) Backup(backupDir string) (BackupResult, error) {
 }
 
 // backupGPGKeys attempts to backup imported GPG keys
-func (r *ReposBackup) backupGPGKeys(backupDir string) {
+func (r *ReposBackup) backupGPGKeys(ctx context.Context, backupDir string) {
 	keysDir := filepath.Join(backupDir, "gpg-keys")
 	if err := utils.EnsureDir(keysDir); err != nil {
 		return
 	}
 
 	// Export RPM GPG keys
-	result := utils.RunCommand("rpm", "-qa", "gpg-pubkey*")
+	result := utils.RunCommandContext(ctx, "rpm", rpmRoot(r.root, "-qa", "gpg-pubkey*")...)
 	if result.Error != nil {
 		return
 	}
@@ -304,7 +318,7 @@ func (r *ReposBackup) backupGPGKeys(backupDir string) {
 			continue
 		}
 
-		exportResult := utils.RunCommand("rpm", "-qi", key)
+		exportResult := utils.RunCommandContext(ctx, "rpm", rpmRoot(r.root, "-qi", key)...)
 		if exportResult.Error == nil {
 			keyFile := filepath.Join(keysDir, key+".txt")
 			utils.WriteFile(keyFile, []byte(exportResult.Stdout))
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"path/filepath"
 	"strings"
@@ -10,13 +11,39 @@ import (
 )
 
 // RPMBackup handles RPM package backup
-type RPMBackup struct{}
+type RPMBackup struct {
+	root string
+}
 
 // NewRPMBackup creates a new RPMBackup instance
 func NewRPMBackup() *RPMBackup {
 	return &RPMBackup{}
 }
 
+// SetRoot lists the packages of the root filesystem mounted at root. dnf
+// only reads what it has cached there and never downloads metadata; without
+// dnf every installed package is listed.
+func (r *RPMBackup) SetRoot(root string) {
+	r.root = root
+}
+
+// rpmRoot returns the rpm arguments args for the root filesystem mounted at
+// root, or for this system if root is ""
+func rpmRoot(root string, args ...string) []string {
+	if root == "" {
+		return args
+	}
+	return append([]string{"--root", root}, args...)
+}
+
+// dnfArgs returns the dnf arguments args for r's root filesystem
+func (r *RPMBackup) dnfArgs(args ...string) []string {
+	if r.root == "" {
+		return args
+	}
+	return append([]string{"--installroot=" + r.root, "--cacheonly", "--disablerepo=*"}, args...)
+}
+
 // Name returns the display name
 func (r *RPMBackup) Name() string {
 	return "RPM Packages"
@@ -33,19 +60,19 @@ func (r *RPMBackup) Available() bool {
 }
 
 // List returns user-installed RPM packages
-func (r *RPMBackup) List() ([]BackupItem, error) {
+func (r *RPMBackup) List(ctx context.Context) ([]BackupItem, error) {
 	var packages []string
 	var err error
 
 	// Try dnf first (preferred for user-installed detection)
 	if utils.CommandExists("dnf") {
-		packages, err = r.listDNFUserInstalled()
+		packages, err = r.listDNFUserInstalled(ctx)
 		if err != nil {
 			utils.Warn("DNF user-installed listing failed, falling back to rpm: %v", err)
-			packages, err = r.listAllRPM()
+			packages, err = r.listAllRPM(ctx)
 		}
 	} else {
-		packages, err = r.listAllRPM()
+		packages, err = r.listAllRPM(ctx)
 	}
 
 	if err != nil {
@@ -67,12 +94,12 @@ func (r *RPMBackup) List() ([]BackupItem, error) {
 }
 
 // listDNFUserInstalled gets packages explicitly installed by user
-func (r *RPMBackup) listDNFUserInstalled() ([]string, error) {
+func (r *RPMBackup) listDNFUserInstalled(ctx context.Context) ([]string, error) {
 	// Get user-installed packages using dnf repoquery
-	result := utils.RunCommand("dnf", "repoquery", "--userinstalled", "--qf", "%{name}")
+	result := utils.RunCommandContext(ctx, "dnf", r.dnfArgs("repoquery", "--userinstalled", "--qf", "%{name}")...)
 	if result.Error != nil {
 		// Fallback to history method
-		return r.listDNFHistory()
+		return r.listDNFHistory(ctx)
 	}
 
 	var packages []string
@@ -87,8 +114,8 @@ func (r *RPMBackup) listDNFUserInstalled() ([]string, error) {
 }
 
 // listDNFHistory uses dnf history to find user-installed packages
-func (r *RPMBackup) listDNFHistory() ([]string, error) {
-	result := utils.RunCommand("dnf", "history", "userinstalled")
+func (r *RPMBackup) listDNFHistory(ctx context.Context) ([]string, error) {
+	result := utils.RunCommandContext(ctx, "dnf", r.dnfArgs("history", "userinstalled")...)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -105,8 +132,8 @@ func (r *RPMBackup) listDNFHistory() ([]string, error) {
 }
 
 // listAllRPM gets all installed packages (less precise)
-func (r *RPMBackup) listAllRPM() ([]string, error) {
-	result := utils.RunCommand("rpm", "-qa", "--qf", "%{NAME}\n")
+func (r *RPMBackup) listAllRPM(ctx context.Context) ([]string, error) {
+	result := utils.RunCommandContext(ctx, "rpm", rpmRoot(r.root, "-qa", "--qf", "%{NAME}\n")...)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -154,13 +181,13 @@ type RPMData struct {
 }
 
 // Backup performs the RPM backup
-func (r *RPMBackup) Backup(backupDir string) (BackupResult, error) {
+func (r *RPMBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
 	result := BackupResult{
 		Type:      BackupTypeRPM,
 		Timestamp: time.Now(),
 	}
 
-	packages, err := r.List()
+	packages, err := r.List(ctx)
 	if err != nil {
 		result.Error = err.Error()
 		return result, err
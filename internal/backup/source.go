@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// Source is what a backup is taken from. The zero Source is the running
+// system and the current user's home directory.
+//
+// Root is the root filesystem of a system that isn't running, such as the
+// disk of a laptop that won't boot mounted at /mnt/old: package lists are
+// read from its rpm, dpkg or pacman database, Flatpaks from its
+// installations and repository files from below it. Home is the home
+// directory files and settings are read from; it defaults to the current
+// user's home directory inside Root.
+type Source struct {
+	Root string
+	Home string
+}
+
+// NewSource returns the source for a root filesystem and home directory,
+// either of which may be "". Both must be existing directories.
+func NewSource(root, home string) (Source, error) {
+	var s Source
+	for _, p := range []struct {
+		path  *string
+		value string
+		what  string
+	}{{&s.Root, root, "source root"}, {&s.Home, home, "source home"}} {
+		if p.value == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p.value)
+		if err != nil {
+			return Source{}, err
+		}
+		if !utils.DirExists(abs) {
+			return Source{}, fmt.Errorf("%s %s is not a directory", p.what, p.value)
+		}
+		*p.path = abs
+	}
+	if s.Root == "/" {
+		s.Root = ""
+	}
+	return s, nil
+}
+
+// LiveSystem reports whether packages and system files are read from the
+// running system
+func (s Source) LiveSystem() bool {
+	return s.Root == ""
+}
+
+// LiveHome reports whether files and settings are read from the current
+// user's home directory, where dconf and gnome-extensions can be asked
+func (s Source) LiveHome() bool {
+	home, err := s.HomeDir()
+	current, currentErr := utils.GetHomeDir()
+	return err == nil && currentErr == nil && home == current
+}
+
+// Live reports whether s is the running system and the current user's home
+func (s Source) Live() bool {
+	return s.LiveSystem() && s.LiveHome()
+}
+
+// HomeDir returns the home directory to back up
+func (s Source) HomeDir() (string, error) {
+	if s.Home != "" {
+		return s.Home, nil
+	}
+	home, err := utils.GetHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if s.Root == "" {
+		return home, nil
+	}
+	return filepath.Join(s.Root, home), nil
+}
+
+// SystemPath returns where the system path p is in the source
+func (s Source) SystemPath(p string) string {
+	if s.Root == "" {
+		return p
+	}
+	return filepath.Join(s.Root, p)
+}
+
+// PackageManager returns the package manager of the source's system
+func (s Source) PackageManager() PackageManager {
+	if s.LiveSystem() {
+		return DetectPackageManager()
+	}
+	return DetectPackageManagerIn(s.Root)
+}
+
+// Hostname returns the name of the source's system
+func (s Source) Hostname() string {
+	if s.LiveSystem() {
+		hostname, _ := os.Hostname()
+		return hostname
+	}
+	if data, err := os.ReadFile(s.SystemPath("/etc/hostname")); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// User returns the name of the user whose home is backed up
+func (s Source) User() string {
+	if s.LiveHome() {
+		return os.Getenv("USER")
+	}
+	home, _ := s.HomeDir()
+	return filepath.Base(home)
+}
+
+// String describes the source for messages
+func (s Source) String() string {
+	home, _ := s.HomeDir()
+	switch {
+	case s.Live():
+		return "this system"
+	case s.LiveSystem():
+		return home
+	case s.Home == "":
+		return s.Root
+	}
+	return fmt.Sprintf("%s with home %s", s.Root, home)
+}
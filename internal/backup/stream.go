@@ -0,0 +1,346 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// fullArchive streams files into a Full Save archive and keeps the SHA-256
+// of each one for the manifest. Only the checksum map grows with the
+// backup; file contents pass through a fixed-size copy buffer.
+//
+// Unreadable sources are skipped with a warning, like a failed copy was
+// before. A write error is fatal: it sticks, later adds do nothing, and
+// close returns it. Cancelling ctx is treated the same way.
+//
+// Without a writer the archive only counts: adds stat their sources and
+// tally the sections, files and bytes a real run would write, so progress
+// can be shown against totals.
+type fullArchive struct {
+	ctx       context.Context
+	w         *archiveWriter
+	links     utils.SymlinkMode
+	progress  *utils.Progress
+	checksums map[string]string
+	dirs      map[string]bool
+	buf       []byte
+	err       error
+
+	// Totals gathered when counting
+	counting bool
+	steps    int
+	files    int
+	bytes    int64
+}
+
+func newFullArchive(ctx context.Context, w *archiveWriter, links utils.SymlinkMode, progress *utils.Progress) *fullArchive {
+	return &fullArchive{
+		ctx:       ctx,
+		w:         w,
+		links:     links,
+		progress:  progress,
+		counting:  w == nil,
+		checksums: make(map[string]string),
+		dirs:      make(map[string]bool),
+		buf:       make([]byte, 64*1024),
+	}
+}
+
+// fail makes err the archive's error unless it already has one
+func (a *fullArchive) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// close flushes the archive and returns the first write error
+func (a *fullArchive) close() error {
+	if err := a.w.Close(); a.err == nil {
+		a.err = err
+	}
+	return a.err
+}
+
+// startSection marks the start of a named part of the backup
+func (a *fullArchive) startSection(name string) {
+	if a.counting {
+		a.steps++
+		return
+	}
+	a.progress.Start(name)
+}
+
+// endSection marks the end of the current section
+func (a *fullArchive) endSection() {
+	if !a.counting {
+		a.progress.StepDone()
+	}
+}
+
+func (a *fullArchive) writeHeader(header *tar.Header) bool {
+	if a.err == nil {
+		a.err = a.ctx.Err()
+	}
+	if a.err != nil {
+		return false
+	}
+	if a.counting {
+		return true
+	}
+	a.err = a.w.tar.WriteHeader(header)
+	return a.err == nil
+}
+
+// addParents writes a header for each directory above name that is not in
+// the archive yet
+func (a *fullArchive) addParents(name string) {
+	dir := path.Dir(name)
+	if dir == "." || a.dirs[dir] {
+		return
+	}
+	a.addParents(dir)
+	a.dirs[dir] = true
+	a.writeHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0755,
+		ModTime:  time.Now(),
+	})
+}
+
+// addBytes adds a file generated in memory, such as a JSON index
+func (a *fullArchive) addBytes(name string, data []byte) {
+	if a.addData(name, data) {
+		sum := sha256.Sum256(data)
+		a.checksums[name] = hex.EncodeToString(sum[:])
+	}
+}
+
+// addManifest adds the manifest, the one file without a checksum
+func (a *fullArchive) addManifest(data []byte) {
+	a.addData(manifestName, data)
+}
+
+func (a *fullArchive) addData(name string, data []byte) bool {
+	a.addParents(name)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if !a.writeHeader(header) {
+		return false
+	}
+	if a.counting {
+		return true
+	}
+	_, a.err = a.w.tar.Write(data)
+	return a.err == nil
+}
+
+// addFile adds the file at src as name, following symlinks. It reports
+// whether the file was added.
+func (a *fullArchive) addFile(name, src string) bool {
+	if a.err != nil {
+		return false
+	}
+	if a.counting {
+		info, err := os.Stat(src)
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+		a.files++
+		a.bytes += info.Size()
+		return true
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		utils.Warn("Failed to backup %s: %v", src, err)
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		utils.Warn("Failed to backup %s: not a regular file", src)
+		return false
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		utils.Warn("Failed to backup %s: %v", src, err)
+		return false
+	}
+	header.Name = name
+
+	a.addParents(name)
+	if !a.writeHeader(header) {
+		return false
+	}
+
+	// The header promised header.Size bytes. A file that shrank while being
+	// read is padded with zeros so the archive stays readable, but gets no
+	// checksum: verifying the archive reports it instead of vouching for
+	// the padding.
+	hash := sha256.New()
+	tw := &stickyWriter{w: a.w.tar}
+	out := io.MultiWriter(tw, hash)
+	n, _ := io.CopyBuffer(out, io.LimitReader(f, header.Size), a.buf)
+	if tw.err != nil {
+		a.err = tw.err
+		return false
+	}
+	if n < header.Size {
+		utils.Warn("Failed to backup %s: it changed while being read", src)
+		if _, err := io.CopyBuffer(tw, io.LimitReader(zeroReader{}, header.Size-n), a.buf); err != nil {
+			a.err = err
+		}
+		return false
+	}
+
+	a.checksums[name] = hex.EncodeToString(hash.Sum(nil))
+	a.progress.FileDone(header.Size)
+	return true
+}
+
+// addTree adds src and everything below it as name, applying the archive's
+// symlink mode. It returns the number of files and links added.
+func (a *fullArchive) addTree(name, src string) int {
+	count := 0
+	err := utils.WalkTree(src, a.links, func(e utils.TreeEntry) error {
+		entryName := path.Join(name, filepath.ToSlash(e.Rel))
+		switch {
+		case e.Info.IsDir():
+			if a.dirs[entryName] {
+				return a.err // Already added from another source directory
+			}
+			header, err := tar.FileInfoHeader(e.Info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = entryName + "/"
+			a.addParents(entryName)
+			a.dirs[entryName] = true
+			a.writeHeader(header)
+		case e.Link != "":
+			header, err := tar.FileInfoHeader(e.Info, e.Link)
+			if err != nil {
+				return err
+			}
+			header.Name = entryName
+			a.addParents(entryName)
+			if a.writeHeader(header) {
+				a.checksums[entryName] = utils.HashLink(e.Link)
+				count++
+			}
+		default:
+			if a.addFile(entryName, e.Path) {
+				count++
+			}
+		}
+		return a.err
+	})
+	if err != nil && a.err == nil {
+		utils.Warn("Failed to backup %s: %v", src, err)
+	}
+	return count
+}
+
+// addDotfiles adds the dotfiles component: copies under dotfiles/ and the
+// dotfiles.json index DotfilesRestore reads, with the skeleton versions of
+// the system at root. It returns the number saved.
+func (a *fullArchive) addDotfiles(list []string, home, root string) int {
+	d := NewDotfilesBackupWithList(list)
+	d.SetHome(home)
+	d.SetSymlinkMode(a.links)
+	entries, err := d.Entries()
+	if err != nil {
+		utils.Warn("Failed to backup dotfiles: %v", err)
+		return 0
+	}
+
+	var files []DotfileInfo
+	for _, entry := range entries {
+		name := path.Join("dotfiles", filepath.ToSlash(entry.RelativePath))
+		switch {
+		case entry.LinkTarget != "":
+			// Saved as a link, nothing to copy
+		case entry.IsDir:
+			a.addTree(name, entry.Path)
+		case !a.addFile(name, entry.Path):
+			continue
+		}
+		files = append(files, entry)
+	}
+
+	data, err := json.MarshalIndent(DotfilesData{Files: files, BackupDir: "dotfiles", SourceHome: home, Skeleton: SkeletonFilesIn(root, files)}, "", "  ")
+	if err == nil {
+		a.addBytes("dotfiles.json", data)
+	}
+	return len(files)
+}
+
+// addBacker adds the output of a backer that can only write to a directory.
+// The repository backers produce a few small files, so a scratch directory
+// is cheap for them; it is removed once its contents are in the archive.
+func (a *fullArchive) addBacker(b Backer) bool {
+	if a.counting {
+		return false
+	}
+
+	scratch, err := os.MkdirTemp("", "rego-section-*")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(scratch)
+
+	if _, err := b.Backup(utils.WithCommandHook(a.ctx, a.progress.Run), scratch); err != nil {
+		return false
+	}
+
+	entries, err := os.ReadDir(scratch)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		a.addTree(entry.Name(), filepath.Join(scratch, entry.Name()))
+	}
+	return a.err == nil
+}
+
+// stickyWriter remembers the first write error, to tell it apart from a
+// read error in the same copy
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
+
+// zeroReader reads an endless run of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
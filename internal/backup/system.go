@@ -40,6 +40,22 @@ func DetectPackageManager() PackageManager {
 	return PMUnknown
 }
 
+// DetectPackageManagerIn returns the package manager of the root filesystem
+// mounted at root, from the database or binary each one leaves behind
+func DetectPackageManagerIn(root string) PackageManager {
+	switch {
+	case utils.FileExists(filepath.Join(root, "var", "lib", "dpkg", "status")):
+		return PMAPT
+	case utils.FileExists(filepath.Join(root, "usr", "bin", "zypper")):
+		return PMZypper
+	case utils.FileExists(filepath.Join(root, "usr", "bin", "dnf")):
+		return PMDNF
+	case utils.DirExists(filepath.Join(root, "var", "lib", "pacman", "local")):
+		return PMPacman
+	}
+	return PMUnknown
+}
+
 // GetPackageManagerName returns a friendly name
 func GetPackageManagerName() string {
 	switch DetectPackageManager() {
@@ -56,83 +72,16 @@ func GetPackageManagerName() string {
 	}
 }
 
-// APTBackup handles apt package backup for Debian/Ubuntu
-type APTBackup struct{}
-
-func NewAPTBackup() *APTBackup { return &APTBackup{} }
-
-// ListUserInstalled returns manually installed packages
-func (a *APTBackup) ListUserInstalled() ([]string, error) {
-	// apt-mark showmanual lists manually installed packages
-	result := utils.RunCommand("apt-mark", "showmanual")
-	if result.Error != nil {
-		return nil, result.Error
-	}
-
-	var packages []string
-	for _, line := range strings.Split(result.Stdout, "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" && !a.isBasePackage(line) {
-			packages = append(packages, line)
-		}
-	}
-	return packages, nil
-}
-
-// isBasePackage filters out base system packages
-func (a *APTBackup) isBasePackage(pkg string) bool {
-	base := map[string]bool{
-		"apt": true, "dpkg": true, "base-files": true, "init": true,
-		"systemd": true, "libc6": true, "bash": true, "coreutils": true,
-		"ubuntu-minimal": true, "ubuntu-standard": true, "ubuntu-desktop": true,
-		"debian-archive-keyring": true, "ubuntu-keyring": true,
-	}
-	return base[pkg]
-}
-
-// BackupSources backs up APT source lists
-func (a *APTBackup) BackupSources(destDir string) (int, error) {
-	utils.EnsureDir(destDir)
-	count := 0
-
-	// Copy /etc/apt/sources.list
-	if utils.FileExists("/etc/apt/sources.list") {
-		if utils.CopyFile("/etc/apt/sources.list", filepath.Join(destDir, "sources.list")) == nil {
-			count++
-		}
-	}
-
-	// Copy /etc/apt/sources.list.d/*.list
-	sourcesDir := "/etc/apt/sources.list.d"
-	if utils.DirExists(sourcesDir) {
-		files, _ := filepath.Glob(filepath.Join(sourcesDir, "*.list"))
-		for _, f := range files {
-			if utils.CopyFile(f, filepath.Join(destDir, filepath.Base(f))) == nil {
-				count++
-			}
-		}
-	}
-
-	// Copy trusted GPG keys
-	keysDir := "/etc/apt/trusted.gpg.d"
-	if utils.DirExists(keysDir) {
-		keysDest := filepath.Join(destDir, "trusted.gpg.d")
-		utils.EnsureDir(keysDest)
-		files, _ := filepath.Glob(filepath.Join(keysDir, "*"))
-		for _, f := range files {
-			if utils.CopyFile(f, filepath.Join(keysDest, filepath.Base(f))) == nil {
-				count++
-			}
-		}
-	}
-
-	return count, nil
-}
-
 // GetDistro returns the Linux distribution name
 func GetDistro() string {
+	return GetDistroIn("")
+}
+
+// GetDistroIn returns the distribution name of the root filesystem mounted
+// at root, or of this system if root is ""
+func GetDistroIn(root string) string {
 	// Try /etc/os-release
-	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+	if data, err := os.ReadFile(filepath.Join(root, "/etc/os-release")); err == nil {
 		for _, line := range strings.Split(string(data), "\n") {
 			if strings.HasPrefix(line, "ID=") {
 				return strings.Trim(strings.TrimPrefix(line, "ID="), "\"")
@@ -140,13 +89,13 @@ func GetDistro() string {
 		}
 	}
 	// Fallback checks
-	if utils.FileExists("/etc/fedora-release") {
+	if utils.FileExists(filepath.Join(root, "/etc/fedora-release")) {
 		return "fedora"
 	}
-	if utils.FileExists("/etc/debian_version") {
+	if utils.FileExists(filepath.Join(root, "/etc/debian_version")) {
 		return "debian"
 	}
-	if utils.FileExists("/etc/arch-release") {
+	if utils.FileExists(filepath.Join(root, "/etc/arch-release")) {
 		return "arch"
 	}
 	return "unknown"
@@ -154,12 +103,18 @@ func GetDistro() string {
 
 // GetDistroName returns a friendly distro name
 func GetDistroName() string {
-	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+	return GetDistroNameIn("")
+}
+
+// GetDistroNameIn returns the friendly distro name of the root filesystem
+// mounted at root, or of this system if root is ""
+func GetDistroNameIn(root string) string {
+	if data, err := os.ReadFile(filepath.Join(root, "/etc/os-release")); err == nil {
 		for _, line := range strings.Split(string(data), "\n") {
 			if strings.HasPrefix(line, "PRETTY_NAME=") {
 				return strings.Trim(strings.TrimPrefix(line, "PRETTY_NAME="), "\"")
 			}
 		}
 	}
-	return GetDistro()
+	return GetDistroIn(root)
 }
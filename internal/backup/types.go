@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"time"
 )
 
@@ -15,6 +16,10 @@ const (
 	BackupTypeGnomeSettings   BackupType = "gnome_settings"
 	BackupTypeDotfiles        BackupType = "dotfiles"
 	BackupTypeFonts           BackupType = "fonts"
+	BackupTypeZypper          BackupType = "zypper"
+	BackupTypeZypperRepos     BackupType = "zypper_repos"
+	BackupTypeAPT             BackupType = "apt"
+	BackupTypeAPTSources      BackupType = "apt_sources"
 )
 
 // BackupItem represents a single item that can be backed up
@@ -46,6 +51,9 @@ type BackupManifest struct {
 	Results     map[BackupType]BackupResult `json:"results"`
 	BackupPath  string                      `json:"backup_path"`
 	Description string                      `json:"description,omitempty"`
+	// Checksums maps every file in the backup directory, relative to it, to
+	// its SHA-256
+	Checksums map[string]string `json:"checksums,omitempty"`
 }
 
 // BackupOptions configures backup behavior
@@ -57,6 +65,11 @@ type BackupOptions struct {
 	IncludeGnomeSettings   bool     `json:"include_gnome_settings"`
 	IncludeDotfiles        bool     `json:"include_dotfiles"`
 	IncludeFonts           bool     `json:"include_fonts"`
+	IncludeZypper          bool     `json:"include_zypper"`
+	IncludeZypperRepos     bool     `json:"include_zypper_repos"`
+	IncludeAPT             bool     `json:"include_apt"`
+	IncludeAPTSources      bool     `json:"include_apt_sources"`
+	FollowSymlinks         bool     `json:"follow_symlinks"`
 	DotfilesList           []string `json:"dotfiles_list,omitempty"`
 	BackupPath             string   `json:"backup_path"`
 	Description            string   `json:"description,omitempty"`
@@ -72,6 +85,10 @@ func DefaultBackupOptions() BackupOptions {
 		IncludeGnomeSettings:   true,
 		IncludeDotfiles:        true,
 		IncludeFonts:           true,
+		IncludeZypper:          true,
+		IncludeZypperRepos:     true,
+		IncludeAPT:             true,
+		IncludeAPTSources:      true,
 		DotfilesList:           DefaultDotfiles(),
 	}
 }
@@ -106,9 +123,9 @@ type Backer interface {
 	// Available checks if this backup type is available on the system
 	Available() bool
 	// List returns items that would be backed up
-	List() ([]BackupItem, error)
+	List(ctx context.Context) ([]BackupItem, error)
 	// Backup performs the backup to the specified directory
-	Backup(backupDir string) (BackupResult, error)
+	Backup(ctx context.Context, backupDir string) (BackupResult, error)
 }
 
 // AllBackupTypes returns all available backup types
@@ -117,6 +134,10 @@ func AllBackupTypes() []BackupType {
 		BackupTypeFlatpak,
 		BackupTypeRPM,
 		BackupTypeRepos,
+		BackupTypeZypper,
+		BackupTypeZypperRepos,
+		BackupTypeAPT,
+		BackupTypeAPTSources,
 		BackupTypeGnomeExtensions,
 		BackupTypeGnomeSettings,
 		BackupTypeDotfiles,
@@ -134,6 +155,10 @@ func BackupTypeName(t BackupType) string {
 		BackupTypeGnomeSettings:   "GNOME Settings",
 		BackupTypeDotfiles:        "Dotfiles",
 		BackupTypeFonts:           "User Fonts",
+		BackupTypeZypper:          "Zypper Packages",
+		BackupTypeZypperRepos:     "Zypper Repositories",
+		BackupTypeAPT:             "APT Packages",
+		BackupTypeAPTSources:      "APT Sources",
 	}
 	if name, ok := names[t]; ok {
 		return name
@@ -151,6 +176,10 @@ func BackupTypeDescription(t BackupType) string {
 		BackupTypeGnomeSettings:   "GNOME desktop customizations (dconf database)",
 		BackupTypeDotfiles:        "Shell configurations, git settings, and other dotfiles",
 		BackupTypeFonts:           "User-installed fonts from ~/.local/share/fonts",
+		BackupTypeZypper:          "User-installed openSUSE packages from zypper",
+		BackupTypeZypperRepos:     "Zypper repositories with priorities and GPG keys",
+		BackupTypeAPT:             "Manually installed Debian/Ubuntu packages",
+		BackupTypeAPTSources:      "APT source lists and their signing keys",
 	}
 	if desc, ok := descriptions[t]; ok {
 		return desc
@@ -0,0 +1,440 @@
+package backup
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Rejection is an entry of a loaded backup that failed validation. A backup
+// file may come from anywhere, so every name in it is checked against a
+// strict grammar before it reaches a command; rejected entries are left out
+// of the restore and reported instead.
+type Rejection struct {
+	Field  string `json:"field"` // Where the entry came from, e.g. "flatpaks"
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s: %q %s", r.Field, r.Value, r.Reason)
+}
+
+// maxNameLength bounds every name, no package manager allows longer ones
+const maxNameLength = 255
+
+var (
+	// Package names of dnf, apt, pacman and zypper, with apt's ":arch"
+	packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_+][A-Za-z0-9._+@:~-]*$`)
+	// Reverse-DNS Flatpak application IDs with at least three elements
+	flatpakIDPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+){2,}$`)
+	// GNOME extension UUIDs are a name and a domain joined by "@"
+	extensionUUIDPattern = regexp.MustCompile(`^[A-Za-z0-9._+-]+@[A-Za-z0-9._+-]+$`)
+	// Flatpak branches and architectures, "stable" or "x86_64"
+	flatpakBranchPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+	// Flatpak commits are OSTree checksums
+	flatpakCommitPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// Repository IDs, zypper aliases, Flatpak remotes and KDE widget IDs
+	identifierPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._:+-]*$`)
+	// dconf dump section headers, "[/]" or "[org/gnome/desktop/interface]"
+	dconfSectionPattern = regexp.MustCompile(`^\[(/|[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)*)\]$`)
+	dconfKeyPattern     = regexp.MustCompile(`^[A-Za-z0-9_.-]+=`)
+)
+
+// urlSchemes are the schemes a repository or key URL may use; zypper also
+// knows the local media ones
+var urlSchemes = []string{"http", "https", "ftp", "file", "dir", "cd", "dvd", "iso"}
+
+// checkPattern checks value against pattern, named what for the error
+func checkPattern(value string, pattern *regexp.Regexp, what string) error {
+	if len(value) > maxNameLength {
+		return fmt.Errorf("is longer than %d characters", maxNameLength)
+	}
+	if !pattern.MatchString(value) {
+		return fmt.Errorf("is not a valid %s", what)
+	}
+	return nil
+}
+
+// CheckPackageName checks a package name from a backup
+func CheckPackageName(name string) error {
+	return checkPattern(name, packageNamePattern, "package name")
+}
+
+// CheckFlatpakID checks a Flatpak application ID from a backup
+func CheckFlatpakID(id string) error {
+	return checkPattern(id, flatpakIDPattern, "Flatpak application ID")
+}
+
+// CheckExtensionUUID checks a GNOME extension UUID from a backup
+func CheckExtensionUUID(uuid string) error {
+	return checkPattern(uuid, extensionUUIDPattern, "extension UUID")
+}
+
+// CheckIdentifier checks a repository ID, zypper alias, Flatpak remote name
+// or KDE widget ID from a backup
+func CheckIdentifier(id string) error {
+	return checkPattern(id, identifierPattern, "identifier")
+}
+
+// CheckText checks a free-form value that is only shown, such as a
+// hostname or a repository's display name. Control characters are refused
+// so a backup can't send escape sequences to the terminal.
+func CheckText(s string) error {
+	if len(s) > 1024 {
+		return fmt.Errorf("is longer than 1024 characters")
+	}
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return fmt.Errorf("contains control characters")
+	}
+	return nil
+}
+
+// CheckURL checks a repository or key URL from a backup
+func CheckURL(raw string) error {
+	if raw == "" || strings.HasPrefix(raw, "-") || strings.ContainsAny(raw, " \t") {
+		return fmt.Errorf("is not a valid URL")
+	}
+	if err := CheckText(raw); err != nil {
+		return err
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("is not a valid URL")
+	}
+	for _, scheme := range urlSchemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("has unsupported URL scheme %q", u.Scheme)
+}
+
+// CheckRelativePath checks a path a backup stores relative to a directory,
+// which must stay inside it
+func CheckRelativePath(path string) error {
+	if path == "" || filepath.IsAbs(path) || filepath.Clean(path) != path ||
+		path == ".." || strings.HasPrefix(path, "../") || strings.ContainsRune(path, 0) {
+		return fmt.Errorf("is not a clean path inside the backup")
+	}
+	return CheckText(path)
+}
+
+// CheckSystemPath checks an absolute path a backup restores to, which must
+// be below one of roots
+func CheckSystemPath(path string, roots ...string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || strings.ContainsRune(path, 0) {
+		return fmt.Errorf("is not an absolute, clean path")
+	}
+	for _, root := range roots {
+		if strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return CheckText(path)
+		}
+	}
+	return fmt.Errorf("is outside %s", strings.Join(roots, " and "))
+}
+
+// APTSourcePatterns and APTKeyPatterns are the only files an apt sources
+// backup may put back. The rest of /etc/apt is refused: a file in
+// apt.conf.d or preferences.d could run commands as root on the next
+// apt-get update.
+var (
+	APTSourcePatterns = []string{"/etc/apt/sources.list", "/etc/apt/sources.list.d/*.list", "/etc/apt/sources.list.d/*.sources"}
+	APTKeyPatterns    = []string{"/etc/apt/trusted.gpg.d/*", "/etc/apt/keyrings/*", "/usr/share/keyrings/*"}
+)
+
+// CheckAPTSourcePath checks the path of an apt source file from a backup
+func CheckAPTSourcePath(path string) error {
+	return checkPathPattern(path, APTSourcePatterns, "an apt source file")
+}
+
+// CheckAPTKeyPath checks the path of an apt signing key from a backup
+func CheckAPTKeyPath(path string) error {
+	return checkPathPattern(path, APTKeyPatterns, "an apt keyring")
+}
+
+// checkPathPattern checks that path is absolute and clean and matches one
+// of patterns, named what for the error
+func checkPathPattern(path string, patterns []string, what string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || strings.ContainsRune(path, 0) {
+		return fmt.Errorf("is not an absolute, clean path")
+	}
+	if !MatchesPathPattern(path, patterns) {
+		return fmt.Errorf("is not %s", what)
+	}
+	return CheckText(path)
+}
+
+// MatchesPathPattern reports whether path matches one of patterns, in
+// which * stays within one directory
+func MatchesPathPattern(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckZypperRepo checks every field of a saved zypper repository
+func CheckZypperRepo(repo ZypperRepo) error {
+	if err := CheckIdentifier(repo.Alias); err != nil {
+		return fmt.Errorf("alias %w", err)
+	}
+	if err := CheckText(repo.Name); err != nil {
+		return fmt.Errorf("name %w", err)
+	}
+	if err := CheckURL(repo.BaseURL); err != nil {
+		return fmt.Errorf("URL %w", err)
+	}
+	if repo.Priority < 0 || repo.Priority > 200 {
+		return fmt.Errorf("priority %d is not between 0 and 200", repo.Priority)
+	}
+	for _, key := range strings.Fields(repo.GPGKey) {
+		if filepath.IsAbs(key) {
+			if err := CheckSystemPath(key, "/etc/pki", "/usr/share", "/usr/lib/rpm"); err != nil {
+				return fmt.Errorf("GPG key %w", err)
+			}
+		} else if err := CheckURL(key); err != nil {
+			return fmt.Errorf("GPG key %w", err)
+		}
+	}
+	if repo.FileName != "" && (filepath.Base(repo.FileName) != repo.FileName || CheckIdentifier(repo.FileName) != nil) {
+		return fmt.Errorf("file name %q is not a plain file name", repo.FileName)
+	}
+	return nil
+}
+
+// CheckFlatpakDeployment checks a Flatpak app with its installation,
+// remote, commit, runtime and extensions from a backup
+func CheckFlatpakDeployment(d FlatpakDeployment) error {
+	if err := CheckFlatpakID(d.ID); err != nil {
+		return err
+	}
+	if err := CheckText(d.Name); err != nil {
+		return fmt.Errorf("has a name that %w", err)
+	}
+	for _, field := range []struct{ what, value string }{{"installation", d.Installation}, {"origin", d.Origin}} {
+		if field.value == "" {
+			continue
+		}
+		if err := CheckIdentifier(field.value); err != nil {
+			return fmt.Errorf("has an %s that %w", field.what, err)
+		}
+	}
+	if d.Branch != "" {
+		if err := checkPattern(d.Branch, flatpakBranchPattern, "branch"); err != nil {
+			return fmt.Errorf("has a branch that %w", err)
+		}
+	}
+	if d.Commit != "" && !flatpakCommitPattern.MatchString(d.Commit) {
+		return fmt.Errorf("has a commit %q that is not a checksum", d.Commit)
+	}
+	if d.Runtime != "" {
+		if err := checkFlatpakRef("runtime/" + d.Runtime); err != nil {
+			return fmt.Errorf("has a runtime that %w", err)
+		}
+	}
+	for _, ext := range d.Extensions {
+		if err := checkFlatpakRef(ext); err != nil {
+			return fmt.Errorf("has an extension that %w", err)
+		}
+	}
+	return nil
+}
+
+// checkFlatpakRef checks a ref such as runtime/org.gnome.Platform/x86_64/46
+func checkFlatpakRef(s string) error {
+	ref, ok := parseFlatpakRef(s)
+	if !ok || CheckFlatpakID(ref.id) != nil ||
+		checkPattern(ref.arch, flatpakBranchPattern, "") != nil || checkPattern(ref.branch, flatpakBranchPattern, "") != nil {
+		return fmt.Errorf("is not a valid Flatpak ref")
+	}
+	return nil
+}
+
+// CheckDconf checks that settings is a dconf dump: section headers and
+// key=value lines only, so nothing else reaches dconf load
+func CheckDconf(settings string) error {
+	if strings.ContainsRune(settings, 0) {
+		return fmt.Errorf("contains a NUL byte")
+	}
+	inSection := false
+	for i, line := range strings.Split(settings, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "["):
+			if !dconfSectionPattern.MatchString(line) {
+				return fmt.Errorf("line %d is not a valid section header", i+1)
+			}
+			inSection = true
+		case dconfKeyPattern.MatchString(line):
+			if !inSection {
+				return fmt.Errorf("line %d sets a key outside any section", i+1)
+			}
+		default:
+			return fmt.Errorf("line %d is not a key=value line", i+1)
+		}
+	}
+	return nil
+}
+
+// FilterValid returns the values check accepts and a rejection from field
+// for each of the others
+func FilterValid(field string, values []string, check func(string) error) ([]string, []Rejection) {
+	var valid []string
+	var rejected []Rejection
+	for _, value := range values {
+		if err := check(value); err != nil {
+			rejected = append(rejected, Rejection{Field: field, Value: value, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, value)
+	}
+	return valid, rejected
+}
+
+// FilterValidItems is FilterValid for the names of backup items
+func FilterValidItems(field string, items []BackupItem, check func(string) error) ([]BackupItem, []Rejection) {
+	var valid []BackupItem
+	var rejected []Rejection
+	for _, item := range items {
+		if err := check(item.Name); err != nil {
+			rejected = append(rejected, Rejection{Field: field, Value: item.Name, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, item)
+	}
+	return valid, rejected
+}
+
+// Validate drops the entries of b that fail validation and returns them.
+// Loading a backup validates it; Rejected keeps the result.
+func (b *LightBackup) Validate() []Rejection {
+	var rejected, r []Rejection
+	keep := func(field string, values *[]string, check func(string) error) {
+		*values, r = FilterValid(field, *values, check)
+		rejected = append(rejected, r...)
+	}
+	keep("flatpaks", &b.Flatpaks, CheckFlatpakID)
+	keep("rpm_packages", &b.RPMPackages, CheckPackageName)
+	keep("apt_packages", &b.APTPackages, CheckPackageName)
+	keep("pacman_packages", &b.PacmanPackages, CheckPackageName)
+	keep("aur_packages", &b.AURPackages, CheckPackageName)
+	keep("zypper_packages", &b.ZypperPackages, CheckPackageName)
+	keep("gnome_extensions", &b.GnomeExtensions, CheckExtensionUUID)
+	keep("kde_widgets", &b.KDEWidgets, CheckIdentifier)
+	keep("repos", &b.Repos, CheckIdentifier)
+
+	var repos []ZypperRepo
+	for _, repo := range b.ZypperRepos {
+		if err := CheckZypperRepo(repo); err != nil {
+			rejected = append(rejected, Rejection{Field: "zypper_repos", Value: repo.Alias, Reason: err.Error()})
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	b.ZypperRepos = repos
+
+	var apps []FlatpakDeployment
+	for _, app := range b.FlatpakApps {
+		if err := CheckFlatpakDeployment(app); err != nil {
+			rejected = append(rejected, Rejection{Field: "flatpak_apps", Value: app.ID, Reason: err.Error()})
+			continue
+		}
+		apps = append(apps, app)
+	}
+	b.FlatpakApps = apps
+
+	if b.DconfSettings != "" {
+		if err := CheckDconf(b.DconfSettings); err != nil {
+			rejected = append(rejected, Rejection{Field: "dconf_settings", Value: "dconf dump", Reason: err.Error()})
+			b.DconfSettings = ""
+		}
+	}
+
+	// Shown only, but must not carry escape sequences to the terminal
+	for _, text := range []struct {
+		field string
+		value *string
+	}{
+		{"hostname", &b.Hostname},
+		{"user", &b.User},
+		{"distro", &b.Distro},
+		{"desktop", &b.Desktop},
+	} {
+		if err := CheckText(*text.value); err != nil {
+			rejected = append(rejected, Rejection{Field: text.field, Value: *text.value, Reason: err.Error()})
+			*text.value = ""
+		}
+	}
+	return rejected
+}
+
+// Validate drops the locations that are not inside the home directory and
+// returns them. The sections they belong to are then restored to where
+// ReGo puts them by default.
+func (m *FullBackupManifest) Validate() []Rejection {
+	var rejected []Rejection
+	for dir, loc := range m.Locations {
+		if err := CheckRelativePath(loc); err != nil {
+			rejected = append(rejected, Rejection{Field: "manifest.json locations", Value: loc, Reason: err.Error()})
+			delete(m.Locations, dir)
+		}
+	}
+	m.Rejected = rejected
+	return rejected
+}
+
+// Validate drops the packages that fail validation and returns them
+func (d *RPMData) Validate() []Rejection {
+	var rejected []Rejection
+	d.Packages, rejected = FilterValidItems("rpm_packages.json", d.Packages, CheckPackageName)
+	return rejected
+}
+
+// Validate drops the packages that fail validation and returns them
+func (d *APTData) Validate() []Rejection {
+	var rejected []Rejection
+	d.Packages, rejected = FilterValidItems("apt_packages.json", d.Packages, CheckPackageName)
+	return rejected
+}
+
+// Validate drops the packages that fail validation and returns them
+func (d *ZypperData) Validate() []Rejection {
+	var rejected []Rejection
+	d.Packages, rejected = FilterValidItems("zypper_packages.json", d.Packages, CheckPackageName)
+	return rejected
+}
+
+// Validate drops the sources and keys outside the apt directories and
+// returns them
+func (d *APTSourcesData) Validate() []Rejection {
+	var sources, keys []Rejection
+	d.Sources, sources = FilterValid("apt_sources.json", d.Sources, CheckAPTSourcePath)
+	d.Keys, keys = FilterValid("apt_sources.json", d.Keys, CheckAPTKeyPath)
+	return append(keys, sources...)
+}
+
+// Validate drops the repositories and keys that fail validation and
+// returns them
+func (d *ZypperReposData) Validate() []Rejection {
+	var rejected, r []Rejection
+	var repos []ZypperRepo
+	for _, repo := range d.Repos {
+		if err := CheckZypperRepo(repo); err != nil {
+			rejected = append(rejected, Rejection{Field: "zypper_repos.json", Value: repo.Alias, Reason: err.Error()})
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	d.Repos = repos
+	d.RepoFiles, r = FilterValid("zypper_repos.json", d.RepoFiles, CheckRelativePath)
+	rejected = append(rejected, r...)
+	d.GPGKeys, r = FilterValid("zypper_repos.json", d.GPGKeys, CheckRelativePath)
+	return append(rejected, r...)
+}
@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	systemPath := func(path string) error {
+		return CheckSystemPath(path, "/etc/yum.repos.d", "/etc/pki/rpm-gpg/")
+	}
+	tests := []struct {
+		name  string
+		check func(string) error
+		value string
+		ok    bool
+	}{
+		{"package", CheckPackageName, "gcc-c++", true},
+		{"package with arch", CheckPackageName, "libc6:i386", true},
+		{"package with epoch-like version", CheckPackageName, "python3.12", true},
+		{"package option", CheckPackageName, "--installroot=/", false},
+		{"package with space", CheckPackageName, "vim emacs", false},
+		{"package with semicolon", CheckPackageName, "vim;reboot", false},
+		{"package with newline", CheckPackageName, "vim\nreboot", false},
+		{"package too long", CheckPackageName, strings.Repeat("a", maxNameLength+1), false},
+		{"empty package", CheckPackageName, "", false},
+
+		{"flatpak", CheckFlatpakID, "org.mozilla.firefox", true},
+		{"flatpak with dash", CheckFlatpakID, "com.github.tchx84.Flatseal-2", true},
+		{"flatpak with two elements", CheckFlatpakID, "org.firefox", false},
+		{"flatpak option", CheckFlatpakID, "--system", false},
+		{"flatpak with slash", CheckFlatpakID, "org.mozilla.firefox/x86_64", false},
+
+		{"extension", CheckExtensionUUID, "dash-to-dock@micxgx.gmail.com", true},
+		{"extension without domain", CheckExtensionUUID, "dash-to-dock", false},
+		{"extension with slash", CheckExtensionUUID, "../x@y", false},
+
+		{"identifier", CheckIdentifier, "rpmfusion-free", true},
+		{"identifier with colon", CheckIdentifier, "home:user:branch", true},
+		{"identifier option", CheckIdentifier, "-rf", false},
+		{"identifier with slash", CheckIdentifier, "repos/../x", false},
+		{"identifier with space", CheckIdentifier, "my repo", false},
+
+		{"text", CheckText, "Fedora 41 (Workstation)", true},
+		{"text with escape", CheckText, "name\x1b]0;pwned\x07", false},
+		{"text with newline", CheckText, "line\nline", false},
+		{"text too long", CheckText, strings.Repeat("a", 1025), false},
+
+		{"https URL", CheckURL, "https://download.example.com/repo/$releasever/", true},
+		{"zypper media URL", CheckURL, "dvd:/?devices=/dev/sr0", true},
+		{"URL scheme", CheckURL, "gopher://example.com/", false},
+		{"URL option", CheckURL, "--gpgcheck=0", false},
+		{"URL with space", CheckURL, "https://example.com/ x", false},
+		{"empty URL", CheckURL, "", false},
+
+		{"relative path", CheckRelativePath, ".config/foo.conf", true},
+		{"current directory", CheckRelativePath, ".", true},
+		{"absolute path", CheckRelativePath, "/etc/passwd", false},
+		{"parent path", CheckRelativePath, "..", false},
+		{"escaping path", CheckRelativePath, "../.ssh/authorized_keys", false},
+		{"unclean path", CheckRelativePath, ".config/../../x", false},
+		{"path with NUL", CheckRelativePath, "a\x00b", false},
+		{"empty path", CheckRelativePath, "", false},
+
+		{"system path", systemPath, "/etc/yum.repos.d/rpmfusion.repo", true},
+		{"system path under trailing slash root", systemPath, "/etc/pki/rpm-gpg/RPM-GPG-KEY", true},
+		{"system root itself", systemPath, "/etc/yum.repos.d", false},
+		{"system path prefix", systemPath, "/etc/yum.repos.d.evil/x", false},
+		{"system path escaping", systemPath, "/etc/yum.repos.d/../sudoers", false},
+		{"system path elsewhere", systemPath, "/etc/sudoers.d/x", false},
+		{"relative system path", systemPath, "etc/yum.repos.d/x.repo", false},
+
+		{"sources.list", CheckAPTSourcePath, "/etc/apt/sources.list", true},
+		{"list source", CheckAPTSourcePath, "/etc/apt/sources.list.d/google-chrome.list", true},
+		{"deb822 source", CheckAPTSourcePath, "/etc/apt/sources.list.d/ubuntu.sources", true},
+		{"apt.conf.d hook", CheckAPTSourcePath, "/etc/apt/apt.conf.d/99hook", false},
+		{"preferences", CheckAPTSourcePath, "/etc/apt/preferences.d/pin", false},
+		{"source of another type", CheckAPTSourcePath, "/etc/apt/sources.list.d/x.conf", false},
+		{"source in a subdirectory", CheckAPTSourcePath, "/etc/apt/sources.list.d/a/b.list", false},
+		{"escaping source", CheckAPTSourcePath, "/etc/apt/sources.list.d/../apt.conf.d/x.list", false},
+		{"relative source", CheckAPTSourcePath, "etc/apt/sources.list", false},
+		{"key as source", CheckAPTSourcePath, "/etc/apt/trusted.gpg.d/x.gpg", false},
+
+		{"trusted key", CheckAPTKeyPath, "/etc/apt/trusted.gpg.d/docker.gpg", true},
+		{"keyring", CheckAPTKeyPath, "/etc/apt/keyrings/docker.asc", true},
+		{"shared keyring", CheckAPTKeyPath, "/usr/share/keyrings/google.gpg", true},
+		{"key in apt.conf.d", CheckAPTKeyPath, "/etc/apt/apt.conf.d/docker.gpg", false},
+		{"keyring directory", CheckAPTKeyPath, "/etc/apt/keyrings", false},
+		{"key in a subdirectory", CheckAPTKeyPath, "/usr/share/keyrings/a/b.gpg", false},
+		{"source as key", CheckAPTKeyPath, "/etc/apt/sources.list", false},
+
+		{"dconf", CheckDconf, "[org/gnome/desktop/interface]\ngtk-theme='Adwaita'\n", true},
+		{"dconf root", CheckDconf, "[/]\nkey=1\n", true},
+		{"dconf key outside a section", CheckDconf, "key=1\n", false},
+		{"dconf bad header", CheckDconf, "[org/gnome desktop]\nkey=1\n", false},
+		{"dconf stray line", CheckDconf, "[org/gnome]\nnot a key\n", false},
+		{"dconf NUL", CheckDconf, "[org/gnome]\nkey=\x00\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(tt.value)
+			if tt.ok && err != nil {
+				t.Errorf("%q rejected: %v", tt.value, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("%q accepted", tt.value)
+			}
+		})
+	}
+}
+
+func TestCheckZypperRepo(t *testing.T) {
+	valid := ZypperRepo{Alias: "packman", Name: "Packman", BaseURL: "https://ftp.gwdg.de/pub/linux/packman/", Priority: 90, GPGCheck: true, FileName: "packman"}
+	tests := []struct {
+		name   string
+		modify func(*ZypperRepo)
+		ok     bool
+	}{
+		{"valid", func(*ZypperRepo) {}, true},
+		{"key URL", func(r *ZypperRepo) { r.GPGKey = "https://example.com/key.asc" }, true},
+		{"key file", func(r *ZypperRepo) { r.GPGKey = "/etc/pki/packman.asc" }, true},
+		{"alias option", func(r *ZypperRepo) { r.Alias = "--root=/" }, false},
+		{"name with escape", func(r *ZypperRepo) { r.Name = "\x1b[2J" }, false},
+		{"URL option", func(r *ZypperRepo) { r.BaseURL = "-x" }, false},
+		{"priority too high", func(r *ZypperRepo) { r.Priority = 201 }, false},
+		{"key file elsewhere", func(r *ZypperRepo) { r.GPGKey = "/root/.ssh/id_rsa" }, false},
+		{"file name with path", func(r *ZypperRepo) { r.FileName = "../../etc/x" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := valid
+			tt.modify(&repo)
+			err := CheckZypperRepo(repo)
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestCheckFlatpakDeployment(t *testing.T) {
+	valid := FlatpakDeployment{
+		ID: "org.gnome.Maps", Name: "Maps", Installation: FlatpakUser, Origin: "flathub", Branch: "stable",
+		Commit:     strings.Repeat("ab", 32),
+		Runtime:    "org.gnome.Platform/x86_64/47",
+		Extensions: []string{"runtime/org.freedesktop.Platform.GL.default/x86_64/24.08"},
+	}
+	tests := []struct {
+		name   string
+		modify func(*FlatpakDeployment)
+		ok     bool
+	}{
+		{"valid", func(*FlatpakDeployment) {}, true},
+		{"bare ID", func(d *FlatpakDeployment) { *d = FlatpakDeployment{ID: d.ID} }, true},
+		{"installation option", func(d *FlatpakDeployment) { d.Installation = "--system" }, false},
+		{"origin with space", func(d *FlatpakDeployment) { d.Origin = "flat hub" }, false},
+		{"branch option", func(d *FlatpakDeployment) { d.Branch = "-stable" }, false},
+		{"short commit", func(d *FlatpakDeployment) { d.Commit = "abcdef" }, false},
+		{"runtime with too few parts", func(d *FlatpakDeployment) { d.Runtime = "org.gnome.Platform" }, false},
+		{"extension option", func(d *FlatpakDeployment) { d.Extensions = []string{"--user"} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid
+			tt.modify(&d)
+			err := CheckFlatpakDeployment(d)
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestFullBackupManifestValidate(t *testing.T) {
+	m := FullBackupManifest{Locations: map[string]string{
+		"dotfiles":      ".",
+		"fonts":         ".local/share/fonts",
+		"ssh":           "../../root/.ssh",
+		"autostart":     "/etc/xdg/autostart",
+		"backgrounds_0": ".local/../../x",
+	}}
+	rejected := m.Validate()
+	if len(rejected) != 3 || len(m.Rejected) != 3 {
+		t.Errorf("rejected %v, want the three locations outside the home directory", rejected)
+	}
+	for _, dir := range []string{"ssh", "autostart", "backgrounds_0"} {
+		if _, ok := m.Locations[dir]; ok {
+			t.Errorf("location of %s was kept", dir)
+		}
+	}
+	for _, dir := range []string{"dotfiles", "fonts"} {
+		if _, ok := m.Locations[dir]; !ok {
+			t.Errorf("location of %s was dropped", dir)
+		}
+	}
+}
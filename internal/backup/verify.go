@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// manifestName is the manifest file at the root of every backup directory
+// and archive. It is the only file without a checksum.
+const manifestName = "manifest.json"
+
+// manifestVersion is written into new manifests. Manifests before 1.1 have
+// no checksums for symlinks.
+const manifestVersion = "1.1"
+
+// ErrNoChecksums is returned when verifying a backup made before checksums
+// were recorded
+var ErrNoChecksums = errors.New("backup has no checksums (made by an older version of ReGo)")
+
+// VerifyReport lists the differences between a backup and the checksums in
+// its manifest
+type VerifyReport struct {
+	Checked   int      // Files whose checksum matched
+	Missing   []string // Files in the manifest but not in the backup
+	Extra     []string // Files in the backup but not in the manifest
+	Corrupted []string // Files whose content does not match the manifest
+	Truncated bool     // The archive ended early; unread files are missing
+}
+
+// OK reports whether the backup matches its manifest exactly
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Corrupted) == 0 && !r.Truncated
+}
+
+// Problems returns one line per problem found, for display
+func (r *VerifyReport) Problems() []string {
+	var lines []string
+	if r.Truncated {
+		lines = append(lines, "archive is truncated")
+	}
+	for _, f := range r.Corrupted {
+		lines = append(lines, "corrupted: "+f)
+	}
+	for _, f := range r.Missing {
+		lines = append(lines, "missing: "+f)
+	}
+	for _, f := range r.Extra {
+		lines = append(lines, "extra: "+f)
+	}
+	return lines
+}
+
+// checksumManifest reads only the checksums of a BackupManifest or a
+// FullBackupManifest
+type checksumManifest struct {
+	Version   string            `json:"version"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// parseChecksums returns the checksums in a manifest and whether they cover
+// symlinks
+func parseChecksums(data []byte) (map[string]string, bool, error) {
+	var m checksumManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(m.Checksums) == 0 {
+		return nil, false, ErrNoChecksums
+	}
+	return m.Checksums, m.Version != "" && m.Version != "1.0", nil
+}
+
+// VerifyBackupDir checks every file of a backup directory or extracted
+// archive against the checksums in its manifest
+func VerifyBackupDir(dir string) (*VerifyReport, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	expected, links, err := parseChecksums(data)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := utils.ChecksumDir(dir, manifestName)
+	if err != nil {
+		return nil, err
+	}
+	return compareChecksums(expected, actual, links, false), nil
+}
+
+// VerifyArchive checks a Full Save or exported archive without extracting
+// it. Encrypted archives need their passphrase.
+func VerifyArchive(archivePath, passphrase string) (*VerifyReport, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	plain, err := utils.OpenMaybeEncrypted(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := make(map[string]string)
+	var manifest []byte
+	truncated := false
+
+	gzReader, err := gzip.NewReader(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzReader.Close()
+
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			truncated = true
+			break
+		}
+		name := path.Clean(filepath.ToSlash(header.Name))
+		if header.Typeflag == tar.TypeSymlink {
+			actual[name] = utils.HashLink(header.Linkname)
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if name == manifestName {
+			manifest, err = io.ReadAll(tarReader)
+		} else {
+			actual[name], err = utils.HashReader(tarReader)
+		}
+		if err != nil {
+			// A file cut short hashes to the wrong value; drop it so it is
+			// reported as missing along with everything after it
+			delete(actual, name)
+			truncated = true
+			break
+		}
+	}
+
+	if manifest == nil {
+		if truncated {
+			return &VerifyReport{Truncated: true}, nil
+		}
+		return nil, fmt.Errorf("archive has no %s", manifestName)
+	}
+	expected, links, err := parseChecksums(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return compareChecksums(expected, actual, links, truncated), nil
+}
+
+// compareChecksums reports how actual differs from expected. Symlinks are
+// only compared when links says the manifest has checksums for them.
+func compareChecksums(expected, actual map[string]string, links, truncated bool) *VerifyReport {
+	if !links {
+		maps.DeleteFunc(actual, func(_, sum string) bool { return utils.IsLinkChecksum(sum) })
+	}
+	report := &VerifyReport{Truncated: truncated}
+	for name, sum := range expected {
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, name)
+		case got != sum:
+			report.Corrupted = append(report.Corrupted, name)
+		default:
+			report.Checked++
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Corrupted)
+	return report
+}
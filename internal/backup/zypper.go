@@ -0,0 +1,495 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+const zyppReposDir = "/etc/zypp/repos.d"
+
+// ZypperBackup handles zypper package backup for openSUSE
+type ZypperBackup struct {
+	root string
+}
+
+// NewZypperBackup creates a new ZypperBackup instance
+func NewZypperBackup() *ZypperBackup {
+	return &ZypperBackup{}
+}
+
+// SetRoot reads the packages of the root filesystem mounted at root instead
+// of this system's
+func (z *ZypperBackup) SetRoot(root string) {
+	z.root = root
+}
+
+// Name returns the display name
+func (z *ZypperBackup) Name() string {
+	return "Zypper Packages"
+}
+
+// Type returns the backup type
+func (z *ZypperBackup) Type() BackupType {
+	return BackupTypeZypper
+}
+
+// Available checks if zypper is available
+func (z *ZypperBackup) Available() bool {
+	return utils.CommandExists("zypper")
+}
+
+// List returns user-installed packages
+func (z *ZypperBackup) List(ctx context.Context) ([]BackupItem, error) {
+	packages, _, err := z.ListUserInstalled(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []BackupItem
+	for _, pkg := range packages {
+		items = append(items, BackupItem{
+			Name: pkg,
+			Type: BackupTypeZypper,
+		})
+	}
+	return items, nil
+}
+
+// ListUserInstalled returns packages the user asked for, along with the
+// method used to find them. zypper marks those with "i+" in search output;
+// if that fails, everything not in the AutoInstalled database is taken.
+func (z *ZypperBackup) ListUserInstalled(ctx context.Context) ([]string, string, error) {
+	// zypper search only knows the running system
+	if z.root != "" {
+		packages, err := z.listAutoInstalledComplement(ctx)
+		return packages, "zypp_autoinstalled", err
+	}
+
+	packages, err := z.listSearch(ctx)
+	if err == nil {
+		return packages, "zypper_userinstalled", nil
+	}
+
+	utils.Warn("zypper search failed, falling back to AutoInstalled: %v", err)
+	packages, err = z.listAutoInstalledComplement(ctx)
+	return packages, "zypp_autoinstalled", err
+}
+
+// listSearch parses `zypper search --installed-only` for "i+" entries
+func (z *ZypperBackup) listSearch(ctx context.Context) ([]string, error) {
+	result := utils.RunCommandContext(ctx, "zypper", "--non-interactive", "--no-refresh",
+		"search", "--installed-only", "--type", "package")
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	seen := make(map[string]bool)
+	var packages []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 2 || strings.TrimSpace(fields[0]) != "i+" {
+			continue
+		}
+		pkg := strings.TrimSpace(fields[1])
+		if pkg != "" && !seen[pkg] && !z.isBasePackage(pkg) {
+			seen[pkg] = true
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// listAutoInstalledComplement returns installed packages that zypp did not
+// record as pulled in automatically
+func (z *ZypperBackup) listAutoInstalledComplement(ctx context.Context) ([]string, error) {
+	auto := make(map[string]bool)
+	if content, err := os.ReadFile(filepath.Join("/", z.root, "var", "lib", "zypp", "AutoInstalled")); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				auto[line] = true
+			}
+		}
+	}
+
+	result := utils.RunCommandContext(ctx, "rpm", rpmRoot(z.root, "-qa", "--qf", "%{NAME}\n")...)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var packages []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		pkg := strings.TrimSpace(line)
+		if pkg != "" && !auto[pkg] && !z.isBasePackage(pkg) && !strings.HasPrefix(pkg, "gpg-pubkey") {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// isBasePackage filters out base system packages
+func (z *ZypperBackup) isBasePackage(pkg string) bool {
+	base := map[string]bool{
+		"openSUSE-release": true, "openSUSE-build-key": true, "filesystem": true,
+		"glibc": true, "bash": true, "coreutils": true, "systemd": true,
+		"kernel-default": true, "zypper": true, "libzypp": true, "rpm": true,
+		"patterns-base-base": true, "patterns-base-minimal_base": true,
+	}
+	return base[pkg] || strings.HasPrefix(pkg, "openSUSE-release-")
+}
+
+// ZypperData represents the backup data structure
+type ZypperData struct {
+	Packages      []BackupItem `json:"packages"`
+	PackageCount  int          `json:"package_count"`
+	PackageMethod string       `json:"package_method"` // "zypper_userinstalled" or "zypp_autoinstalled"
+}
+
+// Backup performs the zypper package backup
+func (z *ZypperBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
+	result := BackupResult{
+		Type:      BackupTypeZypper,
+		Timestamp: time.Now(),
+	}
+
+	packages, method, err := z.ListUserInstalled(ctx)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	var items []BackupItem
+	for _, pkg := range packages {
+		items = append(items, BackupItem{Name: pkg, Type: BackupTypeZypper})
+	}
+
+	data := ZypperData{
+		Packages:      items,
+		PackageCount:  len(items),
+		PackageMethod: method,
+	}
+
+	filePath := filepath.Join(backupDir, "zypper_packages.json")
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := utils.WriteFile(filePath, jsonData); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	result.Success = true
+	result.Items = items
+	result.ItemCount = len(items)
+	result.FilePath = filePath
+
+	return result, nil
+}
+
+// ZypperReposBackup handles zypper repository backup
+type ZypperReposBackup struct {
+	root string
+}
+
+// NewZypperReposBackup creates a new ZypperReposBackup instance
+func NewZypperReposBackup() *ZypperReposBackup {
+	return &ZypperReposBackup{}
+}
+
+// SetRoot reads the repositories and keys of the root filesystem mounted
+// at root instead of this system's
+func (z *ZypperReposBackup) SetRoot(root string) {
+	z.root = root
+}
+
+// Name returns the display name
+func (z *ZypperReposBackup) Name() string {
+	return "Zypper Repositories"
+}
+
+// Type returns the backup type
+func (z *ZypperReposBackup) Type() BackupType {
+	return BackupTypeZypperRepos
+}
+
+// Available checks if the zypp repos directory exists
+func (z *ZypperReposBackup) Available() bool {
+	return utils.DirExists(filepath.Join("/", z.root, zyppReposDir))
+}
+
+// ZypperRepo contains the settings of a zypper repository
+type ZypperRepo struct {
+	Alias       string `json:"alias"`
+	Name        string `json:"name"`
+	BaseURL     string `json:"baseurl"`
+	Type        string `json:"type,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	AutoRefresh bool   `json:"autorefresh"`
+	Priority    int    `json:"priority"`
+	GPGCheck    bool   `json:"gpgcheck"`
+	GPGKey      string `json:"gpgkey,omitempty"`
+	FileName    string `json:"filename"`
+}
+
+// List returns third-party repositories
+func (z *ZypperReposBackup) List(ctx context.Context) ([]BackupItem, error) {
+	repos, err := z.ListThirdParty(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []BackupItem
+	for _, repo := range repos {
+		items = append(items, BackupItem{
+			Name:        repo.Alias,
+			Type:        BackupTypeZypperRepos,
+			Description: repo.Name,
+			Metadata: map[string]string{
+				"filename": repo.FileName,
+				"enabled":  boolToString(repo.Enabled),
+				"priority": strconv.Itoa(repo.Priority),
+			},
+		})
+	}
+	return items, nil
+}
+
+// ListThirdParty returns all repositories except the openSUSE base ones
+func (z *ZypperReposBackup) ListThirdParty(ctx context.Context) ([]ZypperRepo, error) {
+	repos, err := ListZypperReposIn(z.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var thirdParty []ZypperRepo
+	for _, repo := range repos {
+		if !z.isBaseRepo(repo.Alias) {
+			thirdParty = append(thirdParty, repo)
+		}
+	}
+	return thirdParty, nil
+}
+
+// isBaseRepo checks if a repo is one the openSUSE installer sets up
+func (z *ZypperReposBackup) isBaseRepo(alias string) bool {
+	alias = strings.TrimPrefix(alias, "openSUSE:")
+	return strings.HasPrefix(alias, "repo-") || strings.HasPrefix(alias, "openSUSE-")
+}
+
+// ListZypperRepos parses all .repo files in /etc/zypp/repos.d
+func ListZypperRepos() ([]ZypperRepo, error) {
+	return ListZypperReposIn("")
+}
+
+// ListZypperReposIn parses the .repo files of the root filesystem mounted
+// at root, or of this system if root is ""
+func ListZypperReposIn(root string) ([]ZypperRepo, error) {
+	reposDir := filepath.Join("/", root, zyppReposDir)
+	entries, err := os.ReadDir(reposDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []ZypperRepo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".repo") {
+			continue
+		}
+
+		filePath := filepath.Join(reposDir, entry.Name())
+		fileRepos, err := parseZypperRepoFile(filePath)
+		if err != nil {
+			utils.Warn("Failed to parse %s: %v", filePath, err)
+			continue
+		}
+		repos = append(repos, fileRepos...)
+	}
+	return repos, nil
+}
+
+// parseZypperRepoFile parses a single zypp .repo file
+func parseZypperRepoFile(filePath string) ([]ZypperRepo, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := filepath.Base(filePath)
+	var repos []ZypperRepo
+	var current *ZypperRepo
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if current != nil {
+				repos = append(repos, *current)
+			}
+			// zypp defaults when a key is missing
+			current = &ZypperRepo{
+				Alias:    strings.Trim(line, "[]"),
+				FileName: fileName,
+				Enabled:  true,
+				GPGCheck: true,
+				Priority: 99,
+			}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "name":
+			current.Name = value
+		case "baseurl":
+			current.BaseURL = value
+		case "type":
+			current.Type = value
+		case "enabled":
+			current.Enabled = value == "1" || value == "true"
+		case "autorefresh":
+			current.AutoRefresh = value == "1" || value == "true"
+		case "priority":
+			if p, err := strconv.Atoi(value); err == nil {
+				current.Priority = p
+			}
+		case "gpgcheck":
+			current.GPGCheck = value == "1" || value == "true"
+		case "gpgkey":
+			current.GPGKey = value
+		}
+	}
+
+	if current != nil {
+		repos = append(repos, *current)
+	}
+
+	return repos, nil
+}
+
+// ZypperReposData represents the backup data structure
+type ZypperReposData struct {
+	Repos     []ZypperRepo `json:"repos"`
+	RepoFiles []string     `json:"repo_files"`
+	GPGKeys   []string     `json:"gpg_keys,omitempty"`
+}
+
+// Backup performs the zypper repos backup
+func (z *ZypperReposBackup) Backup(ctx context.Context, backupDir string) (BackupResult, error) {
+	result := BackupResult{
+		Type:      BackupTypeZypperRepos,
+		Timestamp: time.Now(),
+	}
+
+	items, err := z.List(ctx)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	repos, _ := z.ListThirdParty(ctx)
+
+	// Copy actual repo files
+	reposBackupDir := filepath.Join(backupDir, "zypp-repos.d")
+	if err := utils.EnsureDir(reposBackupDir); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	fileSet := make(map[string]bool)
+	var copiedFiles []string
+	for _, repo := range repos {
+		if fileSet[repo.FileName] {
+			continue
+		}
+		fileSet[repo.FileName] = true
+
+		srcPath := filepath.Join("/", z.root, zyppReposDir, repo.FileName)
+		if err := utils.CopyFile(srcPath, filepath.Join(reposBackupDir, repo.FileName)); err != nil {
+			utils.Warn("Failed to copy %s: %v", repo.FileName, err)
+			continue
+		}
+		copiedFiles = append(copiedFiles, repo.FileName)
+	}
+
+	data := ZypperReposData{
+		Repos:     repos,
+		RepoFiles: copiedFiles,
+		GPGKeys:   z.backupGPGKeys(ctx, backupDir),
+	}
+
+	filePath := filepath.Join(backupDir, "zypper_repos.json")
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if err := utils.WriteFile(filePath, jsonData); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	result.Success = true
+	result.Items = items
+	result.ItemCount = len(items)
+	result.FilePath = filePath
+
+	return result, nil
+}
+
+// backupGPGKeys exports the armored keys rpm has imported, so repositories
+// whose gpgkey URL is gone can still be trusted on restore
+func (z *ZypperReposBackup) backupGPGKeys(ctx context.Context, backupDir string) []string {
+	keysDir := filepath.Join(backupDir, "zypp-keys")
+	if err := utils.EnsureDir(keysDir); err != nil {
+		return nil
+	}
+
+	result := utils.RunCommandContext(ctx, "rpm", rpmRoot(z.root, "-q", "gpg-pubkey", "--qf", "%{NAME}-%{VERSION}-%{RELEASE}\n")...)
+	if result.Error != nil {
+		return nil
+	}
+
+	var keys []string
+	for _, key := range strings.Split(result.Stdout, "\n") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		// rpm keeps the armored public key in the package description
+		armored := utils.RunCommandContext(ctx, "rpm", rpmRoot(z.root, "-q", key, "--qf", "%{DESCRIPTION}")...)
+		if armored.Error != nil || !strings.Contains(armored.Stdout, "BEGIN PGP PUBLIC KEY BLOCK") {
+			continue
+		}
+		if err := utils.WriteFile(filepath.Join(keysDir, key+".asc"), []byte(armored.Stdout)); err == nil {
+			keys = append(keys, key+".asc")
+		}
+	}
+	return keys
+}
@@ -0,0 +1,212 @@
+package privileged
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// HelperCommand is the hidden rego subcommand that serves operations
+const HelperCommand = "privileged-helper"
+
+// Helper sends operations to the privileged helper process, which is
+// started the first time one is needed so a restore that changes nothing
+// outside the home directory never asks for a password. A nil *Helper runs
+// operations in this process, which is what ReGo does when it already is
+// root.
+type Helper struct {
+	prompt bool
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	enc    *json.Encoder
+	dec    *json.Decoder
+	stderr bytes.Buffer
+	err    error // Why the helper could not be started
+}
+
+// reply is what the helper answers to each operation
+type reply struct {
+	Ready    bool   `json:"ready,omitempty"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// New returns a helper, or nil when ReGo runs as root. With prompt, sudo
+// may ask for the password on the terminal; without it sudo must have
+// been authenticated already, see AuthCommand.
+func New(prompt bool) *Helper {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	return &Helper{prompt: prompt}
+}
+
+// AuthCommand returns the command that asks for the sudo password up
+// front, for interfaces that own the terminal and can hand it over only
+// while a command runs. It returns nil when no password is needed here:
+// as root, or when pkexec asks through its own dialog.
+func AuthCommand() *exec.Cmd {
+	if os.Geteuid() == 0 || !utils.CommandExists("sudo") {
+		return nil
+	}
+	return exec.Command("sudo", "-v")
+}
+
+// start launches the helper process. Called with mu held.
+func (h *Helper) start() error {
+	if h.cmd != nil || h.err != nil {
+		return h.err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		h.err = fmt.Errorf("failed to find the rego executable: %w", err)
+		return h.err
+	}
+	var cmd *exec.Cmd
+	switch {
+	case utils.CommandExists("sudo") && h.prompt:
+		cmd = exec.Command("sudo", exe, HelperCommand)
+	case utils.CommandExists("sudo"):
+		cmd = exec.Command("sudo", "-n", exe, HelperCommand)
+	case utils.CommandExists("pkexec"):
+		cmd = exec.Command("pkexec", exe, HelperCommand)
+	default:
+		h.err = errors.New("neither sudo nor pkexec is installed, run rego as root")
+		return h.err
+	}
+	cmd.Stderr = &h.stderr
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		if stdout, err = cmd.StdoutPipe(); err == nil {
+			h.dec = json.NewDecoder(stdout)
+			err = cmd.Start()
+		}
+	}
+	if err != nil {
+		h.err = fmt.Errorf("failed to start privileged helper: %w", err)
+		return h.err
+	}
+	h.cmd, h.stdin, h.enc = cmd, stdin, json.NewEncoder(stdin)
+
+	// The helper says it is ready once sudo let it run
+	var r reply
+	if err := h.dec.Decode(&r); err != nil || !r.Ready {
+		cmd.Wait()
+		h.err = fmt.Errorf("privileged helper did not start: %s", h.reason())
+		return h.err
+	}
+	return nil
+}
+
+// reason returns what the helper process said on stderr, or a generic reason
+func (h *Helper) reason() string {
+	if s := strings.TrimSpace(h.stderr.String()); s != "" {
+		return s
+	}
+	return "authentication failed or was cancelled"
+}
+
+// Start launches the helper now rather than at the first operation, while
+// the password given to AuthCommand is still fresh
+func (h *Helper) Start() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.start()
+}
+
+// Run performs op in the helper, starting it if needed
+func (h *Helper) Run(op Op) utils.CommandResult {
+	if h == nil {
+		return Execute(op)
+	}
+	// Check here too, so mistakes show up without a password prompt
+	if err := op.Validate(); err != nil {
+		return failed(err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.start(); err != nil {
+		return failed(err)
+	}
+
+	var r reply
+	if err := h.enc.Encode(op); err != nil {
+		h.err = fmt.Errorf("privileged helper stopped: %s", h.reason())
+		return failed(h.err)
+	}
+	if err := h.dec.Decode(&r); err != nil {
+		h.err = fmt.Errorf("privileged helper stopped: %s", h.reason())
+		return failed(h.err)
+	}
+	result := utils.CommandResult{Stdout: r.Stdout, Stderr: r.Stderr, ExitCode: r.ExitCode}
+	if r.Error != "" {
+		result.Error = errors.New(r.Error)
+	}
+	return result
+}
+
+// Close stops the helper process, if it was started
+func (h *Helper) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cmd == nil {
+		return nil
+	}
+	h.stdin.Close()
+	err := h.cmd.Wait()
+	h.cmd = nil
+	h.err = errors.New("privileged helper was closed")
+	return err
+}
+
+// Serve is the helper process: it reads operations from r, one JSON object
+// per line, and answers each on w until r is closed
+func Serve(r io.Reader, w io.Writer) error {
+	if os.Geteuid() != 0 {
+		return errors.New("the privileged helper must run as root")
+	}
+
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(reply{Ready: true}); err != nil {
+		return err
+	}
+	for {
+		var op Op
+		if err := dec.Decode(&op); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("bad operation: %w", err)
+		}
+
+		result := Execute(op)
+		out := reply{Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode}
+		if result.Error != nil {
+			out.Error = result.Error.Error()
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+}
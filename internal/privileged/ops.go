@@ -0,0 +1,568 @@
+// Package privileged runs the few restore operations that need root, such
+// as installing packages or writing repository files, in one helper process
+// started through sudo or pkexec. The user authenticates once and ReGo
+// itself never runs as root. The helper only accepts the typed operations
+// below, checks their arguments and never runs a shell. An operation can
+// be aimed at a root filesystem mounted elsewhere, such as a fresh install
+// seen from a live USB, instead of the running system.
+package privileged
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// OpKind says what an operation does
+type OpKind string
+
+const (
+	OpInstall       OpKind = "install"        // Install Names through Manager
+	OpInstallCached OpKind = "install_cached" // Install Names through Manager from the package files in Path
+	OpRemove        OpKind = "remove"         // Remove Names through Manager
+	OpRefresh       OpKind = "refresh"        // Refresh the package lists of Manager
+	OpWriteFile     OpKind = "write_file"     // Write Data, or a symlink to Link, to Path
+	OpRemovePath    OpKind = "remove_path"    // Remove Path and everything below it
+	OpImportKey     OpKind = "import_key"     // Import the RPM signing key at Key, a file or https URL
+	OpAddRepo       OpKind = "add_repo"       // Add the signed https zypper repository Repo
+	OpAddRemote     OpKind = "add_remote"     // Add the Flatpak remote Remote from URL
+	OpPin           OpKind = "pin"            // Update the Flatpak app Names to Commit
+)
+
+// Op is one operation for the helper
+type Op struct {
+	Kind     OpKind             `json:"kind"`
+	Manager  string             `json:"manager,omitempty"`
+	Names    []string           `json:"names,omitempty"`
+	Path     string             `json:"path,omitempty"`
+	Data     []byte             `json:"data,omitempty"`
+	Mode     os.FileMode        `json:"mode,omitempty"`
+	Link     string             `json:"link,omitempty"`
+	Key      string             `json:"key,omitempty"`
+	Repo     *backup.ZypperRepo `json:"repo,omitempty"`
+	Remote   string             `json:"remote,omitempty"`   // Flatpak remote to install from or add
+	Sideload string             `json:"sideload,omitempty"` // Offline Flatpak repository to install from first
+	URL      string             `json:"url,omitempty"`
+	Commit   string             `json:"commit,omitempty"` // Flatpak commit to update to
+	Root     string             `json:"root,omitempty"`   // Root filesystem to change instead of this system's
+}
+
+// Install returns the operation that installs names through manager
+func Install(manager string, names []string) Op {
+	return Op{Kind: OpInstall, Manager: manager, Names: names}
+}
+
+// InstallFlatpaks returns the operation that installs apps from remote.
+// Flatpak only needs the helper for the system installation of another
+// root, so the operation must be aimed at one with In.
+func InstallFlatpaks(remote string, apps []string) Op {
+	return Op{Kind: OpInstall, Manager: "flatpak", Remote: remote, Names: apps}
+}
+
+// InstallCached returns the operation that installs names through manager
+// from the package files in dir alone, without downloading anything. Files
+// the target system can't vouch for are left out: RPMs must be signed by a
+// key its rpm database has imported, and debs must match the hash its apt
+// package lists record.
+func InstallCached(manager, dir string, names []string) Op {
+	return Op{Kind: OpInstallCached, Manager: manager, Path: dir, Names: names}
+}
+
+// SideloadFrom returns op, an InstallFlatpaks operation, taking what it can
+// from the offline repository repo before downloading from the remote
+func (op Op) SideloadFrom(repo string) Op {
+	op.Sideload = repo
+	return op
+}
+
+// Remove returns the operation that removes names through manager
+func Remove(manager string, names []string) Op {
+	return Op{Kind: OpRemove, Manager: manager, Names: names}
+}
+
+// Refresh returns the operation that refreshes the package lists of manager
+func Refresh(manager string) Op {
+	return Op{Kind: OpRefresh, Manager: manager}
+}
+
+// WriteFile returns the operation that writes data to path with mode
+func WriteFile(path string, data []byte, mode os.FileMode) Op {
+	return Op{Kind: OpWriteFile, Path: path, Data: data, Mode: mode}
+}
+
+// Symlink returns the operation that makes path a symlink to target
+func Symlink(path, target string) Op {
+	return Op{Kind: OpWriteFile, Path: path, Link: target}
+}
+
+// RemovePath returns the operation that removes path
+func RemovePath(path string) Op {
+	return Op{Kind: OpRemovePath, Path: path}
+}
+
+// ImportKey returns the operation that imports an RPM signing key
+func ImportKey(key string) Op {
+	return Op{Kind: OpImportKey, Key: key}
+}
+
+// AddRepo returns the operation that adds a zypper repository
+func AddRepo(repo backup.ZypperRepo) Op {
+	return Op{Kind: OpAddRepo, Repo: &repo}
+}
+
+// AddRemote returns the operation that adds the Flatpak remote name from
+// url, a repository or .flatpakrepo file. Like InstallFlatpaks it must be
+// aimed at another root with In.
+func AddRemote(name, url string) Op {
+	return Op{Kind: OpAddRemote, Remote: name, URL: url}
+}
+
+// PinFlatpak returns the operation that updates app, or app//branch, to
+// commit, which may be older than the one installed. Like InstallFlatpaks
+// it must be aimed at another root with In.
+func PinFlatpak(app, commit string) Op {
+	return Op{Kind: OpPin, Manager: "flatpak", Names: []string{app}, Commit: commit}
+}
+
+// In returns op aimed at the root filesystem mounted at root. Packages are
+// installed into it and paths are taken to be below it. A root of "" or
+// "/" is the running system.
+func (op Op) In(root string) Op {
+	if root == "/" {
+		root = ""
+	}
+	op.Root = root
+	return op
+}
+
+// systemRoots are the only directories the helper writes to or removes
+// from: where repositories and signing keys live, on the running system or
+// below the root an operation is aimed at. Below /etc/apt only the source
+// files and keyrings matched by aptPatterns are, since anything else there
+// could hook commands into apt-get.
+var systemRoots = []string{
+	"/etc/yum.repos.d",
+	"/etc/zypp/repos.d",
+	"/etc/pki/rpm-gpg",
+}
+
+// aptPatterns are the apt source files and keyrings the helper writes
+var aptPatterns = append(slices.Clone(backup.APTSourcePatterns), backup.APTKeyPatterns...)
+
+// managers are the package managers the helper runs
+var managers = []string{"dnf", "apt-get", "pacman", "zypper"}
+
+// Validate checks that op is well formed and stays within what the helper
+// is allowed to change
+func (op Op) Validate() error {
+	if op.Root != "" {
+		if err := checkRoot(op.Root); err != nil {
+			return err
+		}
+	}
+
+	switch op.Kind {
+	case OpInstall, OpRemove, OpInstallCached:
+		switch {
+		case op.Kind == OpInstallCached:
+			if op.Manager != "dnf" && op.Manager != "apt-get" {
+				return fmt.Errorf("no package cache for %q", op.Manager)
+			}
+			if err := checkDir("package cache", op.Path); err != nil {
+				return err
+			}
+		case op.Manager == "flatpak":
+			if err := checkFlatpak(op); err != nil {
+				return err
+			}
+		default:
+			if err := checkManager(op.Manager); err != nil {
+				return err
+			}
+		}
+		if op.Sideload != "" {
+			if err := checkDir("sideload repository", op.Sideload); err != nil {
+				return err
+			}
+		}
+		if len(op.Names) == 0 {
+			return errors.New("no packages given")
+		}
+		for _, name := range op.Names {
+			if err := checkWord("package", name); err != nil {
+				return err
+			}
+		}
+	case OpRefresh:
+		return checkManager(op.Manager)
+	case OpWriteFile, OpRemovePath:
+		if err := checkSystemPath(op.Root, op.Path); err != nil {
+			return err
+		}
+		if op.Link != "" && op.Data != nil {
+			return errors.New("write_file takes data or a link, not both")
+		}
+	case OpImportKey:
+		// A key fetched over plain http could be swapped on the way
+		if !filepath.IsAbs(op.Key) && !strings.HasPrefix(op.Key, "https://") {
+			return fmt.Errorf("key %q is not an absolute path or https URL", op.Key)
+		}
+		return checkWord("key", op.Key)
+	case OpAddRepo:
+		if op.Repo == nil {
+			return errors.New("no repository given")
+		}
+		if err := checkWord("repository alias", op.Repo.Alias); err != nil {
+			return err
+		}
+		// zypper trusts what it adds as root, so only signed repositories
+		// reached over https are added
+		if !strings.HasPrefix(op.Repo.BaseURL, "https://") {
+			return fmt.Errorf("repository URL %q is not an https URL", op.Repo.BaseURL)
+		}
+		if !op.Repo.GPGCheck {
+			return fmt.Errorf("repository %q does not check signatures", op.Repo.Alias)
+		}
+		return checkWord("repository URL", op.Repo.BaseURL)
+	case OpAddRemote:
+		if err := checkFlatpak(op); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(op.URL, "https://") {
+			return fmt.Errorf("remote URL %q is not an https URL", op.URL)
+		}
+		return checkWord("remote URL", op.URL)
+	case OpPin:
+		if err := checkFlatpak(op); err != nil {
+			return err
+		}
+		if len(op.Names) != 1 {
+			return errors.New("pin takes one app")
+		}
+		if len(op.Commit) != 64 || strings.Trim(op.Commit, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid commit %q", op.Commit)
+		}
+		return checkWord("app", op.Names[0])
+	default:
+		return fmt.Errorf("unknown operation %q", op.Kind)
+	}
+	return nil
+}
+
+// checkManager refuses package managers the helper doesn't run
+func checkManager(manager string) error {
+	for _, m := range managers {
+		if m == manager {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported package manager %q", manager)
+}
+
+// checkFlatpak refuses Flatpak operations on the running system, which
+// flatpak does as the user, and remotes that are not a single word
+func checkFlatpak(op Op) error {
+	if op.Root == "" {
+		return errors.New("flatpak only runs in the helper for another root")
+	}
+	if op.Kind == OpRemove || op.Kind == OpPin {
+		return nil
+	}
+	return checkWord("remote", op.Remote)
+}
+
+// checkRoot refuses roots that are not an absolute, clean path other than
+// "/", or that would not survive being written into a config file
+func checkRoot(root string) error {
+	if !filepath.IsAbs(root) || filepath.Clean(root) != root || root == "/" || strings.ContainsAny(root, "\r\n\x00") {
+		return fmt.Errorf("invalid root %q", root)
+	}
+	return nil
+}
+
+// checkDir refuses directories that are not absolute and clean, or that
+// could be taken as an option
+func checkDir(what, dir string) error {
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir {
+		return fmt.Errorf("%s %q is not absolute and clean", what, dir)
+	}
+	return checkWord(what, dir)
+}
+
+// checkWord refuses values that could be taken as an option or split into
+// several arguments
+func checkWord(what, value string) error {
+	if value == "" || strings.HasPrefix(value, "-") || strings.ContainsAny(value, " \t\r\n\x00") {
+		return fmt.Errorf("invalid %s %q", what, value)
+	}
+	return nil
+}
+
+// checkSystemPath refuses paths outside systemRoots below root, and the
+// system roots themselves
+func checkSystemPath(root, path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("path %q is not absolute and clean", path)
+	}
+	rel := path
+	if root != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(path, root); !ok || !strings.HasPrefix(rel, "/") {
+			return fmt.Errorf("refusing to change %s outside %s", path, root)
+		}
+	}
+	for _, dir := range systemRoots {
+		if strings.HasPrefix(rel, dir+"/") {
+			return nil
+		}
+	}
+	if backup.MatchesPathPattern(rel, aptPatterns) {
+		return nil
+	}
+	return fmt.Errorf("refusing to change %s outside the repository directories", path)
+}
+
+// checkResolved refuses paths that a symlink on the way leads out of the
+// repository directories: a yum.repos.d that links to /etc/sudoers.d, or
+// an etc in the root filesystem at root that links to the running
+// system's /etc. root is "" for this system.
+func checkResolved(root, path string) error {
+	top := root
+	if top == "" {
+		top = "/"
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(top)
+	if err != nil {
+		return err
+	}
+	// The directory may not exist yet; what it will be created in must
+	// still be inside the root and its repository directories
+	dir := filepath.Dir(path)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			rest, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			resolved = filepath.Join(resolved, rest)
+			rel, err := filepath.Rel(resolvedRoot, resolved)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, "../") || checkSystemPath("", filepath.Join("/", rel)) != nil {
+				return fmt.Errorf("refusing to change %s, which leads to %s", path, resolved)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) || dir == top {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Command returns the command line op amounts to, for showing what is run
+func (op Op) Command() []string {
+	switch op.Kind {
+	case OpInstall:
+		return managerCommand(op.Manager, op.Root, installArgs(op))
+	case OpInstallCached:
+		return managerCommand(op.Manager, op.Root, installCachedArgs(op, []string{filepath.Join(op.Path, "*"+cacheExtension(op.Manager))}))
+	case OpRemove:
+		return managerCommand(op.Manager, op.Root, removeArgs(op))
+	case OpRefresh:
+		return managerCommand(op.Manager, op.Root, refreshArgs(op.Manager))
+	case OpWriteFile:
+		if op.Link != "" {
+			return []string{"ln", "-sf", op.Link, op.Path}
+		}
+		return []string{"install", "-D", "-m", fmt.Sprintf("%04o", fileMode(op)), op.Path}
+	case OpRemovePath:
+		return []string{"rm", "-rf", op.Path}
+	case OpImportKey:
+		return managerCommand("rpm", op.Root, []string{"--import", op.Key})
+	case OpAddRepo:
+		if op.Repo != nil {
+			return managerCommand("zypper", op.Root, addRepoArgs(*op.Repo))
+		}
+	case OpAddRemote:
+		return managerCommand("flatpak", op.Root, []string{"remote-add", "--if-not-exists", op.Remote, op.URL})
+	case OpPin:
+		if len(op.Names) == 1 {
+			return managerCommand("flatpak", op.Root, []string{"update", "-y", "--noninteractive", "--commit=" + op.Commit, op.Names[0]})
+		}
+	}
+	return []string{string(op.Kind)}
+}
+
+// Execute runs op as the current user, which is root in the helper
+func Execute(op Op) utils.CommandResult {
+	if err := op.Validate(); err != nil {
+		return failed(err)
+	}
+
+	if op.Kind == OpWriteFile || op.Kind == OpRemovePath {
+		if err := checkResolved(op.Root, op.Path); err != nil {
+			return failed(err)
+		}
+	}
+	switch op.Kind {
+	case OpWriteFile:
+		return failed(writeFile(op))
+	case OpRemovePath:
+		return failed(os.RemoveAll(op.Path))
+	case OpInstallCached:
+		return installCached(op)
+	}
+	timeout := 30 * time.Minute
+	switch op.Kind {
+	case OpImportKey, OpAddRepo, OpAddRemote:
+		timeout = 2 * time.Minute
+	case OpRefresh:
+		timeout = 10 * time.Minute
+	}
+	argv := op.Command()
+	if op.Manager == "flatpak" || op.Kind == OpAddRemote {
+		env, cleanup, err := backup.FlatpakInstallationEnv(op.Root)
+		if err != nil {
+			return failed(err)
+		}
+		defer cleanup()
+		return utils.RunCommandWithEnv(env, timeout, argv[0], argv[1:]...)
+	}
+	return utils.RunCommandWithTimeout(argv[0], timeout, argv[1:]...)
+}
+
+// failed returns the result of an operation that ran no command, which is
+// a success when err is nil
+func failed(err error) utils.CommandResult {
+	if err == nil {
+		return utils.CommandResult{}
+	}
+	return utils.CommandResult{Stderr: err.Error(), ExitCode: 1, Error: err}
+}
+
+// writeFile replaces op.Path, creating its directory if needed
+func writeFile(op Op) error {
+	if err := os.MkdirAll(filepath.Dir(op.Path), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(op.Path); err != nil {
+		return err
+	}
+	if op.Link != "" {
+		return os.Symlink(op.Link, op.Path)
+	}
+	mode := fileMode(op)
+	tmp := op.Path + ".rego-tmp"
+	if err := os.WriteFile(tmp, op.Data, mode); err != nil {
+		return err
+	}
+	// WriteFile leaves the mode to the umask
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, op.Path)
+}
+
+// fileMode returns the mode op writes a file with, 0644 unless it says
+func fileMode(op Op) os.FileMode {
+	if mode := op.Mode.Perm(); mode != 0 {
+		return mode
+	}
+	return 0644
+}
+
+// managerCommand returns the command line that runs manager with args,
+// against the root filesystem mounted at root unless root is ""
+func managerCommand(manager, root string, args []string) []string {
+	if root == "" {
+		return append([]string{manager}, args...)
+	}
+	switch manager {
+	case "dnf":
+		return append([]string{"dnf", "--installroot=" + root}, args...)
+	case "zypper", "rpm":
+		return append([]string{manager, "--root", root}, args...)
+	case "pacman":
+		return append([]string{"pacman", "--sysroot", root}, args...)
+	case "flatpak":
+		// --installation belongs to each flatpak command, not to flatpak
+		return append([]string{"flatpak", args[0], "--installation=" + backup.FlatpakInstallation}, args[1:]...)
+	}
+	// apt-get can't install into another root, so it runs inside it
+	return append([]string{"chroot", root, manager}, args...)
+}
+
+// installArgs returns the arguments that make op.Manager install op.Names
+func installArgs(op Op) []string {
+	var args []string
+	switch op.Manager {
+	case "dnf", "apt-get":
+		args = []string{"install", "-y"}
+	case "pacman":
+		args = []string{"-S", "--needed", "--noconfirm"}
+	case "zypper":
+		args = []string{"--non-interactive", "install", "--auto-agree-with-licenses"}
+	case "flatpak":
+		args = []string{"install", "-y", "--noninteractive"}
+		if op.Sideload != "" {
+			args = append(args, "--sideload-repo="+op.Sideload)
+		}
+		args = append(args, op.Remote)
+	}
+	return append(args, op.Names...)
+}
+
+// removeArgs returns the arguments that make op.Manager remove op.Names
+func removeArgs(op Op) []string {
+	var args []string
+	switch op.Manager {
+	case "flatpak":
+		args = []string{"uninstall", "-y", "--noninteractive"}
+	case "dnf", "apt-get":
+		args = []string{"remove", "-y"}
+	case "pacman":
+		args = []string{"-R", "--noconfirm"}
+	case "zypper":
+		args = []string{"--non-interactive", "remove"}
+	}
+	return append(args, op.Names...)
+}
+
+// refreshArgs returns the arguments that make manager refresh its package lists
+func refreshArgs(manager string) []string {
+	switch manager {
+	case "dnf":
+		return []string{"makecache"}
+	case "apt-get":
+		return []string{"update"}
+	case "pacman":
+		return []string{"-Sy"}
+	}
+	// Keys are imported from the backup first; zypper is not allowed to
+	// trust whatever key a repository offers
+	return []string{"--non-interactive", "refresh"}
+}
+
+// addRepoArgs returns the zypper arguments that recreate a repository
+func addRepoArgs(repo backup.ZypperRepo) []string {
+	args := []string{"--non-interactive", "addrepo", "--priority", strconv.Itoa(repo.Priority)}
+	if repo.Name != "" {
+		args = append(args, "--name", repo.Name)
+	}
+	if repo.AutoRefresh {
+		args = append(args, "--refresh")
+	}
+	if !repo.Enabled {
+		args = append(args, "--disable")
+	}
+	return append(args, repo.BaseURL, repo.Alias)
+}
@@ -0,0 +1,162 @@
+package privileged
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/r8bert/rego/internal/backup"
+)
+
+func TestValidate(t *testing.T) {
+	commit := strings.Repeat("0123456789abcdef", 4)
+	repo := backup.ZypperRepo{Alias: "packman", BaseURL: "https://ftp.gwdg.de/pub/linux/packman/", GPGCheck: true}
+	tests := []struct {
+		name string
+		op   Op
+		ok   bool
+	}{
+		{"install", Install("dnf", []string{"vim", "gcc-c++"}), true},
+		{"install into a root", Install("pacman", []string{"vim"}).In("/mnt"), true},
+		{"install with an unknown manager", Install("sh", []string{"-c"}), false},
+		{"install nothing", Install("dnf", nil), false},
+		{"install an option", Install("dnf", []string{"--setopt=x"}), false},
+		{"install a name with a space", Install("apt-get", []string{"vim emacs"}), false},
+		{"install into a relative root", Install("dnf", []string{"vim"}).In("mnt"), false},
+		{"install into an unclean root", Install("dnf", []string{"vim"}).In("/mnt/../"), false},
+		{"install into a root with a newline", Install("dnf", []string{"vim"}).In("/mnt\n"), false},
+		{"install from a cache", InstallCached("apt-get", "/tmp/cache", []string{"vim"}), true},
+		{"install from a cache pacman has none of", InstallCached("pacman", "/tmp/cache", []string{"vim"}), false},
+		{"install from a relative cache", InstallCached("dnf", "cache", []string{"vim"}), false},
+		{"remove", Remove("zypper", []string{"vim"}), true},
+		{"refresh", Refresh("apt-get"), true},
+		{"refresh an unknown manager", Refresh("npm"), false},
+
+		{"flatpak into a root", InstallFlatpaks("flathub", []string{"org.gnome.Maps"}).In("/mnt"), true},
+		{"flatpak on this system", InstallFlatpaks("flathub", []string{"org.gnome.Maps"}), false},
+		{"flatpak from an option", InstallFlatpaks("--from", []string{"org.gnome.Maps"}).In("/mnt"), false},
+		{"flatpak sideloaded", InstallFlatpaks("flathub", []string{"org.gnome.Maps"}).SideloadFrom("/tmp/repo").In("/mnt"), true},
+		{"flatpak sideloaded from a relative path", InstallFlatpaks("flathub", []string{"org.gnome.Maps"}).SideloadFrom("repo").In("/mnt"), false},
+		{"flatpak removed from a root", Remove("flatpak", []string{"org.gnome.Maps"}).In("/mnt"), true},
+		{"pin", PinFlatpak("org.gnome.Maps//stable", commit).In("/mnt"), true},
+		{"pin to a short commit", PinFlatpak("org.gnome.Maps", "abc").In("/mnt"), false},
+		{"pin to an uppercase commit", PinFlatpak("org.gnome.Maps", strings.ToUpper(commit)).In("/mnt"), false},
+
+		{"write a yum repo", WriteFile("/etc/yum.repos.d/x.repo", nil, 0644), true},
+		{"write a zypper repo", WriteFile("/etc/zypp/repos.d/x.repo", nil, 0644), true},
+		{"write an rpm key", WriteFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-x", nil, 0644), true},
+		{"write sources.list", WriteFile("/etc/apt/sources.list", nil, 0644), true},
+		{"write an apt source", WriteFile("/etc/apt/sources.list.d/x.sources", nil, 0644), true},
+		{"write an apt keyring", WriteFile("/etc/apt/keyrings/x.gpg", nil, 0644), true},
+		{"write a shared keyring", WriteFile("/usr/share/keyrings/x.gpg", nil, 0644), true},
+		{"write into a root", WriteFile("/mnt/etc/yum.repos.d/x.repo", nil, 0644).In("/mnt"), true},
+		{"write an apt.conf.d hook", WriteFile("/etc/apt/apt.conf.d/99hook", nil, 0644), false},
+		{"write apt preferences", WriteFile("/etc/apt/preferences.d/pin", nil, 0644), false},
+		{"write an apt source of another type", WriteFile("/etc/apt/sources.list.d/x.conf", nil, 0644), false},
+		{"write sudoers", WriteFile("/etc/sudoers.d/x", nil, 0644), false},
+		{"write a repository directory", WriteFile("/etc/yum.repos.d", nil, 0644), false},
+		{"write past a repository directory", WriteFile("/etc/yum.repos.d/../shadow", nil, 0644), false},
+		{"write a relative path", WriteFile("etc/yum.repos.d/x.repo", nil, 0644), false},
+		{"write outside the root", WriteFile("/etc/yum.repos.d/x.repo", nil, 0644).In("/mnt"), false},
+		{"write beside the root", WriteFile("/mnt2/etc/yum.repos.d/x.repo", nil, 0644).In("/mnt"), false},
+		{"symlink a repo", Symlink("/etc/yum.repos.d/x.repo", "/usr/share/x.repo"), true},
+		{"write data and a link", Op{Kind: OpWriteFile, Path: "/etc/yum.repos.d/x.repo", Data: []byte("x"), Link: "y"}, false},
+		{"remove a repo", RemovePath("/etc/zypp/repos.d/x.repo"), true},
+		{"remove /etc", RemovePath("/etc"), false},
+
+		{"import a key file", ImportKey("/etc/pki/rpm-gpg/RPM-GPG-KEY-x"), true},
+		{"import an https key", ImportKey("https://example.com/key.asc"), true},
+		{"import an http key", ImportKey("http://example.com/key.asc"), false},
+		{"import a relative key", ImportKey("key.asc"), false},
+		{"import an ftp key", ImportKey("ftp://example.com/key.asc"), false},
+		{"add a repo", AddRepo(repo), true},
+		{"add a repo with an option alias", AddRepo(backup.ZypperRepo{Alias: "--root", BaseURL: repo.BaseURL, GPGCheck: true}), false},
+		{"add an http repo", AddRepo(backup.ZypperRepo{Alias: "packman", BaseURL: "http://ftp.gwdg.de/pub/linux/packman/", GPGCheck: true}), false},
+		{"add a media repo", AddRepo(backup.ZypperRepo{Alias: "dvd", BaseURL: "dvd:/?devices=/dev/sr0", GPGCheck: true}), false},
+		{"add an unsigned repo", AddRepo(backup.ZypperRepo{Alias: "packman", BaseURL: repo.BaseURL}), false},
+		{"add nothing", Op{Kind: OpAddRepo}, false},
+		{"add an https remote", AddRemote("flathub", "https://dl.flathub.org/repo/flathub.flatpakrepo").In("/mnt"), true},
+		{"add an http remote", AddRemote("flathub", "http://dl.flathub.org/repo/flathub.flatpakrepo").In("/mnt"), false},
+		{"add a file remote", AddRemote("local", "file:///srv/repo").In("/mnt"), false},
+		{"add a remote on this system", AddRemote("flathub", "https://dl.flathub.org/repo/flathub.flatpakrepo"), false},
+
+		{"unknown operation", Op{Kind: "exec"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("refused: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("allowed")
+			}
+		})
+	}
+}
+
+func TestExecuteRefusesSymlinks(t *testing.T) {
+	tests := []struct {
+		name string
+		dirs []string // Below the root
+		link string
+		to   func(root, outside string) string
+	}{
+		{"etc out of the root", nil, "etc", func(root, outside string) string { return outside }},
+		{"repository directory to another directory", []string{"etc/sudoers.d"}, "etc/yum.repos.d", func(root, outside string) string {
+			return filepath.Join(root, "etc", "sudoers.d")
+		}},
+		{"relative repository directory", []string{"etc/sudoers.d"}, "etc/yum.repos.d", func(root, outside string) string { return "sudoers.d" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			outside := t.TempDir()
+			for _, dir := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(tt.to(root, outside), filepath.Join(root, tt.link)); err != nil {
+				t.Fatal(err)
+			}
+
+			op := WriteFile(filepath.Join(root, "etc", "yum.repos.d", "x.repo"), []byte("[x]\n"), 0644).In(root)
+			if result := Execute(op); result.Error == nil {
+				t.Error("wrote through a symlink")
+			}
+			for _, dir := range []string{outside, filepath.Join(root, "etc", "sudoers.d")} {
+				if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+					t.Errorf("%s was written to", dir)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteWritesIntoRoot(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "etc", "yum.repos.d", "x.repo")
+	if result := Execute(WriteFile(path, []byte("[x]\n"), 0644).In(root)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "[x]\n" {
+		t.Errorf("read %q, %v", got, err)
+	}
+	if result := Execute(RemovePath(path).In(root)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", path)
+	}
+}
+
+func TestZypperDoesNotImportKeys(t *testing.T) {
+	for _, op := range []Op{Install("zypper", []string{"vim"}), Refresh("zypper"), AddRepo(backup.ZypperRepo{Alias: "x", BaseURL: "https://example.com/"})} {
+		if argv := op.Command(); slices.Contains(argv, "--gpg-auto-import-keys") {
+			t.Errorf("%s imports keys on its own: %v", op.Kind, argv)
+		}
+	}
+}
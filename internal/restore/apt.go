@@ -0,0 +1,287 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// APTRestore handles apt package restoration
+type APTRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
+
+// NewAPTRestore creates a new APTRestore instance
+func NewAPTRestore() *APTRestore {
+	return &APTRestore{}
+}
+
+// SetJournal sets where installed packages are recorded for undo
+func (a *APTRestore) SetJournal(j *Journal) {
+	a.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (a *APTRestore) SetHelper(h *privileged.Helper) {
+	a.helper = h
+}
+
+// SetTarget sets the root filesystem apt-get installs into
+func (a *APTRestore) SetTarget(t Target) {
+	a.target = t
+}
+
+// Name returns the display name
+func (a *APTRestore) Name() string {
+	return "APT Packages"
+}
+
+// Type returns the restore type
+func (a *APTRestore) Type() RestoreType {
+	return RestoreTypeAPT
+}
+
+// DependsOn restores the APT sources and keys first so apt-get can find the packages
+func (a *APTRestore) DependsOn() []RestoreType {
+	return []RestoreType{RestoreTypeAPTSources}
+}
+
+// Available checks if apt-get is available, inside the target root when
+// there is one since that is where it runs
+func (a *APTRestore) Available() bool {
+	if !a.target.LiveSystem() {
+		return utils.FileExists(a.target.SystemPath("/usr/bin/apt-get"))
+	}
+	return utils.CommandExists("apt-get")
+}
+
+// Preview returns what would be restored
+func (a *APTRestore) Preview(backupDir string) ([]string, error) {
+	data, _, err := a.loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for _, pkg := range data.Packages {
+		items = append(items, pkg.Name)
+	}
+	return items, nil
+}
+
+// loadBackupData loads the apt backup data
+func (a *APTRestore) loadBackupData(backupDir string) (*backup.APTData, []backup.Rejection, error) {
+	content, err := os.ReadFile(filepath.Join(backupDir, "apt_packages.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read apt backup: %w", err)
+	}
+
+	var data backup.APTData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse apt backup: %w", err)
+	}
+	return &data, data.Validate(), nil
+}
+
+// Restore performs the apt package restoration
+func (a *APTRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
+	result := RestoreResult{
+		Type:      RestoreTypeAPT,
+		Timestamp: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	data, rejected, err := a.loadBackupData(backupDir)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Packages) + len(rejected)
+
+	if dryRun {
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
+		return result, nil
+	}
+
+	var packageNames []string
+	for _, pkg := range data.Packages {
+		packageNames = append(packageNames, pkg.Name)
+	}
+
+	if len(packageNames) == 0 {
+		result.Success = true
+		return result, nil
+	}
+
+	installed := a.journal.TrackInstall(RestoreTypeAPT, "apt-get", packageNames)
+	result.AddItems(InstallItems("apt-get", a.target.Root, packageNames, func(available []string) utils.CommandResult {
+		return a.helper.Run(privileged.Install("apt-get", available).In(a.target.Root))
+	})...)
+	installed()
+
+	result.Success = result.ItemsFailed == 0
+	return result, nil
+}
+
+// APTSourcesRestore handles APT source list and signing key restoration
+type APTSourcesRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
+
+// NewAPTSourcesRestore creates a new APTSourcesRestore instance
+func NewAPTSourcesRestore() *APTSourcesRestore {
+	return &APTSourcesRestore{}
+}
+
+// SetJournal sets where added files are recorded for undo
+func (a *APTSourcesRestore) SetJournal(j *Journal) {
+	a.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (a *APTSourcesRestore) SetHelper(h *privileged.Helper) {
+	a.helper = h
+}
+
+// SetTarget sets the root filesystem the sources and keys are written to
+func (a *APTSourcesRestore) SetTarget(t Target) {
+	a.target = t
+}
+
+// Name returns the display name
+func (a *APTSourcesRestore) Name() string {
+	return "APT Sources"
+}
+
+// Type returns the restore type
+func (a *APTSourcesRestore) Type() RestoreType {
+	return RestoreTypeAPTSources
+}
+
+// DependsOn returns nothing, sources and keys come first
+func (a *APTSourcesRestore) DependsOn() []RestoreType {
+	return nil
+}
+
+// Available checks if the apt config directory is accessible
+func (a *APTSourcesRestore) Available() bool {
+	return utils.DirExists(a.target.SystemPath("/etc/apt"))
+}
+
+// Preview returns what would be restored
+func (a *APTSourcesRestore) Preview(backupDir string) ([]string, error) {
+	data, _, err := a.loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for _, path := range data.Keys {
+		items = append(items, path+" (key)")
+	}
+	for _, path := range data.Sources {
+		items = append(items, path)
+	}
+	return items, nil
+}
+
+// loadBackupData loads the apt sources backup data
+func (a *APTSourcesRestore) loadBackupData(backupDir string) (*backup.APTSourcesData, []backup.Rejection, error) {
+	content, err := os.ReadFile(filepath.Join(backupDir, "apt_sources.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read apt sources backup: %w", err)
+	}
+
+	var data backup.APTSourcesData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse apt sources backup: %w", err)
+	}
+	return &data, data.Validate(), nil
+}
+
+// Restore puts back the signing keys, then the source files, then refreshes
+// the package lists. Files that already exist are left alone, so the target's
+// own sources.list is never replaced with one from another release.
+func (a *APTSourcesRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
+	result := RestoreResult{
+		Type:      RestoreTypeAPTSources,
+		Timestamp: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	data, rejected, err := a.loadBackupData(backupDir)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	paths := append(append([]string{}, data.Keys...), data.Sources...)
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(paths) + len(rejected)
+
+	if dryRun {
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
+		return result, nil
+	}
+
+	added := 0
+	for _, rel := range paths {
+		path := a.target.SystemPath(rel)
+		if utils.FileExists(path) {
+			result.ItemsSuccess++ // Count as success (preserved)
+			continue
+		}
+
+		srcPath := filepath.Join(backupDir, "apt-sources", rel)
+		if !utils.FileExists(srcPath) {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Backup file not found: %s", rel))
+			continue
+		}
+
+		if err := a.journal.RecordFile(RestoreTypeAPTSources, path); err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+
+		content, err := os.ReadFile(srcPath)
+		if err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to read %s: %v", path, err))
+			continue
+		}
+		cmdResult := a.helper.Run(privileged.WriteFile(path, content, 0644).In(a.target.Root))
+		if cmdResult.Error != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to copy %s: %s", path, cmdResult.Stderr))
+			continue
+		}
+		result.ItemsSuccess++
+		added++
+	}
+
+	if added > 0 {
+		cmdResult := a.helper.Run(privileged.Refresh("apt-get").In(a.target.Root))
+		if cmdResult.Error != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("apt-get update failed: %s", cmdResult.Stderr))
+		}
+	}
+
+	result.Success = result.ItemsFailed == 0
+	return result, nil
+}
@@ -0,0 +1,149 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// dconfDatabase returns the user dconf database in home
+func dconfDatabase(home string) string {
+	return filepath.Join(home, ".config", "dconf", "user")
+}
+
+// loadDconf loads content, a dump of the dconf directory dir, into the
+// settings of t's home directory, after recording what it replaces in j.
+// The current user's settings are loaded through dconf. No dconf service
+// runs for any other home, so its database file is compiled from the
+// settings it has and content instead.
+func loadDconf(t Target, j *Journal, component RestoreType, dir, content string) utils.CommandResult {
+	if t.LiveHome() {
+		if err := j.RecordDconf(component, dir); err != nil {
+			return errorResult(err)
+		}
+		return utils.RunCommandWithInput(content, 30*time.Second, "dconf", "load", dir)
+	}
+
+	home, err := t.HomeDir()
+	if err != nil {
+		return errorResult(err)
+	}
+	db := dconfDatabase(home)
+	if err := j.RecordFile(component, db); err != nil {
+		return errorResult(err)
+	}
+	result := compileDconf(db, dir, content)
+	if result.Error == nil {
+		if err := t.Own(db); err != nil {
+			return errorResult(err)
+		}
+	}
+	return result
+}
+
+// compileDconf writes the dconf database db with the keys it already has
+// and those in content, a dump of dir, taking content's where both have one
+func compileDconf(db, dir, content string) utils.CommandResult {
+	settings := dconfKeyfile{}
+	if utils.FileExists(db) {
+		result := backup.DumpDconfDatabase(filepath.Dir(filepath.Dir(db)))
+		if result.Error != nil {
+			result.Stderr = fmt.Sprintf("failed to read %s: %s", db, result.Stderr)
+			return result
+		}
+		settings.add("/", result.Stdout)
+	}
+	settings.add(dir, content)
+
+	keyfiles, err := os.MkdirTemp("", "rego-dconf-*")
+	if err != nil {
+		return errorResult(err)
+	}
+	defer os.RemoveAll(keyfiles)
+	if err := os.WriteFile(filepath.Join(keyfiles, "rego"), []byte(settings.String()), 0600); err != nil {
+		return errorResult(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(db), 0700); err != nil {
+		return errorResult(err)
+	}
+	tmp := db + ".rego-tmp"
+	result := utils.RunCommand("dconf", "compile", tmp, keyfiles)
+	if result.Error != nil {
+		os.Remove(tmp)
+		return result
+	}
+	if err := os.Rename(tmp, db); err != nil {
+		os.Remove(tmp)
+		return errorResult(err)
+	}
+	return result
+}
+
+// dconfKeyfile holds settings as dconf dump writes them, by absolute
+// directory and key
+type dconfKeyfile map[string]map[string]string
+
+// add reads a dump of dir, overriding the keys already there
+func (k dconfKeyfile) add(dir, dump string) {
+	var group map[string]string
+	for _, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			// Groups are relative to dir, with [/] for dir itself
+			name := strings.Trim(line[1:len(line)-1], "/")
+			path := strings.Trim(dir, "/")
+			if name != "" {
+				path = strings.Trim(path+"/"+name, "/")
+			}
+			if k[path] == nil {
+				k[path] = map[string]string{}
+			}
+			group = k[path]
+		case group != nil:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				group[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+}
+
+// String writes the settings as one keyfile for dconf compile
+func (k dconfKeyfile) String() string {
+	var out strings.Builder
+	paths := make([]string, 0, len(k))
+	for path := range k {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		name := path
+		if name == "" {
+			name = "/"
+		}
+		fmt.Fprintf(&out, "[%s]\n", name)
+		keys := make([]string, 0, len(k[path]))
+		for key := range k[path] {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&out, "%s=%s\n", key, k[path][key])
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// errorResult is the result of a step that failed without running a command
+func errorResult(err error) utils.CommandResult {
+	return utils.CommandResult{Stderr: err.Error(), ExitCode: 1, Error: err}
+}
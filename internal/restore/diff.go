@@ -0,0 +1,392 @@
+package restore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContext is how many unchanged lines a unified diff shows around each change
+const diffContext = 3
+
+// maxDiffLines bounds the lines left to compare once a common prefix and
+// suffix are taken off. Beyond it the middle is shown as replaced whole
+// rather than searched for the shortest edit.
+const maxDiffLines = 2000
+
+// lineOp is one line of an edit script: kept (' '), removed ('-') or added ('+')
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits content into lines that keep their newline, so a
+// missing newline at the end of a file shows up as a difference
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edit script that turns a into b
+func diffLines(a, b []string) []lineOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []lineOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	ops = append(ops, shortestEdit(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	return ops
+}
+
+// shortestEdit finds the edit script from a to b with the fewest removed
+// and added lines, using Myers' algorithm
+func shortestEdit(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	if n+m > maxDiffLines {
+		return replaceAll(a, b)
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v for diagonals -d..d as it was before step d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+// backtrack walks the trace of shortestEdit back from the end of both
+// inputs and returns the edit script in order
+func backtrack(trace [][]int, a, b []string) []lineOp {
+	var ops []lineOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int {
+			if k < -d || k > d {
+				return 0
+			}
+			return v[k+d]
+		}
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, lineOp{' ', a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, lineOp{'+', b[y]})
+			} else {
+				x--
+				ops = append(ops, lineOp{'-', a[x]})
+			}
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceAll is the edit script that removes all of a and adds all of b
+func replaceAll(a, b []string) []lineOp {
+	ops := make([]lineOp, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, lineOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, lineOp{'+', line})
+	}
+	return ops
+}
+
+// unifiedDiff returns the changes from a to b in unified diff format, or
+// "" when they are the same
+func unifiedDiff(nameA, nameB, a, b string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and the context before it
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		to := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				to = i + 1
+			} else if i-to >= 2*diffContext {
+				break
+			}
+		}
+		end := to + diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		writeHunk(&out, ops, from, end)
+		start = end
+	}
+	return out.String()
+}
+
+// writeHunk writes ops[from:end] as one hunk of a unified diff
+func writeHunk(out *strings.Builder, ops []lineOp, from, end int) {
+	// Line numbers are counted from the start of both files
+	lineA, lineB := 1, 1
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			lineA++
+		}
+		if op.kind != '-' {
+			lineB++
+		}
+	}
+	var countA, countB int
+	for _, op := range ops[from:end] {
+		if op.kind != '+' {
+			countA++
+		}
+		if op.kind != '-' {
+			countB++
+		}
+	}
+	if countA == 0 {
+		lineA--
+	}
+	if countB == 0 {
+		lineB--
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+	for _, op := range ops[from:end] {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the start and length of one side of a hunk
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// conflictFile returns current with each change towards backup written as
+// a conflict between markers, for merging by hand in an editor
+func conflictFile(current, backup string) string {
+	ops := diffLines(splitLines(current), splitLines(backup))
+
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			out.WriteString(ops[i].line)
+			i++
+			continue
+		}
+		var ours, theirs []string
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				ours = append(ours, ops[i].line)
+			} else {
+				theirs = append(theirs, ops[i].line)
+			}
+		}
+		out.WriteString("<<<<<<< current\n")
+		writeLines(&out, ours)
+		out.WriteString("=======\n")
+		writeLines(&out, theirs)
+		out.WriteString(">>>>>>> backup\n")
+	}
+	return out.String()
+}
+
+// writeLines writes lines so that each ends with a newline, keeping the
+// conflict markers after them on their own line
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+}
+
+// hasConflictMarkers reports whether content still holds a conflict
+// written by conflictFile
+func hasConflictMarkers(content string) bool {
+	for _, line := range splitLines(content) {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+// hunk replaces the base lines [start, end) with lines
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks groups an edit script from base into the changes it makes to base
+func hunks(ops []lineOp) []hunk {
+	var hs []hunk
+	pos := 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			pos++
+			i++
+			continue
+		}
+		h := hunk{start: pos, end: pos}
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				h.end++
+				pos++
+			} else {
+				h.lines = append(h.lines, ops[i].line)
+			}
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+// applyHunks returns base[start:end] with hs, which lie within it, applied
+func applyHunks(base []string, start, end int, hs []hunk) []string {
+	var lines []string
+	pos := start
+	for _, h := range hs {
+		lines = append(lines, base[pos:h.start]...)
+		lines = append(lines, h.lines...)
+		pos = h.end
+	}
+	return append(lines, base[pos:end]...)
+}
+
+// merge3 merges the changes current and backup each made to base. Changes
+// to different lines are both taken; where both changed the same or
+// neighbouring lines differently, the two versions are written between
+// conflict markers. It returns the merged text and the number of conflicts.
+func merge3(base, current, backup string) (string, int) {
+	lines := splitLines(base)
+	ours := hunks(diffLines(lines, splitLines(current)))
+	theirs := hunks(diffLines(lines, splitLines(backup)))
+
+	var out strings.Builder
+	conflicts, pos := 0, 0
+	for len(ours) > 0 || len(theirs) > 0 {
+		// Start at whichever change comes first and take in every change
+		// from either side that overlaps or touches the region so far
+		start := 0
+		if len(theirs) == 0 || len(ours) > 0 && ours[0].start <= theirs[0].start {
+			start = ours[0].start
+		} else {
+			start = theirs[0].start
+		}
+		end := start
+		var ourGroup, theirGroup []hunk
+		for grown := true; grown; {
+			grown = false
+			for len(ours) > 0 && ours[0].start <= end {
+				end = max(end, ours[0].end)
+				ourGroup, ours = append(ourGroup, ours[0]), ours[1:]
+				grown = true
+			}
+			for len(theirs) > 0 && theirs[0].start <= end {
+				end = max(end, theirs[0].end)
+				theirGroup, theirs = append(theirGroup, theirs[0]), theirs[1:]
+				grown = true
+			}
+		}
+
+		writeRaw(&out, lines[pos:start])
+		ourLines := applyHunks(lines, start, end, ourGroup)
+		theirLines := applyHunks(lines, start, end, theirGroup)
+		switch {
+		case len(theirGroup) == 0 || slices.Equal(ourLines, theirLines):
+			writeRaw(&out, ourLines)
+		case len(ourGroup) == 0:
+			writeRaw(&out, theirLines)
+		default:
+			conflicts++
+			out.WriteString("<<<<<<< current\n")
+			writeLines(&out, ourLines)
+			out.WriteString("=======\n")
+			writeLines(&out, theirLines)
+			out.WriteString(">>>>>>> backup\n")
+		}
+		pos = end
+	}
+	writeRaw(&out, lines[pos:])
+	return out.String(), conflicts
+}
+
+// writeRaw writes lines as they are
+func writeRaw(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
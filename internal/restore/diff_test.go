@@ -0,0 +1,204 @@
+package restore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns lines "1\n" to "n\n"
+func numbered(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		changes int // Removed plus added lines in the shortest edit
+	}{
+		{"same", "a\nb\n", "a\nb\n", 0},
+		{"both empty", "", "", 0},
+		{"from empty", "", "a\nb\n", 2},
+		{"to empty", "a\nb\n", "", 2},
+		{"one line changed", "a\nb\nc\n", "a\nx\nc\n", 2},
+		{"line moved", "a\nb\nc\nd\n", "b\nc\nd\na\n", 2},
+		{"interleaved", "a\nb\nc\nd\ne\n", "a\nx\nc\ny\ne\nf\n", 5},
+		{"newline added at the end", "a\nb", "a\nb\n", 2},
+		{"too many lines to search", numbered(maxDiffLines), strings.ReplaceAll(numbered(maxDiffLines), "0\n", "0!\n"), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := diffLines(splitLines(tt.a), splitLines(tt.b))
+			var a, b strings.Builder
+			changes := 0
+			for _, op := range ops {
+				if op.kind != '+' {
+					a.WriteString(op.line)
+				}
+				if op.kind != '-' {
+					b.WriteString(op.line)
+				}
+				if op.kind != ' ' {
+					changes++
+				}
+			}
+			if a.String() != tt.a || b.String() != tt.b {
+				t.Errorf("edit script does not turn a into b")
+			}
+			if tt.changes >= 0 && changes != tt.changes {
+				t.Errorf("%d lines changed, want %d", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	ten := numbered(10)
+	twenty := numbered(20)
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"same", ten, ten, ""},
+		{"one line changed", ten, strings.Replace(ten, "5\n", "five\n", 1), `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`},
+		{"first line removed", "1\n2\n", "2\n", `--- a
++++ b
+@@ -1,2 +1 @@
+-1
+ 2
+`},
+		{"from empty", "", "a\n", `--- a
++++ b
+@@ -0,0 +1 @@
++a
+`},
+		{"to empty", "a\nb\n", "", `--- a
++++ b
+@@ -1,2 +0,0 @@
+-a
+-b
+`},
+		{"newline dropped at the end", "a\nb\n", "a\nb", `--- a
++++ b
+@@ -1,2 +1,2 @@
+ a
+-b
++b
+\ No newline at end of file
+`},
+		{"nearby changes share a hunk", ten, strings.Replace(strings.Replace(ten, "3\n", "x\n", 1), "8\n", "y\n", 1), `--- a
++++ b
+@@ -1,10 +1,10 @@
+ 1
+ 2
+-3
++x
+ 4
+ 5
+ 6
+ 7
+-8
++y
+ 9
+ 10
+`},
+		{"distant changes get their own hunks", twenty, strings.Replace(strings.Replace(twenty, "\n2\n", "\nx\n", 1), "\n19\n", "\ny\n", 1), `--- a
++++ b
+@@ -1,5 +1,5 @@
+ 1
+-2
++x
+ 3
+ 4
+ 5
+@@ -16,5 +16,5 @@
+ 16
+ 17
+ 18
+-19
++y
+ 20
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", tt.a, tt.b); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := "a\nb\nc\nd\ne\nf\ng\n"
+	tests := []struct {
+		name            string
+		current, backup string
+		want            string
+		conflicts       int
+	}{
+		{"nothing changed", base, base, base, 0},
+		{"only the backup changed", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n", 0},
+		{"only the current file changed", "a\nb\nc\nd\ne\nf\n", base, "a\nb\nc\nd\ne\nf\n", 0},
+		{"different lines changed", "A\nb\nc\nd\ne\nf\ng\n", "a\nb\nc\nd\ne\nf\nG\n", "A\nb\nc\nd\ne\nf\nG\n", 0},
+		{"line removed and another added", "a\nc\nd\ne\nf\ng\n", "a\nb\nc\nd\ne\nf\ng\nh\n", "a\nc\nd\ne\nf\ng\nh\n", 0},
+		{"same change on both sides", "a\nb\nX\nd\ne\nf\ng\n", "a\nb\nX\nd\ne\nf\ng\n", "a\nb\nX\nd\ne\nf\ng\n", 0},
+		{"same line changed differently", "a\nb\nX\nd\ne\nf\ng\n", "a\nb\nY\nd\ne\nf\ng\n",
+			"a\nb\n<<<<<<< current\nX\n=======\nY\n>>>>>>> backup\nd\ne\nf\ng\n", 1},
+		{"neighbouring lines changed", "a\nB\nc\nd\ne\nf\ng\n", "a\nb\nC\nd\ne\nf\ng\n",
+			"a\n<<<<<<< current\nB\nc\n=======\nb\nC\n>>>>>>> backup\nd\ne\nf\ng\n", 1},
+		{"different lines added at the same place", "a\nb\nc\nd\ne\nf\ng\nX\n", "a\nb\nc\nd\ne\nf\ng\nY\n",
+			"a\nb\nc\nd\ne\nf\ng\n<<<<<<< current\nX\n=======\nY\n>>>>>>> backup\n", 1},
+		{"line removed and changed", "a\nb\nd\ne\nf\ng\n", "a\nb\nC\nd\ne\nf\ng\n",
+			"a\nb\n<<<<<<< current\n=======\nC\n>>>>>>> backup\nd\ne\nf\ng\n", 1},
+		{"two conflicts", "X\nb\nc\nd\ne\nf\nX\n", "Y\nb\nc\nd\ne\nf\nY\n",
+			"<<<<<<< current\nX\n=======\nY\n>>>>>>> backup\nb\nc\nd\ne\nf\n<<<<<<< current\nX\n=======\nY\n>>>>>>> backup\n", 2},
+		{"conflict without a final newline", "a\nb\nc\nd\ne\nf\nX", "a\nb\nc\nd\ne\nf\nY",
+			"a\nb\nc\nd\ne\nf\n<<<<<<< current\nX\n=======\nY\n>>>>>>> backup\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := merge3(base, tt.current, tt.backup)
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			if conflicts != tt.conflicts {
+				t.Errorf("%d conflicts, want %d", conflicts, tt.conflicts)
+			}
+			if marked := hasConflictMarkers(got); marked != (tt.conflicts > 0) {
+				t.Errorf("hasConflictMarkers = %v with %d conflicts", marked, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestConflictFile(t *testing.T) {
+	got := conflictFile("a\nb\nc\n", "a\nB\nc")
+	want := "a\n<<<<<<< current\nb\nc\n=======\nB\nc\n>>>>>>> backup\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !hasConflictMarkers(got) {
+		t.Error("conflict markers not found")
+	}
+	if hasConflictMarkers("a <<<<<<< b\n=======\n") {
+		t.Error("markers found in the middle of a line")
+	}
+}
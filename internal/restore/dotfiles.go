@@ -1,18 +1,23 @@
 package restore
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // DotfilesRestore handles dotfiles restoration
 type DotfilesRestore struct {
-	merge bool // If true, don't overwrite existing files
+	merge   bool // If true, don't overwrite existing files
+	choices map[string]DotfileChoice
+	journal *Journal
+	target  Target
 }
 
 // NewDotfilesRestore creates a new DotfilesRestore instance
@@ -25,6 +30,16 @@ func NewDotfilesRestoreMerge() *DotfilesRestore {
 	return &DotfilesRestore{merge: true}
 }
 
+// SetJournal sets where overwritten dotfiles are saved for undo
+func (d *DotfilesRestore) SetJournal(j *Journal) {
+	d.journal = j
+}
+
+// SetTarget sets the home directory the dotfiles are restored into
+func (d *DotfilesRestore) SetTarget(t Target) {
+	d.target = t
+}
+
 // Name returns the display name
 func (d *DotfilesRestore) Name() string {
 	return "Dotfiles"
@@ -35,6 +50,11 @@ func (d *DotfilesRestore) Type() RestoreType {
 	return RestoreTypeDotfiles
 }
 
+// DependsOn returns nothing, dotfiles can be restored at any point
+func (d *DotfilesRestore) DependsOn() []RestoreType {
+	return nil
+}
+
 // Available returns true as dotfiles can always be restored
 func (d *DotfilesRestore) Available() bool {
 	return true
@@ -42,29 +62,65 @@ func (d *DotfilesRestore) Available() bool {
 
 // DotfilesData matches the backup structure
 type DotfilesData struct {
-	Files      []DotfileInfo `json:"files"`
-	BackupDir  string        `json:"backup_dir"`
-	SourceHome string        `json:"source_home"`
+	Files      []DotfileInfo     `json:"files"`
+	BackupDir  string            `json:"backup_dir"`
+	SourceHome string            `json:"source_home"`
+	Skeleton   map[string]string `json:"skeleton,omitempty"` // /etc/skel versions at backup time
 }
 
 // DotfileInfo contains dotfile information
 type DotfileInfo struct {
-	Path         string `json:"path"`
-	RelativePath string `json:"relative_path"`
-	Size         int64  `json:"size"`
-	IsDir        bool   `json:"is_dir"`
-	Exists       bool   `json:"exists"`
+	Path         string      `json:"path"`
+	RelativePath string      `json:"relative_path"`
+	Size         int64       `json:"size"`
+	IsDir        bool        `json:"is_dir"`
+	Exists       bool        `json:"exists"`
+	LinkTarget   string      `json:"link_target,omitempty"`
+	Mode         os.FileMode `json:"mode,omitempty"`
+	ModTime      time.Time   `json:"mod_time"`
+}
+
+// Validate drops the files that would be restored outside the home
+// directory and returns them
+func (d *DotfilesData) Validate() []backup.Rejection {
+	var valid []DotfileInfo
+	var rejected []backup.Rejection
+	for _, file := range d.Files {
+		err := backup.CheckRelativePath(file.RelativePath)
+		if err == nil {
+			if err = backup.CheckText(file.LinkTarget); err != nil {
+				err = fmt.Errorf("link target %w", err)
+			}
+		}
+		if err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "dotfiles.json", Value: file.RelativePath, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, file)
+	}
+	d.Files = valid
+	for rel := range d.Skeleton {
+		if err := backup.CheckRelativePath(rel); err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "dotfiles.json skeleton", Value: rel, Reason: err.Error()})
+			delete(d.Skeleton, rel)
+		}
+	}
+	return rejected
 }
 
 // Preview returns what would be restored
 func (d *DotfilesRestore) Preview(backupDir string) ([]string, error) {
-	data, err := d.loadBackupData(backupDir)
+	data, _, err := d.loadBackupData(backupDir)
 	if err != nil {
 		return nil, err
 	}
 
 	var items []string
 	for _, file := range data.Files {
+		if file.LinkTarget != "" {
+			items = append(items, file.RelativePath+" -> "+file.LinkTarget)
+			continue
+		}
 		items = append(items, file.RelativePath)
 	}
 
@@ -72,19 +128,19 @@ func (d *DotfilesRestore) Preview(backupDir string) ([]string, error) {
 }
 
 // loadBackupData loads the dotfiles backup data
-func (d *DotfilesRestore) loadBackupData(backupDir string) (*DotfilesData, error) {
+func (d *DotfilesRestore) loadBackupData(backupDir string) (*DotfilesData, []backup.Rejection, error) {
 	dataPath := filepath.Join(backupDir, "dotfiles.json")
 	content, err := os.ReadFile(dataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read dotfiles backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to read dotfiles backup: %w", err)
 	}
 
 	var data DotfilesData
 	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse dotfiles backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse dotfiles backup: %w", err)
 	}
 
-	return &data, nil
+	return &data, data.Validate(), nil
 }
 
 // Restore performs the dotfiles restoration
@@ -95,21 +151,22 @@ func (d *DotfilesRestore) Restore(backupDir string, dryRun bool) (RestoreResult,
 		DryRun:    dryRun,
 	}
 
-	data, err := d.loadBackupData(backupDir)
+	data, rejected, err := d.loadBackupData(backupDir)
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
 
-	result.ItemsTotal = len(data.Files)
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Files) + len(rejected)
 
 	if dryRun {
-		result.Success = true
-		result.ItemsSuccess = result.ItemsTotal
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
 		return result, nil
 	}
 
-	home, err := utils.GetHomeDir()
+	home, err := d.target.HomeDir()
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
@@ -122,36 +179,75 @@ func (d *DotfilesRestore) Restore(backupDir string, dryRun bool) (RestoreResult,
 		dstPath := filepath.Join(home, file.RelativePath)
 
 		// Check if source exists in backup
-		if !utils.FileExists(srcPath) && !utils.DirExists(srcPath) {
+		if file.LinkTarget == "" && !utils.FileExists(srcPath) && !utils.DirExists(srcPath) {
 			result.ItemsFailed++
 			result.Errors = append(result.Errors, fmt.Sprintf("Backup not found: %s", file.RelativePath))
 			continue
 		}
 
-		// In merge mode, skip existing files
-		if d.merge && utils.FileExists(dstPath) {
+		existing, statErr := os.Lstat(dstPath)
+		exists := statErr == nil
+
+		// In merge mode, the user's changes to the skeleton are merged into
+		// the current file; without a skeleton it is kept
+		choice := choiceFor(d.choices, file.RelativePath, d.merge)
+		conflicts := -1
+		if choice.Action == MergeSkeleton {
+			choice.Action = KeepCurrent
+			if base, ok := data.Skeleton[file.RelativePath]; ok && exists {
+				if merged, n, ok := mergeWithSkeleton(base, srcPath, dstPath); ok {
+					choice = DotfileChoice{Action: UseMerged, Content: merged}
+					conflicts = n
+				}
+			}
+		}
+
+		// Keep existing files the user chose to keep
+		if choice.Action == KeepCurrent && exists {
 			result.ItemsSuccess++ // Count as success (preserved)
 			continue
 		}
 
-		// Create backup of existing file
-		if utils.FileExists(dstPath) {
-			backupPath := dstPath + ".rego-backup"
-			utils.CopyFile(dstPath, backupPath)
+		if err := d.journal.RecordFile(RestoreTypeDotfiles, dstPath); err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, err.Error())
+			continue
 		}
 
 		var copyErr error
-		if file.IsDir {
-			copyErr = utils.CopyDir(srcPath, dstPath)
+		if choice.Action == UseMerged && exists {
+			copyErr = writeMerged(dstPath, choice.Content)
+		} else if file.LinkTarget != "" {
+			copyErr = restoreDotfileLink(dstPath, file.LinkTarget, existing)
 		} else {
-			copyErr = utils.CopyFile(srcPath, dstPath)
+			if file.IsDir {
+				copyErr = utils.CopyDir(srcPath, dstPath)
+			} else {
+				copyErr = utils.CopyFile(srcPath, dstPath)
+			}
+			if copyErr == nil && file.Mode != 0 {
+				os.Chmod(dstPath, file.Mode.Perm())
+			}
+			if copyErr == nil && !file.ModTime.IsZero() {
+				os.Chtimes(dstPath, file.ModTime, file.ModTime)
+			}
 		}
 
+		if copyErr == nil {
+			copyErr = d.target.Own(dstPath)
+		}
 		if copyErr != nil {
 			result.ItemsFailed++
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to restore %s: %v", file.RelativePath, copyErr))
-		} else {
-			result.ItemsSuccess++
+			continue
+		}
+		result.ItemsSuccess++
+		switch {
+		case conflicts == 0:
+			result.ItemsMerged++
+		case conflicts > 0:
+			result.ItemsConflicted++
+			result.Conflicts = append(result.Conflicts, file.RelativePath)
 		}
 	}
 
@@ -159,7 +255,217 @@ func (d *DotfilesRestore) Restore(backupDir string, dryRun bool) (RestoreResult,
 	return result, nil
 }
 
+// restoreDotfileLink recreates a symlinked dotfile. Whatever is in its place
+// is removed first, unless it is already the same link; the undo journal
+// holds a copy.
+func restoreDotfileLink(dstPath, target string, existing os.FileInfo) error {
+	if existing != nil {
+		if current, err := os.Readlink(dstPath); err == nil && current == target {
+			return nil
+		}
+		if err := os.RemoveAll(dstPath); err != nil {
+			return err
+		}
+	}
+	if err := utils.EnsureDir(filepath.Dir(dstPath)); err != nil {
+		return err
+	}
+	return os.Symlink(target, dstPath)
+}
+
 // SetMerge sets whether to merge or overwrite
 func (d *DotfilesRestore) SetMerge(merge bool) {
 	d.merge = merge
 }
+
+// SetChoices sets what to do with each existing dotfile, by path relative
+// to the home directory. Files without a choice follow SetMerge.
+func (d *DotfilesRestore) SetChoices(choices map[string]DotfileChoice) {
+	d.choices = choices
+}
+
+// DotfileAction is what to do with a dotfile that is already in the home
+// directory
+type DotfileAction string
+
+const (
+	TakeBackup    DotfileAction = "backup"   // Overwrite it with the backup
+	KeepCurrent   DotfileAction = "current"  // Leave it as it is
+	UseMerged     DotfileAction = "merged"   // Write Content, merged by hand
+	MergeSkeleton DotfileAction = "skeleton" // Merge three ways with the skeleton, or keep it without one
+)
+
+// DotfileChoice is the decision made for one existing dotfile
+type DotfileChoice struct {
+	Action  DotfileAction `json:"action"`
+	Content []byte        `json:"content,omitempty"` // For UseMerged
+}
+
+// choiceFor returns the choice made for the dotfile at rel, or without one,
+// merging it in merge mode and taking the backup otherwise
+func choiceFor(choices map[string]DotfileChoice, rel string, merge bool) DotfileChoice {
+	if choice, ok := choices[rel]; ok {
+		return choice
+	}
+	if merge {
+		return DotfileChoice{Action: MergeSkeleton}
+	}
+	return DotfileChoice{Action: TakeBackup}
+}
+
+// writeMerged replaces the file at path with content merged by hand,
+// keeping its permissions
+func writeMerged(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return os.WriteFile(path, content, mode)
+}
+
+// mergeWithSkeleton merges the changes the backup at src made to the
+// skeleton base into the current file at dst. It returns false when either
+// is not a text file that can be merged.
+func mergeWithSkeleton(base, src, dst string) ([]byte, int, bool) {
+	var contents [2][]byte
+	for i, path := range []string{src, dst} {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxDiffSize {
+			return nil, 0, false
+		}
+		if contents[i], err = os.ReadFile(path); err != nil || bytes.IndexByte(contents[i], 0) >= 0 {
+			return nil, 0, false
+		}
+	}
+	merged, conflicts := merge3(base, string(contents[1]), string(contents[0]))
+	return []byte(merged), conflicts, true
+}
+
+// DiffStatus says how a backed-up dotfile compares to the one in the home
+// directory
+type DiffStatus string
+
+const (
+	DiffNew       DiffStatus = "new"       // Not in the home directory
+	DiffIdentical DiffStatus = "identical" // Same as in the home directory
+	DiffChanged   DiffStatus = "changed"   // Both are text files; Diff holds the changes
+	DiffReplaced  DiffStatus = "replaced"  // A directory, link or binary file that can only be replaced whole
+)
+
+// maxDiffSize is the largest file that is compared line by line
+const maxDiffSize = 1 << 20
+
+// DotfileDiff compares a backed-up dotfile with the file it would replace
+type DotfileDiff struct {
+	RelativePath string
+	Status       DiffStatus
+	Diff         string // Unified diff from the current file to the backup
+	Detail       string // Why a DiffReplaced file has no diff
+	Skeleton     bool   // The backup recorded the skeleton version, see MergeSkeleton
+	BackupPath   string
+	HomePath     string
+}
+
+// Conflicts returns the current file with every change the backup makes
+// written between conflict markers, to be merged in an editor
+func (d DotfileDiff) Conflicts() ([]byte, error) {
+	current, err := os.ReadFile(d.HomePath)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := os.ReadFile(d.BackupPath)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(conflictFile(string(current), string(saved))), nil
+}
+
+// HasConflictMarkers reports whether a file merged by hand still holds
+// conflict markers from Conflicts
+func HasConflictMarkers(content []byte) bool {
+	return hasConflictMarkers(string(content))
+}
+
+// Diffs compares every backed-up dotfile with the one in the home directory
+func (d *DotfilesRestore) Diffs(backupDir string) ([]DotfileDiff, error) {
+	data, _, err := d.loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	home, err := d.target.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []DotfileDiff
+	for _, file := range data.Files {
+		src := filepath.Join(backupDir, "dotfiles", file.RelativePath)
+		diff := diffDotfile(file.RelativePath, src, filepath.Join(home, file.RelativePath), file.LinkTarget)
+		_, diff.Skeleton = data.Skeleton[file.RelativePath]
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// diffDotfile compares the backup of a dotfile at src, or the link to
+// linkTarget it was saved as, with the file at dst
+func diffDotfile(rel, src, dst, linkTarget string) DotfileDiff {
+	diff := DotfileDiff{RelativePath: rel, BackupPath: src, HomePath: dst}
+
+	current, err := os.Lstat(dst)
+	if err != nil {
+		diff.Status = DiffNew
+		return diff
+	}
+
+	diff.Status = DiffReplaced
+	if linkTarget == "" {
+		if info, err := os.Lstat(src); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			linkTarget, _ = os.Readlink(src)
+		}
+	}
+	if linkTarget != "" || current.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(dst)
+		switch {
+		case target == linkTarget:
+			diff.Status = DiffIdentical
+		case linkTarget == "":
+			diff.Detail = "current file is a link to " + target
+		default:
+			diff.Detail = "backup is a link to " + linkTarget
+		}
+		return diff
+	}
+
+	saved, err := os.Stat(src)
+	if err != nil {
+		diff.Detail = "not in the backup"
+		return diff
+	}
+	if saved.IsDir() || current.IsDir() {
+		diff.Detail = "directory"
+		return diff
+	}
+	if saved.Size() > maxDiffSize || current.Size() > maxDiffSize {
+		diff.Detail = "too large to compare"
+		return diff
+	}
+
+	a, errA := os.ReadFile(dst)
+	b, errB := os.ReadFile(src)
+	if errA != nil || errB != nil {
+		diff.Detail = "could not be read"
+		return diff
+	}
+	if bytes.Equal(a, b) {
+		diff.Status = DiffIdentical
+		return diff
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		diff.Detail = "binary file"
+		return diff
+	}
+	diff.Status = DiffChanged
+	diff.Diff = unifiedDiff(filepath.Join("~", rel)+" (current)", filepath.Join("~", rel)+" (backup)", string(a), string(b))
+	return diff
+}
@@ -5,19 +5,49 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // FlatpakRestore handles Flatpak restoration
-type FlatpakRestore struct{}
+type FlatpakRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+	pin     bool
+}
 
 // NewFlatpakRestore creates a new FlatpakRestore instance
 func NewFlatpakRestore() *FlatpakRestore {
 	return &FlatpakRestore{}
 }
 
+// SetJournal sets where installed apps are recorded for undo
+func (f *FlatpakRestore) SetJournal(j *Journal) {
+	f.journal = j
+}
+
+// SetHelper sets what installs apps into another root's installation
+func (f *FlatpakRestore) SetHelper(h *privileged.Helper) {
+	f.helper = h
+}
+
+// SetTarget sets the root filesystem whose system installation apps go to
+func (f *FlatpakRestore) SetTarget(t Target) {
+	f.target = t
+}
+
+// SetPin sets whether apps are moved back to the commit they were backed
+// up at once installed
+func (f *FlatpakRestore) SetPin(pin bool) {
+	f.pin = pin
+}
+
 // Name returns the display name
 func (f *FlatpakRestore) Name() string {
 	return "Flatpak Applications"
@@ -28,6 +58,11 @@ func (f *FlatpakRestore) Type() RestoreType {
 	return RestoreTypeFlatpak
 }
 
+// DependsOn adds the remotes first so apps can be installed from them
+func (f *FlatpakRestore) DependsOn() []RestoreType {
+	return []RestoreType{RestoreTypeFlatpakRemotes}
+}
+
 // Available checks if Flatpak is installed
 func (f *FlatpakRestore) Available() bool {
 	return utils.CommandExists("flatpak")
@@ -45,6 +80,26 @@ type FlatpakApp struct {
 	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// Deployment returns how app was installed. Backups from before the
+// installation was recorded have it in the system installation.
+func (app FlatpakApp) Deployment() backup.FlatpakDeployment {
+	d := backup.FlatpakDeployment{
+		ID:           app.Name,
+		Installation: app.Metadata["installation"],
+		Origin:       app.Metadata["origin"],
+		Branch:       app.Metadata["branch"],
+		Commit:       app.Metadata["commit"],
+		Runtime:      app.Metadata["runtime"],
+	}
+	if d.Installation == "" {
+		d.Installation = backup.FlatpakSystem
+	}
+	if extensions := app.Metadata["extensions"]; extensions != "" {
+		d.Extensions = strings.Split(extensions, ",")
+	}
+	return d
+}
+
 // FlatpakRemote represents a Flatpak remote
 type FlatpakRemote struct {
 	Name    string `json:"name"`
@@ -52,17 +107,66 @@ type FlatpakRemote struct {
 	Options string `json:"options,omitempty"`
 }
 
+// Installation returns the installation the remote was in, which flatpak
+// remotes lists among its options
+func (r FlatpakRemote) Installation() string {
+	if slices.Contains(strings.Split(r.Options, ","), backup.FlatpakUser) {
+		return backup.FlatpakUser
+	}
+	return backup.FlatpakSystem
+}
+
+// Validate drops the apps and remotes that fail validation and returns
+// them, the remotes under "flatpak.json remotes"
+func (d *FlatpakData) Validate() []backup.Rejection {
+	var rejected []backup.Rejection
+	var apps []FlatpakApp
+	for _, app := range d.Applications {
+		if err := backup.CheckFlatpakDeployment(app.Deployment()); err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "flatpak.json", Value: app.Name, Reason: err.Error()})
+			continue
+		}
+		apps = append(apps, app)
+	}
+	d.Applications = apps
+
+	var remotes []FlatpakRemote
+	for _, remote := range d.Remotes {
+		err := backup.CheckIdentifier(remote.Name)
+		if err == nil && remote.URL != "" {
+			if err = backup.CheckURL(remote.URL); err != nil {
+				err = fmt.Errorf("URL %w", err)
+			}
+		}
+		if err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "flatpak.json remotes", Value: remote.Name, Reason: err.Error()})
+			continue
+		}
+		remotes = append(remotes, remote)
+	}
+	d.Remotes = remotes
+	return rejected
+}
+
+// rejectedFrom returns the rejections of field
+func rejectedFrom(rejected []backup.Rejection, field string) []backup.Rejection {
+	var from []backup.Rejection
+	for _, r := range rejected {
+		if r.Field == field {
+			from = append(from, r)
+		}
+	}
+	return from
+}
+
 // Preview returns what would be restored
 func (f *FlatpakRestore) Preview(backupDir string) ([]string, error) {
-	data, err := f.loadBackupData(backupDir)
+	data, _, err := f.loadBackupData(backupDir)
 	if err != nil {
 		return nil, err
 	}
 
 	var items []string
-	for _, remote := range data.Remotes {
-		items = append(items, fmt.Sprintf("Remote: %s (%s)", remote.Name, remote.URL))
-	}
 	for _, app := range data.Applications {
 		items = append(items, fmt.Sprintf("App: %s", app.Name))
 	}
@@ -71,19 +175,19 @@ func (f *FlatpakRestore) Preview(backupDir string) ([]string, error) {
 }
 
 // loadBackupData loads the Flatpak backup data
-func (f *FlatpakRestore) loadBackupData(backupDir string) (*FlatpakData, error) {
+func (f *FlatpakRestore) loadBackupData(backupDir string) (*FlatpakData, []backup.Rejection, error) {
 	dataPath := filepath.Join(backupDir, "flatpak.json")
 	content, err := os.ReadFile(dataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read flatpak backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to read flatpak backup: %w", err)
 	}
 
 	var data FlatpakData
 	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse flatpak backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse flatpak backup: %w", err)
 	}
 
-	return &data, nil
+	return &data, data.Validate(), nil
 }
 
 // Restore performs the Flatpak restoration
@@ -94,50 +198,280 @@ func (f *FlatpakRestore) Restore(backupDir string, dryRun bool) (RestoreResult,
 		DryRun:    dryRun,
 	}
 
-	data, err := f.loadBackupData(backupDir)
+	data, rejected, err := f.loadBackupData(backupDir)
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
 
-	result.ItemsTotal = len(data.Applications)
+	rejected = rejectedFrom(rejected, "flatpak.json")
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Applications) + len(rejected)
 
 	if dryRun {
-		result.Success = true
-		result.ItemsSuccess = result.ItemsTotal
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
 		return result, nil
 	}
 
-	// Install remotes first
-	for _, remote := range data.Remotes {
-		if remote.Name == "flathub" {
-			// Flathub is special, use the official command
-			cmdResult := utils.RunCommand("flatpak", "remote-add", "--if-not-exists", "flathub", "https://flathub.org/repo/flathub.flatpakrepo")
-			if cmdResult.Error != nil {
-				utils.Warn("Failed to add flathub remote: %v", cmdResult.Error)
-			}
-		} else if remote.URL != "" {
-			cmdResult := utils.RunCommand("flatpak", "remote-add", "--if-not-exists", remote.Name, remote.URL)
-			if cmdResult.Error != nil {
-				utils.Warn("Failed to add remote %s: %v", remote.Name, cmdResult.Error)
-			}
+	// Install applications
+	var apps []backup.FlatpakDeployment
+	for _, app := range data.Applications {
+		apps = append(apps, app.Deployment())
+	}
+	installed := f.journal.TrackFlatpaks(apps)
+	defer installed()
+
+	available, unavailable := ResolveFlatpakApps(f.target.Root, apps)
+	for _, app := range unavailable {
+		result.AddItems(UnavailableItems([]string{app.ID}, fmt.Sprintf(reasonNotOnRemote, app.Remote()))...)
+	}
+	installer := FlatpakInstaller{Target: f.target, Helper: f.helper, Pin: f.pin}
+	for _, app := range available {
+		result.AddItems(installer.Install(app))
+	}
+
+	result.Success = result.ItemsFailed == 0
+	return result, nil
+}
+
+// FlatpakInstaller installs Flatpak apps into the installations they were
+// backed up from. On the running system that is whichever installation the
+// app was in. For another root, system apps go to its system installation,
+// which only Helper can write, and user apps to the user installation in
+// the target's home directory.
+type FlatpakInstaller struct {
+	Target   Target
+	Helper   *privileged.Helper
+	Progress *utils.Progress
+	Sideload string // Offline repository to take what it can from first
+	Pin      bool   // Move each app back to the commit it was backed up at
+}
+
+// Install installs app and the extensions it had, then with Pin updates
+// it to its recorded commit. An app that is installed without all of its
+// extensions, or that can't be pinned, counts as failed so that resuming
+// tries it again.
+func (f FlatpakInstaller) Install(app backup.FlatpakDeployment) ItemResult {
+	start := time.Now()
+	item := CommandItem(app.ID, f.install(app, []string{app.Ref()}, f.Sideload), time.Since(start))
+	if !item.OK() {
+		return item
+	}
+
+	if len(app.Extensions) > 0 {
+		if result := f.install(app, app.Extensions, ""); result.Error != nil {
+			item.Status = ItemFailed
+			item.Reason = "installed, but not all of its extensions: " + FailureReason(result.Stderr, app.ID, "flatpak")
 		}
 	}
+	if item.OK() && f.Pin && app.Commit != "" {
+		if result := f.pin(app); result.Error != nil {
+			item.Status = ItemFailed
+			item.Reason = fmt.Sprintf("installed, but not at commit %.12s: %s", app.Commit, FailureReason(result.Stderr, app.ID, "flatpak"))
+		}
+	}
+	item.Duration = time.Since(start)
+	return item
+}
 
-	// Install applications
-	for _, app := range data.Applications {
-		origin := "flathub" // Default
-		if app.Metadata != nil && app.Metadata["origin"] != "" {
-			origin = app.Metadata["origin"]
+// AddRemote adds the remote name from url to installation unless it is
+// already there
+func (f FlatpakInstaller) AddRemote(installation, name, url string) utils.CommandResult {
+	app := backup.FlatpakDeployment{Installation: installation}
+	if f.privileged(app) {
+		return f.runPrivileged(privileged.AddRemote(name, url).In(f.Target.Root))
+	}
+	return f.run(app, 30*time.Second, "remote-add", app.InstallationFlag(), "--if-not-exists", name, url)
+}
+
+// install installs refs from app's remote into app's installation
+func (f FlatpakInstaller) install(app backup.FlatpakDeployment, refs []string, sideload string) utils.CommandResult {
+	if f.privileged(app) {
+		return f.runPrivileged(privileged.InstallFlatpaks(app.Remote(), refs).SideloadFrom(sideload).In(f.Target.Root))
+	}
+	args := []string{"install", app.InstallationFlag(), "-y", "--noninteractive"}
+	if sideload != "" {
+		args = append(args, "--sideload-repo="+sideload)
+	}
+	args = append(args, app.Remote())
+	return f.run(app, 5*time.Minute, append(args, refs...)...)
+}
+
+// pin updates app to its recorded commit, which may be older than the one
+// just installed
+func (f FlatpakInstaller) pin(app backup.FlatpakDeployment) utils.CommandResult {
+	if f.privileged(app) {
+		return f.runPrivileged(privileged.PinFlatpak(app.Ref(), app.Commit).In(f.Target.Root))
+	}
+	return f.run(app, 5*time.Minute, "update", app.InstallationFlag(), "-y", "--noninteractive", "--commit="+app.Commit, app.Ref())
+}
+
+// Installed returns the apps in installation
+func (f FlatpakInstaller) Installed(installation string) []string {
+	app := backup.FlatpakDeployment{Installation: installation}
+	if f.privileged(app) {
+		return backup.GetInstalledFlatpaksIn(f.Target.Root)
+	}
+	env, err := f.env(app)
+	if err != nil {
+		return nil
+	}
+	result := utils.RunCommandWithEnv(env, 30*time.Second, "flatpak", "list", app.InstallationFlag(), "--app", "--columns=application")
+	if result.Error != nil {
+		return nil
+	}
+	return strings.Fields(result.Stdout)
+}
+
+// Uninstall removes the apps ids from installation
+func (f FlatpakInstaller) Uninstall(installation string, ids []string) utils.CommandResult {
+	app := backup.FlatpakDeployment{Installation: installation}
+	if f.privileged(app) {
+		return f.runPrivileged(privileged.Remove("flatpak", ids).In(f.Target.Root))
+	}
+	args := append([]string{"uninstall", app.InstallationFlag(), "-y", "--noninteractive"}, ids...)
+	return f.run(app, 30*time.Minute, args...)
+}
+
+// privileged reports whether app goes to the system installation of
+// another root. Any installation but the user's is taken to be that one.
+func (f FlatpakInstaller) privileged(app backup.FlatpakDeployment) bool {
+	return !f.Target.LiveSystem() && app.Installation != backup.FlatpakUser
+}
+
+// run reports flatpak with args and runs it as the user. The user
+// installation of a home other than the current user's is reached through
+// $FLATPAK_USER_DIR.
+func (f FlatpakInstaller) run(app backup.FlatpakDeployment, timeout time.Duration, args ...string) utils.CommandResult {
+	f.Progress.Run("flatpak", args...)
+	env, err := f.env(app)
+	if err != nil {
+		return errorResult(err)
+	}
+	result := utils.RunCommandWithEnv(env, timeout, "flatpak", args...)
+	if dir := env["FLATPAK_USER_DIR"]; dir != "" && result.Error == nil {
+		if err := f.Target.Own(dir); err != nil {
+			return errorResult(err)
 		}
+	}
+	return result
+}
+
+// env returns the environment flatpak needs to reach app's installation
+// as the user, nil unless it is the user installation of another home
+func (f FlatpakInstaller) env(app backup.FlatpakDeployment) (map[string]string, error) {
+	if app.Installation != backup.FlatpakUser || f.Target.LiveHome() {
+		return nil, nil
+	}
+	home, err := f.Target.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"FLATPAK_USER_DIR": filepath.Join(home, ".local", "share", "flatpak")}, nil
+}
+
+// runPrivileged reports the command op amounts to and runs it in the helper
+func (f FlatpakInstaller) runPrivileged(op privileged.Op) utils.CommandResult {
+	argv := op.Command()
+	f.Progress.Run(argv[0], argv[1:]...)
+	return f.Helper.Run(op)
+}
+
+// FlatpakRemotesRestore adds the Flatpak remotes the apps are installed from
+type FlatpakRemotesRestore struct {
+	helper *privileged.Helper
+	target Target
+}
+
+// NewFlatpakRemotesRestore creates a new FlatpakRemotesRestore instance
+func NewFlatpakRemotesRestore() *FlatpakRemotesRestore {
+	return &FlatpakRemotesRestore{}
+}
+
+// SetHelper sets what adds remotes to another root's installation
+func (f *FlatpakRemotesRestore) SetHelper(h *privileged.Helper) {
+	f.helper = h
+}
+
+// SetTarget sets the root filesystem whose system installation gets the remotes
+func (f *FlatpakRemotesRestore) SetTarget(t Target) {
+	f.target = t
+}
+
+// Name returns the display name
+func (f *FlatpakRemotesRestore) Name() string {
+	return "Flatpak Remotes"
+}
+
+// Type returns the restore type
+func (f *FlatpakRemotesRestore) Type() RestoreType {
+	return RestoreTypeFlatpakRemotes
+}
+
+// DependsOn returns nothing, remotes come before the apps
+func (f *FlatpakRemotesRestore) DependsOn() []RestoreType {
+	return nil
+}
+
+// Available checks if Flatpak is installed
+func (f *FlatpakRemotesRestore) Available() bool {
+	return utils.CommandExists("flatpak")
+}
+
+// Preview returns what would be restored
+func (f *FlatpakRemotesRestore) Preview(backupDir string) ([]string, error) {
+	data, _, err := NewFlatpakRestore().loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
 
-		cmdResult := utils.RunCommandWithTimeout("flatpak", 5*time.Minute, "install", "-y", "--noninteractive", origin, app.Name)
-		if cmdResult.Error != nil {
-			result.ItemsFailed++
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to install %s: %s", app.Name, cmdResult.Stderr))
-		} else {
-			result.ItemsSuccess++
+	var items []string
+	for _, remote := range data.Remotes {
+		items = append(items, fmt.Sprintf("%s (%s)", remote.Name, remote.URL))
+	}
+	return items, nil
+}
+
+// Restore adds the remotes that are not configured yet
+func (f *FlatpakRemotesRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
+	result := RestoreResult{
+		Type:      RestoreTypeFlatpakRemotes,
+		Timestamp: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	data, rejected, err := NewFlatpakRestore().loadBackupData(backupDir)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	rejected = rejectedFrom(rejected, "flatpak.json remotes")
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Remotes) + len(rejected)
+
+	if dryRun {
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
+		return result, nil
+	}
+
+	installer := FlatpakInstaller{Target: f.target, Helper: f.helper}
+	for _, remote := range data.Remotes {
+		url := remote.URL
+		if remote.Name == "flathub" {
+			// Flathub is special, use the official repo file
+			url = "https://flathub.org/repo/flathub.flatpakrepo"
 		}
+		if url == "" {
+			result.AddItems(ItemResult{Name: remote.Name, Status: ItemFailed, Reason: "no URL in the backup"})
+			continue
+		}
+
+		start := time.Now()
+		cmdResult := installer.AddRemote(remote.Installation(), remote.Name, url)
+		result.AddItems(CommandItem(remote.Name, cmdResult, time.Since(start)))
 	}
 
 	result.Success = result.ItemsFailed == 0
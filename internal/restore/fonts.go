@@ -8,14 +8,25 @@ import (
 	"github.com/r8bert/rego/internal/utils"
 )
 
-type FontsRestore struct{}
+type FontsRestore struct {
+	journal *Journal
+	target  Target
+}
 
 func NewFontsRestore() *FontsRestore { return &FontsRestore{} }
 
+// SetJournal sets where the fonts written are recorded for undo
+func (f *FontsRestore) SetJournal(j *Journal) { f.journal = j }
+
+// SetTarget sets the home directory the fonts are copied into
+func (f *FontsRestore) SetTarget(t Target) { f.target = t }
+
 func (f *FontsRestore) Name() string      { return "User Fonts" }
 func (f *FontsRestore) Type() RestoreType { return RestoreTypeFonts }
 func (f *FontsRestore) Available() bool   { return true }
 
+func (f *FontsRestore) DependsOn() []RestoreType { return nil }
+
 type FontsData struct {
 	Fonts     []FontInfo `json:"fonts"`
 	TotalSize int64      `json:"total_size"`
@@ -58,16 +69,33 @@ func (f *FontsRestore) Restore(backupDir string, dryRun bool) (RestoreResult, er
 		return result, nil
 	}
 
-	home, _ := utils.GetHomeDir()
+	home, err := f.target.HomeDir()
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
 	userFontsDir := filepath.Join(home, ".local", "share", "fonts")
 
+	for _, file := range files {
+		rel, _ := filepath.Rel(fontsBackupDir, file)
+		if err := f.journal.RecordFile(RestoreTypeFonts, filepath.Join(userFontsDir, rel)); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			return result, err
+		}
+	}
+
 	if err := utils.CopyDir(fontsBackupDir, userFontsDir); err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
+	if err := f.target.Own(userFontsDir); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
 
 	result.ItemsSuccess = result.ItemsTotal
-	if utils.CommandExists("fc-cache") {
+	// Another user's font cache is rebuilt when they first log in
+	if f.target.LiveHome() && utils.CommandExists("fc-cache") {
 		utils.RunCommand("fc-cache", "-f", "-v")
 	}
 	result.Success = true
@@ -0,0 +1,647 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// FullRestore restores a Full Save .tar.gz archive. The archive is extracted
+// to a temporary directory when opened; call Close to remove it.
+type FullRestore struct {
+	archivePath string
+	dir         string
+	ownsDir     bool
+	manifest    backup.FullBackupManifest
+	packages    *backup.LightBackup
+	merge       bool
+	pin         bool
+	choices     map[string]DotfileChoice
+	progress    *utils.Progress
+	journal     *Journal
+	snapshot    *Snapshot
+	noSnapshot  bool
+	helper      *privileged.Helper
+	target      Target
+}
+
+// OpenFullBackup extracts a Full Save archive and reads its manifest. The
+// passphrase is only used if the archive is encrypted; without one, an
+// encrypted archive returns utils.ErrPassphraseRequired.
+func OpenFullBackup(archivePath, passphrase string) (*FullRestore, error) {
+	tmpDir, err := os.MkdirTemp("", "rego-full-restore-*")
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := backup.NewExporter()
+	exporter.SetPassphrase(passphrase)
+	if err := exporter.ImportFromFile(archivePath, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	f, err := NewFullRestoreFromDir(tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	f.archivePath = archivePath
+	f.ownsDir = true
+	return f, nil
+}
+
+// NewFullRestoreFromDir reads a Full Save that has already been extracted.
+// The directory is left in place by Close.
+func NewFullRestoreFromDir(dir string) (*FullRestore, error) {
+	f := &FullRestore{dir: dir}
+
+	content, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("not a Full Save archive: %w", err)
+	}
+	if err := json.Unmarshal(content, &f.manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	f.manifest.Validate()
+
+	packagesPath := filepath.Join(dir, "packages.json")
+	if utils.FileExists(packagesPath) {
+		f.packages, err = backup.LoadLightBackup(packagesPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read package lists: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// Close removes the extracted archive
+func (f *FullRestore) Close() error {
+	if f.dir == "" || !f.ownsDir {
+		return nil
+	}
+	err := os.RemoveAll(f.dir)
+	f.dir = ""
+	return err
+}
+
+// Manifest returns the archive manifest
+func (f *FullRestore) Manifest() backup.FullBackupManifest { return f.manifest }
+
+// Verify checks the extracted archive against the checksums in its manifest
+func (f *FullRestore) Verify() (*backup.VerifyReport, error) {
+	return backup.VerifyBackupDir(f.dir)
+}
+
+// Packages returns the package lists stored in the archive, if any
+func (f *FullRestore) Packages() *backup.LightBackup { return f.packages }
+
+// SetMerge sets whether existing files are kept instead of overwritten
+func (f *FullRestore) SetMerge(merge bool) { f.merge = merge }
+
+// SetPinFlatpaks sets whether Flatpak apps are moved back to the commit
+// they were backed up at
+func (f *FullRestore) SetPinFlatpaks(pin bool) { f.pin = pin }
+
+// SetDotfileChoices sets what to do with each existing dotfile, see
+// DotfilesRestore.SetChoices
+func (f *FullRestore) SetDotfileChoices(choices map[string]DotfileChoice) { f.choices = choices }
+
+// DotfileDiffs compares the dotfiles in the archive with those in the home
+// directory
+func (f *FullRestore) DotfileDiffs() ([]DotfileDiff, error) {
+	if utils.FileExists(filepath.Join(f.dir, "dotfiles.json")) {
+		d := NewDotfilesRestore()
+		d.SetTarget(f.target)
+		return d.Diffs(f.dir)
+	}
+
+	copies, err := f.plan(RestoreTypeDotfiles)
+	if err != nil {
+		return nil, err
+	}
+	home, err := f.target.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	var diffs []DotfileDiff
+	for _, c := range copies {
+		diffs = append(diffs, diffDotfile(c.rel, c.src, filepath.Join(home, c.rel), ""))
+	}
+	return diffs, nil
+}
+
+// fileSections maps archive sections that hold files to their directory in
+// the archive. Wallpapers and themes are spread over backgrounds_0..N and
+// themes_0..N; older archives have a single backgrounds directory.
+var fileSections = []struct {
+	Type RestoreType
+	Dir  string
+}{
+	{RestoreTypeDotfiles, "dotfiles"},
+	{RestoreTypeSSH, "ssh"},
+	{RestoreTypeFonts, "fonts"},
+	{RestoreTypeAutostart, "autostart"},
+	{RestoreTypeBackgrounds, "backgrounds_"},
+	{RestoreTypeThemes, "themes_"},
+	{RestoreTypeKDEConfig, "kde-config"},
+	{RestoreTypeKDEData, "kde-data"},
+}
+
+// Sections returns the sections present in the archive, in restore order
+func (f *FullRestore) Sections() []RestoreType {
+	var sections []RestoreType
+
+	if p := f.packages; p != nil {
+		if len(p.Flatpaks) > 0 {
+			sections = append(sections, RestoreTypeFlatpak)
+		}
+		if len(p.ZypperRepos) > 0 {
+			sections = append(sections, RestoreTypeZypperRepos)
+		}
+		if utils.FileExists(filepath.Join(f.dir, "apt_sources.json")) {
+			sections = append(sections, RestoreTypeAPTSources)
+		}
+		if len(p.RPMPackages) > 0 || len(p.APTPackages) > 0 ||
+			len(p.PacmanPackages) > 0 || len(p.AURPackages) > 0 || len(p.ZypperPackages) > 0 {
+			sections = append(sections, RestoreTypePackages)
+		}
+		if len(p.GnomeExtensions) > 0 {
+			sections = append(sections, RestoreTypeGnomeExtensions)
+		}
+		if p.DconfSettings != "" {
+			sections = append(sections, RestoreTypeGnomeSettings)
+		}
+	}
+
+	for _, s := range fileSections {
+		if items, _ := f.Preview(s.Type); len(items) > 0 {
+			sections = append(sections, s.Type)
+		}
+	}
+
+	return sections
+}
+
+// archiveDirs returns the extracted directories whose name is dir, or that
+// start with dir or are named dir without it when it ends in an underscore
+func (f *FullRestore) archiveDirs(dir string) []string {
+	if !strings.HasSuffix(dir, "_") {
+		if utils.DirExists(filepath.Join(f.dir, dir)) {
+			return []string{dir}
+		}
+		return nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(f.dir, dir+"*"))
+	matches = append(matches, filepath.Join(f.dir, strings.TrimSuffix(dir, "_")))
+	var dirs []string
+	for _, m := range matches {
+		if utils.DirExists(m) {
+			dirs = append(dirs, filepath.Base(m))
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// fileCopy is a single file to put back, relative to the home directory
+type fileCopy struct {
+	src string
+	rel string
+}
+
+// plan returns the files a file section would write. Archives written before
+// locations were recorded fall back to the fixed layout of older versions.
+func (f *FullRestore) plan(section RestoreType) ([]fileCopy, error) {
+	var dirName string
+	for _, s := range fileSections {
+		if s.Type == section {
+			dirName = s.Dir
+		}
+	}
+
+	var copies []fileCopy
+	for _, dir := range f.archiveDirs(dirName) {
+		root := filepath.Join(f.dir, dir)
+		files, err := utils.ListFilesRecursive(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			rel, _ := filepath.Rel(root, file)
+			dest, ok := f.destination(dir, rel)
+			if !ok {
+				continue
+			}
+			copies = append(copies, fileCopy{src: file, rel: dest})
+		}
+	}
+	return copies, nil
+}
+
+// destination maps a file inside an archive directory to its path relative
+// to the home directory, refusing any that would lead out of it
+func (f *FullRestore) destination(dir, rel string) (string, bool) {
+	dest, ok := f.archiveDestination(dir, rel)
+	if !ok || !filepath.IsLocal(dest) {
+		return "", false
+	}
+	return dest, true
+}
+
+// archiveDestination is destination before the check that the path stays
+// in the home directory
+func (f *FullRestore) archiveDestination(dir, rel string) (string, bool) {
+	if loc, ok := f.manifest.Locations[dir]; ok {
+		return filepath.Join(loc, rel), true
+	}
+
+	switch {
+	case dir == "dotfiles":
+		for _, dotfile := range backup.FullBackupDotfiles() {
+			if filepath.Base(dotfile) == rel {
+				return dotfile, true
+			}
+		}
+		return "", false
+	case dir == "ssh":
+		return filepath.Join(".ssh", rel), true
+	case dir == "fonts":
+		return filepath.Join(".local", "share", "fonts", rel), true
+	case dir == "autostart":
+		return filepath.Join(".config", "autostart", rel), true
+	case dir == "backgrounds":
+		return filepath.Join(".local", "share", "backgrounds", rel), true
+	case strings.HasPrefix(dir, "backgrounds_"):
+		var i int
+		if _, err := fmt.Sscanf(dir, "backgrounds_%d", &i); err == nil && i < len(backup.FullBackupBackgroundDirs()) {
+			return filepath.Join(backup.FullBackupBackgroundDirs()[i], rel), true
+		}
+		return "", false
+	case strings.HasPrefix(dir, "themes_"):
+		var i int
+		if _, err := fmt.Sscanf(dir, "themes_%d", &i); err == nil && i < len(backup.FullBackupThemeDirs()) {
+			return filepath.Join(backup.FullBackupThemeDirs()[i], rel), true
+		}
+		return "", false
+	case dir == "kde-config":
+		if filepath.Ext(rel) == ".profile" || filepath.Ext(rel) == ".colorscheme" {
+			return filepath.Join(".local", "share", "konsole", rel), true
+		}
+		if rel == "settings.ini" {
+			return "", false // gtk-3.0 and gtk-4.0 were flattened onto each other
+		}
+		return filepath.Join(".config", rel), true
+	case dir == "kde-data":
+		top := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		for _, dataDir := range backup.NewKDEBackup().KDEDataDirs() {
+			if filepath.Base(dataDir) == top {
+				return filepath.Join(filepath.Dir(dataDir), rel), true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// Preview returns what a section would restore
+func (f *FullRestore) Preview(section RestoreType) ([]string, error) {
+	if p := f.packages; p != nil {
+		switch section {
+		case RestoreTypeFlatpak:
+			return p.Flatpaks, nil
+		case RestoreTypeZypperRepos:
+			var items []string
+			for _, repo := range p.ZypperRepos {
+				items = append(items, fmt.Sprintf("%s (priority %d)", repo.Alias, repo.Priority))
+			}
+			return items, nil
+		case RestoreTypePackages:
+			var pkgs []string
+			pkgs = append(pkgs, p.RPMPackages...)
+			pkgs = append(pkgs, p.APTPackages...)
+			pkgs = append(pkgs, p.PacmanPackages...)
+			pkgs = append(pkgs, p.ZypperPackages...)
+			return append(pkgs, p.AURPackages...), nil
+		case RestoreTypeGnomeExtensions:
+			return p.GnomeExtensions, nil
+		case RestoreTypeGnomeSettings:
+			return []string{"Full dconf database restore"}, nil
+		}
+	}
+
+	if section == RestoreTypeAPTSources {
+		return NewAPTSourcesRestore().Preview(f.dir)
+	}
+	// dotfiles.json also lists dotfiles saved as symlinks, which have no
+	// copy in the archive
+	if section == RestoreTypeDotfiles && utils.FileExists(filepath.Join(f.dir, "dotfiles.json")) {
+		items, err := NewDotfilesRestore().Preview(f.dir)
+		for i := range items {
+			items[i] = filepath.Join("~", items[i])
+		}
+		return items, err
+	}
+
+	copies, err := f.plan(section)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, c := range copies {
+		items = append(items, filepath.Join("~", c.rel))
+	}
+	return items, nil
+}
+
+// SetProgress sets where to report progress. Each section is a step; files
+// are counted as they are copied and packages as they are installed.
+func (f *FullRestore) SetProgress(fn utils.ProgressFunc) {
+	f.progress = utils.NewProgress(fn)
+}
+
+// SetSource records the archive an extracted Full Save came from, for the
+// undo journal
+func (f *FullRestore) SetSource(archivePath string) {
+	f.archivePath = archivePath
+}
+
+// Journal returns the undo journal of the last restore that was not a dry
+// run, or nil
+func (f *FullRestore) Journal() *Journal {
+	return f.journal
+}
+
+// SetHelper sets what runs the package installs and system file writes
+// that need root
+func (f *FullRestore) SetHelper(h *privileged.Helper) {
+	f.helper = h
+}
+
+// SetTarget sets the root filesystem and home directory to restore into
+func (f *FullRestore) SetTarget(t Target) {
+	f.target = t
+}
+
+// SetSkipSnapshot turns off the pre-restore snapshot
+func (f *FullRestore) SetSkipSnapshot(skip bool) {
+	f.noSnapshot = skip
+}
+
+// Snapshot returns the pre-restore snapshot of the last restore that was
+// not a dry run, or nil
+func (f *FullRestore) Snapshot() *Snapshot {
+	return f.snapshot
+}
+
+// Restore restores the given sections in archive order. Unless it is a dry
+// run, the sections are first saved to a pre-restore snapshot and every
+// change is recorded in a new undo journal. There is no snapshot when the
+// target is not the running system and the current user's home.
+func (f *FullRestore) Restore(sections []RestoreType, dryRun bool) []RestoreResult {
+	f.journal = nil
+	if !dryRun {
+		source := f.archivePath
+		if source == "" {
+			source = f.dir
+		}
+		j, err := NewJournal(source)
+		if err != nil {
+			return []RestoreResult{{Timestamp: time.Now(), Errors: []string{fmt.Sprintf("Failed to start undo journal: %v", err)}}}
+		}
+		j.SetTarget(f.target)
+		f.journal = j
+	}
+
+	wanted := make(map[RestoreType]bool)
+	for _, s := range sections {
+		wanted[s] = true
+	}
+
+	var todo []RestoreType
+	for _, section := range f.Sections() {
+		if wanted[section] {
+			todo = append(todo, section)
+		}
+	}
+
+	f.snapshot = nil
+	if !dryRun && !f.noSnapshot && f.target.Live() {
+		s, err := NewSnapshot()
+		if err == nil {
+			err = s.Take(todo...)
+		}
+		if err != nil {
+			return []RestoreResult{{Timestamp: time.Now(), Errors: []string{fmt.Sprintf("Failed to take pre-restore snapshot: %v", err)}}}
+		}
+		f.snapshot = s
+	}
+
+	f.progress.SetTotals(len(todo), 0, 0)
+	var results []RestoreResult
+	for _, section := range todo {
+		f.progress.Start(RestoreTypeName(section))
+		results = append(results, f.restoreSection(section, dryRun))
+		f.progress.StepDone()
+	}
+	return results
+}
+
+func (f *FullRestore) restoreSection(section RestoreType, dryRun bool) RestoreResult {
+	switch section {
+	case RestoreTypeZypperRepos:
+		// Archives with the repo files also carry the rpm keys
+		if utils.FileExists(filepath.Join(f.dir, "zypper_repos.json")) {
+			z := NewZypperReposRestore()
+			z.SetJournal(f.journal)
+			z.SetHelper(f.helper)
+			z.SetTarget(f.target)
+			result, _ := z.Restore(f.dir, dryRun)
+			return result
+		}
+		return f.restorePackages(section, dryRun)
+	case RestoreTypeAPTSources:
+		a := NewAPTSourcesRestore()
+		a.SetJournal(f.journal)
+		a.SetHelper(f.helper)
+		a.SetTarget(f.target)
+		result, _ := a.Restore(f.dir, dryRun)
+		return result
+	case RestoreTypeFlatpak, RestoreTypePackages, RestoreTypeGnomeExtensions, RestoreTypeGnomeSettings:
+		return f.restorePackages(section, dryRun)
+	case RestoreTypeDotfiles:
+		// Archives from this version carry dotfiles.json
+		if utils.FileExists(filepath.Join(f.dir, "dotfiles.json")) {
+			d := NewDotfilesRestore()
+			d.SetMerge(f.merge)
+			d.SetChoices(f.choices)
+			d.SetJournal(f.journal)
+			d.SetTarget(f.target)
+			result, _ := d.Restore(f.dir, dryRun)
+			return result
+		}
+	}
+	return f.restoreFiles(section, dryRun)
+}
+
+// packageSections maps the fields of packages.json to the section that
+// restores them, for reporting the entries validation left out
+var packageSections = map[string]RestoreType{
+	"flatpaks":         RestoreTypeFlatpak,
+	"rpm_packages":     RestoreTypePackages,
+	"apt_packages":     RestoreTypePackages,
+	"pacman_packages":  RestoreTypePackages,
+	"aur_packages":     RestoreTypePackages,
+	"zypper_packages":  RestoreTypePackages,
+	"zypper_repos":     RestoreTypeZypperRepos,
+	"gnome_extensions": RestoreTypeGnomeExtensions,
+	"dconf_settings":   RestoreTypeGnomeSettings,
+}
+
+// restorePackages restores a package section through LightRestore
+func (f *FullRestore) restorePackages(section RestoreType, dryRun bool) RestoreResult {
+	result := RestoreResult{Type: section, Timestamp: time.Now(), DryRun: dryRun}
+	r := NewLightRestore(f.packages, dryRun)
+	r.progress = f.progress
+	r.journal = f.journal
+	r.helper = f.helper
+	r.target = f.target
+	r.cache = filepath.Join(f.dir, backup.PackageCacheDir)
+	r.pin = f.pin
+
+	var success, failed int
+	var err error
+	switch section {
+	case RestoreTypeFlatpak:
+		success, failed, err = r.RestoreFlatpaks()
+	case RestoreTypePackages:
+		for _, install := range []func() (int, int, error){r.RestoreRPM, r.RestoreAPT, r.RestorePacman, r.RestoreAUR, r.RestoreZypper} {
+			s, fl, e := install()
+			success, failed = success+s, failed+fl
+			if e != nil {
+				err = e
+				break
+			}
+		}
+	case RestoreTypeZypperRepos:
+		success, failed, err = r.RestoreZypperRepos()
+	case RestoreTypeGnomeExtensions:
+		success, failed, err = r.RestoreExtensions()
+	case RestoreTypeGnomeSettings:
+		if err = r.RestoreDconf(); err == nil {
+			success = 1
+		} else {
+			failed = 1
+		}
+	}
+
+	for _, r := range r.Results() {
+		result.Items = append(result.Items, r.Items...)
+	}
+	result.ItemsSuccess = success
+	result.ItemsFailed = failed
+	for _, rejected := range f.packages.Rejected {
+		if packageSections[rejected.Field] == section {
+			result.AddItems(RejectedItems([]backup.Rejection{rejected})...)
+		}
+	}
+	result.ItemsTotal = result.ItemsSuccess + result.ItemsFailed
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	result.Success = result.ItemsFailed == 0 && err == nil
+	return result
+}
+
+// restoreFiles copies a file section back under the home directory
+func (f *FullRestore) restoreFiles(section RestoreType, dryRun bool) RestoreResult {
+	result := RestoreResult{Type: section, Timestamp: time.Now(), DryRun: dryRun}
+
+	copies, err := f.plan(section)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	result.ItemsTotal = len(copies)
+
+	if dryRun {
+		result.Success = true
+		result.ItemsSuccess = result.ItemsTotal
+		return result
+	}
+
+	home, err := f.target.HomeDir()
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	for _, c := range copies {
+		f.progress.FilesDone(1)
+		dst := filepath.Join(home, c.rel)
+
+		// Only dotfiles are reviewed one by one
+		choice := choiceFor(nil, c.rel, f.merge)
+		if section == RestoreTypeDotfiles {
+			choice = choiceFor(f.choices, c.rel, f.merge)
+		}
+		// Only archives with dotfiles.json record the skeleton to merge with
+		if choice.Action == MergeSkeleton {
+			choice.Action = KeepCurrent
+		}
+		_, statErr := os.Lstat(dst)
+		exists := statErr == nil
+		if choice.Action == KeepCurrent && exists {
+			result.ItemsSuccess++ // Count as success (preserved)
+			continue
+		}
+
+		if err := f.journal.RecordFile(section, dst); err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+
+		copyFile := utils.CopyFile
+		if info, err := os.Lstat(c.src); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			copyFile = utils.CopySymlink
+		}
+		if choice.Action == UseMerged && exists {
+			copyFile = func(_, dst string) error { return writeMerged(dst, choice.Content) }
+		}
+		err := copyFile(c.src, dst)
+		if err == nil {
+			err = f.target.Own(dst)
+		}
+		if err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to restore %s: %v", c.rel, err))
+			continue
+		}
+		result.ItemsSuccess++
+	}
+
+	switch section {
+	case RestoreTypeSSH:
+		// ssh refuses a group or world writable config
+		os.Chmod(filepath.Join(home, ".ssh"), 0700)
+		os.Chmod(filepath.Join(home, ".ssh", "config"), 0600)
+	case RestoreTypeFonts:
+		if f.target.LiveHome() && utils.CommandExists("fc-cache") {
+			utils.RunCommand("fc-cache", "-f")
+		}
+	}
+
+	result.Success = result.ItemsFailed == 0
+	return result
+}
@@ -2,22 +2,38 @@ package restore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // GnomeExtensionsRestore handles GNOME extensions restoration
-type GnomeExtensionsRestore struct{}
+type GnomeExtensionsRestore struct {
+	journal *Journal
+	target  Target
+}
 
 // NewGnomeExtensionsRestore creates a new GnomeExtensionsRestore instance
 func NewGnomeExtensionsRestore() *GnomeExtensionsRestore {
 	return &GnomeExtensionsRestore{}
 }
 
+// SetJournal sets where installed extensions are recorded for undo
+func (g *GnomeExtensionsRestore) SetJournal(j *Journal) {
+	g.journal = j
+}
+
+// SetTarget sets the home directory whose extension settings are restored.
+// Extensions themselves are only installed into the current user's home.
+func (g *GnomeExtensionsRestore) SetTarget(t Target) {
+	g.target = t
+}
+
 // Name returns the display name
 func (g *GnomeExtensionsRestore) Name() string {
 	return "GNOME Extensions"
@@ -28,6 +44,11 @@ func (g *GnomeExtensionsRestore) Type() RestoreType {
 	return RestoreTypeGnomeExtensions
 }
 
+// DependsOn returns nothing, extensions come before their settings
+func (g *GnomeExtensionsRestore) DependsOn() []RestoreType {
+	return nil
+}
+
 // Available checks if GNOME extensions can be installed
 func (g *GnomeExtensionsRestore) Available() bool {
 	return utils.CommandExists("gnome-extensions") || utils.CommandExists("gnome-shell")
@@ -50,9 +71,29 @@ type ExtensionInfo struct {
 	HasSettings bool   `json:"has_settings"`
 }
 
+// Validate drops the extensions that fail validation and returns them
+func (d *ExtensionsData) Validate() []backup.Rejection {
+	var valid []ExtensionInfo
+	var rejected []backup.Rejection
+	for _, ext := range d.Extensions {
+		err := backup.CheckExtensionUUID(ext.UUID)
+		if err == nil {
+			err = backup.CheckText(ext.Name)
+		}
+		if err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "gnome_extensions.json", Value: ext.UUID, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, ext)
+	}
+	d.Extensions = valid
+	d.EnabledExtensions, _ = backup.FilterValid("gnome_extensions.json", d.EnabledExtensions, backup.CheckExtensionUUID)
+	return rejected
+}
+
 // Preview returns what would be restored
 func (g *GnomeExtensionsRestore) Preview(backupDir string) ([]string, error) {
-	data, err := g.loadBackupData(backupDir)
+	data, _, err := g.loadBackupData(backupDir)
 	if err != nil {
 		return nil, err
 	}
@@ -70,19 +111,19 @@ func (g *GnomeExtensionsRestore) Preview(backupDir string) ([]string, error) {
 }
 
 // loadBackupData loads the extensions backup data
-func (g *GnomeExtensionsRestore) loadBackupData(backupDir string) (*ExtensionsData, error) {
+func (g *GnomeExtensionsRestore) loadBackupData(backupDir string) (*ExtensionsData, []backup.Rejection, error) {
 	dataPath := filepath.Join(backupDir, "gnome_extensions.json")
 	content, err := os.ReadFile(dataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read extensions backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to read extensions backup: %w", err)
 	}
 
 	var data ExtensionsData
 	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse extensions backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse extensions backup: %w", err)
 	}
 
-	return &data, nil
+	return &data, data.Validate(), nil
 }
 
 // Restore performs the GNOME extensions restoration
@@ -93,28 +134,49 @@ func (g *GnomeExtensionsRestore) Restore(backupDir string, dryRun bool) (Restore
 		DryRun:    dryRun,
 	}
 
-	data, err := g.loadBackupData(backupDir)
+	data, rejected, err := g.loadBackupData(backupDir)
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
 
-	result.ItemsTotal = len(data.Extensions)
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Extensions) + len(rejected)
 
 	if dryRun {
-		result.Success = true
-		result.ItemsSuccess = result.ItemsTotal
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
 		return result, nil
 	}
 
 	// Install extensions
+	var uuids []string
+	for _, ext := range data.Extensions {
+		uuids = append(uuids, ext.UUID)
+	}
+
+	// gnome-extensions and the shell's D-Bus API act on the running
+	// session, so another home only gets the settings
+	if !g.target.LiveHome() {
+		result.AddItems(FailedItems(uuids, reasonOtherHome)...)
+		g.restoreExtensionSettings(backupDir, data.Extensions)
+		return result, nil
+	}
+
+	installed := g.journal.TrackInstall(RestoreTypeGnomeExtensions, "gnome-extensions", uuids)
+	defer installed()
+
 	for _, ext := range data.Extensions {
+		start := time.Now()
+		cmdResult := utils.CommandResult{Error: errors.New("gnome-extensions is not installed")}
 		// Try to install via gnome-extensions command
 		if utils.CommandExists("gnome-extensions") {
-			cmdResult := utils.RunCommand("gnome-extensions", "install", ext.UUID)
-			if cmdResult.Error != nil {
-				// Try alternative: use busctl to install from extensions.gnome.org
-				g.tryInstallViaAPI(ext.UUID)
+			cmdResult = utils.RunCommand("gnome-extensions", "install", ext.UUID)
+		}
+		if cmdResult.Error != nil {
+			// Try alternative: use busctl to install from extensions.gnome.org
+			if api := g.tryInstallViaAPI(ext.UUID); api.Error == nil {
+				cmdResult = api
 			}
 		}
 
@@ -123,20 +185,20 @@ func (g *GnomeExtensionsRestore) Restore(backupDir string, dryRun bool) (Restore
 			utils.RunCommand("gnome-extensions", "enable", ext.UUID)
 		}
 
-		result.ItemsSuccess++
+		result.AddItems(CommandItem(ext.UUID, cmdResult, time.Since(start)))
 	}
 
 	// Restore extension settings
 	g.restoreExtensionSettings(backupDir, data.Extensions)
 
-	result.Success = true
+	result.Success = result.ItemsFailed == 0
 	return result, nil
 }
 
 // tryInstallViaAPI attempts to install extension via GNOME Shell API
-func (g *GnomeExtensionsRestore) tryInstallViaAPI(uuid string) {
+func (g *GnomeExtensionsRestore) tryInstallViaAPI(uuid string) utils.CommandResult {
 	// This requires GNOME Shell to be running
-	utils.RunCommand("busctl", "--user", "call",
+	return utils.RunCommand("busctl", "--user", "call",
 		"org.gnome.Shell.Extensions",
 		"/org/gnome/Shell/Extensions",
 		"org.gnome.Shell.Extensions",
@@ -165,16 +227,10 @@ func (g *GnomeExtensionsRestore) restoreExtensionSettings(backupDir string, exte
 		}
 
 		content, err := os.ReadFile(settingsFile)
-		if err != nil {
+		if err != nil || backup.CheckDconf(string(content)) != nil {
 			continue
 		}
 
-		// Load settings via dconf
-		cmdResult := utils.RunCommand("dconf", "load",
-			"/org/gnome/shell/extensions/"+ext.UUID+"/")
-		if cmdResult.Error == nil {
-			// Pipe content to dconf - this is simplified, actual impl needs stdin
-			_ = content
-		}
+		loadDconf(g.target, g.journal, RestoreTypeGnomeExtensions, "/org/gnome/shell/extensions/"+ext.UUID+"/", string(content))
 	}
 }
@@ -6,12 +6,15 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // GnomeSettingsRestore handles GNOME settings restoration
 type GnomeSettingsRestore struct {
 	selectivePaths []string
+	journal        *Journal
+	target         Target
 }
 
 // NewGnomeSettingsRestore creates a new GnomeSettingsRestore instance
@@ -26,6 +29,16 @@ func NewGnomeSettingsRestoreSelective(paths []string) *GnomeSettingsRestore {
 	}
 }
 
+// SetJournal sets where the settings replaced are recorded for undo
+func (g *GnomeSettingsRestore) SetJournal(j *Journal) {
+	g.journal = j
+}
+
+// SetTarget sets the home directory whose dconf database is restored
+func (g *GnomeSettingsRestore) SetTarget(t Target) {
+	g.target = t
+}
+
 // Name returns the display name
 func (g *GnomeSettingsRestore) Name() string {
 	return "GNOME Settings"
@@ -36,6 +49,12 @@ func (g *GnomeSettingsRestore) Type() RestoreType {
 	return RestoreTypeGnomeSettings
 }
 
+// DependsOn installs the extensions first so their settings load into a
+// schema that exists
+func (g *GnomeSettingsRestore) DependsOn() []RestoreType {
+	return []RestoreType{RestoreTypeGnomeExtensions}
+}
+
 // Available checks if dconf is available
 func (g *GnomeSettingsRestore) Available() bool {
 	return utils.CommandExists("dconf")
@@ -106,15 +125,13 @@ func (g *GnomeSettingsRestore) restoreFull(backupDir string, result RestoreResul
 		return result, err
 	}
 
-	// Write to temp file and pipe to dconf
-	tmpFile := filepath.Join(os.TempDir(), "rego_dconf_restore.dconf")
-	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+	if err := backup.CheckDconf(string(content)); err != nil {
+		err = fmt.Errorf("rejected gnome_settings.dconf: %w", err)
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
-	defer os.Remove(tmpFile)
 
-	cmdResult := utils.RunCommand("sh", "-c", fmt.Sprintf("cat %s | dconf load /", tmpFile))
+	cmdResult := loadDconf(g.target, g.journal, RestoreTypeGnomeSettings, "/", string(content))
 	if cmdResult.Error != nil {
 		result.Errors = append(result.Errors, cmdResult.Stderr)
 		return result, cmdResult.Error
@@ -164,15 +181,13 @@ func (g *GnomeSettingsRestore) restoreFromSelectiveDir(selectiveDir string, resu
 			continue
 		}
 
-		// Write to temp file and pipe to dconf
-		tmpFile := filepath.Join(os.TempDir(), "rego_dconf_"+name+".dconf")
-		if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		if err := backup.CheckDconf(string(content)); err != nil {
 			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Rejected %s: %v", name, err))
 			continue
 		}
 
-		cmdResult := utils.RunCommand("sh", "-c", fmt.Sprintf("cat %s | dconf load %s", tmpFile, path))
-		os.Remove(tmpFile)
+		cmdResult := loadDconf(g.target, g.journal, RestoreTypeGnomeSettings, path, string(content))
 
 		if cmdResult.Error != nil {
 			result.ItemsFailed++
@@ -205,13 +220,15 @@ func (g *GnomeSettingsRestore) restoreSelective(backupDir string, result Restore
 			continue
 		}
 
-		tmpFile := filepath.Join(os.TempDir(), "rego_dconf_selective.dconf")
-		os.WriteFile(tmpFile, content, 0644)
+		if err := backup.CheckDconf(string(content)); err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Rejected %s: %v", pathName, err))
+			continue
+		}
 
 		// The path needs to be constructed based on the name
 		// This is simplified - actual implementation needs proper path mapping
-		cmdResult := utils.RunCommand("sh", "-c", fmt.Sprintf("cat %s | dconf load /", tmpFile))
-		os.Remove(tmpFile)
+		cmdResult := loadDconf(g.target, g.journal, RestoreTypeGnomeSettings, "/", string(content))
 
 		if cmdResult.Error != nil {
 			result.ItemsFailed++
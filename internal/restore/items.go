@@ -0,0 +1,319 @@
+package restore
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// Reasons recorded for items that were not installed
+const (
+	reasonNotInRepos   = "not found in any enabled repository"
+	reasonNotOnRemote  = "not found on the %s remote"
+	reasonRestoredOnce = "restored before the restore was resumed"
+	reasonOtherHome    = "extensions can only be installed for the current user's session"
+)
+
+// ResolveAvailable splits names into those manager can install and those
+// no enabled repository has. Unavailable names are left out of the install
+// command so one of them can't fail the rest. The repositories are those of
+// the root filesystem mounted at root, or of this system if root is "". If
+// they can't be asked, every name is returned as available along with the
+// error.
+func ResolveAvailable(manager, root string, names []string) (available, unavailable []string, err error) {
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	var found []string
+	switch manager {
+	case "dnf":
+		args := append([]string{"repoquery", "--quiet", "--queryformat", "%{name}\n"}, names...)
+		if root != "" {
+			args = append([]string{"--installroot=" + root}, args...)
+		}
+		found, err = resolvedLines(utils.RunCommandWithTimeout("dnf", 5*time.Minute, args...))
+	case "apt-get":
+		found, err = resolveAPT(root, names)
+	case "zypper":
+		found, err = resolveZypper(root, names)
+	default:
+		// pacman and the AUR helpers print a "Name : <name>" line for each
+		// package they know and exit 1 if any of them is unknown
+		args := append([]string{"-Si"}, names...)
+		if root != "" {
+			args = append([]string{"--dbpath", filepath.Join(root, "var", "lib", "pacman"), "--config", filepath.Join(root, "etc", "pacman.conf")}, args...)
+		}
+		result := utils.RunCommandWithTimeout(manager, 5*time.Minute, args...)
+		if result.Error != nil && result.Stdout == "" && !strings.Contains(result.Stderr, "not found") {
+			err = fmt.Errorf("%s -Si failed: %s", manager, result.Stderr)
+			break
+		}
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if ok && strings.TrimSpace(key) == "Name" {
+				found = append(found, strings.TrimSpace(value))
+			}
+		}
+	}
+	if err != nil {
+		return names, nil, err
+	}
+	available, unavailable = splitFound(names, found)
+	return available, unavailable, nil
+}
+
+// ResolveFlatpaks is ResolveAvailable for Flatpak apps on remote, as it is
+// configured in the system installation of root
+func ResolveFlatpaks(root, remote string, apps []string) (available, unavailable []string, err error) {
+	if len(apps) == 0 {
+		return nil, nil, nil
+	}
+	args := []string{"remote-ls", "--app", "--columns=application", remote}
+	var result utils.CommandResult
+	if root == "" {
+		result = utils.RunCommandWithTimeout("flatpak", 2*time.Minute, args...)
+	} else {
+		env, cleanup, envErr := backup.FlatpakInstallationEnv(root)
+		if envErr != nil {
+			return apps, nil, envErr
+		}
+		defer cleanup()
+		args = append([]string{args[0], "--installation=" + backup.FlatpakInstallation}, args[1:]...)
+		result = utils.RunCommandWithEnv(env, 2*time.Minute, "flatpak", args...)
+	}
+	found, err := resolvedLines(result)
+	if err != nil {
+		return apps, nil, err
+	}
+	available, unavailable = splitFound(apps, found)
+	return available, unavailable, nil
+}
+
+// ResolveFlatpakApps splits apps into those their remote has and those
+// it doesn't, asking each remote once. Apps whose remote can't be asked
+// are taken to be available.
+func ResolveFlatpakApps(root string, apps []backup.FlatpakDeployment) (available, unavailable []backup.FlatpakDeployment) {
+	byRemote := make(map[string][]string)
+	for _, app := range apps {
+		byRemote[app.Remote()] = append(byRemote[app.Remote()], app.ID)
+	}
+	missing := make(map[string][]string)
+	for remote, ids := range byRemote {
+		_, missing[remote], _ = ResolveFlatpaks(root, remote, ids)
+	}
+	for _, app := range apps {
+		if slices.Contains(missing[app.Remote()], app.ID) {
+			unavailable = append(unavailable, app)
+		} else {
+			available = append(available, app)
+		}
+	}
+	return available, unavailable
+}
+
+// resolvedLines returns the non-empty lines of a successful command
+func resolvedLines(result utils.CommandResult) ([]string, error) {
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %s", result.Error, result.Stderr)
+	}
+	var lines []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// resolveAPT returns the names apt has an install candidate for. Unknown
+// names are left out of apt-cache policy's output; known names without a
+// candidate say "Candidate: (none)".
+func resolveAPT(root string, names []string) ([]string, error) {
+	args := append([]string{"policy"}, names...)
+	if root != "" {
+		// Every path apt uses is relative to Dir
+		args = append([]string{"-o", "Dir=" + root}, args...)
+	}
+	result := utils.RunCommandWithTimeout("apt-cache", 5*time.Minute, args...)
+	if result.Error != nil {
+		return nil, fmt.Errorf("apt-cache policy failed: %s", result.Stderr)
+	}
+	var found []string
+	current := ""
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			current = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Candidate:"); ok && current != "" {
+			if strings.TrimSpace(value) != "(none)" {
+				found = append(found, current)
+			}
+			current = ""
+		}
+	}
+	return found, nil
+}
+
+// zypperSolvable matches a package in zypper's XML search output
+var zypperSolvable = regexp.MustCompile(`<solvable [^>]*name="([^"]+)"`)
+
+// resolveZypper returns the names a zypper repository has
+func resolveZypper(root string, names []string) ([]string, error) {
+	args := append([]string{"--non-interactive", "--quiet", "--xmlout", "search", "--match-exact", "--type", "package"}, names...)
+	if root != "" {
+		args = append([]string{"--root", root}, args...)
+	}
+	result := utils.RunCommandWithTimeout("zypper", 5*time.Minute, args...)
+	// 104 means none of the names were found
+	if result.Error != nil && result.ExitCode != 104 {
+		return nil, fmt.Errorf("zypper search failed: %s", result.Stderr)
+	}
+	var found []string
+	for _, m := range zypperSolvable.FindAllStringSubmatch(result.Stdout, -1) {
+		found = append(found, m[1])
+	}
+	return found, nil
+}
+
+// splitFound splits names into those in found and the rest
+func splitFound(names, found []string) (in, out []string) {
+	for _, name := range names {
+		if slices.Contains(found, name) {
+			in = append(in, name)
+		} else {
+			out = append(out, name)
+		}
+	}
+	return in, out
+}
+
+// InstallItems installs names through manager with the one command install
+// runs, leaving out the names no repository has. Afterwards manager is asked
+// which names are installed, so a command that fails on one name still
+// reports the others. root is the root filesystem install installs into,
+// "" for this system.
+func InstallItems(manager, root string, names []string, install func(names []string) utils.CommandResult) []ItemResult {
+	available, unavailable, _ := ResolveAvailable(manager, root, names)
+	items := UnavailableItems(unavailable, reasonNotInRepos)
+	if len(available) == 0 {
+		return items
+	}
+	start := time.Now()
+	result := install(available)
+	return append(items, InstalledItems(manager, root, available, result.Stderr, time.Since(start))...)
+}
+
+// UnavailableItems returns names as unavailable for reason
+func UnavailableItems(names []string, reason string) []ItemResult {
+	var items []ItemResult
+	for _, name := range names {
+		items = append(items, ItemResult{Name: name, Status: ItemUnavailable, Reason: reason})
+	}
+	return items
+}
+
+// FailedItems returns names as failed for reason, when nothing was run for them
+func FailedItems(names []string, reason string) []ItemResult {
+	var items []ItemResult
+	for _, name := range names {
+		items = append(items, ItemResult{Name: name, Status: ItemFailed, Reason: reason})
+	}
+	return items
+}
+
+// RejectedItems returns the entries validation left out of a backup
+func RejectedItems(rejected []backup.Rejection) []ItemResult {
+	var items []ItemResult
+	for _, r := range rejected {
+		items = append(items, ItemResult{Name: r.Value, Status: ItemRejected, Reason: r.Reason})
+	}
+	return items
+}
+
+// InstalledItems asks manager which of names are installed in root now,
+// after a command that took d and wrote stderr tried to install them
+func InstalledItems(manager, root string, names []string, stderr string, d time.Duration) []ItemResult {
+	missing := backup.FilterMissing(names, installedLister(manager, root)())
+	var items []ItemResult
+	for _, name := range names {
+		item := ItemResult{Name: name, Status: ItemInstalled, Duration: d}
+		if slices.Contains(missing, name) {
+			item.Status = ItemFailed
+			item.Reason = FailureReason(stderr, name, manager)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// CommandItem returns the outcome of a command that restored one item
+func CommandItem(name string, result utils.CommandResult, d time.Duration) ItemResult {
+	item := ItemResult{Name: name, Status: ItemInstalled, Duration: d}
+	if result.Error != nil {
+		item.Status = ItemFailed
+		item.Reason = FailureReason(result.Stderr, name, "")
+		if item.Reason == "" {
+			item.Reason = result.Error.Error()
+		}
+	}
+	return item
+}
+
+// FailureReason picks the error line that names name from the output of
+// manager, or its last line if none does
+func FailureReason(stderr, name, manager string) string {
+	var last string
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if mentions(line, name) {
+			return line
+		}
+		if last == "" {
+			last = line
+		}
+	}
+	if last == "" && manager != "" {
+		return manager + " did not install it"
+	}
+	return last
+}
+
+// mentions reports whether line has name as a whole word, so "vim" is not
+// found in "vim-enhanced"
+func mentions(line, name string) bool {
+	isNameByte := func(b byte) bool {
+		return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("._+-@", b) >= 0
+	}
+	for i := 0; ; {
+		j := strings.Index(line[i:], name)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(name)
+		if (start == 0 || !isNameByte(line[start-1])) && (end == len(line) || !isNameByte(line[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+// skippedItems returns names as restored before the restore was resumed
+func skippedItems(names []string) []ItemResult {
+	var items []ItemResult
+	for _, name := range names {
+		items = append(items, ItemResult{Name: name, Status: ItemSkipped, Reason: reasonRestoredOnce})
+	}
+	return items
+}
@@ -0,0 +1,695 @@
+package restore
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// JournalKind says what a journal entry changed
+type JournalKind string
+
+const (
+	JournalFile      JournalKind = "file"      // A file, directory or symlink was written
+	JournalDconf     JournalKind = "dconf"     // A dconf subtree was loaded
+	JournalPackage   JournalKind = "package"   // A package or Flatpak was installed
+	JournalExtension JournalKind = "extension" // A GNOME extension was installed
+)
+
+// JournalEntry is one change made by a restore, with what is needed to undo it
+type JournalEntry struct {
+	Component RestoreType `json:"component"`
+	Kind      JournalKind `json:"kind"`
+	Path      string      `json:"path,omitempty"`    // File path, or dconf directory such as /org/gnome/
+	Existed   bool        `json:"existed,omitempty"` // Something was at Path before the restore
+	Saved     string      `json:"saved,omitempty"`   // Copy of the prior contents or dconf dump, in the journal directory
+	System    bool        `json:"system,omitempty"`  // Path is outside the home directory and needs root
+	Manager   string      `json:"manager,omitempty"` // Package manager that removes Name
+	Name      string      `json:"name,omitempty"`    // Package, Flatpak or extension installed
+	// Flatpak installation Name went into: system or user, whose directory
+	// is in the home of the journal's Target, or another installation's name
+	Installation string `json:"installation,omitempty"`
+	Undone       bool   `json:"undone,omitempty"`
+}
+
+// Journal records every change a restore makes so it can be undone later.
+// Files are copied before they are overwritten and dconf subtrees dumped
+// before they are loaded; packages are recorded only if the restore is what
+// installed them. A nil *Journal records nothing, which is what dry runs use.
+type Journal struct {
+	ID        string         `json:"id"`
+	Source    string         `json:"source"` // Backup the restore came from
+	Target    Target         `json:"target,omitzero"`
+	CreatedAt time.Time      `json:"created_at"`
+	Entries   []JournalEntry `json:"entries"`
+
+	mu     sync.Mutex
+	dir    string
+	helper *privileged.Helper
+}
+
+// SetHelper sets what undoes the changes that need root: system files and
+// packages
+func (j *Journal) SetHelper(h *privileged.Helper) {
+	j.helper = h
+}
+
+// SetTarget sets the root filesystem and home directory the restore
+// changes, which is where packages are listed and removed on undo
+func (j *Journal) SetTarget(t Target) {
+	if j == nil {
+		return
+	}
+	j.Target = t
+}
+
+// NeedsPrivileges reports whether undoing components, or every pending one
+// if none are given, needs root
+func (j *Journal) NeedsPrivileges(components []RestoreType) bool {
+	if len(components) == 0 {
+		components = j.Pending()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.Entries {
+		if e.Undone || !slices.Contains(components, e.Component) {
+			continue
+		}
+		if e.System || e.Kind == JournalPackage && (e.Manager != "flatpak" || !j.Target.LiveSystem()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoJournal is returned when there is no restore left to undo
+var ErrNoJournal = errors.New("no restore to undo")
+
+// journalRoot returns where journals are kept (~/.config/rego/journal)
+func journalRoot() (string, error) {
+	configDir, err := utils.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "journal"), nil
+}
+
+// NewJournal starts a journal for a restore from source. Nothing is written
+// to disk until the first change is recorded.
+func NewJournal(source string) (*Journal, error) {
+	root, err := journalRoot()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	id := now.Format("20060102-150405.000")
+	return &Journal{ID: id, Source: source, CreatedAt: now, dir: filepath.Join(root, id)}, nil
+}
+
+// LoadJournal reads the journal kept in dir
+func LoadJournal(dir string) (*Journal, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	j := &Journal{dir: dir}
+	if err := json.Unmarshal(content, j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal: %w", err)
+	}
+	return j, nil
+}
+
+// ListJournals returns the journals on disk, newest first
+func ListJournals() ([]*Journal, error) {
+	root, err := journalRoot()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []*Journal
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		j, err := LoadJournal(filepath.Join(root, entry.Name()))
+		if err != nil {
+			utils.Warn("Skipping journal %s: %v", entry.Name(), err)
+			continue
+		}
+		journals = append(journals, j)
+	}
+	sort.Slice(journals, func(a, b int) bool { return journals[a].CreatedAt.After(journals[b].CreatedAt) })
+	return journals, nil
+}
+
+// LastJournal returns the newest journal with changes left to undo, or
+// ErrNoJournal
+func LastJournal() (*Journal, error) {
+	journals, err := ListJournals()
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range journals {
+		if len(j.Pending()) > 0 {
+			return j, nil
+		}
+	}
+	return nil, ErrNoJournal
+}
+
+// Pending returns the components with changes not yet undone, in the order
+// they were restored
+func (j *Journal) Pending() []RestoreType {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var components []RestoreType
+	for _, e := range j.Entries {
+		if !e.Undone && !slices.Contains(components, e.Component) {
+			components = append(components, e.Component)
+		}
+	}
+	return components
+}
+
+// Changes returns a line per change not yet undone in component
+func (j *Journal) Changes(component RestoreType) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var lines []string
+	for _, e := range j.Entries {
+		if e.Undone || e.Component != component {
+			continue
+		}
+		switch e.Kind {
+		case JournalFile:
+			if e.Existed {
+				lines = append(lines, "put back "+e.Path)
+			} else {
+				lines = append(lines, "remove "+e.Path)
+			}
+		case JournalDconf:
+			lines = append(lines, "reload dconf "+e.Path)
+		case JournalPackage:
+			lines = append(lines, fmt.Sprintf("uninstall %s (%s)", e.Name, e.Manager))
+		case JournalExtension:
+			lines = append(lines, "uninstall extension "+e.Name)
+		}
+	}
+	return lines
+}
+
+// RecordFile saves what is at path before a restore writes it. Only the
+// first write to a path is recorded, so undo goes back to the state from
+// before the restore. When path does not exist, the topmost directory the
+// restore will create for it is recorded instead, so undo removes that too.
+// It must succeed before path is touched.
+func (j *Journal) RecordFile(component RestoreType, path string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, e := range j.Entries {
+		if e.Kind == JournalFile && (e.Path == path || !e.Existed && isWithin(e.Path, path)) {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create undo journal: %w", err)
+	}
+	entry := JournalEntry{Component: component, Kind: JournalFile, Path: path, System: !inHome(j.Target, path)}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		entry.Path = topMissing(path)
+		// Undo can only remove system files where the helper may change
+		// them, which a fresh target root may not have the parents of yet
+		if entry.System && privileged.RemovePath(entry.Path).In(j.Target.Root).Validate() != nil {
+			entry.Path = path
+		}
+	} else if err == nil {
+		entry.Existed = true
+		entry.Saved = j.nextSaved()
+		saved := filepath.Join(j.dir, entry.Saved)
+		os.RemoveAll(saved) // Left over from a failed attempt
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			err = utils.CopySymlink(path, saved)
+		case info.IsDir():
+			err = utils.CopyTree(path, saved, utils.SymlinksPreserve)
+		default:
+			err = utils.CopyFile(path, saved)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save %s for undo: %w", path, err)
+		}
+	} else {
+		return fmt.Errorf("failed to save %s for undo: %w", path, err)
+	}
+
+	j.Entries = append(j.Entries, entry)
+	return j.save()
+}
+
+// RecordDconf dumps the dconf subtree at dir before a restore loads into it
+func (j *Journal) RecordDconf(component RestoreType, dir string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, e := range j.Entries {
+		if e.Kind == JournalDconf && e.Path == dir {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create undo journal: %w", err)
+	}
+	result := utils.RunCommand("dconf", "dump", dir)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save dconf %s for undo: %s", dir, result.Stderr)
+	}
+	entry := JournalEntry{Component: component, Kind: JournalDconf, Path: dir, Saved: j.nextSaved()}
+	if err := utils.WriteFile(filepath.Join(j.dir, entry.Saved), []byte(result.Stdout+"\n")); err != nil {
+		return fmt.Errorf("failed to save dconf %s for undo: %w", dir, err)
+	}
+
+	j.Entries = append(j.Entries, entry)
+	return j.save()
+}
+
+// TrackInstall is called before installing names through manager. Calling
+// the returned func after the install records the names that were missing
+// before and are installed now, so undo never removes anything the system
+// already had.
+func (j *Journal) TrackInstall(component RestoreType, manager string, names []string) func() {
+	if j == nil || len(names) == 0 {
+		return func() {}
+	}
+	return j.TrackMissing(component, manager, backup.FilterMissing(names, installedLister(manager, j.Target.Root)()))
+}
+
+// TrackMissing is TrackInstall for callers that already know which names
+// are missing
+func (j *Journal) TrackMissing(component RestoreType, manager string, missing []string) func() {
+	if j == nil || len(missing) == 0 {
+		return func() {}
+	}
+	list := installedLister(manager, j.Target.Root)
+	return func() {
+		kind := JournalPackage
+		if manager == "gnome-extensions" {
+			kind = JournalExtension
+		}
+		still := backup.FilterMissing(missing, list())
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		for _, name := range missing {
+			if !slices.Contains(still, name) {
+				j.Entries = append(j.Entries, JournalEntry{Component: component, Kind: kind, Manager: removerFor(manager), Name: name})
+			}
+		}
+		if err := j.save(); err != nil {
+			utils.Warn("Failed to save undo journal: %v", err)
+		}
+	}
+}
+
+// TrackFlatpaks is TrackInstall for Flatpak apps. Each app is looked for
+// in the installation it goes into, and recorded with it, so that undo
+// removes it from there.
+func (j *Journal) TrackFlatpaks(apps []backup.FlatpakDeployment) func() {
+	if j == nil || len(apps) == 0 {
+		return func() {}
+	}
+	installer := FlatpakInstaller{Target: j.Target, Helper: j.helper}
+	missing := make(map[string][]string)
+	for _, app := range apps {
+		installation := cmp.Or(app.Installation, backup.FlatpakSystem)
+		missing[installation] = append(missing[installation], app.ID)
+	}
+	for installation, ids := range missing {
+		missing[installation] = backup.FilterMissing(ids, installer.Installed(installation))
+	}
+	return func() {
+		var entries []JournalEntry
+		for _, installation := range slices.Sorted(maps.Keys(missing)) {
+			now := installer.Installed(installation)
+			for _, id := range missing[installation] {
+				if slices.Contains(now, id) {
+					entries = append(entries, JournalEntry{Component: RestoreTypeFlatpak, Kind: JournalPackage, Manager: "flatpak", Name: id, Installation: installation})
+				}
+			}
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.Entries = append(j.Entries, entries...)
+		if err := j.save(); err != nil {
+			utils.Warn("Failed to save undo journal: %v", err)
+		}
+	}
+}
+
+// installedLister returns what lists the packages installed by manager, in
+// the root filesystem mounted at root unless it is ""
+func installedLister(manager, root string) func() []string {
+	if root != "" {
+		switch manager {
+		case "dnf", "zypper":
+			return func() []string { return backup.GetInstalledRPMIn(root) }
+		case "apt-get":
+			return func() []string { return backup.GetInstalledAPTIn(root) }
+		case "gnome-extensions":
+			// Extensions are only installed into the current session
+		default:
+			return func() []string { return backup.GetInstalledPacmanIn(root) }
+		}
+	}
+	switch manager {
+	case "gnome-extensions":
+		return backup.GetInstalledGnomeExtensions
+	case "dnf", "zypper":
+		return backup.GetInstalledRPM
+	case "apt-get":
+		return backup.GetInstalledAPT
+	}
+	// pacman and the AUR helpers share the local pacman database
+	return backup.GetInstalledPacman
+}
+
+// removerFor returns the manager that uninstalls what manager installed.
+// AUR helpers install into the pacman database, so pacman removes them.
+func removerFor(manager string) string {
+	switch manager {
+	case "flatpak", "gnome-extensions", "dnf", "zypper", "apt-get":
+		return manager
+	}
+	return "pacman"
+}
+
+// nextSaved returns a new name for a saved copy. Called with mu held.
+func (j *Journal) nextSaved() string {
+	return filepath.Join("saved", strconv.Itoa(len(j.Entries)))
+}
+
+// save writes the journal to disk. Called with mu held. The directory is
+// private because saved copies may include things like SSH config.
+func (j *Journal) save() error {
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.dir, "journal.json.tmp")
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(j.dir, "journal.json"))
+}
+
+// Undo reverts the given components, or every pending one if none are given,
+// newest change first. Each component gets a result; changes that could not
+// be undone stay pending so undo can be retried.
+func (j *Journal) Undo(components []RestoreType) []RestoreResult {
+	if len(components) == 0 {
+		components = j.Pending()
+	}
+
+	var results []RestoreResult
+	for i := len(components) - 1; i >= 0; i-- {
+		results = append(results, j.undoComponent(components[i]))
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.save(); err != nil && len(results) > 0 {
+		results[len(results)-1].Errors = append(results[len(results)-1].Errors, fmt.Sprintf("Failed to update journal: %v", err))
+	}
+	return results
+}
+
+func (j *Journal) undoComponent(component RestoreType) RestoreResult {
+	result := RestoreResult{Type: component, Timestamp: time.Now()}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Packages go in one command per manager, and Flatpaks in one per
+	// installation
+	type remover struct{ manager, installation string }
+	removals := make(map[remover][]int)
+	var removers []remover
+
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		e := &j.Entries[i]
+		if e.Undone || e.Component != component {
+			continue
+		}
+		result.ItemsTotal++
+
+		var err error
+		switch e.Kind {
+		case JournalFile:
+			err = j.undoFile(e)
+		case JournalDconf:
+			err = j.undoDconf(e)
+		case JournalPackage, JournalExtension:
+			r := remover{e.Manager, e.Installation}
+			if _, ok := removals[r]; !ok {
+				removers = append(removers, r)
+			}
+			removals[r] = append(removals[r], i)
+			continue
+		}
+		if err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		e.Undone = true
+		result.ItemsSuccess++
+	}
+
+	for _, r := range removers {
+		var names []string
+		for _, i := range removals[r] {
+			names = append(names, j.Entries[i].Name)
+		}
+		if err := j.uninstall(r.manager, r.installation, names); err != nil {
+			result.ItemsFailed += len(names)
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		for _, i := range removals[r] {
+			j.Entries[i].Undone = true
+		}
+		result.ItemsSuccess += len(names)
+	}
+
+	result.Success = result.ItemsFailed == 0
+	return result
+}
+
+// undoFile puts back what was at a path, or removes it if nothing was there
+func (j *Journal) undoFile(e *JournalEntry) error {
+	saved := filepath.Join(j.dir, e.Saved)
+
+	if e.System {
+		if result := j.helper.Run(privileged.RemovePath(e.Path).In(j.Target.Root)); result.Error != nil {
+			return fmt.Errorf("failed to remove %s: %s", e.Path, result.Stderr)
+		}
+		if !e.Existed {
+			return nil
+		}
+		if err := j.putBackSystem(saved, e.Path); err != nil {
+			return fmt.Errorf("failed to put back %s: %w", e.Path, err)
+		}
+		return nil
+	}
+
+	if err := os.RemoveAll(e.Path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", e.Path, err)
+	}
+	if !e.Existed {
+		return nil
+	}
+	info, err := os.Lstat(saved)
+	if err != nil {
+		return fmt.Errorf("saved copy of %s is missing: %w", e.Path, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		err = utils.CopySymlink(saved, e.Path)
+	} else {
+		err = utils.CopyTree(saved, e.Path, utils.SymlinksPreserve)
+	}
+	if err == nil {
+		err = j.Target.Own(e.Path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put back %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// putBackSystem copies the saved tree at saved back to path outside the
+// home directory, one file at a time through the privileged helper
+func (j *Journal) putBackSystem(saved, path string) error {
+	return filepath.Walk(saved, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(saved, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(path, rel)
+
+		var op privileged.Op
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			op = privileged.Symlink(dst, target)
+		case info.IsDir():
+			// Directories are created along with the files in them
+			return nil
+		default:
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			op = privileged.WriteFile(dst, content, info.Mode().Perm())
+		}
+		if result := j.helper.Run(op.In(j.Target.Root)); result.Error != nil {
+			return fmt.Errorf("%s", result.Stderr)
+		}
+		return nil
+	})
+}
+
+// undoDconf resets a dconf subtree and loads the dump taken before the restore
+func (j *Journal) undoDconf(e *JournalEntry) error {
+	if result := utils.RunCommand("dconf", "reset", "-f", e.Path); result.Error != nil {
+		return fmt.Errorf("failed to reset dconf %s: %s", e.Path, result.Stderr)
+	}
+	saved, err := os.ReadFile(filepath.Join(j.dir, e.Saved))
+	if err != nil {
+		return fmt.Errorf("failed to read saved dconf %s: %w", e.Path, err)
+	}
+	if result := utils.RunCommandWithInput(string(saved), 30*time.Second, "dconf", "load", e.Path); result.Error != nil {
+		return fmt.Errorf("failed to reload dconf %s: %s", e.Path, result.Stderr)
+	}
+	return nil
+}
+
+// uninstall removes what a restore installed through manager, and for
+// Flatpaks from installation
+func (j *Journal) uninstall(manager, installation string, names []string) error {
+	switch manager {
+	case "flatpak":
+		if installation != "" {
+			installer := FlatpakInstaller{Target: j.Target, Helper: j.helper}
+			if result := installer.Uninstall(installation, names); result.Error != nil {
+				return fmt.Errorf("flatpak failed: %s", result.Stderr)
+			}
+			return nil
+		}
+		// Journals from before installations were recorded
+		if !j.Target.LiveSystem() {
+			if result := j.helper.Run(privileged.Remove("flatpak", names).In(j.Target.Root)); result.Error != nil {
+				return fmt.Errorf("flatpak failed: %s", result.Stderr)
+			}
+			return nil
+		}
+		args := append([]string{"uninstall", "-y", "--noninteractive"}, names...)
+		if result := utils.RunCommandWithTimeout("flatpak", 30*time.Minute, args...); result.Error != nil {
+			return fmt.Errorf("flatpak failed: %s", result.Stderr)
+		}
+		return nil
+	case "gnome-extensions":
+		// gnome-extensions takes one extension at a time
+		var failed []string
+		for _, uuid := range names {
+			if result := utils.RunCommand("gnome-extensions", "uninstall", uuid); result.Error != nil {
+				failed = append(failed, uuid)
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to uninstall %s", strings.Join(failed, ", "))
+		}
+		return nil
+	case "dnf", "apt-get", "zypper", "pacman":
+		if result := j.helper.Run(privileged.Remove(manager, names).In(j.Target.Root)); result.Error != nil {
+			return fmt.Errorf("%s failed: %s", manager, result.Stderr)
+		}
+		return nil
+	}
+	return fmt.Errorf("don't know how to uninstall with %s", manager)
+}
+
+// topMissing returns the topmost directory above path that does not exist,
+// or path itself if its parent does
+func topMissing(path string) string {
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		if _, err := os.Lstat(parent); err == nil {
+			return path
+		}
+		path = parent
+	}
+}
+
+// inHome reports whether path is inside the home directory of t
+func inHome(t Target, path string) bool {
+	home, err := t.HomeDir()
+	if err != nil {
+		return false
+	}
+	return isWithin(home, path)
+}
+
+// isWithin reports whether path is root or below it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
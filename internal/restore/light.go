@@ -2,22 +2,166 @@ package restore
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // LightRestore restores from a light backup
 type LightRestore struct {
-	backup *backup.LightBackup
-	dryRun bool
+	backup   *backup.LightBackup
+	dryRun   bool
+	progress *utils.Progress
+	journal  *Journal
+	snapshot *Snapshot
+	resume   *ResumeState
+	helper   *privileged.Helper
+	target   Target
+	cache    string
+	pin      bool
+	results  []RestoreResult
 }
 
 func NewLightRestore(b *backup.LightBackup, dryRun bool) *LightRestore {
 	return &LightRestore{backup: b, dryRun: dryRun}
 }
 
+// SetProgress sets where to report progress: each Restore call is a
+// component, with its packages counted as files and each command reported
+func (r *LightRestore) SetProgress(fn utils.ProgressFunc) {
+	r.progress = utils.NewProgress(fn)
+}
+
+// SetJournal sets where installs and loaded settings are recorded for undo
+func (r *LightRestore) SetJournal(j *Journal) {
+	r.journal = j
+}
+
+// SetSnapshot sets the pre-restore snapshot each component is saved to
+// before it is restored
+func (r *LightRestore) SetSnapshot(s *Snapshot) {
+	r.snapshot = s
+}
+
+// SetHelper sets what runs the package installs and repository changes
+// that need root
+func (r *LightRestore) SetHelper(h *privileged.Helper) {
+	r.helper = h
+}
+
+// SetTarget sets the root filesystem and home directory to restore into
+func (r *LightRestore) SetTarget(t Target) {
+	r.target = t
+}
+
+// SetPackageCache sets the package cache of a Full Save, which packages
+// and Flatpaks are installed from before anything is downloaded
+func (r *LightRestore) SetPackageCache(dir string) {
+	r.cache = dir
+}
+
+// cacheFor returns the directory with the cached package files of manager,
+// or "" if there is none
+func (r *LightRestore) cacheFor(manager string) string {
+	sub := backup.PackageCacheSubdir(manager)
+	if r.cache == "" || sub == "" || !utils.DirExists(filepath.Join(r.cache, sub)) {
+		return ""
+	}
+	return filepath.Join(r.cache, sub)
+}
+
+// SetPinFlatpaks sets whether Flatpak apps are moved back to the commit
+// they were backed up at once installed
+func (r *LightRestore) SetPinFlatpaks(pin bool) {
+	r.pin = pin
+}
+
+// SetResume sets where progress is saved so an interrupted restore can be
+// resumed. Items the state has as restored are skipped.
+func (r *LightRestore) SetResume(s *ResumeState) {
+	r.resume = s
+}
+
+// Results returns the outcome of every item restored so far, one result
+// per component
+func (r *LightRestore) Results() []RestoreResult {
+	return r.results
+}
+
+// record keeps the outcome of items for Results and returns how many were
+// restored and how many failed
+func (r *LightRestore) record(component RestoreType, items []ItemResult) (int, int) {
+	result := RestoreResult{Type: component, ItemsTotal: len(items), Timestamp: time.Now()}
+	result.AddItems(items...)
+	result.Success = result.ItemsFailed == 0
+	r.results = append(r.results, result)
+	return result.ItemsSuccess, result.ItemsFailed
+}
+
+// run reports a command and runs it
+func (r *LightRestore) run(name string, timeout time.Duration, args ...string) utils.CommandResult {
+	r.progress.Run(name, args...)
+	return utils.RunCommandWithTimeout(name, timeout, args...)
+}
+
+// runPrivileged reports the command op amounts to and runs it in the
+// privileged helper
+func (r *LightRestore) runPrivileged(op privileged.Op) utils.CommandResult {
+	argv := op.Command()
+	r.progress.Run(argv[0], argv[1:]...)
+	return r.helper.Run(op)
+}
+
+// installPrivileged returns what installs names through manager as root
+func (r *LightRestore) installPrivileged(manager string) func([]string) utils.CommandResult {
+	return func(names []string) utils.CommandResult {
+		return r.runPrivileged(privileged.Install(manager, names).In(r.target.Root))
+	}
+}
+
+// install saves component to the snapshot, then installs the names not
+// restored yet with one command run by install. What manager reports
+// installed afterwards is recorded for undo and for resuming.
+func (r *LightRestore) install(component RestoreType, label, manager string, names []string, install func([]string) utils.CommandResult) (int, int, error) {
+	todo := r.resume.Pending(component, names)
+	_, done := splitFound(names, todo)
+	items := skippedItems(done)
+	if len(todo) == 0 {
+		success, failed := r.record(component, items)
+		return success, failed, nil
+	}
+
+	if err := r.snapshot.Take(component); err != nil {
+		return 0, len(names), err
+	}
+	r.progress.Start(label)
+
+	installed := r.journal.TrackInstall(component, manager, todo)
+	if dir := r.cacheFor(manager); dir != "" {
+		// What the cache can't provide is downloaded below
+		start := time.Now()
+		r.runPrivileged(privileged.InstallCached(manager, dir, todo).In(r.target.Root))
+		var cached []string
+		todo, cached = splitFound(todo, backup.FilterMissing(todo, installedLister(manager, r.target.Root)()))
+		for _, name := range cached {
+			items = append(items, ItemResult{Name: name, Status: ItemInstalled, Duration: time.Since(start)})
+		}
+	}
+	if len(todo) > 0 {
+		items = append(items, InstallItems(manager, r.target.Root, todo, install)...)
+	}
+	installed()
+	r.resume.MarkItems(component, items)
+	r.progress.FilesDone(len(todo))
+
+	success, failed := r.record(component, items)
+	return success, failed, nil
+}
+
 // RestoreFlatpaks installs all Flatpak apps
 func (r *LightRestore) RestoreFlatpaks() (int, int, error) {
 	if len(r.backup.Flatpaks) == 0 {
@@ -28,18 +172,52 @@ func (r *LightRestore) RestoreFlatpaks() (int, int, error) {
 		return len(r.backup.Flatpaks), 0, nil
 	}
 
-	// Add flathub if not present
-	utils.RunCommand("flatpak", "remote-add", "--if-not-exists", "flathub", "https://flathub.org/repo/flathub.flatpakrepo")
+	if err := r.snapshot.Take(RestoreTypeFlatpak); err != nil {
+		return 0, len(r.backup.Flatpaks), err
+	}
+	r.progress.Start("Flatpaks")
 
-	success, failed := 0, 0
-	for _, app := range r.backup.Flatpaks {
-		result := utils.RunCommandWithTimeout("flatpak", 5*time.Minute, "install", "-y", "--noninteractive", "flathub", app)
-		if result.Error != nil {
-			failed++
-		} else {
-			success++
+	// flatpak takes what it can from an offline repository and downloads the rest
+	installer := FlatpakInstaller{Target: r.target, Helper: r.helper, Progress: r.progress, Pin: r.pin}
+	if dir := r.cacheFor("flatpak"); dir != "" {
+		installer.Sideload = filepath.Join(dir, backup.FlatpakCacheRepo)
+	}
+
+	// Add flathub to each installation that installs from it, if not present
+	const flathubURL = "https://flathub.org/repo/flathub.flatpakrepo"
+	var apps []backup.FlatpakDeployment
+	added := make(map[string]bool)
+	for _, id := range r.backup.Flatpaks {
+		app := r.backup.FlatpakApp(id)
+		apps = append(apps, app)
+		if app.Remote() == "flathub" && !added[app.InstallationFlag()] {
+			installer.AddRemote(app.Installation, "flathub", flathubURL)
+			added[app.InstallationFlag()] = true
 		}
 	}
+
+	installed := r.journal.TrackFlatpaks(apps)
+	defer installed()
+
+	todo := r.resume.Pending(RestoreTypeFlatpak, r.backup.Flatpaks)
+	_, done := splitFound(r.backup.Flatpaks, todo)
+	apps = slices.DeleteFunc(apps, func(app backup.FlatpakDeployment) bool { return slices.Contains(done, app.ID) })
+	available, unavailable := ResolveFlatpakApps(r.target.Root, apps)
+	items := skippedItems(done)
+	r.progress.FilesDone(len(done))
+	for _, app := range unavailable {
+		item := UnavailableItems([]string{app.ID}, fmt.Sprintf(reasonNotOnRemote, app.Remote()))[0]
+		r.resume.MarkItems(RestoreTypeFlatpak, []ItemResult{item})
+		items = append(items, item)
+		r.progress.FilesDone(1)
+	}
+	for _, app := range available {
+		item := installer.Install(app)
+		r.resume.MarkItems(RestoreTypeFlatpak, []ItemResult{item})
+		items = append(items, item)
+		r.progress.FilesDone(1)
+	}
+	success, failed := r.record(RestoreTypeFlatpak, items)
 	return success, failed, nil
 }
 
@@ -53,12 +231,104 @@ func (r *LightRestore) RestoreRPM() (int, int, error) {
 		return len(r.backup.RPMPackages), 0, nil
 	}
 
-	args := append([]string{"install", "-y"}, r.backup.RPMPackages...)
-	result := utils.RunCommandWithTimeout("dnf", 30*time.Minute, args...)
-	if result.Error != nil {
-		return 0, len(r.backup.RPMPackages), fmt.Errorf("dnf install failed: %s", result.Stderr)
+	return r.install(RestoreTypeRPM, "RPM packages", "dnf", r.backup.RPMPackages, r.installPrivileged("dnf"))
+}
+
+// RestoreAPT installs all APT packages
+func (r *LightRestore) RestoreAPT() (int, int, error) {
+	if len(r.backup.APTPackages) == 0 {
+		return 0, 0, nil
 	}
-	return len(r.backup.RPMPackages), 0, nil
+
+	if r.dryRun {
+		return len(r.backup.APTPackages), 0, nil
+	}
+
+	return r.install(RestoreTypeAPT, "APT packages", "apt-get", r.backup.APTPackages, r.installPrivileged("apt-get"))
+}
+
+// RestorePacman installs native Arch packages from the sync repositories
+func (r *LightRestore) RestorePacman() (int, int, error) {
+	if len(r.backup.PacmanPackages) == 0 {
+		return 0, 0, nil
+	}
+
+	if r.dryRun {
+		return len(r.backup.PacmanPackages), 0, nil
+	}
+
+	return r.install(RestoreTypePacman, "Pacman packages", "pacman", r.backup.PacmanPackages, r.installPrivileged("pacman"))
+}
+
+// RestoreAUR installs AUR packages through the detected AUR helper
+func (r *LightRestore) RestoreAUR() (int, int, error) {
+	if len(r.backup.AURPackages) == 0 {
+		return 0, 0, nil
+	}
+
+	if r.dryRun {
+		return len(r.backup.AURPackages), 0, nil
+	}
+
+	if !r.target.LiveSystem() {
+		return 0, len(r.backup.AURPackages), fmt.Errorf("AUR packages can't be installed into %s, only into this system", r.target.Root)
+	}
+
+	helper := DetectAURHelper()
+	if helper == "" {
+		return 0, len(r.backup.AURPackages), fmt.Errorf("no AUR helper found (install yay or paru)")
+	}
+
+	// AUR helpers refuse to run as root and call sudo themselves
+	return r.install(RestoreTypeAUR, "AUR packages", helper, r.backup.AURPackages, func(names []string) utils.CommandResult {
+		return r.run(helper, 60*time.Minute, append([]string{"-S", "--needed", "--noconfirm"}, names...)...)
+	})
+}
+
+// RestoreZypperRepos adds the openSUSE repositories that are not configured yet
+func (r *LightRestore) RestoreZypperRepos() (int, int, error) {
+	if len(r.backup.ZypperRepos) == 0 {
+		return 0, 0, nil
+	}
+
+	if r.dryRun {
+		return len(r.backup.ZypperRepos), 0, nil
+	}
+
+	if err := r.snapshot.Take(RestoreTypeZypperRepos); err != nil {
+		return 0, len(r.backup.ZypperRepos), err
+	}
+	r.progress.Start("Zypper repositories")
+	items := addZypperRepos(r.backup.ZypperRepos, r.target, r.helper, r.journal)
+	r.progress.FilesDone(len(items))
+
+	// Repos that are configured already are skipped on the next run anyway
+	r.resume.MarkItems(RestoreTypeZypperRepos, items)
+	success, failed := r.record(RestoreTypeZypperRepos, items)
+	return success, failed, nil
+}
+
+// RestoreZypper installs all openSUSE packages
+func (r *LightRestore) RestoreZypper() (int, int, error) {
+	if len(r.backup.ZypperPackages) == 0 {
+		return 0, 0, nil
+	}
+
+	if r.dryRun {
+		return len(r.backup.ZypperPackages), 0, nil
+	}
+
+	return r.install(RestoreTypeZypper, "Zypper packages", "zypper", r.backup.ZypperPackages, r.installPrivileged("zypper"))
+}
+
+// DetectAURHelper returns the first installed AUR helper, or "" if none
+func DetectAURHelper() string {
+	for _, helper := range []string{"yay", "paru", "pikaur", "trizen"} {
+		if utils.CommandExists(helper) {
+			return helper
+		}
+	}
+	return ""
 }
 
 // RestoreExtensions installs GNOME extensions
@@ -71,17 +341,39 @@ func (r *LightRestore) RestoreExtensions() (int, int, error) {
 		return len(r.backup.GnomeExtensions), 0, nil
 	}
 
-	success, failed := 0, 0
-	for _, ext := range r.backup.GnomeExtensions {
-		result := utils.RunCommand("gnome-extensions", "install", ext)
+	// gnome-extensions talks to the running shell, which only knows the
+	// current user's home
+	if !r.target.LiveHome() {
+		items := FailedItems(r.backup.GnomeExtensions, reasonOtherHome)
+		success, failed := r.record(RestoreTypeGnomeExtensions, items)
+		return success, failed, nil
+	}
+
+	if err := r.snapshot.Take(RestoreTypeGnomeExtensions); err != nil {
+		return 0, len(r.backup.GnomeExtensions), err
+	}
+	r.progress.Start("GNOME extensions")
+
+	installed := r.journal.TrackInstall(RestoreTypeGnomeExtensions, "gnome-extensions", r.backup.GnomeExtensions)
+	defer installed()
+
+	todo := r.resume.Pending(RestoreTypeGnomeExtensions, r.backup.GnomeExtensions)
+	_, done := splitFound(r.backup.GnomeExtensions, todo)
+	items := skippedItems(done)
+	r.progress.FilesDone(len(done))
+	for _, ext := range todo {
+		start := time.Now()
+		result := r.run("gnome-extensions", 30*time.Second, "install", ext)
 		if result.Error != nil {
 			// Try enabling if already installed
-			utils.RunCommand("gnome-extensions", "enable", ext)
-			failed++
-		} else {
-			success++
+			r.run("gnome-extensions", 30*time.Second, "enable", ext)
 		}
+		item := CommandItem(ext, result, time.Since(start))
+		r.resume.MarkItems(RestoreTypeGnomeExtensions, []ItemResult{item})
+		items = append(items, item)
+		r.progress.FilesDone(1)
 	}
+	success, failed := r.record(RestoreTypeGnomeExtensions, items)
 	return success, failed, nil
 }
 
@@ -91,19 +383,30 @@ func (r *LightRestore) RestoreDconf() error {
 		return nil
 	}
 
-	if r.dryRun {
+	if r.dryRun || r.resume.IsDone(RestoreTypeGnomeSettings, "dconf") {
 		return nil
 	}
 
-	// Write to temp file and load
-	tmpFile := "/tmp/rego-dconf-restore"
-	if err := utils.WriteFile(tmpFile, []byte(r.backup.DconfSettings)); err != nil {
+	if err := r.snapshot.Take(RestoreTypeGnomeSettings); err != nil {
 		return err
 	}
+	r.progress.Start("GNOME settings")
 
-	result := utils.RunCommand("sh", "-c", "cat "+tmpFile+" | dconf load /")
+	// The settings were checked when the backup was loaded and go to
+	// dconf on stdin, or into the database of another home
+	start := time.Now()
+	if r.target.LiveHome() {
+		r.progress.Run("dconf", "load", "/")
+	} else {
+		home, _ := r.target.HomeDir()
+		r.progress.Run("dconf", "compile", dconfDatabase(home))
+	}
+	result := loadDconf(r.target, r.journal, RestoreTypeGnomeSettings, "/", r.backup.DconfSettings)
+	item := CommandItem("dconf", result, time.Since(start))
+	r.resume.MarkItems(RestoreTypeGnomeSettings, []ItemResult{item})
+	r.record(RestoreTypeGnomeSettings, []ItemResult{item})
 	if result.Error != nil {
-		return fmt.Errorf("dconf load failed: %s", result.Stderr)
+		return fmt.Errorf("dconf failed: %s", item.Reason)
 	}
 	return nil
 }
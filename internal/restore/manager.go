@@ -1,19 +1,31 @@
 package restore
 
 import (
+	"fmt"
+
 	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
 )
 
 type Manager struct {
 	restorers  map[RestoreType]Restorer
 	backupPath string
+	journal    *Journal
+	snapshot   *Snapshot
+	helper     *privileged.Helper
+	target     Target
 }
 
 func NewManager() *Manager {
 	m := &Manager{restorers: make(map[RestoreType]Restorer)}
+	m.RegisterRestorer(NewFlatpakRemotesRestore())
 	m.RegisterRestorer(NewFlatpakRestore())
 	m.RegisterRestorer(NewRPMRestore())
 	m.RegisterRestorer(NewReposRestore())
+	m.RegisterRestorer(NewZypperReposRestore())
+	m.RegisterRestorer(NewZypperRestore())
+	m.RegisterRestorer(NewAPTSourcesRestore())
+	m.RegisterRestorer(NewAPTRestore())
 	m.RegisterRestorer(NewGnomeExtensionsRestore())
 	m.RegisterRestorer(NewGnomeSettingsRestore())
 	m.RegisterRestorer(NewDotfilesRestore())
@@ -21,6 +33,12 @@ func NewManager() *Manager {
 	return m
 }
 
+// SetHelper sets what runs the package installs and system file writes
+// that need root
+func (m *Manager) SetHelper(h *privileged.Helper) {
+	m.helper = h
+}
+
 func (m *Manager) RegisterRestorer(r Restorer)                { m.restorers[r.Type()] = r }
 func (m *Manager) GetRestorer(t RestoreType) (Restorer, bool) { r, ok := m.restorers[t]; return r, ok }
 
@@ -46,44 +64,115 @@ type RestoreProgress struct {
 
 type ProgressCallback func(progress RestoreProgress)
 
-func (m *Manager) RunRestore(opts RestoreOptions, callback ProgressCallback) ([]RestoreResult, error) {
-	m.backupPath = opts.BackupPath
-
-	var typesToRestore []RestoreType
-	if opts.IncludeFlatpak {
-		typesToRestore = append(typesToRestore, RestoreTypeFlatpak)
-	}
-	if opts.IncludeRPM {
-		typesToRestore = append(typesToRestore, RestoreTypeRPM)
+// selectedTypes returns the components opts asks for
+func selectedTypes(opts RestoreOptions) []RestoreType {
+	include := map[RestoreType]bool{
+		RestoreTypeFlatpakRemotes:  opts.IncludeFlatpakRemotes,
+		RestoreTypeFlatpak:         opts.IncludeFlatpak,
+		RestoreTypeRPM:             opts.IncludeRPM,
+		RestoreTypeRepos:           opts.IncludeRepos,
+		RestoreTypeZypperRepos:     opts.IncludeZypperRepos,
+		RestoreTypeZypper:          opts.IncludeZypper,
+		RestoreTypeAPTSources:      opts.IncludeAPTSources,
+		RestoreTypeAPT:             opts.IncludeAPT,
+		RestoreTypeGnomeExtensions: opts.IncludeGnomeExtensions,
+		RestoreTypeGnomeSettings:   opts.IncludeGnomeSettings,
+		RestoreTypeDotfiles:        opts.IncludeDotfiles,
+		RestoreTypeFonts:           opts.IncludeFonts,
 	}
-	if opts.IncludeRepos {
-		typesToRestore = append(typesToRestore, RestoreTypeRepos)
+	var types []RestoreType
+	for _, t := range AllRestoreTypes() {
+		if include[t] {
+			types = append(types, t)
+		}
 	}
-	if opts.IncludeGnomeExtensions {
-		typesToRestore = append(typesToRestore, RestoreTypeGnomeExtensions)
+	return types
+}
+
+// Plan returns the order RunRestore would restore the components opts asks
+// for in. Components that aren't available on this system, or can't be
+// restored into the target opts asks for, are left out.
+func (m *Manager) Plan(opts RestoreOptions) (*Plan, error) {
+	target, err := NewTarget(opts.TargetRoot, opts.TargetHome)
+	if err != nil {
+		return nil, err
 	}
-	if opts.IncludeGnomeSettings {
-		typesToRestore = append(typesToRestore, RestoreTypeGnomeSettings)
+	m.target = target
+	for _, r := range m.restorers {
+		if targeted, ok := r.(Targeted); ok {
+			targeted.SetTarget(target)
+		}
 	}
-	if opts.IncludeDotfiles {
-		typesToRestore = append(typesToRestore, RestoreTypeDotfiles)
+
+	var restorers []Restorer
+	for _, t := range selectedTypes(opts) {
+		if r, ok := m.restorers[t]; ok && r.Available() {
+			restorers = append(restorers, r)
+		}
 	}
-	if opts.IncludeFonts {
-		typesToRestore = append(typesToRestore, RestoreTypeFonts)
+	return BuildPlan(restorers)
+}
+
+func (m *Manager) RunRestore(opts RestoreOptions, callback ProgressCallback) ([]RestoreResult, error) {
+	m.backupPath = opts.BackupPath
+
+	plan, err := m.Plan(opts)
+	if err != nil {
+		return nil, err
 	}
+	typesToRestore := plan.Types()
 
 	if dfRestore, ok := m.restorers[RestoreTypeDotfiles].(*DotfilesRestore); ok {
 		dfRestore.SetMerge(opts.MergeDotfiles)
+		dfRestore.SetChoices(opts.DotfileChoices)
+	}
+	if flatpakRestore, ok := m.restorers[RestoreTypeFlatpak].(*FlatpakRestore); ok {
+		flatpakRestore.SetPin(opts.PinFlatpaks)
+	}
+
+	// Every change of a real restore goes in a new undo journal
+	m.journal = nil
+	if !opts.DryRun {
+		source := opts.Source
+		if source == "" {
+			source = opts.BackupPath
+		}
+		j, err := NewJournal(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start undo journal: %w", err)
+		}
+		j.SetTarget(m.target)
+		m.journal = j
+	}
+	for _, r := range m.restorers {
+		if journaled, ok := r.(Journaled); ok {
+			journaled.SetJournal(m.journal)
+		}
+		if elevated, ok := r.(Elevated); ok {
+			elevated.SetHelper(m.helper)
+		}
+	}
+
+	// Save what is about to change before anything is written. Snapshots
+	// are taken of the running system, so a restore into another root or
+	// home has only the undo journal.
+	m.snapshot = nil
+	if !opts.DryRun && !opts.SkipSnapshot && m.target.Live() {
+		s, err := NewSnapshot()
+		if err == nil {
+			err = s.Take(typesToRestore...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to take pre-restore snapshot: %w", err)
+		}
+		m.snapshot = s
 	}
 
 	progress := RestoreProgress{TotalSteps: len(typesToRestore), InProgress: true}
 	var results []RestoreResult
 
 	for i, restoreType := range typesToRestore {
-		restorer, ok := m.restorers[restoreType]
-		if !ok || !restorer.Available() {
-			continue
-		}
+		restorer := m.restorers[restoreType]
 
 		progress.CurrentStep = i + 1
 		progress.CurrentType = restoreType
@@ -104,6 +193,18 @@ func (m *Manager) RunRestore(opts RestoreOptions, callback ProgressCallback) ([]
 	return results, nil
 }
 
+// Journal returns the undo journal of the last restore that was not a dry
+// run, or nil
+func (m *Manager) Journal() *Journal {
+	return m.journal
+}
+
+// Snapshot returns the pre-restore snapshot of the last restore that was
+// not a dry run, or nil
+func (m *Manager) Snapshot() *Snapshot {
+	return m.snapshot
+}
+
 func (m *Manager) PreviewRestore(backupPath string) map[RestoreType][]string {
 	preview := make(map[RestoreType][]string)
 	for t, r := range m.restorers {
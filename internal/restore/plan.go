@@ -0,0 +1,128 @@
+package restore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PlanStep is one component of a restore plan
+type PlanStep struct {
+	Type RestoreType
+	Name string
+	// After lists the components in the plan this one waits for
+	After []RestoreType
+}
+
+// Plan is the order a restore runs its components in
+type Plan struct {
+	Steps []PlanStep
+}
+
+// BuildPlan orders restorers so each one runs after the restorers it
+// depends on. Dependencies that are not part of the restore are ignored.
+// Components that don't depend on each other keep the AllRestoreTypes order,
+// so the same selection always gives the same plan.
+func BuildPlan(restorers []Restorer) (*Plan, error) {
+	byType := make(map[RestoreType]Restorer)
+	for _, r := range restorers {
+		byType[r.Type()] = r
+	}
+
+	// Start from the usual order, then anything not listed there
+	var order []RestoreType
+	for _, t := range AllRestoreTypes() {
+		if _, ok := byType[t]; ok {
+			order = append(order, t)
+		}
+	}
+	for _, r := range restorers {
+		if !slices.Contains(order, r.Type()) {
+			order = append(order, r.Type())
+		}
+	}
+
+	plan := &Plan{}
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[RestoreType]int)
+	var visit func(t RestoreType, path []RestoreType) error
+	visit = func(t RestoreType, path []RestoreType) error {
+		switch state[t] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("restore components depend on each other: %s", joinCycle(append(path, t)))
+		}
+		state[t] = visiting
+
+		step := PlanStep{Type: t, Name: byType[t].Name()}
+		for _, dep := range byType[t].DependsOn() {
+			if _, ok := byType[dep]; !ok {
+				continue
+			}
+			if err := visit(dep, append(path, t)); err != nil {
+				return err
+			}
+			step.After = append(step.After, dep)
+		}
+
+		state[t] = done
+		plan.Steps = append(plan.Steps, step)
+		return nil
+	}
+	for _, t := range order {
+		if err := visit(t, nil); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// joinCycle returns a dependency cycle as "a → b → a"
+func joinCycle(cycle []RestoreType) string {
+	var names []string
+	for _, t := range cycle {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, " → ")
+}
+
+// Types returns the components in the order they run
+func (p *Plan) Types() []RestoreType {
+	var types []RestoreType
+	for _, step := range p.Steps {
+		types = append(types, step.Type)
+	}
+	return types
+}
+
+// Lines returns a numbered line per step, saying what it waits for
+func (p *Plan) Lines() []string {
+	var lines []string
+	for i, step := range p.Steps {
+		line := fmt.Sprintf("%d. %s", i+1, step.Name)
+		if len(step.After) > 0 {
+			var after []string
+			for _, t := range step.After {
+				// Dependencies always come earlier in the plan
+				for _, dep := range p.Steps[:i] {
+					if dep.Type == t {
+						after = append(after, dep.Name)
+					}
+				}
+			}
+			line += " (after " + strings.Join(after, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// String returns the plan one step per line
+func (p *Plan) String() string {
+	return strings.Join(p.Lines(), "\n")
+}
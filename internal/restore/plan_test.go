@@ -0,0 +1,121 @@
+package restore
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeRestorer is a restorer that only has a type and dependencies
+type fakeRestorer struct {
+	Restorer
+	restoreType RestoreType
+	dependsOn   []RestoreType
+}
+
+func (f fakeRestorer) Name() string             { return string(f.restoreType) }
+func (f fakeRestorer) Type() RestoreType        { return f.restoreType }
+func (f fakeRestorer) DependsOn() []RestoreType { return f.dependsOn }
+
+func TestBuildPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		restorers []Restorer
+		want      []RestoreType
+	}{
+		{
+			"packages after their repositories",
+			[]Restorer{NewRPMRestore(), NewReposRestore(), NewZypperRestore(), NewZypperReposRestore(), NewAPTRestore(), NewAPTSourcesRestore()},
+			[]RestoreType{RestoreTypeRepos, RestoreTypeRPM, RestoreTypeZypperRepos, RestoreTypeZypper, RestoreTypeAPTSources, RestoreTypeAPT},
+		},
+		{
+			"apps after their remotes",
+			[]Restorer{NewFlatpakRestore(), NewFlatpakRemotesRestore()},
+			[]RestoreType{RestoreTypeFlatpakRemotes, RestoreTypeFlatpak},
+		},
+		{
+			"settings after extensions",
+			[]Restorer{NewGnomeSettingsRestore(), NewGnomeExtensionsRestore()},
+			[]RestoreType{RestoreTypeGnomeExtensions, RestoreTypeGnomeSettings},
+		},
+		{
+			"dependency not selected",
+			[]Restorer{NewRPMRestore(), NewFlatpakRestore(), NewGnomeSettingsRestore()},
+			[]RestoreType{RestoreTypeFlatpak, RestoreTypeRPM, RestoreTypeGnomeSettings},
+		},
+		{
+			"independent components keep the usual order",
+			[]Restorer{NewFontsRestore(), NewDotfilesRestore(), NewRPMRestore(), NewReposRestore(), NewFlatpakRestore()},
+			[]RestoreType{RestoreTypeFlatpak, RestoreTypeRepos, RestoreTypeRPM, RestoreTypeDotfiles, RestoreTypeFonts},
+		},
+		{
+			"unlisted components come last",
+			[]Restorer{fakeRestorer{restoreType: "extra", dependsOn: []RestoreType{RestoreTypeFonts}}, NewFontsRestore(), NewDotfilesRestore()},
+			[]RestoreType{RestoreTypeDotfiles, RestoreTypeFonts, "extra"},
+		},
+		{"nothing selected", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := BuildPlan(tt.restorers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := plan.Types(); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+			// A step only waits for components that are in the plan
+			for _, step := range plan.Steps {
+				for _, dep := range step.After {
+					if !slices.Contains(tt.want, dep) {
+						t.Errorf("%s waits for %s, which is not selected", step.Type, dep)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPlanCycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		restorers []Restorer
+		cycle     string
+	}{
+		{"itself", []Restorer{
+			fakeRestorer{restoreType: "a", dependsOn: []RestoreType{"a"}},
+		}, "a → a"},
+		{"two components", []Restorer{
+			fakeRestorer{restoreType: "a", dependsOn: []RestoreType{"b"}},
+			fakeRestorer{restoreType: "b", dependsOn: []RestoreType{"a"}},
+		}, "a → b → a"},
+		{"three components", []Restorer{
+			fakeRestorer{restoreType: "a", dependsOn: []RestoreType{"b"}},
+			fakeRestorer{restoreType: "b", dependsOn: []RestoreType{"c"}},
+			fakeRestorer{restoreType: "c", dependsOn: []RestoreType{"a"}},
+			NewFontsRestore(),
+		}, "a → b → c → a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := BuildPlan(tt.restorers)
+			if err == nil {
+				t.Fatalf("no error, plan %v", plan.Types())
+			}
+			if !strings.Contains(err.Error(), tt.cycle) {
+				t.Errorf("error = %v, want the cycle %s", err, tt.cycle)
+			}
+		})
+	}
+}
+
+func TestPlanLines(t *testing.T) {
+	plan, err := BuildPlan([]Restorer{NewRPMRestore(), NewReposRestore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1. DNF Repositories\n2. RPM Packages (after DNF Repositories)"
+	if got := plan.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,23 +1,46 @@
 package restore
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // ReposRestore handles repository restoration
-type ReposRestore struct{}
+type ReposRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
 
 // NewReposRestore creates a new ReposRestore instance
 func NewReposRestore() *ReposRestore {
 	return &ReposRestore{}
 }
 
+// SetJournal sets where overwritten repo files are recorded for undo
+func (r *ReposRestore) SetJournal(j *Journal) {
+	r.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (r *ReposRestore) SetHelper(h *privileged.Helper) {
+	r.helper = h
+}
+
+// SetTarget sets the root filesystem whose /etc/yum.repos.d is restored
+func (r *ReposRestore) SetTarget(t Target) {
+	r.target = t
+}
+
 // Name returns the display name
 func (r *ReposRestore) Name() string {
 	return "DNF Repositories"
@@ -28,9 +51,14 @@ func (r *ReposRestore) Type() RestoreType {
 	return RestoreTypeRepos
 }
 
+// DependsOn returns nothing, repos and their keys come first
+func (r *ReposRestore) DependsOn() []RestoreType {
+	return nil
+}
+
 // Available checks if DNF repos directory is accessible
 func (r *ReposRestore) Available() bool {
-	return utils.DirExists("/etc/yum.repos.d")
+	return utils.DirExists(r.target.SystemPath("/etc/yum.repos.d"))
 }
 
 // ReposData matches the backup structure
@@ -46,9 +74,48 @@ type RepoInfo struct {
 	FileName string `json:"filename"`
 }
 
+// Validate drops the repositories and repository files whose names fail
+// validation and returns them
+func (d *ReposData) Validate() []backup.Rejection {
+	var rejected []backup.Rejection
+	d.RepoFiles, rejected = backup.FilterValid("repos.json", d.RepoFiles, checkRepoFileName)
+	var repos []RepoInfo
+	for _, repo := range d.Repos {
+		if err := checkRepo(repo); err != nil {
+			rejected = append(rejected, backup.Rejection{Field: "repos.json repos", Value: repo.ID, Reason: err.Error()})
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	d.Repos = repos
+	return rejected
+}
+
+// checkRepo checks the ID, name and file name of a repository
+func checkRepo(repo RepoInfo) error {
+	if err := backup.CheckIdentifier(repo.ID); err != nil {
+		return fmt.Errorf("ID %w", err)
+	}
+	if err := backup.CheckText(repo.Name); err != nil {
+		return fmt.Errorf("name %w", err)
+	}
+	if err := checkRepoFileName(repo.FileName); err != nil {
+		return fmt.Errorf("file name %w", err)
+	}
+	return nil
+}
+
+// checkRepoFileName refuses names that would write outside /etc/yum.repos.d
+func checkRepoFileName(name string) error {
+	if filepath.Base(name) != name {
+		return fmt.Errorf("is not a plain file name")
+	}
+	return backup.CheckIdentifier(name)
+}
+
 // Preview returns what would be restored
 func (r *ReposRestore) Preview(backupDir string) ([]string, error) {
-	data, err := r.loadBackupData(backupDir)
+	data, _, err := r.loadBackupData(backupDir)
 	if err != nil {
 		return nil, err
 	}
@@ -62,22 +129,22 @@ func (r *ReposRestore) Preview(backupDir string) ([]string, error) {
 }
 
 // loadBackupData loads the repos backup data
-func (r *ReposRestore) loadBackupData(backupDir string) (*ReposData, error) {
+func (r *ReposRestore) loadBackupData(backupDir string) (*ReposData, []backup.Rejection, error) {
 	dataPath := filepath.Join(backupDir, "repos.json")
 	content, err := os.ReadFile(dataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read repos backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to read repos backup: %w", err)
 	}
 
 	var data ReposData
 	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse repos backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse repos backup: %w", err)
 	}
 
-	return &data, nil
+	return &data, data.Validate(), nil
 }
 
-// Restore performs the repository restoration
+// Restore imports the saved GPG keys and writes the repository files back
 func (r *ReposRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
 	result := RestoreResult{
 		Type:      RestoreTypeRepos,
@@ -85,25 +152,29 @@ func (r *ReposRestore) Restore(backupDir string, dryRun bool) (RestoreResult, er
 		DryRun:    dryRun,
 	}
 
-	data, err := r.loadBackupData(backupDir)
+	data, rejected, err := r.loadBackupData(backupDir)
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
 
-	result.ItemsTotal = len(data.RepoFiles)
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.RepoFiles) + len(rejected)
 
 	if dryRun {
-		result.Success = true
-		result.ItemsSuccess = result.ItemsTotal
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
 		return result, nil
 	}
 
+	// Keys first, so the packages installed from these repos are trusted
+	result.Errors = append(result.Errors, r.importKeys(backupDir)...)
+
 	// Copy repo files back
 	reposBackupDir := filepath.Join(backupDir, "repos.d")
 	for _, fileName := range data.RepoFiles {
 		srcPath := filepath.Join(reposBackupDir, fileName)
-		dstPath := filepath.Join("/etc/yum.repos.d", fileName)
+		dstPath := r.target.SystemPath(filepath.Join("/etc/yum.repos.d", fileName))
 
 		// Check if file exists in backup
 		if !utils.FileExists(srcPath) {
@@ -112,8 +183,19 @@ func (r *ReposRestore) Restore(backupDir string, dryRun bool) (RestoreResult, er
 			continue
 		}
 
-		// Need sudo for /etc/yum.repos.d
-		cmdResult := utils.RunCommand("sudo", "cp", srcPath, dstPath)
+		if err := r.journal.RecordFile(RestoreTypeRepos, dstPath); err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+
+		content, err := os.ReadFile(srcPath)
+		if err != nil {
+			result.ItemsFailed++
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to read %s: %v", fileName, err))
+			continue
+		}
+		cmdResult := r.helper.Run(privileged.WriteFile(dstPath, content, 0644).In(r.target.Root))
 		if cmdResult.Error != nil {
 			result.ItemsFailed++
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to copy %s: %s", fileName, cmdResult.Stderr))
@@ -125,3 +207,63 @@ func (r *ReposRestore) Restore(backupDir string, dryRun bool) (RestoreResult, er
 	result.Success = result.ItemsFailed == 0
 	return result, nil
 }
+
+// importKeys imports each signing key saved in gpg-keys and returns the
+// errors. The backup keeps rpm's description of a key, so only the armored
+// block in it is handed to rpm.
+func (r *ReposRestore) importKeys(backupDir string) []string {
+	keysDir := filepath.Join(backupDir, "gpg-keys")
+	entries, err := os.ReadDir(keysDir)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+
+	scratch, err := os.MkdirTemp("", "rego-gpg-keys-*")
+	if err != nil {
+		return []string{fmt.Sprintf("Failed to import keys: %v", err)}
+	}
+	defer os.RemoveAll(scratch)
+
+	var errs []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		key := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		content, err := os.ReadFile(filepath.Join(keysDir, entry.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Failed to read key %s: %v", key, err))
+			continue
+		}
+		armored, ok := armoredKey(content)
+		if !ok {
+			continue
+		}
+
+		keyPath := filepath.Join(scratch, key+".asc")
+		if err := os.WriteFile(keyPath, armored, 0644); err != nil {
+			errs = append(errs, fmt.Sprintf("Failed to import key %s: %v", key, err))
+			continue
+		}
+		if cmdResult := r.helper.Run(privileged.ImportKey(keyPath).In(r.target.Root)); cmdResult.Error != nil {
+			errs = append(errs, fmt.Sprintf("Failed to import key %s: %s", key, cmdResult.Stderr))
+		}
+	}
+	return errs
+}
+
+// armoredKey returns the armored public key block in content
+func armoredKey(content []byte) ([]byte, bool) {
+	begin := []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")
+	end := []byte("-----END PGP PUBLIC KEY BLOCK-----")
+	start := bytes.Index(content, begin)
+	if start < 0 {
+		return nil, false
+	}
+	length := bytes.Index(content[start:], end)
+	if length < 0 {
+		return nil, false
+	}
+	block := bytes.Clone(content[start : start+length+len(end)])
+	return append(block, '\n'), true
+}
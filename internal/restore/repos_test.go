@@ -0,0 +1,37 @@
+package restore
+
+import "testing"
+
+func TestReposDataValidate(t *testing.T) {
+	data := ReposData{
+		Repos: []RepoInfo{
+			{ID: "rpmfusion-free", Name: "RPM Fusion", FileName: "rpmfusion-free.repo"},
+			{ID: "--setopt=x", Name: "Option", FileName: "x.repo"},
+			{ID: "escape", Name: "\x1b[2J", FileName: "escape.repo"},
+			{ID: "traversal", Name: "Traversal", FileName: "../../sudoers.d/x"},
+		},
+		RepoFiles: []string{"rpmfusion-free.repo", "../passwd"},
+	}
+	rejected := data.Validate()
+
+	want := map[string]string{
+		"--setopt=x": "repos.json repos",
+		"escape":     "repos.json repos",
+		"traversal":  "repos.json repos",
+		"../passwd":  "repos.json",
+	}
+	if len(rejected) != len(want) {
+		t.Errorf("rejected %v, want %d entries", rejected, len(want))
+	}
+	for _, r := range rejected {
+		if field, ok := want[r.Value]; !ok || r.Field != field || r.Reason == "" {
+			t.Errorf("unexpected rejection %v", r)
+		}
+	}
+	if len(data.Repos) != 1 || data.Repos[0].ID != "rpmfusion-free" {
+		t.Errorf("kept repos %v, want only rpmfusion-free", data.Repos)
+	}
+	if len(data.RepoFiles) != 1 || data.RepoFiles[0] != "rpmfusion-free.repo" {
+		t.Errorf("kept files %v, want only rpmfusion-free.repo", data.RepoFiles)
+	}
+}
@@ -0,0 +1,240 @@
+package restore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// ResumeState is the progress of a Quick Save restore, saved after every
+// item so a restore cut short by a closed terminal or a reboot can pick up
+// where it stopped. Items that were restored are skipped on resume and
+// failed ones are tried again. A nil *ResumeState tracks nothing.
+type ResumeState struct {
+	Source      string                   `json:"source"`     // Backup being restored
+	Components  []RestoreType            `json:"components"` // What was selected
+	Target      Target                   `json:"target,omitzero"`
+	PinFlatpaks bool                     `json:"pin_flatpaks,omitempty"`
+	StartedAt   time.Time                `json:"started_at"`
+	UpdatedAt   time.Time                `json:"updated_at"`
+	Done        map[RestoreType][]string `json:"done,omitempty"`
+	Failed      map[RestoreType][]string `json:"failed,omitempty"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// ErrNoResumeState is returned when there is no interrupted restore
+var ErrNoResumeState = errors.New("no restore to resume")
+
+// resumeStatePath returns where the state is kept (~/.config/rego/restore-state.json)
+func resumeStatePath() (string, error) {
+	configDir, err := utils.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "restore-state.json"), nil
+}
+
+// NewResumeState starts tracking a restore of components from source,
+// replacing any earlier state
+func NewResumeState(source string, components []RestoreType) (*ResumeState, error) {
+	path, err := resumeStatePath()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s := &ResumeState{
+		Source:     source,
+		Components: components,
+		StartedAt:  now,
+		UpdatedAt:  now,
+		Done:       make(map[RestoreType][]string),
+		Failed:     make(map[RestoreType][]string),
+		path:       path,
+	}
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetTarget records the root filesystem and home directory being restored
+// into, so resuming restores into the same place
+func (s *ResumeState) SetTarget(t Target) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Target = t
+	return s.save()
+}
+
+// SetPinFlatpaks records that Flatpak apps are moved back to the commit
+// they were backed up at, so resuming does the same
+func (s *ResumeState) SetPinFlatpaks(pin bool) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PinFlatpaks = pin
+	return s.save()
+}
+
+// LoadResumeState reads the state of the interrupted restore, or returns
+// ErrNoResumeState
+func LoadResumeState() (*ResumeState, error) {
+	path, err := resumeStatePath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoResumeState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore state: %w", err)
+	}
+	s := &ResumeState{path: path}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, fmt.Errorf("failed to parse restore state: %w", err)
+	}
+	if s.Done == nil {
+		s.Done = make(map[RestoreType][]string)
+	}
+	if s.Failed == nil {
+		s.Failed = make(map[RestoreType][]string)
+	}
+	return s, nil
+}
+
+// Counts returns how many items were restored and how many failed
+func (s *ResumeState) Counts() (done, failed int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, items := range s.Done {
+		done += len(items)
+	}
+	for _, items := range s.Failed {
+		failed += len(items)
+	}
+	return done, failed
+}
+
+// IsDone reports whether item of component was already restored
+func (s *ResumeState) IsDone(component RestoreType, item string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.Done[component], item)
+}
+
+// Pending returns the items of component that still need restoring
+func (s *ResumeState) Pending(component RestoreType, items []string) []string {
+	if s == nil {
+		return items
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []string
+	for _, item := range items {
+		if !slices.Contains(s.Done[component], item) {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// Mark records whether items of component were restored
+func (s *ResumeState) Mark(component RestoreType, items []string, ok bool) {
+	if s == nil || len(items) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		s.Failed[component] = slices.DeleteFunc(s.Failed[component], func(f string) bool { return f == item })
+		if ok {
+			if !slices.Contains(s.Done[component], item) {
+				s.Done[component] = append(s.Done[component], item)
+			}
+		} else {
+			s.Failed[component] = append(s.Failed[component], item)
+		}
+		if len(s.Failed[component]) == 0 {
+			delete(s.Failed, component)
+		}
+	}
+	if err := s.save(); err != nil {
+		utils.Warn("Failed to save restore state: %v", err)
+	}
+}
+
+// MarkItems records the outcome of each item of component
+func (s *ResumeState) MarkItems(component RestoreType, items []ItemResult) {
+	var ok, failed []string
+	for _, item := range items {
+		if item.OK() {
+			ok = append(ok, item.Name)
+		} else {
+			failed = append(failed, item.Name)
+		}
+	}
+	s.Mark(component, ok, true)
+	s.Mark(component, failed, false)
+}
+
+// Finish ends the restore. The state is removed if everything was
+// restored; otherwise it is kept so the failed items are offered again.
+func (s *ResumeState) Finish() error {
+	if s == nil {
+		return nil
+	}
+	if _, failed := s.Counts(); failed > 0 {
+		return nil
+	}
+	return s.Discard()
+}
+
+// Discard removes the state, so the next restore starts over
+func (s *ResumeState) Discard() error {
+	if s == nil {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// save writes the state atomically. Called with mu held, or before the
+// state is shared.
+func (s *ResumeState) save() error {
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.EnsureDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -5,20 +5,40 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
 	"github.com/r8bert/rego/internal/utils"
 )
 
 // RPMRestore handles RPM package restoration
-type RPMRestore struct{}
+type RPMRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
 
 // NewRPMRestore creates a new RPMRestore instance
 func NewRPMRestore() *RPMRestore {
 	return &RPMRestore{}
 }
 
+// SetJournal sets where installed packages are recorded for undo
+func (r *RPMRestore) SetJournal(j *Journal) {
+	r.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (r *RPMRestore) SetHelper(h *privileged.Helper) {
+	r.helper = h
+}
+
+// SetTarget sets the root filesystem dnf installs into
+func (r *RPMRestore) SetTarget(t Target) {
+	r.target = t
+}
+
 // Name returns the display name
 func (r *RPMRestore) Name() string {
 	return "RPM Packages"
@@ -29,26 +49,19 @@ func (r *RPMRestore) Type() RestoreType {
 	return RestoreTypeRPM
 }
 
+// DependsOn adds the third-party repos first so dnf can find the packages
+func (r *RPMRestore) DependsOn() []RestoreType {
+	return []RestoreType{RestoreTypeRepos}
+}
+
 // Available checks if DNF is available
 func (r *RPMRestore) Available() bool {
 	return utils.CommandExists("dnf")
 }
 
-// RPMData matches the backup structure
-type RPMData struct {
-	Packages      []RPMPackage `json:"packages"`
-	PackageCount  int          `json:"package_count"`
-	PackageMethod string       `json:"package_method"`
-}
-
-// RPMPackage represents an RPM package
-type RPMPackage struct {
-	Name string `json:"name"`
-}
-
 // Preview returns what would be restored
 func (r *RPMRestore) Preview(backupDir string) ([]string, error) {
-	data, err := r.loadBackupData(backupDir)
+	data, _, err := r.loadBackupData(backupDir)
 	if err != nil {
 		return nil, err
 	}
@@ -62,19 +75,19 @@ func (r *RPMRestore) Preview(backupDir string) ([]string, error) {
 }
 
 // loadBackupData loads the RPM backup data
-func (r *RPMRestore) loadBackupData(backupDir string) (*RPMData, error) {
+func (r *RPMRestore) loadBackupData(backupDir string) (*backup.RPMData, []backup.Rejection, error) {
 	dataPath := filepath.Join(backupDir, "rpm_packages.json")
 	content, err := os.ReadFile(dataPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read rpm backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to read rpm backup: %w", err)
 	}
 
-	var data RPMData
+	var data backup.RPMData
 	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse rpm backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse rpm backup: %w", err)
 	}
 
-	return &data, nil
+	return &data, data.Validate(), nil
 }
 
 // Restore performs the RPM package restoration
@@ -85,17 +98,18 @@ func (r *RPMRestore) Restore(backupDir string, dryRun bool) (RestoreResult, erro
 		DryRun:    dryRun,
 	}
 
-	data, err := r.loadBackupData(backupDir)
+	data, rejected, err := r.loadBackupData(backupDir)
 	if err != nil {
 		result.Errors = append(result.Errors, err.Error())
 		return result, err
 	}
 
-	result.ItemsTotal = len(data.Packages)
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Packages) + len(rejected)
 
 	if dryRun {
-		result.Success = true
-		result.ItemsSuccess = result.ItemsTotal
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
 		return result, nil
 	}
 
@@ -110,25 +124,12 @@ func (r *RPMRestore) Restore(backupDir string, dryRun bool) (RestoreResult, erro
 		return result, nil
 	}
 
-	// Install all packages in one go
-	args := append([]string{"install", "-y"}, packageNames...)
-	cmdResult := utils.RunCommandWithTimeout("dnf", 30*time.Minute, args...)
-
-	if cmdResult.Error != nil {
-		// Try to parse what succeeded and what failed
-		result.Errors = append(result.Errors, cmdResult.Stderr)
-
-		// Count failures from error message
-		for _, pkg := range packageNames {
-			if strings.Contains(cmdResult.Stderr, pkg) {
-				result.ItemsFailed++
-			} else {
-				result.ItemsSuccess++
-			}
-		}
-	} else {
-		result.ItemsSuccess = len(packageNames)
-	}
+	// Install everything the repositories have in one go
+	installed := r.journal.TrackInstall(RestoreTypeRPM, "dnf", packageNames)
+	result.AddItems(InstallItems("dnf", r.target.Root, packageNames, func(available []string) utils.CommandResult {
+		return r.helper.Run(privileged.Install("dnf", available).In(r.target.Root))
+	})...)
+	installed()
 
 	result.Success = result.ItemsFailed == 0
 	return result, nil
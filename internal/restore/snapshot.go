@@ -0,0 +1,212 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// Snapshot is a "pre-restore" backup of the parts of this system a restore
+// is about to change: a Quick Save of the package lists, extensions and
+// settings, and a Full Save scoped to the files. Both load like any other
+// backup, so a bad restore can be rolled back with `rego load`. A nil
+// *Snapshot takes nothing, which is what dry runs use.
+type Snapshot struct {
+	// Dir is where the snapshot lives (~/.config/rego/snapshots/pre-restore-<time>)
+	Dir string
+
+	mu    sync.Mutex
+	taken []RestoreType
+	quick *backup.LightBackup
+	fulls int
+}
+
+// packageTypes are the components that install with one package manager
+var packageTypes = []RestoreType{RestoreTypeRPM, RestoreTypeAPT, RestoreTypePacman, RestoreTypeAUR, RestoreTypeZypper}
+
+// Snapshot file names inside Dir
+const (
+	snapshotQuickName = "rego-pre-restore.json"
+	snapshotFullName  = "rego-pre-restore.tar.gz"
+)
+
+// NewSnapshot starts a pre-restore snapshot. Nothing is written to disk
+// until the first component is taken.
+func NewSnapshot() (*Snapshot, error) {
+	configDir, err := utils.GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	name := "pre-restore-" + time.Now().Format("2006-01-02-150405")
+	return &Snapshot{Dir: filepath.Join(configDir, "snapshots", name)}, nil
+}
+
+// Path returns where the snapshot lives, or "" if nothing was saved
+func (s *Snapshot) Path() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.taken) == 0 {
+		return ""
+	}
+	return s.Dir
+}
+
+// Take saves the current state of components that are not in the snapshot
+// yet. It is called before a restore writes anything, so the state saved
+// is the one from before the restore.
+func (s *Snapshot) Take(components ...RestoreType) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var todo []RestoreType
+	for _, c := range components {
+		// A snapshot of all packages covers each package manager
+		covered := slices.Contains(s.taken, RestoreTypePackages) && slices.Contains(packageTypes, c)
+		if !covered && !slices.Contains(s.taken, c) && !slices.Contains(todo, c) {
+			todo = append(todo, c)
+		}
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+
+	light, full, quick, archive := snapshotOptions(todo)
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	ctx := context.Background()
+	if quick {
+		b, err := backup.CreateLightBackupWithOptions(ctx, light)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", joinRestoreTypes(todo), err)
+		}
+		if s.quick == nil {
+			s.quick = b
+		} else {
+			mergeLightBackup(s.quick, b, todo)
+		}
+		if err := s.quick.SaveToFile(filepath.Join(s.Dir, snapshotQuickName)); err != nil {
+			return fmt.Errorf("failed to save snapshot: %w", err)
+		}
+	}
+
+	if archive {
+		// A Full Save can't be appended to, so later components get their own
+		name := snapshotFullName
+		if s.fulls > 0 {
+			name = fmt.Sprintf("rego-pre-restore-%d.tar.gz", s.fulls+1)
+		}
+		if _, err := backup.CreateFullBackup(ctx, full, filepath.Join(s.Dir, name)); err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", joinRestoreTypes(todo), err)
+		}
+		s.fulls++
+	}
+
+	s.taken = append(s.taken, todo...)
+	return nil
+}
+
+// snapshotOptions returns what a Quick Save and a Full Save must include to
+// cover components. Package lists, extensions and settings go in the Quick
+// Save; files, and the repo files that can't be recreated from a name, go
+// in the Full Save. The booleans say whether each is needed at all.
+func snapshotOptions(components []RestoreType) (light backup.LightBackupOptions, full backup.FullBackupOptions, quick, archive bool) {
+	for _, c := range components {
+		switch c {
+		case RestoreTypeFlatpak, RestoreTypeFlatpakRemotes:
+			light.Flatpaks = true
+		case RestoreTypeRPM, RestoreTypeAPT, RestoreTypePacman, RestoreTypeAUR, RestoreTypeZypper, RestoreTypePackages:
+			light.RPM = true
+		case RestoreTypeRepos:
+			light.Repos = true
+		case RestoreTypeZypperRepos, RestoreTypeAPTSources:
+			light.Repos = true
+			full.Repos = true
+		case RestoreTypeGnomeExtensions:
+			light.Extensions = true
+		case RestoreTypeGnomeSettings:
+			light.Settings = true
+		case RestoreTypeDotfiles:
+			full.Dotfiles = true
+		case RestoreTypeFonts:
+			full.Fonts = true
+		case RestoreTypeSSH:
+			full.SSHConfig = true
+		case RestoreTypeAutostart:
+			full.Autostart = true
+		case RestoreTypeBackgrounds:
+			full.Backgrounds = true
+		case RestoreTypeThemes:
+			full.Themes = true
+		case RestoreTypeKDEConfig:
+			full.KDEConfig = true
+		case RestoreTypeKDEData:
+			full.KDEData = true
+		}
+	}
+	quick = light.Flatpaks || light.RPM || light.Repos || light.Extensions || light.Settings
+	archive = full.Repos || full.Dotfiles || full.Fonts || full.SSHConfig || full.Autostart ||
+		full.Backgrounds || full.Themes || full.KDEConfig || full.KDEData
+	return light, full, quick, archive
+}
+
+// mergeLightBackup copies the parts of src that cover components into dst.
+// Lists saved for other components are kept, since they were taken before
+// those components were restored.
+func mergeLightBackup(dst, src *backup.LightBackup, components []RestoreType) {
+	for _, c := range components {
+		switch c {
+		case RestoreTypeFlatpak, RestoreTypeFlatpakRemotes:
+			dst.Flatpaks = src.Flatpaks
+		case RestoreTypeRPM:
+			dst.RPMPackages = src.RPMPackages
+		case RestoreTypeAPT:
+			dst.APTPackages = src.APTPackages
+		case RestoreTypePacman:
+			dst.PacmanPackages = src.PacmanPackages
+		case RestoreTypeAUR:
+			dst.AURPackages = src.AURPackages
+		case RestoreTypeZypper:
+			dst.ZypperPackages = src.ZypperPackages
+		case RestoreTypePackages:
+			dst.RPMPackages = src.RPMPackages
+			dst.APTPackages = src.APTPackages
+			dst.PacmanPackages = src.PacmanPackages
+			dst.AURPackages = src.AURPackages
+			dst.ZypperPackages = src.ZypperPackages
+		case RestoreTypeRepos, RestoreTypeZypperRepos, RestoreTypeAPTSources:
+			dst.Repos = src.Repos
+			dst.ZypperRepos = src.ZypperRepos
+		case RestoreTypeGnomeExtensions:
+			dst.GnomeExtensions = src.GnomeExtensions
+		case RestoreTypeGnomeSettings:
+			dst.DconfSettings = src.DconfSettings
+		}
+	}
+}
+
+// joinRestoreTypes returns the display names of types, comma separated
+func joinRestoreTypes(types []RestoreType) string {
+	s := ""
+	for i, t := range types {
+		if i > 0 {
+			s += ", "
+		}
+		s += RestoreTypeName(t)
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package restore
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/r8bert/rego/internal/backup"
+)
+
+func TestMergeLightBackup(t *testing.T) {
+	before := func() *backup.LightBackup {
+		return &backup.LightBackup{PacmanPackages: []string{"vim"}, AURPackages: []string{"yay"}, Flatpaks: []string{"org.gnome.Maps"}}
+	}
+	now := &backup.LightBackup{PacmanPackages: []string{"vim", "git"}, AURPackages: []string{"yay", "paru"}, Flatpaks: nil}
+
+	tests := []struct {
+		name              string
+		components        []RestoreType
+		pacman, aur, apps []string
+	}{
+		{"pacman only", []RestoreType{RestoreTypePacman}, now.PacmanPackages, []string{"yay"}, []string{"org.gnome.Maps"}},
+		{"AUR only", []RestoreType{RestoreTypeAUR}, []string{"vim"}, now.AURPackages, []string{"org.gnome.Maps"}},
+		{"all packages", []RestoreType{RestoreTypePackages}, now.PacmanPackages, now.AURPackages, []string{"org.gnome.Maps"}},
+		{"flatpaks", []RestoreType{RestoreTypeFlatpak}, []string{"vim"}, []string{"yay"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := before()
+			mergeLightBackup(dst, now, tt.components)
+			if !slices.Equal(dst.PacmanPackages, tt.pacman) {
+				t.Errorf("pacman packages = %v, want %v", dst.PacmanPackages, tt.pacman)
+			}
+			if !slices.Equal(dst.AURPackages, tt.aur) {
+				t.Errorf("AUR packages = %v, want %v", dst.AURPackages, tt.aur)
+			}
+			if !slices.Equal(dst.Flatpaks, tt.apps) {
+				t.Errorf("flatpaks = %v, want %v", dst.Flatpaks, tt.apps)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// Target is what a restore changes. The zero Target is the running system
+// and the current user's home directory.
+//
+// Root is a root filesystem mounted somewhere else, such as a fresh install
+// seen from a live USB: packages are installed into it with dnf
+// --installroot and the like, Flatpaks go to its system installation and
+// repository files are written below it. Home is a home directory other
+// than the current user's, such as a new user's before their first login;
+// it defaults to the current user's home directory inside Root.
+type Target struct {
+	Root string `json:"root,omitempty"`
+	Home string `json:"home,omitempty"`
+}
+
+// NewTarget returns the target for a root filesystem and home directory,
+// either of which may be "". Both must be existing directories, and only
+// root may restore into a home directory that belongs to someone else.
+func NewTarget(root, home string) (Target, error) {
+	var t Target
+	for _, p := range []struct {
+		path  *string
+		value string
+		what  string
+	}{{&t.Root, root, "target root"}, {&t.Home, home, "target home"}} {
+		if p.value == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p.value)
+		if err != nil {
+			return Target{}, err
+		}
+		if !utils.DirExists(abs) {
+			return Target{}, fmt.Errorf("%s %s is not a directory", p.what, p.value)
+		}
+		*p.path = abs
+	}
+	if t.Root == "/" {
+		t.Root = ""
+	}
+	if home, err := t.HomeDir(); err == nil && os.Geteuid() != 0 {
+		if _, _, other := otherOwner(home); other {
+			return Target{}, fmt.Errorf("target home %s belongs to another user; restore into it as that user or as root", home)
+		}
+	}
+	return t, nil
+}
+
+// LiveSystem reports whether packages and system files go to the running
+// system
+func (t Target) LiveSystem() bool {
+	return t.Root == ""
+}
+
+// LiveHome reports whether files and settings go to the current user's
+// home directory, where dconf, gnome-extensions and fc-cache act
+func (t Target) LiveHome() bool {
+	home, err := t.HomeDir()
+	current, currentErr := utils.GetHomeDir()
+	return err == nil && currentErr == nil && home == current
+}
+
+// Live reports whether t is the running system and the current user's home
+func (t Target) Live() bool {
+	return t.LiveSystem() && t.LiveHome()
+}
+
+// HomeDir returns the home directory to restore into
+func (t Target) HomeDir() (string, error) {
+	if t.Home != "" {
+		return t.Home, nil
+	}
+	home, err := utils.GetHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if t.Root == "" {
+		return home, nil
+	}
+	return filepath.Join(t.Root, home), nil
+}
+
+// Own gives path, everything below it and the directories between it and
+// the home directory to the owner of the home directory, if that is not
+// whoever runs ReGo. Root restoring into a new user's home leaves nothing
+// there the user can't change.
+func (t Target) Own(path string) error {
+	home, err := t.HomeDir()
+	if err != nil {
+		return err
+	}
+	uid, gid, other := otherOwner(home)
+	if !other || !isWithin(home, path) {
+		return nil
+	}
+	err = filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to give %s to the owner of %s: %w", path, home, err)
+	}
+	for dir := filepath.Dir(path); dir != home && isWithin(home, dir); dir = filepath.Dir(dir) {
+		if err := os.Lchown(dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to give %s to the owner of %s: %w", dir, home, err)
+		}
+	}
+	return nil
+}
+
+// otherOwner returns the user and group that own dir, and whether that is
+// someone other than whoever runs ReGo
+func otherOwner(dir string) (int, int, bool) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0, 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) == os.Geteuid() {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// SystemPath returns where the system path p is in the target
+func (t Target) SystemPath(p string) string {
+	if t.Root == "" {
+		return p
+	}
+	return filepath.Join(t.Root, p)
+}
+
+// String describes the target for messages
+func (t Target) String() string {
+	home, _ := t.HomeDir()
+	switch {
+	case t.Live():
+		return "this system"
+	case t.LiveSystem():
+		return home
+	case t.Home == "":
+		return t.Root
+	}
+	return fmt.Sprintf("%s with home %s", t.Root, home)
+}
@@ -2,6 +2,8 @@ package restore
 
 import (
 	"time"
+
+	"github.com/r8bert/rego/internal/privileged"
 )
 
 // RestoreType mirrors backup types for consistency
@@ -9,39 +11,128 @@ type RestoreType string
 
 const (
 	RestoreTypeFlatpak         RestoreType = "flatpak"
+	RestoreTypeFlatpakRemotes  RestoreType = "flatpak_remotes"
 	RestoreTypeRPM             RestoreType = "rpm"
 	RestoreTypeRepos           RestoreType = "repos"
 	RestoreTypeGnomeExtensions RestoreType = "gnome_extensions"
 	RestoreTypeGnomeSettings   RestoreType = "gnome_settings"
 	RestoreTypeDotfiles        RestoreType = "dotfiles"
 	RestoreTypeFonts           RestoreType = "fonts"
+	RestoreTypeZypper          RestoreType = "zypper"
+	RestoreTypeZypperRepos     RestoreType = "zypper_repos"
+	RestoreTypeAPT             RestoreType = "apt"
+	RestoreTypeAPTSources      RestoreType = "apt_sources"
+	RestoreTypePacman          RestoreType = "pacman"
+	RestoreTypeAUR             RestoreType = "aur"
+
+	// Sections that only exist in Full Save archives
+	RestoreTypePackages    RestoreType = "packages"
+	RestoreTypeSSH         RestoreType = "ssh"
+	RestoreTypeAutostart   RestoreType = "autostart"
+	RestoreTypeBackgrounds RestoreType = "backgrounds"
+	RestoreTypeThemes      RestoreType = "themes"
+	RestoreTypeKDEConfig   RestoreType = "kde_config"
+	RestoreTypeKDEData     RestoreType = "kde_data"
 )
 
 // RestoreResult holds the result of a restore operation
 type RestoreResult struct {
-	Type         RestoreType `json:"type"`
-	Success      bool        `json:"success"`
-	ItemsTotal   int         `json:"items_total"`
-	ItemsSuccess int         `json:"items_success"`
-	ItemsFailed  int         `json:"items_failed"`
-	Errors       []string    `json:"errors,omitempty"`
-	Timestamp    time.Time   `json:"timestamp"`
-	DryRun       bool        `json:"dry_run"`
+	Type         RestoreType  `json:"type"`
+	Success      bool         `json:"success"`
+	ItemsTotal   int          `json:"items_total"`
+	ItemsSuccess int          `json:"items_success"`
+	ItemsFailed  int          `json:"items_failed"`
+	Errors       []string     `json:"errors,omitempty"`
+	Items        []ItemResult `json:"items,omitempty"`
+	Timestamp    time.Time    `json:"timestamp"`
+	DryRun       bool         `json:"dry_run"`
+
+	// Dotfiles merged three ways with the distro skeleton: how many merged
+	// cleanly, and which were written with conflict markers to resolve
+	ItemsMerged     int      `json:"items_merged,omitempty"`
+	ItemsConflicted int      `json:"items_conflicted,omitempty"`
+	Conflicts       []string `json:"conflicts,omitempty"`
+}
+
+// ItemStatus is what happened to one package, app or extension
+type ItemStatus string
+
+const (
+	ItemInstalled   ItemStatus = "installed"
+	ItemSkipped     ItemStatus = "skipped"     // Restored already, nothing to do
+	ItemUnavailable ItemStatus = "unavailable" // No repository or remote has it
+	ItemFailed      ItemStatus = "failed"
+	ItemRejected    ItemStatus = "rejected" // Failed validation, never passed to a command
+)
+
+// ItemResult is the outcome of restoring one item
+type ItemResult struct {
+	Name   string     `json:"name"`
+	Status ItemStatus `json:"status"`
+	Reason string     `json:"reason,omitempty"` // Why it was skipped or failed
+	// Duration is how long the item took. Items installed by one command
+	// share that command's duration.
+	Duration time.Duration `json:"duration"`
+}
+
+// OK reports whether the item is in place after the restore
+func (i ItemResult) OK() bool {
+	return i.Status == ItemInstalled || i.Status == ItemSkipped
+}
+
+// AddItems records items and counts them as restored or failed
+func (r *RestoreResult) AddItems(items ...ItemResult) {
+	for _, item := range items {
+		r.Items = append(r.Items, item)
+		if item.OK() {
+			r.ItemsSuccess++
+		} else {
+			r.ItemsFailed++
+		}
+	}
+}
+
+// FailedItems returns the items that were not restored
+func (r RestoreResult) FailedItems() []ItemResult {
+	var failed []ItemResult
+	for _, item := range r.Items {
+		if !item.OK() {
+			failed = append(failed, item)
+		}
+	}
+	return failed
 }
 
 // RestoreOptions configures restore behavior
 type RestoreOptions struct {
 	BackupPath             string   `json:"backup_path"`
+	Source                 string   `json:"source,omitempty"` // Archive BackupPath was extracted from, for the undo journal
 	DryRun                 bool     `json:"dry_run"`
+	SkipSnapshot           bool     `json:"skip_snapshot,omitempty"` // Don't save a pre-restore snapshot first
 	IncludeFlatpak         bool     `json:"include_flatpak"`
+	IncludeFlatpakRemotes  bool     `json:"include_flatpak_remotes"`
 	IncludeRPM             bool     `json:"include_rpm"`
 	IncludeRepos           bool     `json:"include_repos"`
 	IncludeGnomeExtensions bool     `json:"include_gnome_extensions"`
 	IncludeGnomeSettings   bool     `json:"include_gnome_settings"`
 	IncludeDotfiles        bool     `json:"include_dotfiles"`
 	IncludeFonts           bool     `json:"include_fonts"`
+	IncludeZypper          bool     `json:"include_zypper"`
+	IncludeZypperRepos     bool     `json:"include_zypper_repos"`
+	IncludeAPT             bool     `json:"include_apt"`
+	IncludeAPTSources      bool     `json:"include_apt_sources"`
 	MergeDotfiles          bool     `json:"merge_dotfiles"`               // false = overwrite
 	SelectiveSettings      []string `json:"selective_settings,omitempty"` // Specific dconf paths
+	PinFlatpaks            bool     `json:"pin_flatpaks,omitempty"`       // Update Flatpak apps to their backed up commit
+
+	// DotfileChoices decides per file what happens to existing dotfiles,
+	// by path relative to the home directory, ahead of MergeDotfiles
+	DotfileChoices map[string]DotfileChoice `json:"dotfile_choices,omitempty"`
+
+	// TargetRoot and TargetHome restore into a mounted root filesystem or
+	// another home directory instead of the running system, see Target
+	TargetRoot string `json:"target_root,omitempty"`
+	TargetHome string `json:"target_home,omitempty"`
 }
 
 // DefaultRestoreOptions returns sensible defaults
@@ -49,12 +140,17 @@ func DefaultRestoreOptions() RestoreOptions {
 	return RestoreOptions{
 		DryRun:                 true, // Default to dry run for safety
 		IncludeFlatpak:         true,
+		IncludeFlatpakRemotes:  true,
 		IncludeRPM:             true,
 		IncludeRepos:           true,
 		IncludeGnomeExtensions: true,
 		IncludeGnomeSettings:   true,
 		IncludeDotfiles:        true,
 		IncludeFonts:           true,
+		IncludeZypper:          true,
+		IncludeZypperRepos:     true,
+		IncludeAPT:             true,
+		IncludeAPTSources:      true,
 		MergeDotfiles:          false,
 	}
 }
@@ -71,14 +167,55 @@ type Restorer interface {
 	Preview(backupDir string) ([]string, error)
 	// Restore performs the restore from the backup directory
 	Restore(backupDir string, dryRun bool) (RestoreResult, error)
+	// DependsOn returns the components that must be restored first when
+	// they are part of the same restore
+	DependsOn() []RestoreType
+}
+
+// Journaled is implemented by restorers that record their changes in an
+// undo journal. Manager sets the journal before a restore that is not a dry run.
+type Journaled interface {
+	SetJournal(j *Journal)
+}
+
+// Elevated is implemented by restorers that install packages or write
+// system files, which they do through the privileged helper. Manager sets
+// the helper before a restore.
+type Elevated interface {
+	SetHelper(h *privileged.Helper)
+}
+
+// Targeted is implemented by restorers that can restore into another root
+// filesystem or home directory. Manager sets the target before it plans a
+// restore.
+type Targeted interface {
+	SetTarget(t Target)
+}
+
+// NeedsPrivileges reports whether restoring any of types installs packages
+// or writes system files
+func NeedsPrivileges(types []RestoreType) bool {
+	for _, t := range types {
+		switch t {
+		case RestoreTypeRPM, RestoreTypeRepos, RestoreTypeZypper, RestoreTypeZypperRepos,
+			RestoreTypeAPT, RestoreTypeAPTSources, RestoreTypePacman, RestoreTypePackages:
+			return true
+		}
+	}
+	return false
 }
 
 // AllRestoreTypes returns all restore types
 func AllRestoreTypes() []RestoreType {
 	return []RestoreType{
+		RestoreTypeFlatpakRemotes,
 		RestoreTypeFlatpak,
 		RestoreTypeRPM,
 		RestoreTypeRepos,
+		RestoreTypeZypperRepos,
+		RestoreTypeZypper,
+		RestoreTypeAPTSources,
+		RestoreTypeAPT,
 		RestoreTypeGnomeExtensions,
 		RestoreTypeGnomeSettings,
 		RestoreTypeDotfiles,
@@ -90,12 +227,26 @@ func AllRestoreTypes() []RestoreType {
 func RestoreTypeName(t RestoreType) string {
 	names := map[RestoreType]string{
 		RestoreTypeFlatpak:         "Flatpak Applications",
+		RestoreTypeFlatpakRemotes:  "Flatpak Remotes",
 		RestoreTypeRPM:             "RPM Packages",
 		RestoreTypeRepos:           "DNF Repositories",
 		RestoreTypeGnomeExtensions: "GNOME Extensions",
 		RestoreTypeGnomeSettings:   "GNOME Settings",
 		RestoreTypeDotfiles:        "Dotfiles",
 		RestoreTypeFonts:           "User Fonts",
+		RestoreTypeZypper:          "Zypper Packages",
+		RestoreTypeZypperRepos:     "Zypper Repositories",
+		RestoreTypeAPT:             "APT Packages",
+		RestoreTypeAPTSources:      "APT Sources",
+		RestoreTypePacman:          "Pacman Packages",
+		RestoreTypeAUR:             "AUR Packages",
+		RestoreTypePackages:        "System Packages",
+		RestoreTypeSSH:             "SSH Config",
+		RestoreTypeAutostart:       "Autostart Apps",
+		RestoreTypeBackgrounds:     "Wallpapers",
+		RestoreTypeThemes:          "GTK Themes",
+		RestoreTypeKDEConfig:       "KDE Plasma Config",
+		RestoreTypeKDEData:         "KDE Themes/Widgets",
 	}
 	if name, ok := names[t]; ok {
 		return name
@@ -0,0 +1,300 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/privileged"
+	"github.com/r8bert/rego/internal/utils"
+)
+
+// ZypperRestore handles zypper package restoration
+type ZypperRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
+
+// NewZypperRestore creates a new ZypperRestore instance
+func NewZypperRestore() *ZypperRestore {
+	return &ZypperRestore{}
+}
+
+// SetJournal sets where installed packages are recorded for undo
+func (z *ZypperRestore) SetJournal(j *Journal) {
+	z.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (z *ZypperRestore) SetHelper(h *privileged.Helper) {
+	z.helper = h
+}
+
+// SetTarget sets the root filesystem zypper installs into
+func (z *ZypperRestore) SetTarget(t Target) {
+	z.target = t
+}
+
+// Name returns the display name
+func (z *ZypperRestore) Name() string {
+	return "Zypper Packages"
+}
+
+// Type returns the restore type
+func (z *ZypperRestore) Type() RestoreType {
+	return RestoreTypeZypper
+}
+
+// DependsOn adds the repos first so zypper can find the packages
+func (z *ZypperRestore) DependsOn() []RestoreType {
+	return []RestoreType{RestoreTypeZypperRepos}
+}
+
+// Available checks if zypper is available
+func (z *ZypperRestore) Available() bool {
+	return utils.CommandExists("zypper")
+}
+
+// Preview returns what would be restored
+func (z *ZypperRestore) Preview(backupDir string) ([]string, error) {
+	data, _, err := z.loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for _, pkg := range data.Packages {
+		items = append(items, pkg.Name)
+	}
+	return items, nil
+}
+
+// loadBackupData loads the zypper backup data
+func (z *ZypperRestore) loadBackupData(backupDir string) (*backup.ZypperData, []backup.Rejection, error) {
+	content, err := os.ReadFile(filepath.Join(backupDir, "zypper_packages.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read zypper backup: %w", err)
+	}
+
+	var data backup.ZypperData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse zypper backup: %w", err)
+	}
+	return &data, data.Validate(), nil
+}
+
+// Restore performs the zypper package restoration
+func (z *ZypperRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
+	result := RestoreResult{
+		Type:      RestoreTypeZypper,
+		Timestamp: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	data, rejected, err := z.loadBackupData(backupDir)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Packages) + len(rejected)
+
+	if dryRun {
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
+		return result, nil
+	}
+
+	var packageNames []string
+	for _, pkg := range data.Packages {
+		packageNames = append(packageNames, pkg.Name)
+	}
+
+	if len(packageNames) == 0 {
+		result.Success = true
+		return result, nil
+	}
+
+	installed := z.journal.TrackInstall(RestoreTypeZypper, "zypper", packageNames)
+	result.AddItems(InstallItems("zypper", z.target.Root, packageNames, func(available []string) utils.CommandResult {
+		return z.helper.Run(privileged.Install("zypper", available).In(z.target.Root))
+	})...)
+	installed()
+
+	result.Success = result.ItemsFailed == 0
+	return result, nil
+}
+
+// ZypperReposRestore handles zypper repository restoration
+type ZypperReposRestore struct {
+	journal *Journal
+	helper  *privileged.Helper
+	target  Target
+}
+
+// NewZypperReposRestore creates a new ZypperReposRestore instance
+func NewZypperReposRestore() *ZypperReposRestore {
+	return &ZypperReposRestore{}
+}
+
+// SetJournal sets where added repo files are recorded for undo
+func (z *ZypperReposRestore) SetJournal(j *Journal) {
+	z.journal = j
+}
+
+// SetHelper sets what runs the commands that need root
+func (z *ZypperReposRestore) SetHelper(h *privileged.Helper) {
+	z.helper = h
+}
+
+// SetTarget sets the root filesystem the repositories are added to
+func (z *ZypperReposRestore) SetTarget(t Target) {
+	z.target = t
+}
+
+// Name returns the display name
+func (z *ZypperReposRestore) Name() string {
+	return "Zypper Repositories"
+}
+
+// Type returns the restore type
+func (z *ZypperReposRestore) Type() RestoreType {
+	return RestoreTypeZypperRepos
+}
+
+// DependsOn returns nothing, repos and their keys come first
+func (z *ZypperReposRestore) DependsOn() []RestoreType {
+	return nil
+}
+
+// Available checks if zypper is available
+func (z *ZypperReposRestore) Available() bool {
+	return utils.CommandExists("zypper")
+}
+
+// Preview returns what would be restored
+func (z *ZypperReposRestore) Preview(backupDir string) ([]string, error) {
+	data, _, err := z.loadBackupData(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for _, repo := range data.Repos {
+		items = append(items, fmt.Sprintf("%s (priority %d)", repo.Alias, repo.Priority))
+	}
+	return items, nil
+}
+
+// loadBackupData loads the zypper repos backup data
+func (z *ZypperReposRestore) loadBackupData(backupDir string) (*backup.ZypperReposData, []backup.Rejection, error) {
+	content, err := os.ReadFile(filepath.Join(backupDir, "zypper_repos.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read zypper repos backup: %w", err)
+	}
+
+	var data backup.ZypperReposData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse zypper repos backup: %w", err)
+	}
+	return &data, data.Validate(), nil
+}
+
+// Restore imports the saved GPG keys and adds every repository that is not
+// configured yet
+func (z *ZypperReposRestore) Restore(backupDir string, dryRun bool) (RestoreResult, error) {
+	result := RestoreResult{
+		Type:      RestoreTypeZypperRepos,
+		Timestamp: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	data, rejected, err := z.loadBackupData(backupDir)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	result.AddItems(RejectedItems(rejected)...)
+	result.ItemsTotal = len(data.Repos) + len(rejected)
+
+	if dryRun {
+		result.Success = result.ItemsFailed == 0
+		result.ItemsSuccess = result.ItemsTotal - result.ItemsFailed
+		return result, nil
+	}
+
+	// Keys first, since the refresh below only trusts keys already imported
+	for _, key := range data.GPGKeys {
+		keyPath := filepath.Join(backupDir, "zypp-keys", key)
+		if !utils.FileExists(keyPath) {
+			continue
+		}
+		if cmdResult := z.helper.Run(privileged.ImportKey(keyPath).In(z.target.Root)); cmdResult.Error != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to import key %s: %s", key, cmdResult.Stderr))
+		}
+	}
+
+	result.AddItems(addZypperRepos(data.Repos, z.target, z.helper, z.journal)...)
+
+	result.Success = result.ItemsFailed == 0
+	return result, nil
+}
+
+// addZypperRepos adds the repositories that t does not have yet, then
+// refreshes, all through h. Existing aliases are skipped. zypper imports no
+// keys on its own, so repositories whose keys are neither saved in the
+// backup nor given as a file or https URL fail to refresh. The .repo file
+// zypper writes for each one is recorded in j.
+func addZypperRepos(repos []backup.ZypperRepo, t Target, h *privileged.Helper, j *Journal) []ItemResult {
+	existing := make(map[string]bool)
+	current, _ := backup.ListZypperReposIn(t.Root)
+	for _, repo := range current {
+		existing[repo.Alias] = true
+	}
+
+	var items []ItemResult
+	added := false
+	for _, repo := range repos {
+		if existing[repo.Alias] {
+			items = append(items, ItemResult{Name: repo.Alias, Status: ItemSkipped, Reason: "already configured"})
+			continue
+		}
+
+		// The helper only adds signed https repositories
+		add := privileged.AddRepo(repo).In(t.Root)
+		if err := add.Validate(); err != nil {
+			items = append(items, ItemResult{Name: repo.Alias, Status: ItemRejected, Reason: err.Error()})
+			continue
+		}
+
+		// Keys given as files or https URLs are imported before the repo is
+		// trusted; the helper refuses any other
+		if repo.GPGKey != "" {
+			for _, key := range strings.Fields(repo.GPGKey) {
+				h.Run(privileged.ImportKey(key).In(t.Root))
+			}
+		}
+
+		if err := j.RecordFile(RestoreTypeZypperRepos, t.SystemPath(filepath.Join("/etc/zypp/repos.d", repo.Alias+".repo"))); err != nil {
+			items = append(items, ItemResult{Name: repo.Alias, Status: ItemFailed, Reason: err.Error()})
+			continue
+		}
+
+		start := time.Now()
+		item := CommandItem(repo.Alias, h.Run(add), time.Since(start))
+		added = added || item.OK()
+		items = append(items, item)
+	}
+
+	if added {
+		h.Run(privileged.Refresh("zypper").In(t.Root))
+	}
+	return items
+}
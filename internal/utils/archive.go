@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnsafeArchive is returned when an archive entry would be written
+// outside the extraction root or breaks an extraction limit
+var ErrUnsafeArchive = errors.New("unsafe archive")
+
+// ExtractLimits bounds what an archive may unpack to. Zero means no limit.
+type ExtractLimits struct {
+	MaxFileSize  int64 // Largest single file, in bytes
+	MaxTotalSize int64 // Sum of all file sizes, in bytes
+	MaxEntries   int   // Number of entries of any type
+}
+
+// DefaultExtractLimits returns limits generous enough for any real backup
+func DefaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxFileSize:  4 << 30,
+		MaxTotalSize: 32 << 30,
+		MaxEntries:   1000000,
+	}
+}
+
+// ExtractTarGz unpacks a gzip-compressed tar stream into destDir with
+// ExtractTar
+func ExtractTarGz(r io.Reader, destDir string, limits ExtractLimits) error {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzReader.Close()
+	return ExtractTar(gzReader, destDir, limits)
+}
+
+// ExtractTar unpacks a tar stream into destDir. Entries with absolute paths
+// or ".." components are rejected, as are symlinks pointing outside destDir
+// and entries below a symlink. Regular files, directories and symlinks keep
+// their permission bits and mtimes; setuid, setgid and sticky bits are
+// dropped, and other entry types (devices, FIFOs, hard links) are skipped.
+func ExtractTar(r io.Reader, destDir string, limits ExtractLimits) error {
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if err := EnsureDir(root); err != nil {
+		return err
+	}
+
+	type dirMeta struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirMeta
+	var total int64
+	entries := 0
+
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entries++
+		if limits.MaxEntries > 0 && entries > limits.MaxEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrUnsafeArchive, limits.MaxEntries)
+		}
+
+		target, err := extractPath(root, header.Name)
+		if err != nil {
+			return err
+		}
+		if target == root {
+			continue
+		}
+		if err := checkNoSymlinkParents(root, target); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := removeIfNotDir(target); err != nil {
+				return err
+			}
+			// Keep directories writable until everything is unpacked
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMeta{target, mode, header.ModTime})
+
+		case tar.TypeReg:
+			if limits.MaxFileSize > 0 && header.Size > limits.MaxFileSize {
+				return fmt.Errorf("%w: %s is larger than %d bytes", ErrUnsafeArchive, header.Name, limits.MaxFileSize)
+			}
+			total += header.Size
+			if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+				return fmt.Errorf("%w: contents are larger than %d bytes", ErrUnsafeArchive, limits.MaxTotalSize)
+			}
+			if err := writeEntry(target, tarReader, header.Size, mode); err != nil {
+				return err
+			}
+			os.Chtimes(target, header.ModTime, header.ModTime)
+
+		case tar.TypeSymlink:
+			// A cleaned target only has ".." at its start, where it walks up
+			// real directories, so it cannot step back out through a symlink
+			linkname := filepath.Clean(header.Linkname)
+			if err := checkSymlinkTarget(root, target, linkname); err != nil {
+				return err
+			}
+			if err := EnsureDir(filepath.Dir(target)); err != nil {
+				return err
+			}
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Apply directory modes and mtimes last, deepest first, since creating
+	// their contents changed them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, dirs[i].mode|0700)
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return nil
+}
+
+// extractPath returns where an entry named name goes under root
+func extractPath(root, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: absolute path %q", ErrUnsafeArchive, name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: path %q leaves the archive root", ErrUnsafeArchive, name)
+		}
+	}
+	return filepath.Join(root, name), nil
+}
+
+// checkNoSymlinkParents makes sure no directory between root and target is
+// a symlink, so an earlier entry cannot redirect a later one
+func checkNoSymlinkParents(root, target string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below a symlink", ErrUnsafeArchive, target)
+		}
+	}
+	return nil
+}
+
+// checkSymlinkTarget rejects symlinks that are absolute or resolve outside
+// root
+func checkSymlinkTarget(root, target, linkname string) error {
+	if linkname == "." || filepath.IsAbs(linkname) {
+		return fmt.Errorf("%w: symlink %s points to %q", ErrUnsafeArchive, target, linkname)
+	}
+	resolved := filepath.Join(filepath.Dir(target), linkname)
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return fmt.Errorf("%w: symlink %s points outside the archive", ErrUnsafeArchive, target)
+	}
+	return nil
+}
+
+// writeEntry writes exactly size bytes from r to a new file at target
+func writeEntry(target string, r io.Reader, size int64, mode os.FileMode) error {
+	if err := EnsureDir(filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(out, r, size); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// The umask may have masked bits off at create time
+	return os.Chmod(target, mode)
+}
+
+// removeExisting deletes a file or symlink left by an earlier entry with the
+// same name, so nothing is written through it
+func removeExisting(target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %s is both a directory and a file", ErrUnsafeArchive, target)
+	}
+	return os.Remove(target)
+}
+
+// removeIfNotDir deletes a file or symlink where a directory is expected
+func removeIfNotDir(target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(target)
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is one entry of a test archive. Typeflag defaults to a regular
+// file holding Body.
+type tarEntry struct {
+	Name     string
+	Typeflag byte
+	Linkname string
+	Body     string
+	Mode     int64
+}
+
+// buildTar returns a tar stream with entries in order
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.Name, Typeflag: e.Typeflag, Linkname: e.Linkname, Mode: e.Mode}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(e.Body))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		limits  ExtractLimits
+	}{
+		{"absolute path", []tarEntry{{Name: "/etc/passwd", Body: "x"}}, ExtractLimits{}},
+		{"parent directory", []tarEntry{{Name: "../escape", Body: "x"}}, ExtractLimits{}},
+		{"parent inside the path", []tarEntry{{Name: "a/../../escape", Body: "x"}}, ExtractLimits{}},
+		{"absolute directory", []tarEntry{{Name: "/tmp/escape/", Typeflag: tar.TypeDir}}, ExtractLimits{}},
+		{"absolute symlink", []tarEntry{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}, ExtractLimits{}},
+		{"symlink out of the root", []tarEntry{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../outside"}}, ExtractLimits{}},
+		{"nested symlink out of the root", []tarEntry{
+			{Name: "a/", Typeflag: tar.TypeDir},
+			{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+		}, ExtractLimits{}},
+		{"symlink that climbs back out", []tarEntry{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a/../../outside"}}, ExtractLimits{}},
+		{"symlink to the root itself", []tarEntry{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."}}, ExtractLimits{}},
+		{"file below a symlink", []tarEntry{
+			{Name: "sub/", Typeflag: tar.TypeDir},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "link/file", Body: "x"},
+		}, ExtractLimits{}},
+		{"file over a directory", []tarEntry{
+			{Name: "dir/", Typeflag: tar.TypeDir},
+			{Name: "dir", Body: "x"},
+		}, ExtractLimits{}},
+		{"too many entries", []tarEntry{{Name: "a", Body: "x"}, {Name: "b", Body: "x"}}, ExtractLimits{MaxEntries: 1}},
+		{"file too large", []tarEntry{{Name: "a", Body: "12345"}}, ExtractLimits{MaxFileSize: 4}},
+		{"contents too large", []tarEntry{{Name: "a", Body: "123"}, {Name: "b", Body: "123"}}, ExtractLimits{MaxTotalSize: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "root")
+			err := ExtractTar(bytes.NewReader(buildTar(t, tt.entries)), root, tt.limits)
+			if !errors.Is(err, ErrUnsafeArchive) {
+				t.Errorf("error = %v, want %v", err, ErrUnsafeArchive)
+			}
+			// Nothing may appear next to the extraction root
+			entries, _ := os.ReadDir(base)
+			for _, e := range entries {
+				if e.Name() != "root" {
+					t.Errorf("%s was written outside the root", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTarTruncated(t *testing.T) {
+	data := buildTar(t, []tarEntry{{Name: "a", Body: strings.Repeat("x", 4096)}})
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"inside the header", 100},
+		{"inside the contents", 512 + 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ExtractTar(bytes.NewReader(data[:tt.size]), t.TempDir(), ExtractLimits{})
+			if err == nil {
+				t.Error("extracted a truncated archive without an error")
+			}
+		})
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	root := t.TempDir()
+	entries := []tarEntry{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "dir/file", Body: "contents"},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+		{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "dir/../dir/file"},
+		{Name: "setuid", Body: "#!/bin/sh\n", Mode: 04755},
+		{Name: "fifo", Typeflag: tar.TypeFifo},
+		// A later entry replaces a symlink instead of writing through it
+		{Name: "target", Body: "original"},
+		{Name: "replaced", Typeflag: tar.TypeSymlink, Linkname: "target"},
+		{Name: "replaced", Body: "new"},
+	}
+	if err := ExtractTar(bytes.NewReader(buildTar(t, entries)), root, DefaultExtractLimits()); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		name string
+		want string
+	}{
+		{"dir/file", "contents"},
+		{"dir/link", "contents"},
+		{"up", "contents"},
+		{"target", "original"},
+		{"replaced", "new"},
+	}
+	for _, f := range files {
+		got, err := os.ReadFile(filepath.Join(root, f.name))
+		if err != nil {
+			t.Errorf("%s: %v", f.name, err)
+			continue
+		}
+		if string(got) != f.want {
+			t.Errorf("%s = %q, want %q", f.name, got, f.want)
+		}
+	}
+
+	if info, err := os.Lstat(filepath.Join(root, "replaced")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("replaced is still a symlink")
+	}
+	if info, err := os.Stat(filepath.Join(root, "setuid")); err != nil {
+		t.Error(err)
+	} else if info.Mode()&os.ModeSetuid != 0 || info.Mode().Perm() != 0755 {
+		t.Errorf("setuid mode = %v, want the setuid bit dropped", info.Mode())
+	}
+	if _, err := os.Lstat(filepath.Join(root, "fifo")); !os.IsNotExist(err) {
+		t.Errorf("fifo was extracted")
+	}
+}
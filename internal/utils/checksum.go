@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkPrefix marks the checksum of a symlink, which covers its target. File
+// checksums are plain hex, so a link never matches a file holding its target.
+const linkPrefix = "link:"
+
+// HashReader returns the hex SHA-256 of everything read from r
+func HashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashLink returns the checksum of a symlink to target
+func HashLink(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return linkPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsLinkChecksum reports whether sum is the checksum of a symlink
+func IsLinkChecksum(sum string) bool {
+	return strings.HasPrefix(sum, linkPrefix)
+}
+
+// HashFile returns the hex SHA-256 of the file at path
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return HashReader(f)
+}
+
+// ChecksumDir hashes every regular file under dir, and the target of every
+// symlink. Keys are slash-separated paths relative to dir; the names in
+// skip are left out.
+func ChecksumDir(dir string, skip ...string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if !info.Mode().IsRegular() && !isLink {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, s := range skip {
+			if rel == s {
+				return nil
+			}
+		}
+
+		if isLink {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sums[rel] = HashLink(target)
+			return nil
+		}
+		sum, err := HashFile(path)
+		if err != nil {
+			return err
+		}
+		sums[rel] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
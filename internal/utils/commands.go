@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -27,8 +29,81 @@ func RunCommandWithTimeout(name string, timeout time.Duration, args ...string) C
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	return runCommand(exec.CommandContext(ctx, name, args...))
+}
+
+// RunCommandWithInput is RunCommandWithTimeout with input on the command's
+// stdin, so data never has to pass through a shell
+func RunCommandWithInput(input string, timeout time.Duration, name string, args ...string) CommandResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return runCommand(cmd)
+}
+
+// RunCommandContext is RunCommand, stopped early when ctx is done. The
+// command runs in its own process group, which is sent SIGTERM on
+// cancellation and SIGKILL once the command has exited or after a few
+// seconds, so helpers it started are not left behind. Being outside the
+// terminal's process group it cannot prompt there; commands that may ask for
+// a password (sudo) should use RunCommandWithTimeout.
+func RunCommandContext(ctx context.Context, name string, args ...string) CommandResult {
+	return RunCommandInDir(ctx, "", 30*time.Second, name, args...)
+}
+
+// RunCommandInDir is RunCommandContext with its own timeout, run in the
+// working directory dir unless dir is "". Downloads that write where they
+// run, such as apt-get download, need both.
+func RunCommandInDir(ctx context.Context, dir string, timeout time.Duration, name string, args ...string) CommandResult {
+	if hook, ok := ctx.Value(commandHookKey{}).(CommandHook); ok {
+		hook(name, args...)
+	}
 
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	result := runCommand(cmd)
+	if result.Error != nil && ctx.Err() != nil {
+		// Report why the command was stopped rather than the signal, and
+		// make sure nothing in its group outlives it
+		result.Error = ctx.Err()
+		if cmd.Process != nil {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+	return result
+}
+
+// CommandHook is told about each command RunCommandContext is about to run
+type CommandHook func(name string, args ...string)
+
+type commandHookKey struct{}
+
+// WithCommandHook returns a context under which RunCommandContext calls hook
+// before running a command, after any hook ctx already had
+func WithCommandHook(ctx context.Context, hook CommandHook) context.Context {
+	if parent, ok := ctx.Value(commandHookKey{}).(CommandHook); ok {
+		next := hook
+		hook = func(name string, args ...string) {
+			parent(name, args...)
+			next(name, args...)
+		}
+	}
+	return context.WithValue(ctx, commandHookKey{}, hook)
+}
+
+// runCommand runs cmd and collects its output
+func runCommand(cmd *exec.Cmd) CommandResult {
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -60,7 +135,16 @@ func CommandExists(name string) bool {
 
 // RunCommandLines executes a command and returns stdout split into lines
 func RunCommandLines(name string, args ...string) ([]string, error) {
-	result := RunCommand(name, args...)
+	return commandLines(RunCommand(name, args...))
+}
+
+// RunCommandLinesContext is RunCommandLines with the cancellation of
+// RunCommandContext
+func RunCommandLinesContext(ctx context.Context, name string, args ...string) ([]string, error) {
+	return commandLines(RunCommandContext(ctx, name, args...))
+}
+
+func commandLines(result CommandResult) ([]string, error) {
 	if result.Error != nil {
 		return nil, fmt.Errorf("command failed: %w - %s", result.Error, result.Stderr)
 	}
@@ -72,34 +156,16 @@ func RunCommandLines(name string, args ...string) ([]string, error) {
 	return strings.Split(result.Stdout, "\n"), nil
 }
 
-// RunCommandWithEnv executes a command with additional environment variables
-func RunCommandWithEnv(env map[string]string, name string, args ...string) CommandResult {
-	cmd := exec.Command(name, args...)
+// RunCommandWithEnv is RunCommandWithTimeout with variables added to the
+// environment the command inherits
+func RunCommandWithEnv(env map[string]string, timeout time.Duration, name string, args ...string) CommandResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Build environment
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = os.Environ()
 	for k, v := range env {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-
-	result := CommandResult{
-		Stdout: strings.TrimSpace(stdout.String()),
-		Stderr: strings.TrimSpace(stderr.String()),
-	}
-
-	if err != nil {
-		result.Error = err
-		if exitError, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitError.ExitCode()
-		} else {
-			result.ExitCode = -1
-		}
-	}
-
-	return result
+	return runCommand(cmd)
 }
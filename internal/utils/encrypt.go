@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encrypted backups start with a fixed header followed by AES-256-GCM
+// chunks. The key comes from the passphrase through PBKDF2-SHA256 with a
+// random salt, so every file has its own key and the chunk counter alone is
+// a safe nonce. The last chunk carries a flag in its nonce, which makes a
+// truncated file fail to decrypt instead of ending early.
+//
+// Header layout (all integers big-endian):
+//
+//	magic      8 bytes  "REGOENC1"
+//	iterations 4 bytes  PBKDF2 rounds
+//	chunkSize  4 bytes  plaintext bytes per chunk
+//	salt      16 bytes
+//	check     16 bytes  passphrase verifier, derived with the key
+//
+// The whole header is authenticated as additional data of every chunk.
+
+const (
+	encryptMagic      = "REGOENC1"
+	encryptHeaderSize = 8 + 4 + 4 + 16 + 16
+	encryptIterations = 600000
+	// A header asking for more rounds than this would tie up the CPU
+	// before the passphrase could even be checked
+	encryptMaxIterations = 10 * encryptIterations
+	encryptChunkSize     = 64 * 1024
+	encryptMaxChunk      = 16 * 1024 * 1024
+)
+
+var (
+	// ErrPassphraseRequired is returned when opening an encrypted file
+	// without a passphrase
+	ErrPassphraseRequired = errors.New("file is encrypted, a passphrase is required")
+	// ErrWrongPassphrase is returned when the passphrase does not match
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrCorrupted is returned when an encrypted file fails authentication
+	ErrCorrupted = errors.New("encrypted file is corrupted or truncated")
+)
+
+// IsEncrypted reports whether the file at path is a rego encrypted file
+func IsEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(encryptMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == encryptMagic
+}
+
+// deriveKeys returns the AES key and the passphrase verifier
+func deriveKeys(passphrase string, salt []byte, iterations int) ([]byte, []byte, error) {
+	derived, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 48)
+	if err != nil {
+		return nil, nil, err
+	}
+	return derived[:32], derived[32:], nil
+}
+
+// chunkNonce builds the GCM nonce for a chunk from its counter and the
+// final-chunk flag
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it in fixed-size chunks
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewEncryptWriter returns a writer that encrypts to w with a key derived
+// from passphrase. Close must be called to write the final chunk; it does
+// not close w.
+func NewEncryptWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, check, err := deriveKeys(passphrase, salt, encryptIterations)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, encryptHeaderSize)
+	header = append(header, encryptMagic...)
+	header = binary.BigEndian.AppendUint32(header, encryptIterations)
+	header = binary.BigEndian.AppendUint32(header, encryptChunkSize)
+	header = append(header, salt...)
+	header = append(header, check[:16]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, encryptChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close writes the final chunk, which is always shorter than a full chunk
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// decryptReader decrypts a stream written by encryptWriter
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// NewDecryptReader returns a reader that decrypts r. It returns
+// ErrWrongPassphrase if the passphrase does not match the file.
+func NewDecryptReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:8]) != encryptMagic {
+		return nil, errors.New("not an encrypted rego file")
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	iterations := int(binary.BigEndian.Uint32(header[8:12]))
+	chunkSize := int(binary.BigEndian.Uint32(header[12:16]))
+	if iterations < 1 || chunkSize < 1 || chunkSize > encryptMaxChunk {
+		return nil, ErrCorrupted
+	}
+	if iterations > encryptMaxIterations {
+		return nil, fmt.Errorf("encrypted file asks for %d key derivation rounds, more than the %d ReGo accepts", iterations, encryptMaxIterations)
+	}
+
+	key, check, err := deriveKeys(passphrase, header[16:32], iterations)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(check[:16], header[32:48]) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		chunk:  make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next reads and opens one chunk. A full chunk is never the last one.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.counter, last), d.chunk[:n], d.header)
+	if err != nil {
+		return ErrCorrupted
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// WriteFileEncrypted writes data to path, encrypted when passphrase is set
+func WriteFileEncrypted(path string, data []byte, passphrase string) error {
+	if passphrase == "" {
+		return os.WriteFile(path, data, 0644)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := NewEncryptWriter(f, passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFileEncrypted reads path, decrypting it if it is encrypted
+func ReadFileEncrypted(path, passphrase string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := OpenMaybeEncrypted(f, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// OpenMaybeEncrypted returns a reader over the plaintext of r, decrypting
+// it if it starts with the encrypted file header
+func OpenMaybeEncrypted(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(encryptMagic))
+	if !bytes.Equal(magic, []byte(encryptMagic)) {
+		return br, nil
+	}
+	return NewDecryptReader(br, passphrase)
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// encryptForTest encrypts plain with testPassphrase
+func encryptForTest(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decryptForTest decrypts data and returns the plaintext read before the
+// first error
+func decryptForTest(data []byte, passphrase string) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"one chunk less a byte", encryptChunkSize - 1},
+		{"exactly one chunk", encryptChunkSize},
+		{"one chunk and a byte", encryptChunkSize + 1},
+		{"several chunks", 3*encryptChunkSize + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			plain := bytes.Repeat([]byte("rego"), tt.size/4+1)[:tt.size]
+			data := encryptForTest(t, plain)
+
+			// Every chunk carries a tag, and a final chunk always follows
+			// the full ones
+			chunks := tt.size/encryptChunkSize + 1
+			if want := encryptHeaderSize + tt.size + chunks*16; len(data) != want {
+				t.Errorf("encrypted size = %d, want %d", len(data), want)
+			}
+
+			got, err := decryptForTest(data, testPassphrase)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("decrypted %d bytes, want the %d written", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	// Two full chunks and a short last one
+	plain := bytes.Repeat([]byte{0x5a}, 2*encryptChunkSize+10)
+	data := encryptForTest(t, plain)
+	sealed := encryptChunkSize + 16
+	firstChunk := data[encryptHeaderSize : encryptHeaderSize+sealed]
+	secondChunk := data[encryptHeaderSize+sealed : encryptHeaderSize+2*sealed]
+	lastChunk := data[encryptHeaderSize+2*sealed:]
+
+	// An exact multiple of the chunk size ends in an empty final chunk
+	even := encryptForTest(t, bytes.Repeat([]byte{0x5a}, encryptChunkSize))
+
+	withHeader := func(offset int, value uint32) []byte {
+		tampered := bytes.Clone(data)
+		binary.BigEndian.PutUint32(tampered[offset:], value)
+		return tampered
+	}
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	flipped := bytes.Clone(data)
+	flipped[encryptHeaderSize+sealed+100] ^= 1
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		wantErr    error
+		wantText   string // When the error is not one of the sentinels
+	}{
+		{"wrong passphrase", data, "wrong", ErrWrongPassphrase, ""},
+		{"no passphrase", data, "", ErrPassphraseRequired, ""},
+		{"not encrypted", []byte("plain text that is long enough to be a header......"), testPassphrase, nil, "not an encrypted rego file"},
+		{"header cut short", data[:encryptHeaderSize-1], testPassphrase, nil, "not an encrypted rego file"},
+		{"header only", data[:encryptHeaderSize], testPassphrase, ErrCorrupted, ""},
+		{"cut inside the first chunk", data[:encryptHeaderSize+100], testPassphrase, ErrCorrupted, ""},
+		{"cut at a chunk boundary", data[:encryptHeaderSize+2*sealed], testPassphrase, ErrCorrupted, ""},
+		{"last chunk missing its tag", data[:len(data)-1], testPassphrase, ErrCorrupted, ""},
+		{"empty final chunk dropped", even[:len(even)-16], testPassphrase, ErrCorrupted, ""},
+		{"trailing bytes", concat(data, []byte{0}), testPassphrase, ErrCorrupted, ""},
+		{"chunks swapped", concat(data[:encryptHeaderSize], secondChunk, firstChunk, lastChunk), testPassphrase, ErrCorrupted, ""},
+		{"chunk dropped", concat(data[:encryptHeaderSize], firstChunk, lastChunk), testPassphrase, ErrCorrupted, ""},
+		{"bit flipped", flipped, testPassphrase, ErrCorrupted, ""},
+		{"zero iterations", withHeader(8, 0), testPassphrase, ErrCorrupted, ""},
+		{"too many iterations", withHeader(8, encryptMaxIterations+1), testPassphrase, nil, "key derivation rounds"},
+		{"zero chunk size", withHeader(12, 0), testPassphrase, ErrCorrupted, ""},
+		{"chunk size too large", withHeader(12, encryptMaxChunk+1), testPassphrase, ErrCorrupted, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Each run derives a key, which is slow on purpose
+			_, err := decryptForTest(tt.data, tt.passphrase)
+			switch {
+			case err == nil:
+				t.Fatal("decrypted without an error")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			case tt.wantText != "" && !strings.Contains(err.Error(), tt.wantText):
+				t.Errorf("error = %v, want one about %q", err, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestOpenMaybeEncryptedPassesPlainThrough(t *testing.T) {
+	plain := []byte(`{"version": "1.0"}`)
+	r, err := OpenMaybeEncrypted(bytes.NewReader(plain), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("got %q, want %q", got, plain)
+	}
+}
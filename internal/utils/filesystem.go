@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // EnsureDir creates a directory if it doesn't exist
@@ -30,7 +31,20 @@ func DirExists(path string) bool {
 	return info.IsDir()
 }
 
-// CopyFile copies a file from src to dst, preserving permissions
+// SymlinkMode says what copying and archiving do with symlinks
+type SymlinkMode int
+
+const (
+	// SymlinksPreserve recreates links that point inside the tree being
+	// copied. Links that leave it are followed, since their target is not
+	// part of the copy.
+	SymlinksPreserve SymlinkMode = iota
+	// SymlinksFollow copies whatever every link points to
+	SymlinksFollow
+)
+
+// CopyFile copies a file from src to dst, following symlinks and preserving
+// permissions and mtime. A symlink at dst is replaced, not written through.
 func CopyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -49,6 +63,12 @@ func CopyFile(src, dst string) error {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return fmt.Errorf("failed to replace symlink: %w", err)
+		}
+	}
+
 	destFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, sourceInfo.Mode())
 	if err != nil {
 		return fmt.Errorf("failed to create destination file: %w", err)
@@ -58,44 +78,169 @@ func CopyFile(src, dst string) error {
 	if _, err := io.Copy(destFile, sourceFile); err != nil {
 		return fmt.Errorf("failed to copy file contents: %w", err)
 	}
+	if err := destFile.Close(); err != nil {
+		return fmt.Errorf("failed to copy file contents: %w", err)
+	}
 
+	os.Chmod(dst, sourceInfo.Mode().Perm())
+	os.Chtimes(dst, sourceInfo.ModTime(), sourceInfo.ModTime())
 	return nil
 }
 
-// CopyDir recursively copies a directory
-func CopyDir(src, dst string) error {
-	srcInfo, err := os.Stat(src)
+// CopySymlink recreates the symlink src at dst with the same target,
+// replacing any file or symlink already at dst
+func CopySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
 	if err != nil {
-		return fmt.Errorf("failed to stat source directory: %w", err)
+		return fmt.Errorf("failed to read symlink: %w", err)
 	}
+	return makeSymlink(target, dst)
+}
 
-	if err := os.MkdirAll(dst, srcInfo.Mode()); err != nil {
+func makeSymlink(target, dst string) error {
+	if err := EnsureDir(filepath.Dir(dst)); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
+	if info, err := os.Lstat(dst); err == nil && !info.IsDir() {
+		os.Remove(dst)
+	}
+	return os.Symlink(target, dst)
+}
+
+// CopyDir recursively copies a directory, keeping symlinks inside it
+func CopyDir(src, dst string) error {
+	return CopyTree(src, dst, SymlinksPreserve)
+}
 
-	entries, err := os.ReadDir(src)
+// CopyTree copies a file, directory or symlink from src to dst, preserving
+// permissions and mtimes. links decides what happens to symlinks below src.
+func CopyTree(src, dst string, links SymlinkMode) error {
+	var dirs []TreeEntry
+	err := WalkTree(src, links, func(e TreeEntry) error {
+		target := filepath.Join(dst, e.Rel)
+		switch {
+		case e.Link != "":
+			return makeSymlink(e.Link, target)
+		case e.Info.IsDir():
+			// Keep directories writable until their contents are copied
+			if err := os.MkdirAll(target, e.Info.Mode().Perm()|0700); err != nil {
+				return fmt.Errorf("failed to create destination directory: %w", err)
+			}
+			dirs = append(dirs, e)
+			return nil
+		default:
+			return CopyFile(e.Path, target)
+		}
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read source directory: %w", err)
+		return err
 	}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dst, dirs[i].Rel)
+		os.Chmod(target, dirs[i].Info.Mode().Perm())
+		os.Chtimes(target, dirs[i].Info.ModTime(), dirs[i].Info.ModTime())
+	}
+	return nil
+}
 
-		if entry.IsDir() {
-			if err := CopyDir(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			if err := CopyFile(srcPath, dstPath); err != nil {
+// TreeEntry is a file, directory or symlink found by WalkTree
+type TreeEntry struct {
+	Path string      // Where the entry is on disk
+	Rel  string      // Path relative to the walked root, "." for the root
+	Info os.FileInfo // The entry, or what it points to if it is a followed link
+	Link string      // Target of a symlink kept as a link, otherwise empty
+}
+
+// WalkTree calls fn for src and everything below it, parents before their
+// contents. links decides whether a symlink is reported as a link (only
+// possible when it points inside src) or as what it points to. Dangling
+// links that would be followed and symlink loops are skipped.
+func WalkTree(src string, links SymlinkMode, fn func(TreeEntry) error) error {
+	root, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	w := treeWalker{root: root, links: links, seen: make(map[string]bool), fn: fn}
+	return w.walk(root, ".")
+}
+
+// treeWalker walks one tree; seen holds the directories being walked above
+// the current one, to stop at symlink loops when following links
+type treeWalker struct {
+	root  string
+	links SymlinkMode
+	seen  map[string]bool
+	fn    func(TreeEntry) error
+}
+
+func (w *treeWalker) walk(path, rel string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if w.links == SymlinksPreserve && LinkStaysInside(w.root, path) {
+			target, err := os.Readlink(path)
+			if err != nil {
 				return err
 			}
+			return w.fn(TreeEntry{Path: path, Rel: rel, Info: info, Link: target})
+		}
+		info, err = os.Stat(path)
+		if err != nil {
+			Warn("Skipping dangling symlink %s", path)
+			return nil
+		}
+	}
+
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return nil // Sockets, FIFOs and devices are not backed up
 		}
+		return w.fn(TreeEntry{Path: path, Rel: rel, Info: info})
 	}
 
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+	if w.seen[real] {
+		Warn("Skipping symlink loop at %s", path)
+		return nil
+	}
+	w.seen[real] = true
+	defer delete(w.seen, real)
+
+	if err := w.fn(TreeEntry{Path: path, Rel: rel, Info: info}); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read source directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := w.walk(filepath.Join(path, entry.Name()), filepath.Join(rel, entry.Name())); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// LinkStaysInside reports whether the symlink at path has a relative target
+// that resolves inside root
+func LinkStaysInside(root, path string) bool {
+	target, err := os.Readlink(path)
+	if err != nil || filepath.IsAbs(target) {
+		return false
+	}
+	resolved := filepath.Join(filepath.Dir(path), target)
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // GetHomeDir returns the user's home directory
 func GetHomeDir() (string, error) {
 	home, err := os.UserHomeDir()
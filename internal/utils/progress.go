@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ProgressEvent reports how far a backup or restore has got. Totals are
+// zero when they are not known up front.
+type ProgressEvent struct {
+	Component string // Part of the operation under way, e.g. "Flatpaks"
+	Command   string // Command line being run, empty between commands
+	Step      int    // Components finished
+	Steps     int    // Components in total
+
+	// Files counts files copied or, when installing, packages
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// ProgressFunc receives progress events. It is never called concurrently.
+type ProgressFunc func(ProgressEvent)
+
+// Progress keeps the running totals of an operation and sends every change
+// to a ProgressFunc. It is safe for concurrent use, and a nil *Progress or
+// one with a nil func does nothing.
+type Progress struct {
+	mu sync.Mutex
+	fn ProgressFunc
+	ev ProgressEvent
+}
+
+// NewProgress returns a Progress that reports to fn
+func NewProgress(fn ProgressFunc) *Progress {
+	return &Progress{fn: fn}
+}
+
+// update applies change to the current event and reports the result
+func (p *Progress) update(change func(ev *ProgressEvent)) {
+	if p == nil || p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	change(&p.ev)
+	p.fn(p.ev)
+}
+
+// SetTotals sets how many components, files and bytes there are in all
+func (p *Progress) SetTotals(steps, files int, bytes int64) {
+	p.update(func(ev *ProgressEvent) {
+		ev.Steps, ev.FilesTotal, ev.BytesTotal = steps, files, bytes
+	})
+}
+
+// Start reports that work on component has begun
+func (p *Progress) Start(component string) {
+	p.update(func(ev *ProgressEvent) {
+		ev.Component, ev.Command = component, ""
+	})
+}
+
+// Run reports the command about to run, as name and arguments
+func (p *Progress) Run(name string, args ...string) {
+	p.update(func(ev *ProgressEvent) {
+		ev.Command = FormatCommand(name, args...)
+	})
+}
+
+// StepDone reports that a component has finished
+func (p *Progress) StepDone() {
+	p.update(func(ev *ProgressEvent) {
+		ev.Step++
+		ev.Command = ""
+	})
+}
+
+// FileDone reports a finished file of the given size, or a package with size 0
+func (p *Progress) FileDone(size int64) {
+	p.update(func(ev *ProgressEvent) {
+		ev.FilesDone++
+		ev.BytesDone += size
+	})
+}
+
+// FilesDone reports n finished packages, or files whose size is not tracked
+func (p *Progress) FilesDone(n int) {
+	p.update(func(ev *ProgressEvent) {
+		ev.FilesDone += n
+	})
+}
+
+// FormatCommand returns a command line for display, abbreviated when it
+// lists many arguments such as a long package list
+func FormatCommand(name string, args ...string) string {
+	const maxArgs = 6
+	line := name
+	for i, arg := range args {
+		if i == maxArgs {
+			line += fmt.Sprintf(" … (%d more)", len(args)-maxArgs)
+			break
+		}
+		line += " " + arg
+	}
+	return line
+}
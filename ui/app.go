@@ -15,6 +15,8 @@ const (
 	ViewLoadMenu
 	ViewLoadQuick
 	ViewLoadFull
+	ViewLoadFolder
+	ViewUndo
 	ViewAbout
 )
 
@@ -27,7 +29,9 @@ type Model struct {
 	fullSave    views.FullSaveView
 	loadMenu    views.LoadMenuView
 	loadQuick   views.LightRestoreView
-	loadFull    views.RestoreView
+	loadFull    views.FullRestoreView
+	loadFolder  views.RestoreView
+	undo        views.UndoView
 	about       views.AboutView
 }
 
@@ -53,6 +57,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 	case tea.KeyMsg:
 		if msg.String() == "ctrl+c" {
+			// Stop running saves first so no command outlives the program
+			m.quickSave.Stop()
+			m.fullSave.Stop()
 			return m, tea.Quit
 		}
 	}
@@ -73,6 +80,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "load":
 			m.loadMenu = views.NewLoadMenuView()
 			m.currentView = ViewLoadMenu
+		case "resume":
+			m.loadQuick = views.NewLightRestoreViewResume(m.mainMenu.Resume())
+			m.currentView = ViewLoadQuick
 		case "about":
 			m.currentView = ViewAbout
 		case "quit":
@@ -92,13 +102,23 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.loadMenu, cmd, nav = m.loadMenu.Update(msg)
 		switch nav {
 		case "back":
+			// A restore may have finished or failed since, so the resume
+			// offer is checked again
+			m.mainMenu = views.NewMainMenuView()
 			m.currentView = ViewMainMenu
 		case "load_quick":
 			m.loadQuick = views.NewLightRestoreView()
 			m.currentView = ViewLoadQuick
 		case "load_full":
-			m.loadFull = views.NewRestoreView()
+			m.loadFull = views.NewFullRestoreView()
 			m.currentView = ViewLoadFull
+		case "load_folder":
+			m.loadFolder = views.NewRestoreView()
+			m.currentView = ViewLoadFolder
+		case "undo":
+			m.undo = views.NewUndoView()
+			m.currentView = ViewUndo
+			cmd = m.undo.Init()
 		}
 	case ViewLoadQuick:
 		m.loadQuick, cmd, nav = m.loadQuick.Update(msg)
@@ -110,6 +130,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if nav == "back" {
 			m.currentView = ViewLoadMenu
 		}
+	case ViewLoadFolder:
+		m.loadFolder, cmd, nav = m.loadFolder.Update(msg)
+		if nav == "back" {
+			m.currentView = ViewLoadMenu
+		}
+	case ViewUndo:
+		m.undo, cmd, nav = m.undo.Update(msg)
+		if nav == "back" {
+			m.currentView = ViewLoadMenu
+		}
 	case ViewAbout:
 		m.about, cmd, nav = m.about.Update(msg)
 		if nav == "back" {
@@ -134,6 +164,10 @@ func (m Model) View() string {
 		content = m.loadQuick.View()
 	case ViewLoadFull:
 		content = m.loadFull.View()
+	case ViewLoadFolder:
+		content = m.loadFolder.View()
+	case ViewUndo:
+		content = m.undo.View()
 	case ViewAbout:
 		content = m.about.View()
 	default:
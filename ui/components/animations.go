@@ -1,6 +1,7 @@
 package components
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -51,25 +52,34 @@ func (s *Spinner) View() string {
 	return styles.WarningStyle.Render(s.frames[s.frame])
 }
 
-// AnimatedProgress shows an animated progress bar
+// AnimatedProgress shows an animated progress bar. Once progress has been
+// made it also estimates the time left from the rate so far.
 type AnimatedProgress struct {
-	current   int
-	total     int
+	current   int64
+	total     int64
 	width     int
 	frame     int
 	label     string
 	status    string
+	detail    string
 	startTime time.Time
 }
 
 func NewAnimatedProgress(total int) *AnimatedProgress {
-	return &AnimatedProgress{total: total, width: 30, startTime: time.Now()}
+	return &AnimatedProgress{total: int64(total), width: 30, startTime: time.Now()}
 }
 
-func (p *AnimatedProgress) SetCurrent(c int)   { p.current = c }
+func (p *AnimatedProgress) SetCurrent(c int)   { p.current = int64(c) }
 func (p *AnimatedProgress) SetLabel(l string)  { p.label = l }
 func (p *AnimatedProgress) SetStatus(s string) { p.status = s }
+func (p *AnimatedProgress) SetDetail(d string) { p.detail = d }
 func (p *AnimatedProgress) Tick()              { p.frame++ }
+
+// SetProgress sets how much is done out of total, in any unit
+func (p *AnimatedProgress) SetProgress(done, total int64) {
+	p.current, p.total = done, total
+}
+
 func (p *AnimatedProgress) Increment() {
 	if p.current < p.total {
 		p.current++
@@ -81,7 +91,18 @@ func (p *AnimatedProgress) Percent() float64 {
 	if p.total == 0 {
 		return 0
 	}
-	return float64(p.current) / float64(p.total)
+	return min(float64(p.current)/float64(p.total), 1)
+}
+
+// ETA estimates the time left, or returns false while there is too little
+// progress to go on
+func (p *AnimatedProgress) ETA() (time.Duration, bool) {
+	pct := p.Percent()
+	elapsed := time.Since(p.startTime)
+	if pct <= 0 || pct >= 1 || elapsed < 2*time.Second {
+		return 0, false
+	}
+	return time.Duration(float64(elapsed) * (1 - pct) / pct), true
 }
 
 func (p *AnimatedProgress) View() string {
@@ -109,10 +130,10 @@ func (p *AnimatedProgress) View() string {
 	bar.WriteString(styles.DimStyle.Render(strings.Repeat("░", empty)))
 
 	b.WriteString("[" + bar.String() + "] ")
-
-	// Percentage with animation
-	pct := int(p.Percent() * 100)
-	b.WriteString(styles.NormalStyle.Render(strings.Repeat(" ", 3-len(string(rune('0'+pct%10)))) + string(rune('0'+pct/10)) + string(rune('0'+pct%10)) + "%"))
+	b.WriteString(styles.NormalStyle.Render(fmt.Sprintf("%3d%%", int(p.Percent()*100))))
+	if p.detail != "" {
+		b.WriteString("  " + styles.DimStyle.Render(p.detail))
+	}
 	b.WriteString("\n")
 
 	// Status with spinner
@@ -121,9 +142,12 @@ func (p *AnimatedProgress) View() string {
 		b.WriteString(styles.WarningStyle.Render(spinner) + " " + styles.StatusStyle.Render(p.status) + "\n")
 	}
 
-	// Elapsed time
-	elapsed := time.Since(p.startTime).Round(time.Second)
-	b.WriteString(styles.DimStyle.Render("Elapsed: "+elapsed.String()) + "\n")
+	// Elapsed time, and time left once it can be estimated
+	elapsed := "Elapsed: " + time.Since(p.startTime).Round(time.Second).String()
+	if eta, ok := p.ETA(); ok {
+		elapsed += " • About " + eta.Round(time.Second).String() + " left"
+	}
+	b.WriteString(styles.DimStyle.Render(elapsed) + "\n")
 
 	return b.String()
 }
@@ -0,0 +1,62 @@
+package components
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+// Pager shows text taller than the screen a page at a time, in a box of
+// fixed size so the text keeps its alignment
+type Pager struct {
+	lines  []string
+	offset int
+	width  int
+	height int
+}
+
+func NewPager(width, height int) *Pager {
+	return &Pager{width: width, height: height}
+}
+
+// SetLines replaces the text and scrolls back to the top
+func (p *Pager) SetLines(lines []string) {
+	p.lines = lines
+	p.offset = 0
+}
+
+func (p *Pager) Up()       { p.scroll(-1) }
+func (p *Pager) Down()     { p.scroll(1) }
+func (p *Pager) PageUp()   { p.scroll(-p.height) }
+func (p *Pager) PageDown() { p.scroll(p.height) }
+
+func (p *Pager) scroll(n int) {
+	p.offset += n
+	if max := len(p.lines) - p.height; p.offset > max {
+		p.offset = max
+	}
+	if p.offset < 0 {
+		p.offset = 0
+	}
+}
+
+func (p *Pager) View() string {
+	end := p.offset + p.height
+	if end > len(p.lines) {
+		end = len(p.lines)
+	}
+	visible := append([]string(nil), p.lines[p.offset:end]...)
+	// Pad so the screen doesn't jump while scrolling
+	for len(visible) < p.height {
+		visible = append(visible, "")
+	}
+
+	box := lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(styles.Border).Width(p.width)
+	view := box.Render(strings.Join(visible, "\n")) + "\n"
+	if len(p.lines) > p.height {
+		view += styles.DimStyle.Render(fmt.Sprintf("lines %d-%d of %d", p.offset+1, end, len(p.lines))) + "\n"
+	}
+	return view
+}
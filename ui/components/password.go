@@ -0,0 +1,51 @@
+package components
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+// PasswordInput is a single-line input that masks what is typed
+type PasswordInput struct {
+	prompt string
+	value  []rune
+	err    string
+}
+
+func NewPasswordInput(prompt string) *PasswordInput {
+	return &PasswordInput{prompt: prompt}
+}
+
+func (p *PasswordInput) SetPrompt(prompt string) { p.prompt = prompt }
+func (p *PasswordInput) SetError(err string)     { p.err = err }
+func (p *PasswordInput) Value() string           { return string(p.value) }
+func (p *PasswordInput) Reset()                  { p.value = nil }
+
+// HandleKey edits the value; Enter and Esc are left to the caller
+func (p *PasswordInput) HandleKey(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyRunes:
+		p.value = append(p.value, msg.Runes...)
+		p.err = ""
+	case tea.KeySpace:
+		p.value = append(p.value, ' ')
+		p.err = ""
+	case tea.KeyBackspace:
+		if len(p.value) > 0 {
+			p.value = p.value[:len(p.value)-1]
+		}
+	case tea.KeyCtrlU:
+		p.value = nil
+	}
+}
+
+func (p *PasswordInput) View() string {
+	view := styles.NormalStyle.Render(p.prompt) + "\n\n"
+	view += "  " + styles.SelectedStyle.Render("🔑 "+strings.Repeat("•", len(p.value))+"▏") + "\n"
+	if p.err != "" {
+		view += "\n" + styles.ErrorStyle.Render("  ✗ "+p.err) + "\n"
+	}
+	return view
+}
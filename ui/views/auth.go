@@ -0,0 +1,35 @@
+package views
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/r8bert/rego/internal/privileged"
+)
+
+// privilegedAuthMsg is sent once the user typed the password a restore
+// needs, or right away when none is needed here
+type privilegedAuthMsg struct {
+	err error
+}
+
+// authenticate asks for the sudo password before a restore that needs root
+// starts. The TUI owns the terminal, so sudo gets it only while this runs;
+// the helper started afterwards reuses the password.
+func authenticate() tea.Cmd {
+	cmd := privileged.AuthCommand()
+	if cmd == nil {
+		return func() tea.Msg { return privilegedAuthMsg{} }
+	}
+	return tea.ExecProcess(cmd, func(err error) tea.Msg { return privilegedAuthMsg{err: err} })
+}
+
+// startHelper returns the privileged helper for a restore. When it will be
+// needed it is started right away, while sudo still has the password.
+func startHelper(needed bool) (*privileged.Helper, error) {
+	h := privileged.New(false)
+	if needed {
+		if err := h.Start(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
@@ -1,6 +1,7 @@
 package views
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -28,6 +29,7 @@ type BackupView struct {
 	manager    *backup.Manager
 	manifest   *backup.BackupManifest
 	error      error
+	task       *task
 }
 
 func NewBackupView() BackupView {
@@ -69,6 +71,11 @@ func (v BackupView) Update(msg tea.Msg) (BackupView, tea.Cmd, string) {
 		v.status.Add(components.StatusItem{Label: msg.name, Status: "running"})
 		return v, nil, ""
 	case backupCompleteMsg:
+		v.task = nil
+		if isCancelled(msg.err) {
+			v.phase = BackupPhaseSelect
+			return v, nil, ""
+		}
 		v.phase = BackupPhaseComplete
 		v.manifest = msg.manifest
 		v.error = msg.err
@@ -100,12 +107,18 @@ func (v BackupView) Update(msg tea.Msg) (BackupView, tea.Cmd, string) {
 			case "enter":
 				if v.confirm.Confirmed() {
 					v.phase = BackupPhaseRunning
-					return v, v.runBackup(), ""
+					var ctx context.Context
+					v.task, ctx = startTask()
+					return v, v.runBackup(ctx, v.task), ""
 				}
 				v.phase = BackupPhaseSelect
 			case "esc":
 				v.phase = BackupPhaseSelect
 			}
+		case BackupPhaseRunning:
+			if msg.String() == "esc" {
+				v.task.Cancel()
+			}
 		case BackupPhaseComplete:
 			if msg.String() == "enter" || msg.String() == "esc" {
 				return v, nil, "back"
@@ -115,8 +128,9 @@ func (v BackupView) Update(msg tea.Msg) (BackupView, tea.Cmd, string) {
 	return v, nil, ""
 }
 
-func (v BackupView) runBackup() tea.Cmd {
+func (v BackupView) runBackup(ctx context.Context, t *task) tea.Cmd {
 	return func() tea.Msg {
+		defer t.finish()
 		selected := v.checkboxes.GetSelected()
 		opts := backup.BackupOptions{
 			IncludeFlatpak:         hasID(selected, "flatpak"),
@@ -126,8 +140,12 @@ func (v BackupView) runBackup() tea.Cmd {
 			IncludeGnomeSettings:   hasID(selected, "gnome_settings"),
 			IncludeDotfiles:        hasID(selected, "dotfiles"),
 			IncludeFonts:           hasID(selected, "fonts"),
+			IncludeZypper:          hasID(selected, "zypper"),
+			IncludeZypperRepos:     hasID(selected, "zypper_repos"),
+			IncludeAPT:             hasID(selected, "apt"),
+			IncludeAPTSources:      hasID(selected, "apt_sources"),
 		}
-		manifest, err := v.manager.RunBackup(opts, nil)
+		manifest, err := v.manager.RunBackup(ctx, opts, nil)
 		return backupCompleteMsg{manifest, err}
 	}
 }
@@ -152,7 +170,8 @@ func (v BackupView) View() string {
 	case BackupPhaseConfirm:
 		s += v.confirm.View()
 	case BackupPhaseRunning:
-		s += "Backup in progress...\n\n" + v.progress.View()
+		s += "Backup in progress...\n\n" + v.progress.View() + "\n\n"
+		s += styles.FooterStyle.Render("Esc: Cancel")
 	case BackupPhaseComplete:
 		if v.error != nil {
 			s += styles.ErrorStyle.Render("Backup failed: " + v.error.Error())
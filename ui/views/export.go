@@ -1,6 +1,7 @@
 package views
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,6 +16,7 @@ type ExportPhase int
 
 const (
 	ExportPhaseSelect ExportPhase = iota
+	ExportPhasePassphrase
 	ExportPhaseRunning
 	ExportPhaseComplete
 )
@@ -25,6 +27,9 @@ type ExportView struct {
 	outputPath string
 	fileSize   int64
 	error      error
+	encrypt    bool
+	prompt     *passphrasePrompt
+	task       *task
 }
 
 type exportCompleteMsg struct {
@@ -55,6 +60,11 @@ func (v ExportView) Init() tea.Cmd { return nil }
 func (v ExportView) Update(msg tea.Msg) (ExportView, tea.Cmd, string) {
 	switch msg := msg.(type) {
 	case exportCompleteMsg:
+		v.task = nil
+		if isCancelled(msg.err) {
+			v.phase = ExportPhaseSelect
+			return v, nil, ""
+		}
 		v.phase = ExportPhaseComplete
 		v.outputPath = msg.path
 		v.fileSize = msg.size
@@ -72,14 +82,31 @@ func (v ExportView) Update(msg tea.Msg) (ExportView, tea.Cmd, string) {
 				v.checkboxes.Toggle()
 			case "a":
 				v.checkboxes.ToggleAll()
+			case "e":
+				v.encrypt = !v.encrypt
 			case "enter":
 				if len(v.checkboxes.GetSelected()) > 0 {
-					v.phase = ExportPhaseRunning
-					return v, v.runExport(), ""
+					if v.encrypt {
+						v.phase = ExportPhasePassphrase
+						v.prompt = newPassphrasePrompt(true)
+						return v, nil, ""
+					}
+					return v.startExport("")
 				}
 			case "esc":
 				return v, nil, "back"
 			}
+		case ExportPhasePassphrase:
+			done, cancelled := v.prompt.Update(msg)
+			if cancelled {
+				v.phase = ExportPhaseSelect
+			} else if done {
+				return v.startExport(v.prompt.Value())
+			}
+		case ExportPhaseRunning:
+			if msg.String() == "esc" {
+				v.task.Cancel()
+			}
 		case ExportPhaseComplete:
 			if msg.String() == "enter" || msg.String() == "esc" {
 				return v, nil, "back"
@@ -89,8 +116,16 @@ func (v ExportView) Update(msg tea.Msg) (ExportView, tea.Cmd, string) {
 	return v, nil, ""
 }
 
-func (v ExportView) runExport() tea.Cmd {
+func (v ExportView) startExport(passphrase string) (ExportView, tea.Cmd, string) {
+	v.phase = ExportPhaseRunning
+	var ctx context.Context
+	v.task, ctx = startTask()
+	return v, v.runExport(ctx, v.task, passphrase), ""
+}
+
+func (v ExportView) runExport(ctx context.Context, t *task, passphrase string) tea.Cmd {
 	return func() tea.Msg {
+		defer t.finish()
 		selected := v.checkboxes.GetSelected()
 		opts := backup.BackupOptions{
 			IncludeFlatpak:         hasID(selected, "flatpak"),
@@ -100,11 +135,16 @@ func (v ExportView) runExport() tea.Cmd {
 			IncludeGnomeSettings:   hasID(selected, "gnome_settings"),
 			IncludeDotfiles:        hasID(selected, "dotfiles"),
 			IncludeFonts:           hasID(selected, "fonts"),
+			IncludeZypper:          hasID(selected, "zypper"),
+			IncludeZypperRepos:     hasID(selected, "zypper_repos"),
+			IncludeAPT:             hasID(selected, "apt"),
+			IncludeAPTSources:      hasID(selected, "apt_sources"),
 		}
 
 		exporter := backup.NewExporter()
+		exporter.SetPassphrase(passphrase)
 		outputPath := backup.GetDefaultExportPath()
-		err := exporter.ExportQuick(opts, outputPath)
+		err := exporter.ExportQuick(ctx, opts, outputPath)
 
 		var size int64
 		if err == nil {
@@ -138,12 +178,18 @@ func (v ExportView) View() string {
 	case ExportPhaseSelect:
 		s += "Select what to include:\n\n"
 		s += v.checkboxes.View() + "\n"
-		s += styles.DimStyle.Render("Output: "+filepath.Base(v.outputPath)) + "\n\n"
-		s += styles.FooterStyle.Render("Space: Toggle • Enter: Export • Esc: Back")
+		s += styles.DimStyle.Render("Output: "+filepath.Base(v.outputPath)) + "\n"
+		s += renderEncryptOption(v.encrypt) + "\n\n"
+		s += styles.FooterStyle.Render("Space: Toggle • e: Encrypt • Enter: Export • Esc: Back")
+
+	case ExportPhasePassphrase:
+		s += v.prompt.View() + "\n"
+		s += styles.FooterStyle.Render("Enter: Continue • Esc: Cancel")
 
 	case ExportPhaseRunning:
 		s += styles.WarningStyle.Render("⏳ Creating backup archive...") + "\n\n"
-		s += "Please wait, this may take a moment."
+		s += "Please wait, this may take a moment.\n\n"
+		s += styles.FooterStyle.Render("Esc: Cancel")
 
 	case ExportPhaseComplete:
 		if v.error != nil {
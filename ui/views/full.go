@@ -1,11 +1,17 @@
 package views
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/internal/utils"
 	"github.com/r8bert/rego/ui/components"
 	"github.com/r8bert/rego/ui/styles"
 )
@@ -14,6 +20,7 @@ type FullSavePhase int
 
 const (
 	FullSavePhaseSelect FullSavePhase = iota
+	FullSavePhasePassphrase
 	FullSavePhaseRunning
 	FullSavePhaseDone
 )
@@ -21,11 +28,16 @@ const (
 type FullSaveView struct {
 	phase      FullSavePhase
 	checkboxes *components.CheckboxList
+	progress   *components.AnimatedProgress
 	frame      int
 	path       string
 	size       int64
 	stats      map[string]int
 	error      error
+	encrypt    bool
+	prompt     *passphrasePrompt
+	task       *task
+	cancelling bool
 }
 
 type fullSaveDoneMsg struct {
@@ -40,6 +52,7 @@ func NewFullSaveView() FullSaveView {
 		{ID: "flatpaks", Title: "Flatpak Apps", Description: "All installed Flatpak applications", Checked: true},
 		{ID: "rpm", Title: "RPM Packages", Description: "User-installed system packages", Checked: true},
 		{ID: "repos", Title: "Repositories", Description: "Third-party DNF repos", Checked: true},
+		{ID: "package_cache", Title: "Offline Package Cache", Description: "Package files to restore without a network (large)", Checked: false},
 		{ID: "extensions", Title: "GNOME Extensions", Description: "Shell extensions and settings", Checked: backup.IsGNOME()},
 		{ID: "settings", Title: "GNOME Settings", Description: "Desktop customizations (dconf)", Checked: backup.IsGNOME()},
 		{ID: "kde_config", Title: "KDE Plasma Config", Description: "Plasma, KWin, shortcuts", Checked: backup.IsKDE()},
@@ -63,8 +76,21 @@ func (v FullSaveView) Update(msg tea.Msg) (FullSaveView, tea.Cmd, string) {
 	switch msg := msg.(type) {
 	case components.TickMsg:
 		v.frame++
+		if v.phase == FullSavePhaseRunning {
+			v.progress.Tick()
+			if ev, ok := v.task.Progress(); ok {
+				showProgress(v.progress, ev)
+			}
+		}
 		return v, components.Tick(), ""
 	case fullSaveDoneMsg:
+		v.task = nil
+		if v.cancelling && isCancelled(msg.err) {
+			v.phase = FullSavePhaseSelect
+			v.cancelling = false
+			return v, nil, ""
+		}
+		v.cancelling = false
 		v.phase = FullSavePhaseDone
 		v.path = msg.path
 		v.size = msg.size
@@ -83,14 +109,32 @@ func (v FullSaveView) Update(msg tea.Msg) (FullSaveView, tea.Cmd, string) {
 				v.checkboxes.Toggle()
 			case "a":
 				v.checkboxes.ToggleAll()
+			case "e":
+				v.encrypt = !v.encrypt
 			case "enter":
 				if len(v.checkboxes.GetSelected()) > 0 {
-					v.phase = FullSavePhaseRunning
-					return v, tea.Batch(v.runBackup(), components.Tick()), ""
+					if v.encrypt {
+						v.phase = FullSavePhasePassphrase
+						v.prompt = newPassphrasePrompt(true)
+						return v, nil, ""
+					}
+					return v.startBackup("")
 				}
 			case "esc", "q":
 				return v, nil, "back"
 			}
+		case FullSavePhasePassphrase:
+			done, cancelled := v.prompt.Update(msg)
+			if cancelled {
+				v.phase = FullSavePhaseSelect
+			} else if done {
+				return v.startBackup(v.prompt.Value())
+			}
+		case FullSavePhaseRunning:
+			if msg.String() == "esc" {
+				v.task.Cancel()
+				v.cancelling = true
+			}
 		case FullSavePhaseDone:
 			return v, nil, "back"
 		}
@@ -98,6 +142,20 @@ func (v FullSaveView) Update(msg tea.Msg) (FullSaveView, tea.Cmd, string) {
 	return v, nil, ""
 }
 
+func (v FullSaveView) startBackup(passphrase string) (FullSaveView, tea.Cmd, string) {
+	v.phase = FullSavePhaseRunning
+	v.progress = components.NewAnimatedProgress(0)
+	v.progress.SetStatus("Counting files...")
+	var ctx context.Context
+	v.task, ctx = startTask()
+	return v, tea.Batch(v.runBackup(ctx, v.task, passphrase), components.Tick()), ""
+}
+
+// Stop cancels a running save and waits for it to wind down
+func (v FullSaveView) Stop() {
+	v.task.Stop()
+}
+
 func (v FullSaveView) getOptions() backup.FullBackupOptions {
 	selected := v.checkboxes.GetSelected()
 	opts := backup.FullBackupOptions{}
@@ -109,6 +167,8 @@ func (v FullSaveView) getOptions() backup.FullBackupOptions {
 			opts.RPM = true
 		case "repos":
 			opts.Repos = true
+		case "package_cache":
+			opts.PackageCache = true
 		case "extensions":
 			opts.Extensions = true
 		case "settings":
@@ -134,11 +194,14 @@ func (v FullSaveView) getOptions() backup.FullBackupOptions {
 	return opts
 }
 
-func (v FullSaveView) runBackup() tea.Cmd {
+func (v FullSaveView) runBackup(ctx context.Context, t *task, passphrase string) tea.Cmd {
 	return func() tea.Msg {
+		defer t.finish()
 		opts := v.getOptions()
+		opts.Passphrase = passphrase
+		opts.Progress = t.report
 		path := backup.GetDefaultFullBackupPath()
-		stats, err := backup.CreateFullBackup(opts, path)
+		stats, err := backup.CreateFullBackup(ctx, opts, path)
 
 		var size int64
 		if err == nil {
@@ -164,9 +227,6 @@ func formatSizeFull(b int64) string {
 	return fmt.Sprintf("%.2f GB", float64(b)/(1024*1024*1024))
 }
 
-// Animation frames
-var zipAnim = []string{"🗜️", "📦", "🗜️", "📦"}
-
 func (v FullSaveView) View() string {
 	s := styles.RenderLogo() + "\n\n"
 	s += styles.TitleStyle.Render("💾 Full Save") + "\n"
@@ -175,54 +235,29 @@ func (v FullSaveView) View() string {
 	switch v.phase {
 	case FullSavePhaseSelect:
 		s += v.checkboxes.View() + "\n"
-		s += styles.DimStyle.Render("Output: "+v.path) + "\n\n"
+		s += styles.DimStyle.Render("Output: "+v.path) + "\n"
+		s += renderEncryptOption(v.encrypt) + "\n\n"
 		// Animated hint
 		cursor := []string{"▸", "►", "▸", "▶"}[v.frame/3%4]
 		s += styles.SuccessStyle.Render(cursor+" Press ENTER to save") + "\n"
-		s += styles.FooterStyle.Render("Space: Toggle • a: All • Esc: Back")
+		s += styles.FooterStyle.Render("Space: Toggle • a: All • e: Encrypt • Esc: Back")
+
+	case FullSavePhasePassphrase:
+		s += v.prompt.View() + "\n"
+		s += styles.FooterStyle.Render("Enter: Continue • Esc: Cancel")
 
 	case FullSavePhaseRunning:
 		s += "\n"
 		// Big animated backup indicator
 		spinner := []string{"◐", "◓", "◑", "◒"}[v.frame/2%4]
-		zip := zipAnim[v.frame/3%len(zipAnim)]
-
 		s += styles.WarningStyle.Render(fmt.Sprintf("  %s Creating backup archive %s", spinner, spinner)) + "\n\n"
-
-		// Animated file copying visualization
-		steps := []string{"Scanning packages...", "Copying dotfiles...", "Copying fonts...", "Compressing archive..."}
-		step := v.frame / 10 % len(steps)
-		for i, st := range steps {
-			icon := "○"
-			if i < step {
-				icon = styles.SuccessStyle.Render("●")
-			} else if i == step {
-				icon = styles.WarningStyle.Render(spinner)
-			}
-			s += fmt.Sprintf("  %s %s\n", icon, st)
-		}
-
-		// Moving progress bar
-		s += "\n"
-		barWidth := 25
-		pos := v.frame % (barWidth * 2)
-		if pos >= barWidth {
-			pos = barWidth*2 - pos
-		}
-		bar := ""
-		for i := 0; i < barWidth; i++ {
-			if i >= pos-3 && i <= pos+3 {
-				if i == pos {
-					bar += zip
-				} else {
-					bar += "█"
-				}
-			} else {
-				bar += "░"
-			}
+		s += v.progress.View()
+		if v.cancelling {
+			s += "\n  " + styles.DimStyle.Render("Cancelling...")
+		} else {
+			s += "\n  " + styles.DimStyle.Render("This may take a moment for large files...")
 		}
-		s += "  [" + styles.SuccessStyle.Render(bar) + "]\n"
-		s += "\n  " + styles.DimStyle.Render("This may take a moment for large files...")
+		s += "\n\n" + styles.FooterStyle.Render("Esc: Cancel")
 
 	case FullSavePhaseDone:
 		if v.error != nil {
@@ -245,7 +280,7 @@ func (v FullSaveView) View() string {
 						switch k {
 						case "flatpaks":
 							icon = "📦"
-						case "rpm":
+						case "rpm", "apt", "pacman", "aur", "zypper":
 							icon = "📦"
 						case "dotfiles":
 							icon = "📄"
@@ -278,3 +313,411 @@ func (v FullSaveView) View() string {
 
 	return s
 }
+
+type FullRestorePhase int
+
+const (
+	FullRestorePhaseSelectFile FullRestorePhase = iota
+	FullRestorePhasePassphrase
+	FullRestorePhaseOpening
+	FullRestorePhaseSelect
+	FullRestorePhaseReview
+	FullRestorePhaseRunning
+	FullRestorePhaseDone
+)
+
+// FullRestoreView restores a Full Save archive
+type FullRestoreView struct {
+	phase      FullRestorePhase
+	frame      int
+	fileMenu   *components.Menu
+	openPath   string
+	prompt     *passphrasePrompt
+	restore    *restore.FullRestore
+	verify     *backup.VerifyReport
+	verifyErr  error
+	checkboxes *components.CheckboxList
+	dryRun     bool
+	merge      bool
+	review     *dotfileReview
+	results    []restore.RestoreResult
+	snapshot   string
+	error      error
+	progress   *components.AnimatedProgress
+	latest     *progressSlot
+}
+
+type fullRestoreOpenedMsg struct {
+	restore   *restore.FullRestore
+	verify    *backup.VerifyReport
+	verifyErr error
+	err       error
+}
+
+type fullRestoreDoneMsg struct {
+	results  []restore.RestoreResult
+	snapshot string
+	err      error
+}
+
+func NewFullRestoreView() FullRestoreView {
+	var items []components.MenuItem
+	for _, path := range backup.FindFullBackups() {
+		desc := ""
+		if info, err := os.Stat(path); err == nil {
+			desc = fmt.Sprintf("%s - %s", info.ModTime().Format("2006-01-02 15:04"), formatSizeFull(info.Size()))
+		}
+		items = append(items, components.MenuItem{ID: path, Title: filepath.Base(path), Description: desc})
+	}
+	if len(items) == 0 {
+		items = append(items, components.MenuItem{ID: "", Title: "No Full Save archives found", Description: "Copy a rego-full-*.tar.gz to your home folder"})
+	}
+	return FullRestoreView{fileMenu: components.NewMenu(items), dryRun: true}
+}
+
+func (v FullRestoreView) Init() tea.Cmd { return components.Tick() }
+
+func (v FullRestoreView) Update(msg tea.Msg) (FullRestoreView, tea.Cmd, string) {
+	switch msg := msg.(type) {
+	case components.TickMsg:
+		v.frame++
+		if v.phase == FullRestorePhaseRunning {
+			v.progress.Tick()
+			if ev, ok := v.latest.Progress(); ok {
+				showProgress(v.progress, ev)
+			}
+		}
+		return v, components.Tick(), ""
+	case fullRestoreOpenedMsg:
+		if errors.Is(msg.err, utils.ErrWrongPassphrase) {
+			v.phase = FullRestorePhasePassphrase
+			v.prompt = newPassphrasePrompt(false)
+			v.prompt.SetError("Wrong passphrase, try again")
+			return v, nil, ""
+		}
+		if msg.err != nil {
+			v.phase = FullRestorePhaseSelectFile
+			v.error = msg.err
+			return v, nil, ""
+		}
+		v.restore = msg.restore
+		v.verify, v.verifyErr = msg.verify, msg.verifyErr
+		v.setupSections()
+		v.phase = FullRestorePhaseSelect
+		return v, nil, ""
+	case privilegedAuthMsg:
+		if msg.err != nil {
+			v.phase = FullRestorePhaseDone
+			v.error = fmt.Errorf("authentication failed: %w", msg.err)
+			return v, nil, ""
+		}
+		return v, v.runRestore(), ""
+	case dotfileEditedMsg:
+		if v.review != nil {
+			v.review.Edited(msg)
+		}
+		return v, nil, ""
+	case fullRestoreDoneMsg:
+		v.phase = FullRestorePhaseDone
+		v.results = msg.results
+		v.snapshot = msg.snapshot
+		v.error = msg.err
+		return v, nil, ""
+	case tea.KeyMsg:
+		switch v.phase {
+		case FullRestorePhaseSelectFile:
+			switch msg.String() {
+			case "up", "k":
+				v.fileMenu.Up()
+			case "down", "j":
+				v.fileMenu.Down()
+			case "enter":
+				if path := v.fileMenu.Selected().ID; path != "" {
+					v.error = nil
+					v.openPath = path
+					if utils.IsEncrypted(path) {
+						v.phase = FullRestorePhasePassphrase
+						v.prompt = newPassphrasePrompt(false)
+						return v, nil, ""
+					}
+					v.phase = FullRestorePhaseOpening
+					return v, openFullRestore(path, ""), ""
+				}
+			case "esc", "q":
+				return v, nil, "back"
+			}
+		case FullRestorePhasePassphrase:
+			done, cancelled := v.prompt.Update(msg)
+			if cancelled {
+				v.phase = FullRestorePhaseSelectFile
+			} else if done {
+				v.phase = FullRestorePhaseOpening
+				return v, openFullRestore(v.openPath, v.prompt.Value()), ""
+			}
+		case FullRestorePhaseSelect:
+			switch msg.String() {
+			case "up", "k":
+				v.checkboxes.Up()
+			case "down", "j":
+				v.checkboxes.Down()
+			case " ":
+				v.checkboxes.Toggle()
+			case "a":
+				v.checkboxes.ToggleAll()
+			case "d":
+				v.dryRun = !v.dryRun
+			case "m":
+				v.merge = !v.merge
+			case "enter":
+				if len(v.checkboxes.GetSelected()) > 0 {
+					v.error = nil
+					v.review = nil
+					// Existing dotfiles are reviewed before they are overwritten
+					if !v.dryRun && hasID(v.checkboxes.GetSelected(), string(restore.RestoreTypeDotfiles)) {
+						diffs, err := v.restore.DotfileDiffs()
+						if err != nil {
+							v.error = err
+							return v, nil, ""
+						}
+						if v.review = newDotfileReview(diffs, v.merge); v.review != nil {
+							v.phase = FullRestorePhaseReview
+							return v, nil, ""
+						}
+					}
+					return v.start()
+				}
+			case "esc":
+				v.close()
+				v.error = nil
+				v.phase = FullRestorePhaseSelectFile
+			}
+		case FullRestorePhaseReview:
+			cmd, done, cancelled := v.review.Update(msg)
+			if cancelled {
+				v.review = nil
+				v.phase = FullRestorePhaseSelect
+			} else if done {
+				return v.start()
+			}
+			return v, cmd, ""
+		case FullRestorePhaseDone:
+			v.close()
+			return v, nil, "back"
+		}
+	}
+	return v, nil, ""
+}
+
+// start runs the restore of the selected sections, asking for the
+// password first when it needs root
+func (v FullRestoreView) start() (FullRestoreView, tea.Cmd, string) {
+	v.phase = FullRestorePhaseRunning
+	v.progress = components.NewAnimatedProgress(0)
+	v.latest = &progressSlot{}
+	if !v.dryRun && restore.NeedsPrivileges(v.sections()) {
+		return v, authenticate(), ""
+	}
+	return v, v.runRestore(), ""
+}
+
+// close removes the extracted archive
+func (v *FullRestoreView) close() {
+	if v.restore != nil {
+		v.restore.Close()
+		v.restore = nil
+	}
+}
+
+func openFullRestore(path, passphrase string) tea.Cmd {
+	return func() tea.Msg {
+		r, err := restore.OpenFullBackup(path, passphrase)
+		if err != nil {
+			return fullRestoreOpenedMsg{err: err}
+		}
+		report, verifyErr := r.Verify()
+		return fullRestoreOpenedMsg{restore: r, verify: report, verifyErr: verifyErr}
+	}
+}
+
+func (v *FullRestoreView) setupSections() {
+	var items []components.CheckboxItem
+	for _, section := range v.restore.Sections() {
+		preview, _ := v.restore.Preview(section)
+		items = append(items, components.CheckboxItem{
+			ID:          string(section),
+			Title:       fmt.Sprintf("%s (%d)", restore.RestoreTypeName(section), len(preview)),
+			Description: previewSummary(preview),
+			Checked:     true,
+		})
+	}
+	v.checkboxes = components.NewCheckboxList(items)
+}
+
+// previewSummary shows the first few items of a section preview
+func previewSummary(items []string) string {
+	const max = 3
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return strings.Join(items[:max], ", ") + fmt.Sprintf(", +%d more", len(items)-max)
+}
+
+// renderFailedItems lists the items of r that were not restored and why,
+// indented under r's line
+func renderFailedItems(r restore.RestoreResult) string {
+	s := ""
+	for _, item := range r.FailedItems() {
+		s += "      " + styles.ErrorStyle.Render("✗ "+item.Name) + " " + styles.DimStyle.Render(item.Reason) + "\n"
+	}
+	return s
+}
+
+// renderMerged says how many dotfiles of r were merged with the skeleton
+// and lists those left with conflicts to resolve
+func renderMerged(r restore.RestoreResult) string {
+	if r.ItemsMerged == 0 && r.ItemsConflicted == 0 {
+		return ""
+	}
+	s := "      " + styles.DimStyle.Render(fmt.Sprintf("%d merged, %d with conflicts", r.ItemsMerged, r.ItemsConflicted)) + "\n"
+	for _, path := range r.Conflicts {
+		s += "      " + styles.WarningStyle.Render("⚠ ~/"+path) + " " + styles.DimStyle.Render("has conflict markers to resolve") + "\n"
+	}
+	return s
+}
+
+// renderRejected lists the entries of a backup that failed validation and
+// are left out of the restore
+func renderRejected(rejected []backup.Rejection) string {
+	if len(rejected) == 0 {
+		return ""
+	}
+	const max = 5
+	s := styles.WarningStyle.Render(fmt.Sprintf("⚠ %d entries failed validation and will not be restored", len(rejected))) + "\n"
+	for i, r := range rejected {
+		if i == max {
+			s += styles.DimStyle.Render(fmt.Sprintf("  +%d more", len(rejected)-max)) + "\n"
+			break
+		}
+		s += styles.DimStyle.Render("  "+r.String()) + "\n"
+	}
+	return s
+}
+
+// renderSnapshot says where the pre-restore snapshot was saved, if any
+func renderSnapshot(path string) string {
+	if path == "" {
+		return ""
+	}
+	return "\n" + styles.DimStyle.Render("📸 Pre-restore snapshot saved to "+path) + "\n"
+}
+
+// sections returns the selected sections
+func (v FullRestoreView) sections() []restore.RestoreType {
+	var sections []restore.RestoreType
+	for _, item := range v.checkboxes.GetSelected() {
+		sections = append(sections, restore.RestoreType(item.ID))
+	}
+	return sections
+}
+
+func (v FullRestoreView) runRestore() tea.Cmd {
+	r, dryRun, merge, latest := v.restore, v.dryRun, v.merge, v.latest
+	sections, choices := v.sections(), v.review.Choices()
+	return func() tea.Msg {
+		h, err := startHelper(!dryRun && restore.NeedsPrivileges(sections))
+		if err != nil {
+			return fullRestoreDoneMsg{err: err}
+		}
+		defer h.Close()
+		r.SetMerge(merge)
+		r.SetDotfileChoices(choices)
+		r.SetProgress(latest.report)
+		r.SetHelper(h)
+		results := r.Restore(sections, dryRun)
+		return fullRestoreDoneMsg{results: results, snapshot: r.Snapshot().Path()}
+	}
+}
+
+func (v FullRestoreView) View() string {
+	s := styles.TitleStyle.Render("💾 Load Full Save") + "\n\n"
+
+	switch v.phase {
+	case FullRestorePhaseSelectFile:
+		s += styles.DescriptionStyle.Render("Select an archive to restore:") + "\n\n"
+		s += v.fileMenu.View() + "\n"
+		if v.error != nil {
+			s += styles.ErrorStyle.Render("✗ "+v.error.Error()) + "\n\n"
+		}
+		s += styles.FooterStyle.Render("↑/↓: Navigate • Enter: Open • Esc: Back")
+
+	case FullRestorePhasePassphrase:
+		s += styles.DimStyle.Render("🔒 "+filepath.Base(v.openPath)+" is encrypted") + "\n\n"
+		s += v.prompt.View() + "\n"
+		s += styles.FooterStyle.Render("Enter: Open • Esc: Back")
+
+	case FullRestorePhaseOpening:
+		spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}[v.frame%10]
+		s += styles.WarningStyle.Render(spinner+" Extracting and verifying archive...") + "\n"
+
+	case FullRestorePhaseSelect:
+		m := v.restore.Manifest()
+		info := fmt.Sprintf("Host: %s\nDate: %s", m.Hostname, m.CreatedAt.Format("2006-01-02 15:04"))
+		s += styles.CardStyle.Render(info) + "\n"
+		s += renderVerifyReport(v.verify, v.verifyErr) + "\n\n"
+		if len(m.Rejected) > 0 {
+			s += renderRejected(m.Rejected) + "\n"
+		}
+		if p := v.restore.Packages(); p != nil && len(p.Rejected) > 0 {
+			s += renderRejected(p.Rejected) + "\n"
+		}
+
+		mode := styles.SuccessStyle.Render("[DRY RUN]")
+		if !v.dryRun {
+			mode = styles.WarningStyle.Render("[LIVE MODE]")
+		}
+		existing := "overwrite"
+		if v.merge {
+			existing = "keep, merge dotfiles"
+		}
+		s += "Mode: " + mode + "  Existing files: " + styles.NormalStyle.Render(existing) + "\n\n"
+		s += styles.NormalStyle.Render("Select what to restore:") + "\n\n"
+		s += v.checkboxes.View() + "\n"
+		if v.error != nil {
+			s += styles.ErrorStyle.Render("✗ "+v.error.Error()) + "\n\n"
+		}
+		s += styles.DimStyle.Render("Space: Toggle • a: All • d: Dry Run • m: Keep/Merge Existing • Enter: Restore • Esc: Cancel")
+
+	case FullRestorePhaseReview:
+		s += v.review.View()
+
+	case FullRestorePhaseRunning:
+		spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}[v.frame%10]
+		s += styles.WarningStyle.Render(spinner+" Restoring...") + "\n\n"
+		s += v.progress.View() + "\n"
+		s += styles.DimStyle.Render("Please wait, this may take a while...")
+
+	case FullRestorePhaseDone:
+		if v.dryRun {
+			s += styles.WarningStyle.Render("DRY RUN - No changes were made") + "\n\n"
+		}
+		if v.error != nil {
+			s += styles.ErrorStyle.Render("✗ Nothing was restored: "+v.error.Error()) + "\n"
+		}
+		for _, r := range v.results {
+			status := styles.SuccessStyle.Render("✓")
+			if !r.Success {
+				status = styles.ErrorStyle.Render("✗")
+			}
+			s += fmt.Sprintf("  %s %s: %d/%d items\n", status, restore.RestoreTypeName(r.Type), r.ItemsSuccess, r.ItemsTotal)
+			s += renderMerged(r)
+			s += renderFailedItems(r)
+			for _, e := range r.Errors {
+				s += "      " + styles.DimStyle.Render(e) + "\n"
+			}
+		}
+		s += renderSnapshot(v.snapshot)
+		s += "\n" + styles.DimStyle.Render("[Any key] Continue")
+	}
+
+	return s
+}
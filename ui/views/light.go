@@ -1,13 +1,21 @@
 package views
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/internal/utils"
 	"github.com/r8bert/rego/ui/components"
 	"github.com/r8bert/rego/ui/styles"
 )
@@ -16,6 +24,7 @@ type LightPhase int
 
 const (
 	LightPhaseSelect LightPhase = iota
+	LightPhasePassphrase
 	LightPhaseRunning
 	LightPhaseDone
 )
@@ -30,6 +39,10 @@ type LightBackupView struct {
 	size       int64
 	stats      map[string]int
 	error      error
+	encrypt    bool
+	prompt     *passphrasePrompt
+	task       *task
+	cancelling bool
 }
 
 type lightBackupDoneMsg struct {
@@ -80,10 +93,20 @@ func (v LightBackupView) Update(msg tea.Msg) (LightBackupView, tea.Cmd, string)
 		v.spinner.Tick()
 		v.progress.Tick()
 		if v.phase == LightPhaseRunning {
+			if ev, ok := v.task.Progress(); ok {
+				showProgress(v.progress, ev)
+			}
 			return v, components.Tick(), ""
 		}
 		return v, nil, ""
 	case lightBackupDoneMsg:
+		v.task = nil
+		if v.cancelling && isCancelled(msg.err) {
+			v.phase = LightPhaseSelect
+			v.cancelling = false
+			return v, nil, ""
+		}
+		v.cancelling = false
 		v.phase = LightPhaseDone
 		v.path = msg.path
 		v.size = msg.size
@@ -102,15 +125,32 @@ func (v LightBackupView) Update(msg tea.Msg) (LightBackupView, tea.Cmd, string)
 				v.checkboxes.Toggle()
 			case "a":
 				v.checkboxes.ToggleAll()
+			case "e":
+				v.encrypt = !v.encrypt
 			case "enter":
 				if len(v.checkboxes.GetSelected()) > 0 {
-					v.phase = LightPhaseRunning
-					v.progress = components.NewAnimatedProgress(len(v.checkboxes.GetSelected()))
-					return v, tea.Batch(v.runBackup(), components.Tick()), ""
+					if v.encrypt {
+						v.phase = LightPhasePassphrase
+						v.prompt = newPassphrasePrompt(true)
+						return v, nil, ""
+					}
+					return v.startBackup("")
 				}
 			case "esc", "q":
 				return v, nil, "back"
 			}
+		case LightPhasePassphrase:
+			done, cancelled := v.prompt.Update(msg)
+			if cancelled {
+				v.phase = LightPhaseSelect
+			} else if done {
+				return v.startBackup(v.prompt.Value())
+			}
+		case LightPhaseRunning:
+			if msg.String() == "esc" {
+				v.task.Cancel()
+				v.cancelling = true
+			}
 		case LightPhaseDone:
 			return v, nil, "back"
 		}
@@ -118,6 +158,19 @@ func (v LightBackupView) Update(msg tea.Msg) (LightBackupView, tea.Cmd, string)
 	return v, nil, ""
 }
 
+func (v LightBackupView) startBackup(passphrase string) (LightBackupView, tea.Cmd, string) {
+	v.phase = LightPhaseRunning
+	v.progress = components.NewAnimatedProgress(len(v.checkboxes.GetSelected()))
+	var ctx context.Context
+	v.task, ctx = startTask()
+	return v, tea.Batch(v.runBackup(ctx, v.task, passphrase), components.Tick()), ""
+}
+
+// Stop cancels a running save and waits for it to wind down
+func (v LightBackupView) Stop() {
+	v.task.Stop()
+}
+
 func (v LightBackupView) getOptions() backup.LightBackupOptions {
 	selected := v.checkboxes.GetSelected()
 	opts := backup.LightBackupOptions{}
@@ -140,16 +193,18 @@ func (v LightBackupView) getOptions() backup.LightBackupOptions {
 	return opts
 }
 
-func (v LightBackupView) runBackup() tea.Cmd {
+func (v LightBackupView) runBackup(ctx context.Context, t *task, passphrase string) tea.Cmd {
 	return func() tea.Msg {
+		defer t.finish()
 		opts := v.getOptions()
-		b, err := backup.CreateLightBackupWithOptions(opts)
+		opts.Progress = t.report
+		b, err := backup.CreateLightBackupWithOptions(ctx, opts)
 		if err != nil {
 			return lightBackupDoneMsg{err: err}
 		}
 
 		path := backup.GetDefaultLightBackupPath()
-		if err := b.SaveToFile(path); err != nil {
+		if err := b.SaveToFileWithPassphrase(path, passphrase); err != nil {
 			return lightBackupDoneMsg{err: err}
 		}
 
@@ -184,11 +239,16 @@ func (v LightBackupView) View() string {
 	switch v.phase {
 	case LightPhaseSelect:
 		s += v.checkboxes.View() + "\n"
-		s += styles.DimStyle.Render("Output: "+v.path) + "\n\n"
+		s += styles.DimStyle.Render("Output: "+v.path) + "\n"
+		s += renderEncryptOption(v.encrypt) + "\n\n"
 		// Animated hint
 		cursor := []string{"▸", "►", "▸", "▶"}[v.frame/3%4]
 		s += styles.SuccessStyle.Render(cursor+" Press ENTER to save") + "\n"
-		s += styles.FooterStyle.Render("Space: Toggle • a: All • Esc: Back")
+		s += styles.FooterStyle.Render("Space: Toggle • a: All • e: Encrypt • Esc: Back")
+
+	case LightPhasePassphrase:
+		s += v.prompt.View() + "\n"
+		s += styles.FooterStyle.Render("Enter: Continue • Esc: Cancel")
 
 	case LightPhaseRunning:
 		// Animated spinner
@@ -196,25 +256,12 @@ func (v LightBackupView) View() string {
 		s += "\n"
 		s += styles.WarningStyle.Render("  "+spinner+" Scanning system "+spinner) + "\n\n"
 
-		// Animated dots
-		dots := []string{"", ".", "..", "..."}[v.frame/3%4]
-		s += styles.DimStyle.Render("  Collecting package lists"+dots) + "\n"
-
-		// Progress bar animation
-		barWidth := 20
-		pos := v.frame % (barWidth * 2)
-		if pos >= barWidth {
-			pos = barWidth*2 - pos
+		if v.cancelling {
+			dots := []string{"", ".", "..", "..."}[v.frame/3%4]
+			s += styles.DimStyle.Render("  Cancelling"+dots) + "\n\n"
 		}
-		bar := ""
-		for i := 0; i < barWidth; i++ {
-			if i >= pos-2 && i <= pos+2 {
-				bar += "█"
-			} else {
-				bar += "░"
-			}
-		}
-		s += "\n  [" + styles.SuccessStyle.Render(bar) + "]\n"
+		s += v.progress.View() + "\n"
+		s += styles.FooterStyle.Render("Esc: Cancel")
 
 	case LightPhaseDone:
 		if v.error != nil {
@@ -233,6 +280,18 @@ func (v LightBackupView) View() string {
 				if v.stats["rpm"] > 0 {
 					s += fmt.Sprintf("     • %d RPM packages\n", v.stats["rpm"])
 				}
+				if v.stats["apt"] > 0 {
+					s += fmt.Sprintf("     • %d APT packages\n", v.stats["apt"])
+				}
+				if v.stats["pacman"] > 0 {
+					s += fmt.Sprintf("     • %d Pacman packages\n", v.stats["pacman"])
+				}
+				if v.stats["aur"] > 0 {
+					s += fmt.Sprintf("     • %d AUR packages\n", v.stats["aur"])
+				}
+				if v.stats["zypper"] > 0 {
+					s += fmt.Sprintf("     • %d Zypper packages\n", v.stats["zypper"])
+				}
 				if v.stats["extensions"] > 0 {
 					s += fmt.Sprintf("     • %d Extensions\n", v.stats["extensions"])
 				}
@@ -255,7 +314,7 @@ func (v LightBackupView) View() string {
 
 // LightRestoreView for restoring from a light backup
 type LightRestoreView struct {
-	phase        int // 0=file select, 1=checking, 2=select items, 3=running, 4=done
+	phase        int // 0=file select, 1=checking, 2=select items, 3=running, 4=done, 5=passphrase
 	frame        int
 	path         string
 	backup       *backup.LightBackup
@@ -264,14 +323,27 @@ type LightRestoreView struct {
 	selections   map[string]bool
 	checkStatus  string
 	results      string
+	snapshot     string
+	resume       *restore.ResumeState
+	outcomes     []restore.RestoreResult
 	error        error
+	prompt       *passphrasePrompt
 }
 
 type lightRestoreDoneMsg struct {
 	results string
+	items   []restore.RestoreResult
 	err     error
 }
 
+// lightPreparedMsg is sent once the restore state and the pre-restore
+// snapshot are saved
+type lightPreparedMsg struct {
+	resume   *restore.ResumeState
+	snapshot string
+	err      error
+}
+
 type checkDoneMsg struct {
 	check *backup.RestoreCheck
 }
@@ -280,6 +352,13 @@ func NewLightRestoreView() LightRestoreView {
 	return LightRestoreView{path: backup.GetDefaultLightBackupPath()}
 }
 
+// NewLightRestoreViewResume picks up an interrupted restore: the same backup
+// with the same components selected. What was installed is no longer listed,
+// so only the rest is restored.
+func NewLightRestoreViewResume(s *restore.ResumeState) LightRestoreView {
+	return LightRestoreView{path: s.Source, resume: s}
+}
+
 func (v LightRestoreView) Init() tea.Cmd { return components.Tick() }
 
 func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string) {
@@ -310,6 +389,36 @@ func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string
 				Description: fmt.Sprintf("%d already installed [sudo]", c.APTSkipped), Checked: true,
 			})
 		}
+		if len(c.PacmanToInstall) > 0 {
+			items = append(items, components.CheckboxItem{
+				ID: "pacman", Title: fmt.Sprintf("Pacman Packages (%d to install)", len(c.PacmanToInstall)),
+				Description: fmt.Sprintf("%d already installed [sudo]", c.PacmanSkipped), Checked: true,
+			})
+		}
+		if len(c.AURToInstall) > 0 {
+			desc := fmt.Sprintf("%d already installed", c.AURSkipped)
+			if helper := restore.DetectAURHelper(); helper != "" {
+				desc += " [" + helper + "]"
+			} else {
+				desc += " [no AUR helper found]"
+			}
+			items = append(items, components.CheckboxItem{
+				ID: "aur", Title: fmt.Sprintf("AUR Packages (%d to install)", len(c.AURToInstall)),
+				Description: desc, Checked: true,
+			})
+		}
+		if len(c.ZypperReposToAdd) > 0 {
+			items = append(items, components.CheckboxItem{
+				ID: "zypper_repos", Title: fmt.Sprintf("Zypper Repositories (%d to add)", len(c.ZypperReposToAdd)),
+				Description: fmt.Sprintf("%d already configured [sudo]", c.ZypperReposSkipped), Checked: true,
+			})
+		}
+		if len(c.ZypperToInstall) > 0 {
+			items = append(items, components.CheckboxItem{
+				ID: "zypper", Title: fmt.Sprintf("Zypper Packages (%d to install)", len(c.ZypperToInstall)),
+				Description: fmt.Sprintf("%d already installed [sudo]", c.ZypperSkipped), Checked: true,
+			})
+		}
 		if len(c.ExtensionsToEnable) > 0 {
 			items = append(items, components.CheckboxItem{
 				ID: "extensions", Title: fmt.Sprintf("GNOME Extensions (%d to enable)", len(c.ExtensionsToEnable)),
@@ -322,15 +431,47 @@ func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string
 				Description: "Desktop customizations", Checked: true,
 			})
 		}
+		if v.resume != nil {
+			for i := range items {
+				items[i].Checked = slices.Contains(v.resume.Components, lightSnapshotTypes[items[i].ID])
+			}
+		}
 		if len(items) > 0 {
 			v.checkboxes = components.NewCheckboxList(items)
 		}
 		v.phase = 2
 		return v, nil, ""
+	case lightPreparedMsg:
+		if msg.err != nil {
+			v.phase = 4
+			v.results = "Nothing was restored: " + msg.err.Error()
+			v.error = msg.err
+			return v, nil, ""
+		}
+		v.resume = msg.resume
+		v.snapshot = msg.snapshot
+
+		if v.needsPrivileges() {
+			return v, authenticate(), ""
+		}
+		return v, v.runRestore(), ""
+	case privilegedAuthMsg:
+		if msg.err != nil {
+			v.phase = 4
+			v.results = "Nothing was restored: authentication failed"
+			v.error = msg.err
+			return v, nil, ""
+		}
+		return v, v.runRestore(), ""
 	case lightRestoreDoneMsg:
 		v.phase = 4
 		v.results = msg.results
+		v.outcomes = msg.items
 		v.error = msg.err
+		v.resume.Finish()
+		if _, failed := v.resume.Counts(); failed > 0 {
+			v.results += fmt.Sprintf("\n\n%d items failed, choose Resume Restore on the main menu to retry them", failed)
+		}
 		return v, nil, ""
 	case tea.KeyMsg:
 		switch v.phase {
@@ -338,17 +479,37 @@ func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string
 			switch msg.String() {
 			case "enter":
 				b, err := backup.LoadLightBackup(v.path)
+				if errors.Is(err, utils.ErrPassphraseRequired) {
+					v.error = nil
+					v.phase = 5
+					v.prompt = newPassphrasePrompt(false)
+					return v, nil, ""
+				}
 				if err != nil {
 					v.error = err
 					return v, nil, ""
 				}
-				v.backup = b
-				v.phase = 1
-				v.checkStatus = "Checking installed packages..."
-				return v, v.runCheck(), ""
+				return v.startCheck(b)
 			case "esc":
 				return v, nil, "back"
 			}
+		case 5:
+			done, cancelled := v.prompt.Update(msg)
+			if cancelled {
+				v.phase = 0
+			} else if done {
+				b, err := backup.LoadLightBackupWithPassphrase(v.path, v.prompt.Value())
+				if errors.Is(err, utils.ErrWrongPassphrase) {
+					v.prompt.SetError("Wrong passphrase, try again")
+					return v, nil, ""
+				}
+				if err != nil {
+					v.phase = 0
+					v.error = err
+					return v, nil, ""
+				}
+				return v.startCheck(b)
+			}
 		case 2:
 			// Selection phase with checkboxes
 			switch msg.String() {
@@ -386,16 +547,7 @@ func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string
 					return v, nil, "" // Nothing selected, don't proceed
 				}
 				v.phase = 3
-
-				// Check if we need sudo (RPM or APT selected)
-				needsSudo := (v.selections["rpm"] && len(v.restoreCheck.RPMToInstall) > 0) ||
-					(v.selections["apt"] && len(v.restoreCheck.APTToInstall) > 0)
-
-				if needsSudo {
-					// Use tea.ExecProcess to release terminal for sudo password
-					return v, v.runRestoreWithSudo(), ""
-				}
-				return v, v.runRestoreNoSudo(), ""
+				return v, v.prepareRestore(), ""
 			case "esc":
 				v.phase = 0
 				v.error = nil
@@ -408,6 +560,13 @@ func (v LightRestoreView) Update(msg tea.Msg) (LightRestoreView, tea.Cmd, string
 	return v, nil, ""
 }
 
+func (v LightRestoreView) startCheck(b *backup.LightBackup) (LightRestoreView, tea.Cmd, string) {
+	v.backup = b
+	v.phase = 1
+	v.checkStatus = "Checking installed packages..."
+	return v, v.runCheck(), ""
+}
+
 func (v LightRestoreView) runCheck() tea.Cmd {
 	return func() tea.Msg {
 		check := backup.CheckRestore(v.backup)
@@ -415,100 +574,200 @@ func (v LightRestoreView) runCheck() tea.Cmd {
 	}
 }
 
-// runRestoreNoSudo handles restore operations that don't need sudo
-func (v LightRestoreView) runRestoreNoSudo() tea.Cmd {
+// lightSnapshotTypes maps the items of the select screen to what they change
+var lightSnapshotTypes = map[string]restore.RestoreType{
+	"flatpaks":     restore.RestoreTypeFlatpak,
+	"rpm":          restore.RestoreTypeRPM,
+	"apt":          restore.RestoreTypeAPT,
+	"pacman":       restore.RestoreTypePacman,
+	"aur":          restore.RestoreTypeAUR,
+	"zypper_repos": restore.RestoreTypeZypperRepos,
+	"zypper":       restore.RestoreTypeZypper,
+	"extensions":   restore.RestoreTypeGnomeExtensions,
+	"dconf":        restore.RestoreTypeGnomeSettings,
+}
+
+// prepareRestore starts saving progress so the restore can be resumed, and
+// saves the selected components as they are now, before the restore changes
+// them. A resumed restore keeps its state and took its snapshot already.
+func (v LightRestoreView) prepareRestore() tea.Cmd {
+	var types []restore.RestoreType
+	for id := range v.selections {
+		if t, ok := lightSnapshotTypes[id]; ok && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	path, resume := v.path, v.resume
 	return func() tea.Msg {
-		var results []string
+		if resume != nil {
+			return lightPreparedMsg{resume: resume}
+		}
+		source, err := filepath.Abs(path)
+		if err != nil {
+			return lightPreparedMsg{err: err}
+		}
+		state, err := restore.NewResumeState(source, types)
+		if err != nil {
+			return lightPreparedMsg{err: fmt.Errorf("failed to save restore state: %w", err)}
+		}
+		s, err := restore.NewSnapshot()
+		if err == nil {
+			err = s.Take(types...)
+		}
+		if err != nil {
+			state.Discard()
+			return lightPreparedMsg{err: fmt.Errorf("failed to take pre-restore snapshot: %w", err)}
+		}
+		return lightPreparedMsg{resume: state, snapshot: s.Path()}
+	}
+}
+
+// needsPrivileges reports whether the selection installs packages or adds
+// repositories, which needs root
+func (v LightRestoreView) needsPrivileges() bool {
+	c := v.restoreCheck
+	return (v.selections["rpm"] && len(c.RPMToInstall) > 0) ||
+		(v.selections["apt"] && len(c.APTToInstall) > 0) ||
+		(v.selections["pacman"] && len(c.PacmanToInstall) > 0) ||
+		(v.selections["aur"] && len(c.AURToInstall) > 0) ||
+		(v.selections["zypper_repos"] && len(c.ZypperReposToAdd) > 0) ||
+		(v.selections["zypper"] && len(c.ZypperToInstall) > 0)
+}
+
+// runRestore restores the selected items. Packages and repositories go
+// through the privileged helper, everything else runs as the user.
+func (v LightRestoreView) runRestore() tea.Cmd {
+	return func() tea.Msg {
+		var results []restore.RestoreResult
+		var errs []error
 		c := v.restoreCheck
+		j, err := restore.NewJournal(v.path)
+		if err != nil {
+			return lightRestoreDoneMsg{results: "Nothing was restored", err: err}
+		}
+		add := func(component restore.RestoreType, items []restore.ItemResult) {
+			results = append(results, lightResult(component, items))
+			v.resume.MarkItems(component, items)
+		}
+
+		h, err := startHelper(v.needsPrivileges())
+		if err != nil {
+			return lightRestoreDoneMsg{results: "Nothing was restored", err: err}
+		}
+		defer h.Close()
 
 		// 1. Flatpaks
 		if v.selections["flatpaks"] && len(c.FlatpaksToInstall) > 0 {
-			for _, app := range c.FlatpaksToInstall {
-				cmd := exec.Command("flatpak", "install", "-y", "flathub", app)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				cmd.Run()
+			var apps []backup.FlatpakDeployment
+			for _, id := range c.FlatpaksToInstall {
+				apps = append(apps, v.backup.FlatpakApp(id))
+			}
+			available, unavailable := restore.ResolveFlatpakApps("", apps)
+			installed := j.TrackFlatpaks(available)
+			var items []restore.ItemResult
+			for _, app := range unavailable {
+				items = append(items, restore.UnavailableItems([]string{app.ID}, fmt.Sprintf("not found on the %s remote", app.Remote()))...)
+			}
+			for _, app := range available {
+				items = append(items, restore.FlatpakInstaller{}.Install(app))
+			}
+			installed()
+			add(restore.RestoreTypeFlatpak, items)
+		}
+
+		// 2. System packages and repositories, restored from what the
+		// check found missing
+		b := &backup.LightBackup{}
+		if v.selections["rpm"] {
+			b.RPMPackages = c.RPMToInstall
+		}
+		if v.selections["apt"] {
+			b.APTPackages = c.APTToInstall
+		}
+		if v.selections["pacman"] {
+			b.PacmanPackages = c.PacmanToInstall
+		}
+		if v.selections["aur"] {
+			b.AURPackages = c.AURToInstall
+		}
+		if v.selections["zypper"] {
+			b.ZypperPackages = c.ZypperToInstall
+		}
+		if v.selections["zypper_repos"] {
+			b.ZypperRepos = c.ZypperReposToAdd
+		}
+		r := restore.NewLightRestore(b, false)
+		r.SetJournal(j)
+		r.SetResume(v.resume)
+		r.SetHelper(h)
+		for _, restoreFn := range []func() (int, int, error){
+			r.RestoreRPM, r.RestoreAPT, r.RestorePacman, r.RestoreAUR, r.RestoreZypperRepos, r.RestoreZypper,
+		} {
+			if _, _, err := restoreFn(); err != nil {
+				errs = append(errs, err)
 			}
-			results = append(results, fmt.Sprintf("Installed %d Flatpaks", len(c.FlatpaksToInstall)))
 		}
+		results = append(results, r.Results()...)
 
-		// 2. GNOME Extensions
+		// 3. GNOME Extensions
 		if v.selections["extensions"] && len(c.ExtensionsToEnable) > 0 {
+			var items []restore.ItemResult
 			for _, ext := range c.ExtensionsToEnable {
-				exec.Command("gnome-extensions", "enable", ext).Run()
+				start := time.Now()
+				result := utils.RunCommand("gnome-extensions", "enable", ext)
+				items = append(items, restore.CommandItem(ext, result, time.Since(start)))
 			}
-			results = append(results, fmt.Sprintf("Enabled %d GNOME extensions", len(c.ExtensionsToEnable)))
+			add(restore.RestoreTypeGnomeExtensions, items)
 		}
 
-		// 3. Dconf settings
+		// 4. Dconf settings
 		if v.selections["dconf"] && c.HasDconfSettings && v.backup.DconfSettings != "" {
+			if err := j.RecordDconf(restore.RestoreTypeGnomeSettings, "/"); err != nil {
+				return lightRestoreDoneMsg{results: "Dconf settings were not restored", err: err, items: results}
+			}
+			start := time.Now()
+			var stderr bytes.Buffer
 			cmd := exec.Command("dconf", "load", "/")
 			cmd.Stdin = strings.NewReader(v.backup.DconfSettings)
-			cmd.Run()
-			results = append(results, "Restored dconf settings")
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			add(restore.RestoreTypeGnomeSettings, []restore.ItemResult{
+				restore.CommandItem("dconf", utils.CommandResult{Stderr: stderr.String(), Error: err}, time.Since(start)),
+			})
 		}
 
+		err = errors.Join(errs...)
 		if len(results) == 0 {
-			return lightRestoreDoneMsg{results: "Nothing was restored"}
+			return lightRestoreDoneMsg{results: "Nothing was restored", err: err}
 		}
-		return lightRestoreDoneMsg{results: "Restore complete:\n" + joinLines(results)}
-	}
-}
-
-// runRestoreWithSudo handles restore using tea.ExecProcess for sudo password
-func (v LightRestoreView) runRestoreWithSudo() tea.Cmd {
-	c := v.restoreCheck
-
-	// Build a shell script to run all restore operations
-	var script string
-	script += "#!/bin/bash\n"
-	script += "set -e\n"
-	script += "echo '=== ReGo Restore ==='\n"
-
-	// Flatpaks (no sudo)
-	if v.selections["flatpaks"] && len(c.FlatpaksToInstall) > 0 {
-		for _, app := range c.FlatpaksToInstall {
-			script += fmt.Sprintf("flatpak install -y flathub %s\n", app)
+		summary := lightSummary(results)
+		if err != nil {
+			summary += "\n\n" + err.Error()
 		}
+		return lightRestoreDoneMsg{results: summary, items: results, err: err}
 	}
+}
 
-	// RPM packages (with sudo)
-	if v.selections["rpm"] && len(c.RPMToInstall) > 0 {
-		script += "sudo dnf install -y"
-		for _, pkg := range c.RPMToInstall {
-			script += " " + pkg
-		}
-		script += "\n"
-	}
+// lightResult counts the outcome of component's items
+func lightResult(component restore.RestoreType, items []restore.ItemResult) restore.RestoreResult {
+	result := restore.RestoreResult{Type: component, ItemsTotal: len(items), Timestamp: time.Now()}
+	result.AddItems(items...)
+	result.Success = result.ItemsFailed == 0
+	return result
+}
 
-	// APT packages (with sudo)
-	if v.selections["apt"] && len(c.APTToInstall) > 0 {
-		script += "sudo apt install -y"
-		for _, pkg := range c.APTToInstall {
-			script += " " + pkg
-		}
-		script += "\n"
+// lightSummary returns a line per component saying how much was restored
+func lightSummary(results []restore.RestoreResult) string {
+	var lines []string
+	failed := 0
+	for _, r := range results {
+		lines = append(lines, fmt.Sprintf("%s: %d of %d restored", restore.RestoreTypeName(r.Type), r.ItemsSuccess, r.ItemsTotal))
+		failed += r.ItemsFailed
 	}
-
-	// GNOME extensions (no sudo)
-	if v.selections["extensions"] && len(c.ExtensionsToEnable) > 0 {
-		for _, ext := range c.ExtensionsToEnable {
-			script += fmt.Sprintf("gnome-extensions enable %s 2>/dev/null || true\n", ext)
-		}
+	if failed > 0 {
+		return fmt.Sprintf("Restore finished, %d items failed:\n", failed) + joinLines(lines)
 	}
-
-	script += "echo ''\n"
-	script += "echo '=== Restore Complete! Press Enter to continue ==='\n"
-	script += "read\n"
-
-	// Use tea.ExecProcess to run bash with the script
-	cmd := exec.Command("bash", "-c", script)
-	return tea.ExecProcess(cmd, func(err error) tea.Msg {
-		if err != nil {
-			return lightRestoreDoneMsg{results: "Restore completed with some errors", err: err}
-		}
-		return lightRestoreDoneMsg{results: "Restore complete!"}
-	})
+	return "Restore complete:\n" + joinLines(lines)
 }
 
 func joinLines(lines []string) string {
@@ -539,6 +798,11 @@ func (v LightRestoreView) View() string {
 		}
 		content += styles.DimStyle.Render("[Enter] Load  [Esc] Back")
 
+	case 5:
+		content = styles.DimStyle.Render("🔒 "+v.path+" is encrypted") + "\n\n"
+		content += v.prompt.View() + "\n"
+		content += styles.DimStyle.Render("[Enter] Unlock  [Esc] Back")
+
 	case 1:
 		// Checking phase - show verification in progress
 		spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}[v.frame%10]
@@ -579,6 +843,9 @@ func (v LightRestoreView) View() string {
 			infoLines = append(infoLines, fmt.Sprintf("Distro: %s", v.backup.Distro))
 		}
 		content += styles.CardStyle.Render(strings.Join(infoLines, "\n")) + "\n\n"
+		if len(v.backup.Rejected) > 0 {
+			content += renderRejected(v.backup.Rejected) + "\n"
+		}
 
 		// Show checkboxes or "everything installed" message
 		if v.checkboxes != nil {
@@ -586,9 +853,10 @@ func (v LightRestoreView) View() string {
 			content += v.checkboxes.View() + "\n"
 			// Show sudo warning if RPM/APT packages selected
 			c := v.restoreCheck
-			if len(c.RPMToInstall) > 0 || len(c.APTToInstall) > 0 {
-				content += styles.WarningStyle.Render("⚠ Package installation requires sudo") + "\n"
-				content += styles.DimStyle.Render("  If password fails, run: sudo rego") + "\n\n"
+			if len(c.RPMToInstall) > 0 || len(c.APTToInstall) > 0 || len(c.PacmanToInstall) > 0 || len(c.AURToInstall) > 0 ||
+				len(c.ZypperToInstall) > 0 || len(c.ZypperReposToAdd) > 0 {
+				content += styles.WarningStyle.Render("⚠ Package installation requires root") + "\n"
+				content += styles.DimStyle.Render("  You will be asked for your password once") + "\n\n"
 			}
 			content += styles.DimStyle.Render("Space: Toggle • a: All • Enter: Restore • Esc: Cancel")
 		} else {
@@ -604,7 +872,13 @@ func (v LightRestoreView) View() string {
 
 	case 4:
 		// Done phase
-		content = styles.CardStyle.Render(v.results) + "\n\n"
+		content = styles.CardStyle.Render(v.results) + "\n"
+		for _, r := range v.outcomes {
+			if failed := renderFailedItems(r); failed != "" {
+				content += "\n  " + styles.DescriptionStyle.Render(restore.RestoreTypeName(r.Type)) + "\n" + failed
+			}
+		}
+		content += renderSnapshot(v.snapshot) + "\n"
 		content += styles.DimStyle.Render("[Any key] Continue")
 	}
 
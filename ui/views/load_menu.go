@@ -16,6 +16,8 @@ func NewLoadMenuView() LoadMenuView {
 	items := []components.MenuItem{
 		{ID: "quick", Title: "⚡ Quick Save", Description: "Restore from .json file (package lists)"},
 		{ID: "full", Title: "💾 Full Save", Description: "Restore from .tar.gz archive (with files)"},
+		{ID: "folder", Title: "📁 Backup Folder", Description: "Restore from ~/.config/rego/backups"},
+		{ID: "undo", Title: "↩ Undo Last Restore", Description: "Revert what the last restore changed"},
 	}
 	return LoadMenuView{menu: components.NewMenu(items)}
 }
@@ -39,6 +41,10 @@ func (v LoadMenuView) Update(msg tea.Msg) (LoadMenuView, tea.Cmd, string) {
 				return v, nil, "load_quick"
 			} else if sel.ID == "full" {
 				return v, nil, "load_full"
+			} else if sel.ID == "folder" {
+				return v, nil, "load_folder"
+			} else if sel.ID == "undo" {
+				return v, nil, "undo"
 			}
 		case "esc", "q":
 			return v, nil, "back"
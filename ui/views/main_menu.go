@@ -1,9 +1,13 @@
 package views
 
 import (
+	"fmt"
+	"path/filepath"
+
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/internal/restore"
 	"github.com/r8bert/rego/ui/components"
 	"github.com/r8bert/rego/ui/styles"
 )
@@ -12,6 +16,7 @@ type MainMenuView struct {
 	cursor int
 	items  []menuItem
 	frame  int
+	resume *restore.ResumeState
 }
 
 type menuItem struct {
@@ -22,7 +27,7 @@ type menuItem struct {
 }
 
 func NewMainMenuView() MainMenuView {
-	return MainMenuView{
+	m := MainMenuView{
 		items: []menuItem{
 			{id: "quick", icon: ">", title: "Quick Save", desc: "Light backup - package lists only"},
 			{id: "full", icon: ">", title: "Full Save", desc: "Complete backup with all files"},
@@ -31,6 +36,22 @@ func NewMainMenuView() MainMenuView {
 			{id: "quit", icon: ">", title: "Quit", desc: "Exit application"},
 		},
 	}
+
+	// Offer to finish a restore that was interrupted or had failures. One
+	// into another root or home came from the command line and is resumed
+	// there.
+	if state, err := restore.LoadResumeState(); err == nil && state.Target.Live() {
+		done, failed := state.Counts()
+		desc := fmt.Sprintf("%s - %d done, %d to retry", filepath.Base(state.Source), done, failed)
+		m.resume = state
+		m.items = append([]menuItem{{id: "resume", icon: ">", title: "Resume Restore", desc: desc}}, m.items...)
+	}
+	return m
+}
+
+// Resume returns the restore offered for resuming, if any
+func (m MainMenuView) Resume() *restore.ResumeState {
+	return m.resume
 }
 
 func (m MainMenuView) Init() tea.Cmd { return components.Tick() }
@@ -0,0 +1,77 @@
+package views
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/r8bert/rego/ui/components"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+// passphrasePrompt asks for a passphrase, and asks a second time when it
+// will be used to encrypt so a typo can't lock the backup away
+type passphrasePrompt struct {
+	input   *components.PasswordInput
+	confirm bool
+	first   string
+	value   string
+}
+
+func newPassphrasePrompt(confirm bool) *passphrasePrompt {
+	prompt := "Enter the backup passphrase:"
+	if confirm {
+		prompt = "Choose a passphrase to encrypt the backup:"
+	}
+	return &passphrasePrompt{input: components.NewPasswordInput(prompt), confirm: confirm}
+}
+
+// Update handles a key and reports whether the passphrase is complete or the
+// prompt was cancelled
+func (p *passphrasePrompt) Update(msg tea.KeyMsg) (done, cancelled bool) {
+	switch msg.String() {
+	case "esc":
+		return false, true
+	case "enter":
+		value := p.input.Value()
+		if value == "" {
+			p.input.SetError("The passphrase can't be empty")
+			return false, false
+		}
+		p.input.Reset()
+
+		if !p.confirm {
+			p.value = value
+			return true, false
+		}
+		if p.first == "" {
+			p.first = value
+			p.input.SetPrompt("Type the passphrase again to confirm:")
+			return false, false
+		}
+		if value != p.first {
+			p.first = ""
+			p.input.SetPrompt("Choose a passphrase to encrypt the backup:")
+			p.input.SetError("Passphrases didn't match, try again")
+			return false, false
+		}
+		p.value = value
+		return true, false
+	}
+
+	p.input.HandleKey(msg)
+	return false, false
+}
+
+// SetError shows an error under the input, e.g. after a wrong passphrase
+func (p *passphrasePrompt) SetError(err string) { p.input.SetError(err) }
+
+// Value returns the passphrase once Update reported done
+func (p *passphrasePrompt) Value() string { return p.value }
+
+func (p *passphrasePrompt) View() string { return p.input.View() }
+
+// renderEncryptOption shows whether the save will be encrypted
+func renderEncryptOption(encrypt bool) string {
+	if encrypt {
+		return styles.SuccessStyle.Render("🔒 Encrypted with a passphrase")
+	}
+	return styles.DimStyle.Render("🔓 Not encrypted (press e to encrypt)")
+}
@@ -7,6 +7,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/r8bert/rego/internal/backup"
 	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/internal/utils"
 	"github.com/r8bert/rego/ui/styles"
 	"github.com/r8bert/rego/ui/components"
 )
@@ -17,6 +18,7 @@ const (
 	RestorePhaseSelectBackup RestorePhase = iota
 	RestorePhaseSelectComponents
 	RestorePhaseConfirm
+	RestorePhaseReview
 	RestorePhaseRunning
 	RestorePhaseComplete
 )
@@ -29,14 +31,21 @@ type RestoreView struct {
 	confirm      *components.Confirm
 	progress     *components.Progress
 	dryRun       bool
+	review       *dotfileReview
 	selectedPath string
+	verify       *backup.VerifyReport
+	verifyErr    error
 	results      []restore.RestoreResult
+	plan         []string
+	snapshot     string
 	error        error
 }
 
 type restoreCompleteMsg struct {
-	results []restore.RestoreResult
-	err     error
+	results  []restore.RestoreResult
+	plan     []string
+	snapshot string
+	err      error
 }
 
 func NewRestoreView() RestoreView {
@@ -61,9 +70,23 @@ func (v RestoreView) Init() tea.Cmd { return nil }
 
 func (v RestoreView) Update(msg tea.Msg) (RestoreView, tea.Cmd, string) {
 	switch msg := msg.(type) {
+	case privilegedAuthMsg:
+		if msg.err != nil {
+			v.phase = RestorePhaseComplete
+			v.error = fmt.Errorf("authentication failed: %w", msg.err)
+			return v, nil, ""
+		}
+		return v, v.runRestore(), ""
+	case dotfileEditedMsg:
+		if v.review != nil {
+			v.review.Edited(msg)
+		}
+		return v, nil, ""
 	case restoreCompleteMsg:
 		v.phase = RestorePhaseComplete
 		v.results = msg.results
+		v.plan = msg.plan
+		v.snapshot = msg.snapshot
 		v.error = msg.err
 		return v, nil, ""
 	case tea.KeyMsg:
@@ -78,6 +101,7 @@ func (v RestoreView) Update(msg tea.Msg) (RestoreView, tea.Cmd, string) {
 				sel := v.backupMenu.Selected()
 				if sel.ID != "" {
 					v.selectedPath = sel.ID
+					v.verify, v.verifyErr = backup.VerifyBackupDir(sel.ID)
 					v.setupComponentSelection()
 					v.phase = RestorePhaseSelectComponents
 				}
@@ -115,13 +139,36 @@ func (v RestoreView) Update(msg tea.Msg) (RestoreView, tea.Cmd, string) {
 				v.confirm.Toggle()
 			case "enter":
 				if v.confirm.Confirmed() {
-					v.phase = RestorePhaseRunning
-					return v, v.runRestore(), ""
+					v.review = nil
+					// Existing dotfiles are reviewed before they are overwritten
+					if !v.dryRun && hasID(v.checkboxes.GetSelected(), string(restore.RestoreTypeDotfiles)) &&
+						utils.FileExists(filepath.Join(v.selectedPath, "dotfiles.json")) {
+						diffs, err := restore.NewDotfilesRestore().Diffs(v.selectedPath)
+						if err != nil {
+							v.phase = RestorePhaseComplete
+							v.error = err
+							return v, nil, ""
+						}
+						if v.review = newDotfileReview(diffs, false); v.review != nil {
+							v.phase = RestorePhaseReview
+							return v, nil, ""
+						}
+					}
+					return v.start()
 				}
 				v.phase = RestorePhaseSelectComponents
 			case "esc":
 				v.phase = RestorePhaseSelectComponents
 			}
+		case RestorePhaseReview:
+			cmd, done, cancelled := v.review.Update(msg)
+			if cancelled {
+				v.review = nil
+				v.phase = RestorePhaseSelectComponents
+			} else if done {
+				return v.start()
+			}
+			return v, cmd, ""
 		case RestorePhaseComplete:
 			if msg.String() == "enter" || msg.String() == "esc" {
 				return v, nil, "back"
@@ -131,6 +178,15 @@ func (v RestoreView) Update(msg tea.Msg) (RestoreView, tea.Cmd, string) {
 	return v, nil, ""
 }
 
+// start runs the restore, asking for the password first when it needs root
+func (v RestoreView) start() (RestoreView, tea.Cmd, string) {
+	v.phase = RestorePhaseRunning
+	if !v.dryRun && restore.NeedsPrivileges(v.selectedTypes()) {
+		return v, authenticate(), ""
+	}
+	return v, v.runRestore(), ""
+}
+
 func (v *RestoreView) setupComponentSelection() {
 	mgr := restore.NewManager()
 	available := mgr.GetAvailableRestorers()
@@ -144,22 +200,47 @@ func (v *RestoreView) setupComponentSelection() {
 	v.progress = components.NewProgress(len(items))
 }
 
+// selectedTypes returns the selected components
+func (v RestoreView) selectedTypes() []restore.RestoreType {
+	var types []restore.RestoreType
+	for _, item := range v.checkboxes.GetSelected() {
+		types = append(types, restore.RestoreType(item.ID))
+	}
+	return types
+}
+
 func (v RestoreView) runRestore() tea.Cmd {
 	return func() tea.Msg {
+		h, err := startHelper(!v.dryRun && restore.NeedsPrivileges(v.selectedTypes()))
+		if err != nil {
+			return restoreCompleteMsg{err: err}
+		}
+		defer h.Close()
 		selected := v.checkboxes.GetSelected()
 		opts := restore.RestoreOptions{
 			BackupPath: v.selectedPath, DryRun: v.dryRun,
 			IncludeFlatpak:         hasID(selected, "flatpak"),
+			IncludeFlatpakRemotes:  hasID(selected, "flatpak_remotes"),
 			IncludeRPM:             hasID(selected, "rpm"),
 			IncludeRepos:           hasID(selected, "repos"),
 			IncludeGnomeExtensions: hasID(selected, "gnome_extensions"),
 			IncludeGnomeSettings:   hasID(selected, "gnome_settings"),
 			IncludeDotfiles:        hasID(selected, "dotfiles"),
 			IncludeFonts:           hasID(selected, "fonts"),
+			IncludeZypper:          hasID(selected, "zypper"),
+			IncludeZypperRepos:     hasID(selected, "zypper_repos"),
+			IncludeAPT:             hasID(selected, "apt"),
+			IncludeAPTSources:      hasID(selected, "apt_sources"),
+			DotfileChoices:         v.review.Choices(),
 		}
 		mgr := restore.NewManager()
+		mgr.SetHelper(h)
+		var lines []string
+		if plan, err := mgr.Plan(opts); err == nil {
+			lines = plan.Lines()
+		}
 		results, err := mgr.RunRestore(opts, nil)
-		return restoreCompleteMsg{results, err}
+		return restoreCompleteMsg{results, lines, mgr.Snapshot().Path(), err}
 	}
 }
 
@@ -176,17 +257,25 @@ func (v RestoreView) View() string {
 		if !v.dryRun {
 			mode = styles.WarningStyle.Render("[LIVE MODE]")
 		}
-		s += "Mode: " + mode + "\n\n"
+		s += "Mode: " + mode + "\n"
+		s += renderVerifyReport(v.verify, v.verifyErr) + "\n\n"
 		s += styles.DescriptionStyle.Render("Select components to restore:") + "\n\n"
 		s += v.checkboxes.View() + "\n"
 		s += styles.FooterStyle.Render("Space: Toggle • d: Toggle Dry Run • Enter: Continue")
 	case RestorePhaseConfirm:
 		s += v.confirm.View()
+	case RestorePhaseReview:
+		s += v.review.View()
 	case RestorePhaseRunning:
 		s += "Restore in progress...\n\n" + v.progress.View()
 	case RestorePhaseComplete:
 		if v.dryRun {
 			s += styles.WarningStyle.Render("DRY RUN - No changes were made") + "\n\n"
+			s += styles.DescriptionStyle.Render("Restore plan:") + "\n"
+			for _, line := range v.plan {
+				s += "  " + line + "\n"
+			}
+			s += "\n"
 		}
 		if v.error != nil {
 			s += styles.ErrorStyle.Render("Restore failed: "+v.error.Error()) + "\n"
@@ -197,7 +286,10 @@ func (v RestoreView) View() string {
 				status = styles.ErrorStyle.Render("✗")
 			}
 			s += fmt.Sprintf("  %s %s: %d/%d items\n", status, r.Type, r.ItemsSuccess, r.ItemsTotal)
+			s += renderMerged(r)
+			s += renderFailedItems(r)
 		}
+		s += renderSnapshot(v.snapshot)
 		s += "\n" + styles.FooterStyle.Render("Press Enter to continue")
 	}
 	return s
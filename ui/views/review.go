@@ -0,0 +1,251 @@
+package views
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/ui/components"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+// reviewHeight is how many lines of a diff are shown at once
+const reviewHeight = 14
+
+// reviewWidth is how wide the diff pane is; longer lines are cut off
+const reviewWidth = 90
+
+var (
+	diffAddStyle  = lipgloss.NewStyle().Foreground(styles.Success)
+	diffDelStyle  = lipgloss.NewStyle().Foreground(styles.Danger)
+	diffHunkStyle = lipgloss.NewStyle().Foreground(styles.Secondary)
+)
+
+// dotfileReview shows how each backed-up dotfile differs from the one in
+// the home directory and lets the user decide, file by file, whether to
+// take the backup, keep the current file or merge the two in $EDITOR
+type dotfileReview struct {
+	diffs   []restore.DotfileDiff
+	choices map[string]restore.DotfileChoice
+	cursor  int
+	pager   *components.Pager
+	status  string
+}
+
+// dotfileEditedMsg is sent when the editor opened on a merge file exits
+type dotfileEditedMsg struct {
+	rel  string
+	path string
+	err  error
+}
+
+// newDotfileReview returns a review of the dotfiles a restore would
+// overwrite, or nil when it overwrites none. Each starts out as merge mode
+// would treat it.
+func newDotfileReview(diffs []restore.DotfileDiff, merge bool) *dotfileReview {
+	r := &dotfileReview{choices: map[string]restore.DotfileChoice{}, pager: components.NewPager(reviewWidth, reviewHeight)}
+	for _, d := range diffs {
+		if d.Status != restore.DiffChanged && d.Status != restore.DiffReplaced {
+			continue
+		}
+		r.diffs = append(r.diffs, d)
+		action := restore.TakeBackup
+		if merge && d.Skeleton && d.Status == restore.DiffChanged {
+			action = restore.MergeSkeleton
+		} else if merge {
+			action = restore.KeepCurrent
+		}
+		r.choices[d.RelativePath] = restore.DotfileChoice{Action: action}
+	}
+	if len(r.diffs) == 0 {
+		return nil
+	}
+	r.show()
+	return r
+}
+
+// Choices returns what was decided for each reviewed dotfile
+func (r *dotfileReview) Choices() map[string]restore.DotfileChoice {
+	if r == nil {
+		return nil
+	}
+	return r.choices
+}
+
+// show puts the diff of the current file in the pager
+func (r *dotfileReview) show() {
+	d := r.diffs[r.cursor]
+	if d.Status != restore.DiffChanged {
+		r.pager.SetLines([]string{styles.DimStyle.Render("No line by line diff: " + d.Detail)})
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(d.Diff, "\n"), "\n") {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if runes := []rune(line); len(runes) > reviewWidth {
+			line = string(runes[:reviewWidth-1]) + "…"
+		}
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = styles.DimStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			line = diffHunkStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			line = diffAddStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			line = diffDelStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	r.pager.SetLines(lines)
+}
+
+// Update handles a key. It returns the command to run, if any, and whether
+// the review is finished or was cancelled.
+func (r *dotfileReview) Update(msg tea.KeyMsg) (cmd tea.Cmd, done, cancelled bool) {
+	d := r.diffs[r.cursor]
+	switch msg.String() {
+	case "up", "k":
+		r.pager.Up()
+	case "down", "j":
+		r.pager.Down()
+	case "pgup":
+		r.pager.PageUp()
+	case "pgdown", " ":
+		r.pager.PageDown()
+	case "left", "h", "shift+tab":
+		if r.cursor > 0 {
+			r.cursor--
+			r.status = ""
+			r.show()
+		}
+	case "right", "l", "tab":
+		if r.cursor < len(r.diffs)-1 {
+			r.cursor++
+			r.status = ""
+			r.show()
+		}
+	case "b":
+		r.choices[d.RelativePath] = restore.DotfileChoice{Action: restore.TakeBackup}
+	case "c":
+		r.choices[d.RelativePath] = restore.DotfileChoice{Action: restore.KeepCurrent}
+	case "m":
+		if !d.Skeleton || d.Status != restore.DiffChanged {
+			r.status = "The backup has no skeleton version of this file to merge with"
+			return nil, false, false
+		}
+		r.choices[d.RelativePath] = restore.DotfileChoice{Action: restore.MergeSkeleton}
+	case "e":
+		if d.Status != restore.DiffChanged {
+			r.status = "Only text files can be merged by hand"
+			return nil, false, false
+		}
+		cmd, err := r.edit(d)
+		if err != nil {
+			r.status = "✗ " + err.Error()
+		}
+		return cmd, false, false
+	case "enter":
+		return nil, true, false
+	case "esc":
+		return nil, false, true
+	}
+	return nil, false, false
+}
+
+// edit opens $EDITOR on the current file with the backup's changes marked
+// as conflicts, or on the earlier hand merge if there is one
+func (r *dotfileReview) edit(d restore.DotfileDiff) (tea.Cmd, error) {
+	content, err := d.Conflicts()
+	if choice := r.choices[d.RelativePath]; choice.Action == restore.UseMerged {
+		content, err = choice.Content, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the name so the editor picks the right syntax
+	f, err := os.CreateTemp("", "rego-merge-*-"+filepath.Base(d.RelativePath))
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	rel, path := d.RelativePath, f.Name()
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return dotfileEditedMsg{rel: rel, path: path, err: err}
+	}), nil
+}
+
+// Edited takes the result of a merge by hand once the editor exits
+func (r *dotfileReview) Edited(msg dotfileEditedMsg) {
+	defer os.Remove(msg.path)
+	if msg.err != nil {
+		r.status = "✗ Editor failed: " + msg.err.Error()
+		return
+	}
+	content, err := os.ReadFile(msg.path)
+	if err != nil {
+		r.status = "✗ " + err.Error()
+		return
+	}
+	r.choices[msg.rel] = restore.DotfileChoice{Action: restore.UseMerged, Content: content}
+	r.status = "✓ Merged by hand"
+	if restore.HasConflictMarkers(content) {
+		r.status = "⚠ Merged by hand, but conflict markers are left in the file"
+	}
+}
+
+// choiceName describes a decision for the review screen
+func choiceName(action restore.DotfileAction) string {
+	switch action {
+	case restore.KeepCurrent:
+		return styles.NormalStyle.Render("keep current")
+	case restore.UseMerged:
+		return styles.AccentStyle.Render("merged by hand")
+	case restore.MergeSkeleton:
+		return styles.SuccessStyle.Render("merge with skeleton")
+	}
+	return styles.WarningStyle.Render("take backup")
+}
+
+func (r *dotfileReview) View() string {
+	d := r.diffs[r.cursor]
+	s := styles.NormalStyle.Render(fmt.Sprintf("Dotfiles that differ from your home folder (%d/%d)", r.cursor+1, len(r.diffs))) + "\n\n"
+	s += styles.SelectedStyle.Render(filepath.Join("~", d.RelativePath)) + "  " +
+		styles.DimStyle.Render(string(d.Status)) + "  → " + choiceName(r.choices[d.RelativePath].Action) + "\n\n"
+	s += r.pager.View() + "\n"
+
+	counts := map[restore.DotfileAction]int{}
+	for _, choice := range r.choices {
+		counts[choice.Action]++
+	}
+	s += styles.DimStyle.Render(fmt.Sprintf("%d take backup • %d keep current • %d merged by hand • %d merge with skeleton",
+		counts[restore.TakeBackup], counts[restore.KeepCurrent], counts[restore.UseMerged], counts[restore.MergeSkeleton])) + "\n"
+	if r.status != "" {
+		s += styles.WarningStyle.Render(r.status) + "\n"
+	}
+	s += "\n" + styles.DimStyle.Render("b: Take Backup • c: Keep Current • m: Merge with Skeleton • e: Merge in $EDITOR") + "\n"
+	s += styles.DimStyle.Render("↑/↓: Scroll • ←/→: File • Enter: Restore • Esc: Back")
+	return s
+}
@@ -0,0 +1,116 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/r8bert/rego/internal/utils"
+	"github.com/r8bert/rego/ui/components"
+)
+
+// task is a background operation the user can cancel with Esc, or by
+// quitting with Ctrl+C. Views hold it by pointer so the copies Update makes
+// all share it. A nil task is idle.
+type task struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	latest progressSlot
+}
+
+// progressSlot keeps the latest progress event of work running in the
+// background, for the view to read on each tick
+type progressSlot struct {
+	mu       sync.Mutex
+	ev       utils.ProgressEvent
+	reported bool
+}
+
+// report is a utils.ProgressFunc that keeps the latest event
+func (p *progressSlot) report(ev utils.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ev, p.reported = ev, true
+}
+
+// Progress returns the latest event, or false if none has arrived
+func (p *progressSlot) Progress() (utils.ProgressEvent, bool) {
+	if p == nil {
+		return utils.ProgressEvent{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ev, p.reported
+}
+
+// startTask returns a task and the context its work should run under. The
+// work must call finish when it returns.
+func startTask() (*task, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &task{cancel: cancel, done: make(chan struct{})}, ctx
+}
+
+// finish marks the work as returned
+func (t *task) finish() {
+	t.cancel()
+	close(t.done)
+}
+
+// Cancel asks the work to stop and returns without waiting for it
+func (t *task) Cancel() {
+	if t != nil {
+		t.cancel()
+	}
+}
+
+// Stop cancels the work and waits for it to return, so the commands it
+// started are gone before the program exits. Commands get a few seconds to
+// exit after SIGTERM, so the wait is bounded a little past that.
+func (t *task) Stop() {
+	if t == nil {
+		return
+	}
+	t.cancel()
+	select {
+	case <-t.done:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// report is a utils.ProgressFunc for the task's work
+func (t *task) report(ev utils.ProgressEvent) {
+	t.latest.report(ev)
+}
+
+// Progress returns the latest progress event, or false if none has arrived
+func (t *task) Progress() (utils.ProgressEvent, bool) {
+	if t == nil {
+		return utils.ProgressEvent{}, false
+	}
+	return t.latest.Progress()
+}
+
+// showProgress puts a progress event on a progress bar. The bar follows
+// bytes when they are known, then files, then components.
+func showProgress(p *components.AnimatedProgress, ev utils.ProgressEvent) {
+	p.SetLabel(ev.Component)
+	p.SetStatus(ev.Command)
+	switch {
+	case ev.BytesTotal > 0:
+		p.SetProgress(ev.BytesDone, ev.BytesTotal)
+		p.SetDetail(fmt.Sprintf("%s / %s", formatSize(ev.BytesDone), formatSize(ev.BytesTotal)))
+	case ev.FilesTotal > 0:
+		p.SetProgress(int64(ev.FilesDone), int64(ev.FilesTotal))
+		p.SetDetail(fmt.Sprintf("%d / %d items", ev.FilesDone, ev.FilesTotal))
+	default:
+		p.SetProgress(int64(ev.Step), int64(ev.Steps))
+		p.SetDetail(fmt.Sprintf("%d / %d steps", ev.Step, ev.Steps))
+	}
+}
+
+// isCancelled reports whether err means the user cancelled the task
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
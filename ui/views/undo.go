@@ -0,0 +1,187 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/r8bert/rego/internal/restore"
+	"github.com/r8bert/rego/ui/components"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+type UndoPhase int
+
+const (
+	UndoPhaseLoading UndoPhase = iota
+	UndoPhaseSelect
+	UndoPhaseRunning
+	UndoPhaseDone
+)
+
+// UndoView reverts the last restore, fully or per component
+type UndoView struct {
+	phase      UndoPhase
+	frame      int
+	journal    *restore.Journal
+	checkboxes *components.CheckboxList
+	results    []restore.RestoreResult
+	error      error
+}
+
+type undoLoadedMsg struct {
+	journal *restore.Journal
+	err     error
+}
+
+type undoDoneMsg struct {
+	results []restore.RestoreResult
+	err     error
+}
+
+func NewUndoView() UndoView {
+	return UndoView{}
+}
+
+func (v UndoView) Init() tea.Cmd {
+	return tea.Batch(components.Tick(), func() tea.Msg {
+		j, err := restore.LastJournal()
+		return undoLoadedMsg{journal: j, err: err}
+	})
+}
+
+func (v UndoView) Update(msg tea.Msg) (UndoView, tea.Cmd, string) {
+	switch msg := msg.(type) {
+	case components.TickMsg:
+		v.frame++
+		return v, components.Tick(), ""
+	case undoLoadedMsg:
+		v.journal, v.error = msg.journal, msg.err
+		if v.journal != nil {
+			var items []components.CheckboxItem
+			for _, c := range v.journal.Pending() {
+				items = append(items, components.CheckboxItem{
+					ID:          string(c),
+					Title:       restore.RestoreTypeName(c),
+					Description: previewSummary(v.journal.Changes(c)),
+					Checked:     true,
+				})
+			}
+			v.checkboxes = components.NewCheckboxList(items)
+		}
+		v.phase = UndoPhaseSelect
+		return v, nil, ""
+	case privilegedAuthMsg:
+		if msg.err != nil {
+			v.phase = UndoPhaseDone
+			v.error = fmt.Errorf("authentication failed: %w", msg.err)
+			return v, nil, ""
+		}
+		return v, v.runUndo(), ""
+	case undoDoneMsg:
+		v.phase = UndoPhaseDone
+		v.results = msg.results
+		v.error = msg.err
+		return v, nil, ""
+	case tea.KeyMsg:
+		switch v.phase {
+		case UndoPhaseSelect:
+			if v.journal == nil {
+				return v, nil, "back"
+			}
+			switch msg.String() {
+			case "up", "k":
+				v.checkboxes.Up()
+			case "down", "j":
+				v.checkboxes.Down()
+			case " ":
+				v.checkboxes.Toggle()
+			case "a":
+				v.checkboxes.ToggleAll()
+			case "enter":
+				if len(v.checkboxes.GetSelected()) > 0 {
+					v.phase = UndoPhaseRunning
+					if v.journal.NeedsPrivileges(v.selected()) {
+						return v, authenticate(), ""
+					}
+					return v, v.runUndo(), ""
+				}
+			case "esc", "q":
+				return v, nil, "back"
+			}
+		case UndoPhaseDone:
+			return v, nil, "back"
+		}
+	}
+	return v, nil, ""
+}
+
+// selected returns the components selected for undo
+func (v UndoView) selected() []restore.RestoreType {
+	var selected []restore.RestoreType
+	for _, item := range v.checkboxes.GetSelected() {
+		selected = append(selected, restore.RestoreType(item.ID))
+	}
+	return selected
+}
+
+func (v UndoView) runUndo() tea.Cmd {
+	j, selected := v.journal, v.selected()
+	return func() tea.Msg {
+		h, err := startHelper(j.NeedsPrivileges(selected))
+		if err != nil {
+			return undoDoneMsg{err: err}
+		}
+		defer h.Close()
+		j.SetHelper(h)
+		return undoDoneMsg{results: j.Undo(selected)}
+	}
+}
+
+func (v UndoView) View() string {
+	s := styles.TitleStyle.Render("↩ Undo Last Restore") + "\n\n"
+	spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}[v.frame%10]
+
+	switch v.phase {
+	case UndoPhaseLoading:
+		s += styles.WarningStyle.Render(spinner+" Reading undo journal...") + "\n"
+
+	case UndoPhaseSelect:
+		if v.journal == nil {
+			if errors.Is(v.error, restore.ErrNoJournal) {
+				s += styles.DimStyle.Render("Nothing to undo") + "\n\n"
+			} else {
+				s += styles.ErrorStyle.Render("✗ "+v.error.Error()) + "\n\n"
+			}
+			s += styles.DimStyle.Render("[Any key] Back")
+			break
+		}
+		info := fmt.Sprintf("Source: %s\nDate: %s", v.journal.Source, v.journal.CreatedAt.Format("2006-01-02 15:04"))
+		s += styles.CardStyle.Render(info) + "\n\n"
+		s += styles.NormalStyle.Render("Select what to revert:") + "\n\n"
+		s += v.checkboxes.View() + "\n"
+		s += styles.DimStyle.Render("Space: Toggle • a: All • Enter: Undo • Esc: Back")
+
+	case UndoPhaseRunning:
+		s += styles.WarningStyle.Render(spinner+" Reverting...") + "\n\n"
+		s += styles.DimStyle.Render("Please wait, this may take a while...")
+
+	case UndoPhaseDone:
+		if v.error != nil {
+			s += styles.ErrorStyle.Render("✗ Nothing was reverted: "+v.error.Error()) + "\n"
+		}
+		for _, r := range v.results {
+			status := styles.SuccessStyle.Render("✓")
+			if !r.Success {
+				status = styles.ErrorStyle.Render("✗")
+			}
+			s += fmt.Sprintf("  %s %s: %d/%d changes reverted\n", status, restore.RestoreTypeName(r.Type), r.ItemsSuccess, r.ItemsTotal)
+			for _, e := range r.Errors {
+				s += "      " + styles.DimStyle.Render(e) + "\n"
+			}
+		}
+		s += "\n" + styles.DimStyle.Render("[Any key] Continue")
+	}
+
+	return s
+}
@@ -0,0 +1,36 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/r8bert/rego/internal/backup"
+	"github.com/r8bert/rego/ui/styles"
+)
+
+// renderVerifyReport shows the result of checking a backup against its
+// checksums, listing the first few problems
+func renderVerifyReport(report *backup.VerifyReport, err error) string {
+	switch {
+	case errors.Is(err, backup.ErrNoChecksums):
+		return styles.DimStyle.Render("Integrity: not checked (no checksums in this backup)")
+	case err != nil:
+		return styles.ErrorStyle.Render("✗ Integrity check failed: " + err.Error())
+	case report == nil:
+		return ""
+	case report.OK():
+		return styles.SuccessStyle.Render(fmt.Sprintf("✓ %d files verified", report.Checked))
+	}
+
+	const max = 5
+	problems := report.Problems()
+	s := styles.ErrorStyle.Render(fmt.Sprintf("✗ Backup is damaged: %d problems", len(problems)))
+	for i, line := range problems {
+		if i == max {
+			s += "\n" + styles.DimStyle.Render(fmt.Sprintf("    +%d more", len(problems)-max))
+			break
+		}
+		s += "\n" + styles.DimStyle.Render("    "+line)
+	}
+	return s
+}